@@ -3,9 +3,11 @@ package backend
 import (
 	"context"
 	"crypto/rand"
+	"debug/buildinfo"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"log"
@@ -18,6 +20,7 @@ import (
 
 	"gioui.org/x/explorer"
 	"git.sr.ht/~gioverse/skel/stream"
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
 )
 
 type Benchmark struct {
@@ -42,13 +45,25 @@ type ResultSet struct {
 	SummaryJoules   []float64
 	SummaryWatts    []float64
 	SummaryDuration time.Duration
+	// Throttling lists the throttle series that were active while the benchmark ran.
+	Throttling []string
+	// LowCoverage describes the series that lack data for much of a baseline or of the run,
+	// which makes their results unreliable.
+	LowCoverage []string
 }
 
+// minBenchmarkCoverage is the fraction of each benchmark phase that a series must have data
+// for to avoid a warning.
+const minBenchmarkCoverage = 0.9
+
 type BenchmarkData struct {
 	SessionID                                                            string
 	BenchmarkID                                                          string
 	Command                                                              string
+	Commit                                                               string
+	Tags                                                                 []string
 	Notes                                                                string
+	Burst                                                                bool
 	PreBaselineStart, PreBaselineEnd, PostBaselineStart, PostBaselineEnd int64
 	Err                                                                  error
 	Results                                                              ResultSet `json:"-"`
@@ -58,7 +73,9 @@ func (b *BenchmarkData) attemptComputeResults(session Session) bool {
 	if session.Mode == ModeReplaying && !session.Loaded {
 		return false
 	}
-	series := session.Data.Headings()
+	// Only energy series can be meaningfully baselined.
+	data := session.Data.EnergySeries()
+	series := data.Headings()
 
 	sectionsCount := 4
 	rows := len(series) * sectionsCount
@@ -69,30 +86,38 @@ func (b *BenchmarkData) attemptComputeResults(session Session) bool {
 	finalSectionOffset := (sectionsCount - 1) * sectionStride
 	totalBaselineDuration := float64(b.PreBaselineEnd - b.PreBaselineStart + b.PostBaselineEnd - b.PostBaselineStart)
 	var runDuration float64
+	var lowCoverage []string
 	for section := 0; section < sectionsCount-1; section++ {
 		var start, end int64
+		var phase string
 		isBaseline := false
 		switch section {
 		case 0:
 			start = b.PreBaselineStart
 			end = b.PreBaselineEnd
+			phase = "pre-baseline"
 			isBaseline = true
 		case 1:
 			start = b.PreBaselineEnd
 			end = b.PostBaselineStart
+			phase = "run"
 			runDuration = float64(end-start) / 1_000_000_000
 		case 2:
 			start = b.PostBaselineStart
 			end = b.PostBaselineEnd
+			phase = "post-baseline"
 			isBaseline = true
 		}
 		sectionOffset := section * sectionStride
-		for i, s := range session.Data {
-			max, mean, min, sum, ok := s.RatesBetween(start, end)
+		for i, s := range data {
+			max, mean, min, sum, coverage, ok := s.RatesBetween(start, end)
 			if !ok {
 				// Need to retry once new data is available.
 				return false
 			}
+			if coverage < minBenchmarkCoverage {
+				lowCoverage = append(lowCoverage, fmt.Sprintf("%s: %.0f%% of %s", series[i], coverage*100, phase))
+			}
 			values[sectionOffset+i*cols+0] = sum
 			values[sectionOffset+i*cols+1] = min
 			values[sectionOffset+i*cols+2] = max
@@ -123,10 +148,31 @@ func (b *BenchmarkData) attemptComputeResults(session Session) bool {
 		values[finalSectionOffset+i*cols+3] -= baseline
 		rs.SummaryWatts = append(rs.SummaryWatts, values[finalSectionOffset+i*cols+3])
 	}
+	rs.Throttling = throttlingBetween(session.Data, b.PreBaselineEnd, b.PostBaselineStart)
+	rs.LowCoverage = lowCoverage
 	b.Results = rs
 	return true
 }
 
+// throttlingBetween returns the names of the throttle series in the dataset that reported
+// throttling at any point in the interval.
+func throttlingBetween(data Dataset, start, end int64) []string {
+	var throttling []string
+	for _, s := range data {
+		if s.Unit() != sensors.Percent {
+			continue
+		}
+		name := strings.TrimSuffix(s.Name(), " ("+s.Unit().String()+")")
+		if !strings.HasSuffix(name, sensors.ThrottleSuffix) {
+			continue
+		}
+		if max, _, _, _, _, ok := s.RatesBetween(start, end); ok && max > 0 {
+			throttling = append(throttling, name)
+		}
+	}
+	return throttling
+}
+
 // computeResults needs some session data to work from (in case the sessionStream channel is drained),
 // but uses the sessionStream channel to determine when to re-attempt becuase new data has arrived.
 func (b *BenchmarkData) computeResults(latestSession Session, sessionStream <-chan Session) {
@@ -157,7 +203,7 @@ func (b *Benchmark) StreamDatasetForBenchmarks(ctx context.Context, benchmarks .
 			session := <-b.ds.StreamSession(subCtx, sessionID)
 			subCancel()
 			for _, benchmark := range benchmarks {
-				for _, series := range session.Data {
+				for _, series := range session.Data.EnergySeries() {
 					ds = append(ds, NewBenchmarkSeriesFrom(series, benchmark))
 				}
 			}
@@ -174,7 +220,11 @@ func randomIDString() string {
 	return strings.ReplaceAll(base64.StdEncoding.EncodeToString(buf[:]), "=", "")
 }
 
-func (b *Benchmark) Run(commandName, notes string, baselineDur time.Duration) (mutation *stream.Mutation[BenchmarkData], isNew bool) {
+// Run benchmarks the given command, measuring baselines of baselineDur before and after it.
+// If burst is set, the sensors sample their fast sensors at high resolution while the
+// command runs, which is useful for commands that finish in a fraction of a second. The
+// benchmark is indexed with the given tags and the commit the command was built from.
+func (b *Benchmark) Run(commandName, notes string, tags []string, baselineDur time.Duration, burst bool) (mutation *stream.Mutation[BenchmarkData], isNew bool) {
 	return stream.Mutate(b.executePool, commandName, func(ctx context.Context) (values <-chan BenchmarkData) {
 		out := make(chan BenchmarkData)
 		go func() {
@@ -188,9 +238,13 @@ func (b *Benchmark) Run(commandName, notes string, baselineDur time.Duration) (m
 				SessionID:        session.ID,
 				BenchmarkID:      randomIDString(),
 				Command:          commandName,
+				Commit:           programCommit(commandName),
+				Tags:             tags,
 				Notes:            notes,
+				Burst:            burst,
 				PreBaselineStart: startTime.UnixNano(),
 			}
+			b.mark(currentData, currentData.PreBaselineStart, "pre-baseline start")
 			timer := time.NewTimer(baselineDur)
 			// Emit pre start time data.
 			select {
@@ -206,15 +260,26 @@ func (b *Benchmark) Run(commandName, notes string, baselineDur time.Duration) (m
 			case <-ctx.Done():
 				return
 			}
+			b.mark(currentData, currentData.PreBaselineEnd, "command start")
 			// Emit pre end time data.
 			select {
 			case out <- currentData:
 			case <-ctx.Done():
 				return
 			}
+			if burst {
+				if err := b.ds.StartBurst(); err != nil {
+					log.Printf("failed starting burst sampling: %v", err)
+				}
+			}
 			err := cmd.Start()
 			currentData.Err = err
 			if err != nil {
+				if burst {
+					if err := b.ds.StopBurst(); err != nil {
+						log.Printf("failed stopping burst sampling: %v", err)
+					}
+				}
 				// Emit start error.
 				select {
 				case out <- currentData:
@@ -225,8 +290,14 @@ func (b *Benchmark) Run(commandName, notes string, baselineDur time.Duration) (m
 				return
 			}
 			currentData.Err = cmd.Wait()
+			if burst {
+				if err := b.ds.StopBurst(); err != nil {
+					log.Printf("failed stopping burst sampling: %v", err)
+				}
+			}
 			// By adding the monotonic interval between now and the start time, we avoid clock skew.
 			currentData.PostBaselineStart = startTime.UnixNano() + time.Since(startTime).Nanoseconds()
+			b.mark(currentData, currentData.PostBaselineStart, "command end")
 			timer.Reset(baselineDur)
 			// Emit post start time data.
 			select {
@@ -241,6 +312,7 @@ func (b *Benchmark) Run(commandName, notes string, baselineDur time.Duration) (m
 			case <-ctx.Done():
 				return
 			}
+			b.mark(currentData, currentData.PostBaselineEnd, "post-baseline end")
 			// Calculate results.
 			subCtx, cancel := context.WithCancel(ctx)
 			defer cancel()
@@ -253,15 +325,14 @@ func (b *Benchmark) Run(commandName, notes string, baselineDur time.Duration) (m
 				return
 			}
 			// We're done.
-			benchFile := benchmarkFileFor(session.ID)
+			benchFile := b.ds.benchmarkPath(session.ID)
 			benchmarkData, err := os.ReadFile(benchFile)
 			priorBenchmarks := []BenchmarkData{}
-			isNewFile := false
-			if errors.Is(err, fs.ErrNotExist) {
-				isNewFile = true
-			} else if err != nil {
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
 				log.Printf("failed opening benchmark file %q: %v", benchFile, err)
-			} else {
+				log.Printf("not writing new benchmark to avoid overwriting previous data")
+				return
+			} else if err == nil {
 				if err := json.Unmarshal(benchmarkData, &priorBenchmarks); err != nil {
 					log.Printf("failed reading benchmark file %q: %v", benchFile, err)
 					newName := benchFile + ".corrupt"
@@ -269,50 +340,77 @@ func (b *Benchmark) Run(commandName, notes string, baselineDur time.Duration) (m
 					if err := os.Rename(benchFile, newName); err != nil {
 						log.Printf("failed renaming corrupt benchmark file %q: %v", benchFile, err)
 					}
+					priorBenchmarks = nil
 				}
 			}
-			newName := benchFile + ".old"
 			priorBenchmarks = append(priorBenchmarks, currentData)
-			if !isNewFile {
-				if err := os.Rename(benchFile, newName); err != nil && !errors.Is(err, fs.ErrNotExist) {
-					log.Printf("failed renaming old benchmark file %q: %v", benchFile, err)
-					log.Printf("not writing new benchmark to avoid overwriting previous data")
-					return
-				}
-			}
 			newJSON, err := json.MarshalIndent(priorBenchmarks, "", "  ")
 			if err != nil {
 				log.Printf("failed marshalling new benchmark data: %v", err)
 				return
 			}
-			exeDir, _ := os.Executable()
-			f, err := os.Create(filepath.Join(filepath.Dir(exeDir), benchFile))
-			if err != nil {
-				log.Printf("failed opening new benchmark data: %v", err)
+			// Replace the file atomically so that a crash can't lose earlier benchmarks.
+			if err := writeFileAtomic(benchFile, newJSON); err != nil {
+				log.Printf("failed writing new benchmark data: %v", err)
 				return
 			}
-			defer func() {
-				err := f.Close()
-				if err != nil {
-					log.Printf("failed closing new benchmark data: %v", err)
-					return
-				}
-				if !isNewFile {
-					if err := os.Remove(newName); err != nil {
-						log.Printf("failed removing old benchmark file %q: %v", newName, err)
-						return
-					}
+			if b.ds.library != nil {
+				if err := b.ds.library.RecordBenchmark(currentData.record()); err != nil {
+					log.Printf("failed indexing benchmark: %v", err)
 				}
-			}()
-			if _, err := f.Write(newJSON); err != nil {
-				log.Printf("failed writing new benchmark data: %v", err)
-				return
 			}
 		}()
 		return out
 	})
 }
 
+// record describes the benchmark and its results for the library's index.
+func (b BenchmarkData) record() BenchmarkRecord {
+	host, _ := os.Hostname()
+	return BenchmarkRecord{
+		ID:            b.BenchmarkID,
+		SessionID:     b.SessionID,
+		Host:          host,
+		Command:       b.Command,
+		Commit:        b.Commit,
+		Tags:          b.Tags,
+		Notes:         b.Notes,
+		Start:         time.Unix(0, b.PreBaselineStart),
+		Duration:      time.Duration(b.PostBaselineEnd - b.PreBaselineStart),
+		Series:        b.Results.Series,
+		SummaryJoules: b.Results.SummaryJoules,
+		SummaryWatts:  b.Results.SummaryWatts,
+		RunDuration:   b.Results.SummaryDuration,
+	}
+}
+
+// programCommit returns the version control revision that a command was built from, or ""
+// if it can't be determined. Go programs record their revision in their build info, and
+// other programs are assumed to be built within the git checkout that holds them.
+func programCommit(commandName string) string {
+	path, err := exec.LookPath(commandName)
+	if err != nil {
+		return ""
+	}
+	if info, err := buildinfo.ReadFile(path); err == nil {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	out, err := exec.Command("git", "-C", filepath.Dir(path), "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// mark labels a phase boundary of a benchmark in the sensors' trace.
+func (b *Benchmark) mark(data BenchmarkData, timestampNS int64, phase string) {
+	b.ds.Mark(timestampNS, "benchmark "+data.BenchmarkID+" "+phase)
+}
+
 func (b *Benchmark) LoadBenchmarks(expl *explorer.Explorer) *stream.Mutation[[]BenchmarkData] {
 	m, _ := stream.Mutate(b.loadPool, struct{}{}, func(ctx context.Context) (values <-chan []BenchmarkData) {
 		out := make(chan []BenchmarkData)
@@ -343,6 +441,9 @@ func (b *Benchmark) LoadBenchmarks(expl *explorer.Explorer) *stream.Mutation[[]B
 			for sessionID, relevantBenchmarks := range sessions {
 				filename := sessionFileFor(sessionID)
 				sessionFile, err := os.Open(filepath.Join(basepath, filename))
+				if errors.Is(err, fs.ErrNotExist) && b.ds.library != nil {
+					sessionFile, err = os.Open(b.ds.library.SessionPath(sessionID))
+				}
 				if err != nil {
 					log.Printf("failed opening session file %q: %v", filename, err)
 					continue
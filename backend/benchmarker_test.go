@@ -0,0 +1,59 @@
+package backend
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestThrottlingBetween(t *testing.T) {
+	const trace = `sample start (ns), sample end (ns), gpu (W), gpu power throttled (%), gpu thermal throttled (%), gpu utilization (%), 
+0, 10, 100, 0, 0, 90, 
+10, 20, 150, 100, 0, 95, 
+20, 30, 120, 0, 0, 90, 
+`
+	ds, err := LoadTrace(strings.NewReader(trace))
+	if err != nil {
+		t.Fatalf("failed loading trace: %v", err)
+	}
+	type testcase struct {
+		name       string
+		start, end int64
+		expected   []string
+	}
+	for _, tc := range []testcase{
+		{name: "before throttling", start: 0, end: 10},
+		{name: "during throttling", start: 5, end: 25, expected: []string{"gpu power throttled"}},
+		{name: "after throttling", start: 20, end: 30},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			throttling := throttlingBetween(ds, tc.start, tc.end)
+			if !slices.Equal(throttling, tc.expected) {
+				t.Errorf("expected throttling %q, got %q", tc.expected, throttling)
+			}
+		})
+	}
+}
+
+func TestBenchmarkCoverage(t *testing.T) {
+	// The cpu series has no data from 20 to 40, which is most of the run.
+	const trace = `sample start (ns), sample end (ns), cpu (J), gpu (J), 
+0, 10, 1, 2, 
+10, 20, 1, 2, 
+20, 40, , 2, 
+40, 50, 1, 2, 
+50, 60, 1, 2, 
+`
+	ds, err := LoadTrace(strings.NewReader(trace))
+	if err != nil {
+		t.Fatalf("failed loading trace: %v", err)
+	}
+	bd := BenchmarkData{PreBaselineStart: 0, PreBaselineEnd: 20, PostBaselineStart: 50, PostBaselineEnd: 60}
+	if !bd.attemptComputeResults(Session{Data: ds, Mode: ModeReplaying, Loaded: true}) {
+		t.Fatalf("expected results to be computed")
+	}
+	expected := []string{"cpu (J): 33% of run"}
+	if !slices.Equal(bd.Results.LowCoverage, expected) {
+		t.Errorf("expected low coverage %q, got %q", expected, bd.Results.LowCoverage)
+	}
+}
@@ -0,0 +1,399 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+// EstimatedWallName is the name of the series synthesized from a calibration.
+const EstimatedWallName = "estimated wall (W)"
+
+type CalibrationModel string
+
+const (
+	// LinearModel estimates wall power as a weighted sum of every component's power.
+	LinearModel CalibrationModel = "linear"
+	// PiecewiseModel estimates wall power from the total power of the components, allowing
+	// the slope to change at each knot. This captures power supplies that are less
+	// efficient at low loads.
+	PiecewiseModel CalibrationModel = "piecewise"
+)
+
+// Calibration models the wall power of a machine as a function of the power reported by
+// its component sensors.
+type Calibration struct {
+	Hostname string
+	Created  time.Time
+	Model    CalibrationModel
+	// Reference is the name of the series the model was fit to.
+	Reference string
+	// Components are the names of the series the model takes as input.
+	Components []string
+	// Intercept is the estimated wall power in Watts when every component draws nothing.
+	Intercept float64
+	// Coefficients holds one weight per component for linear models. For piecewise models,
+	// it holds the slope against the components' total power followed by the change in
+	// slope at each knot.
+	Coefficients []float64
+	// Knots are the total component power in Watts at which the slope of a piecewise model
+	// changes.
+	Knots []float64 `json:",omitempty"`
+	// RMSError is the root-mean-square error in Watts of the model over the data it was
+	// fit to.
+	RMSError float64
+}
+
+// Estimate returns the modeled wall power given the power drawn by each component, in the
+// same order as c.Components.
+func (c Calibration) Estimate(componentWatts []float64) float64 {
+	features := c.features(componentWatts)
+	estimate := c.Intercept
+	for i, f := range features {
+		estimate += c.Coefficients[i] * f
+	}
+	return estimate
+}
+
+// features returns the model inputs (excluding the intercept) for the given component
+// power readings.
+func (c Calibration) features(componentWatts []float64) []float64 {
+	if c.Model != PiecewiseModel {
+		return componentWatts
+	}
+	total := 0.0
+	for _, w := range componentWatts {
+		total += w
+	}
+	features := make([]float64, 0, len(c.Knots)+1)
+	features = append(features, total)
+	for _, knot := range c.Knots {
+		features = append(features, max(total-knot, 0))
+	}
+	return features
+}
+
+// FitCalibration fits a model estimating the reference series from the component series.
+// Each series is averaged over consecutive windows of the given duration (in nanoseconds)
+// wherever all of them have data, and the model is fit to those averages by least squares.
+// knots sets the number of slope changes in a piecewise model, and is ignored by linear
+// models.
+func FitCalibration(reference DataSeries, components []DataSeries, model CalibrationModel, knots int, window int64) (Calibration, error) {
+	if len(components) == 0 {
+		return Calibration{}, fmt.Errorf("no component series to calibrate")
+	}
+	if window <= 0 {
+		return Calibration{}, fmt.Errorf("invalid window %d", window)
+	}
+	start, end := reference.Domain()
+	for _, s := range components {
+		sStart, sEnd := s.Domain()
+		start = max(start, sStart)
+		end = min(end, sEnd)
+	}
+	var (
+		targets []float64
+		inputs  [][]float64
+	)
+windows:
+	for t := start; t+window <= end; t += window {
+		_, target, _, _, _, ok := reference.RatesBetween(t, t+window)
+		if !ok {
+			continue
+		}
+		input := make([]float64, len(components))
+		for i, s := range components {
+			_, mean, _, _, _, ok := s.RatesBetween(t, t+window)
+			if !ok {
+				continue windows
+			}
+			input[i] = mean
+		}
+		targets = append(targets, target)
+		inputs = append(inputs, input)
+	}
+
+	cal := Calibration{
+		Model:     model,
+		Reference: reference.Name(),
+	}
+	hostname, err := os.Hostname()
+	if err == nil {
+		cal.Hostname = hostname
+	}
+	cal.Created = time.Now()
+	for _, s := range components {
+		cal.Components = append(cal.Components, s.Name())
+	}
+	switch model {
+	case LinearModel:
+	case PiecewiseModel:
+		cal.Knots = quantileKnots(inputs, knots)
+	default:
+		return Calibration{}, fmt.Errorf("unknown calibration model %q", model)
+	}
+
+	rows := make([][]float64, len(inputs))
+	for i, input := range inputs {
+		rows[i] = append([]float64{1}, cal.features(input)...)
+	}
+	if len(rows) < len(rows[0]) {
+		return Calibration{}, fmt.Errorf("need at least %d windows of overlapping data to fit, have %d", len(rows[0]), len(rows))
+	}
+	solution, err := leastSquares(rows, targets)
+	if err != nil {
+		return Calibration{}, fmt.Errorf("failed fitting calibration: %w", err)
+	}
+	cal.Intercept = solution[0]
+	cal.Coefficients = solution[1:]
+
+	squaredError := 0.0
+	for i, input := range inputs {
+		residual := cal.Estimate(input) - targets[i]
+		squaredError += residual * residual
+	}
+	cal.RMSError = math.Sqrt(squaredError / float64(len(inputs)))
+	return cal, nil
+}
+
+// quantileKnots places count knots at evenly spaced quantiles of the total power of the
+// inputs, skipping duplicates.
+func quantileKnots(inputs [][]float64, count int) []float64 {
+	totals := make([]float64, len(inputs))
+	for i, input := range inputs {
+		for _, w := range input {
+			totals[i] += w
+		}
+	}
+	slices.Sort(totals)
+	var knots []float64
+	for k := 1; k <= count && len(totals) > 0; k++ {
+		knot := totals[k*(len(totals)-1)/(count+1)]
+		if len(knots) == 0 || knot > knots[len(knots)-1] {
+			knots = append(knots, knot)
+		}
+	}
+	return knots
+}
+
+// leastSquares returns the x minimizing |rows·x - targets|² by solving the normal
+// equations. The first column of rows is assumed to be the intercept. A tiny ridge penalty
+// on the other columns keeps the system solvable when components are redundant, like a
+// package series and the core series it contains.
+func leastSquares(rows [][]float64, targets []float64) ([]float64, error) {
+	n := len(rows[0])
+	// Build the augmented matrix [AᵀA | Aᵀb].
+	m := make([][]float64, n)
+	for i := range m {
+		m[i] = make([]float64, n+1)
+	}
+	for r, row := range rows {
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				m[i][j] += row[i] * row[j]
+			}
+			m[i][n] += row[i] * targets[r]
+		}
+	}
+	for i := 1; i < n; i++ {
+		m[i][i] += 1e-9 * max(m[i][i], 1)
+	}
+	// Gaussian elimination with partial pivoting.
+	for col := 0; col < n; col++ {
+		pivot := col
+		for r := col + 1; r < n; r++ {
+			if math.Abs(m[r][col]) > math.Abs(m[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(m[pivot][col]) < 1e-12 {
+			return nil, errors.New("inputs do not vary enough to fit a model")
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		for r := col + 1; r < n; r++ {
+			factor := m[r][col] / m[col][col]
+			for c := col; c <= n; c++ {
+				m[r][c] -= factor * m[col][c]
+			}
+		}
+	}
+	solution := make([]float64, n)
+	for i := n - 1; i >= 0; i-- {
+		sum := m[i][n]
+		for j := i + 1; j < n; j++ {
+			sum -= m[i][j] * solution[j]
+		}
+		solution[i] = sum / m[i][i]
+	}
+	return solution, nil
+}
+
+// CalibrationPath returns the path of this machine's calibration file.
+func CalibrationPath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed locating config dir: %w", err)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("failed resolving hostname: %w", err)
+	}
+	return filepath.Join(configDir, "watt-wiser", "calibration-"+hostname+".json"), nil
+}
+
+// LoadCalibration reads a calibration file.
+func LoadCalibration(path string) (Calibration, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Calibration{}, err
+	}
+	var cal Calibration
+	if err := json.Unmarshal(data, &cal); err != nil {
+		return Calibration{}, fmt.Errorf("failed decoding calibration %q: %w", path, err)
+	}
+	wantCoefficients := len(cal.Components)
+	if cal.Model == PiecewiseModel {
+		wantCoefficients = len(cal.Knots) + 1
+	}
+	if len(cal.Coefficients) != wantCoefficients {
+		return Calibration{}, fmt.Errorf("calibration %q has %d coefficients, expected %d", path, len(cal.Coefficients), wantCoefficients)
+	}
+	return cal, nil
+}
+
+// SaveCalibration writes a calibration file, creating its directory if necessary.
+func SaveCalibration(path string, cal Calibration) error {
+	data, err := json.MarshalIndent(cal, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed encoding calibration: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed creating calibration dir: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed writing calibration: %w", err)
+	}
+	return nil
+}
+
+// CalibratedSeries estimates wall power by applying a calibration to the component series
+// it was fit against.
+type CalibratedSeries struct {
+	cal        Calibration
+	components []DataSeries
+}
+
+var _ DataSeries = (*CalibratedSeries)(nil)
+
+// NewCalibratedSeries applies the calibration to the matching series of the dataset. It
+// returns false if any of the calibration's components are missing.
+func NewCalibratedSeries(cal Calibration, ds Dataset) (*CalibratedSeries, bool) {
+	components := make([]DataSeries, 0, len(cal.Components))
+	for _, name := range cal.Components {
+		idx := slices.IndexFunc(ds, func(s DataSeries) bool {
+			return s.Name() == name
+		})
+		if idx < 0 {
+			return nil, false
+		}
+		components = append(components, ds[idx])
+	}
+	return &CalibratedSeries{cal: cal, components: components}, true
+}
+
+func (c *CalibratedSeries) inputSeries() []DataSeries {
+	return c.components
+}
+
+func (c *CalibratedSeries) Name() string {
+	return EstimatedWallName
+}
+
+func (c *CalibratedSeries) Unit() sensors.Unit {
+	return sensors.Watts
+}
+
+func (c *CalibratedSeries) Initialized() bool {
+	for _, s := range c.components {
+		if !s.Initialized() {
+			return false
+		}
+	}
+	return true
+}
+
+// Domain returns the interval in which every component has data.
+func (c *CalibratedSeries) Domain() (min, max int64) {
+	for i, s := range c.components {
+		sMin, sMax := s.Domain()
+		if i == 0 {
+			min, max = sMin, sMax
+			continue
+		}
+		min = maxOf(min, sMin)
+		max = minOf(max, sMax)
+	}
+	return min, max
+}
+
+// RatesBetween estimates the mean wall power from the components' means. The extrema are
+// estimated from the components' extrema, which assumes that the components peak together.
+// The coverage is that of the least covered component.
+func (c *CalibratedSeries) RatesBetween(timestampA, timestampB int64) (maximum, mean, minimum, sum, coverage float64, ok bool) {
+	maxes := make([]float64, len(c.components))
+	means := make([]float64, len(c.components))
+	mins := make([]float64, len(c.components))
+	coverage = 1
+	for i, s := range c.components {
+		var componentCoverage float64
+		maxes[i], means[i], mins[i], _, componentCoverage, ok = s.RatesBetween(timestampA, timestampB)
+		if !ok {
+			return 0, 0, 0, 0, 0, false
+		}
+		coverage = min(coverage, componentCoverage)
+	}
+	if coverage == 0 {
+		return 0, 0, 0, 0, 0, true
+	}
+	mean = c.cal.Estimate(means)
+	low, high := c.cal.Estimate(mins), c.cal.Estimate(maxes)
+	minimum, maximum = minOf(low, high, mean), maxOf(low, high, mean)
+	interval := timestampB - timestampA
+	if interval < 0 {
+		interval = -interval
+	}
+	sum = mean * float64(interval) * coverage / 1_000_000_000
+	return maximum, mean, minimum, sum, coverage, true
+}
+
+func (c *CalibratedSeries) Sum() float64 {
+	start, end := c.Domain()
+	_, _, _, sum, _, _ := c.RatesBetween(start, end)
+	return sum
+}
+
+func (c *CalibratedSeries) RateRange() (min, max float64) {
+	mins := make([]float64, len(c.components))
+	maxes := make([]float64, len(c.components))
+	for i, s := range c.components {
+		mins[i], maxes[i] = s.RateRange()
+	}
+	low, high := c.cal.Estimate(mins), c.cal.Estimate(maxes)
+	return minOf(low, high), maxOf(low, high)
+}
+
+// minOf and maxOf avoid shadowing by the named results of DataSeries methods.
+func minOf[T int64 | float64](values ...T) T {
+	return slices.Min(values)
+}
+
+func maxOf[T int64 | float64](values ...T) T {
+	return slices.Max(values)
+}
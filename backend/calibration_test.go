@@ -0,0 +1,136 @@
+package backend
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+// calibrationTrace generates a trace in which the wall power is a known function of a
+// package energy counter and a GPU power level.
+func calibrationTrace(wall func(pkg, gpu float64) float64) string {
+	var b strings.Builder
+	b.WriteString("sample start (ns), sample end (ns), package-0 (J), gpu (W), wall (W), \n")
+	const second = 1_000_000_000
+	for i := 0; i < 60; i++ {
+		pkg := 5 + float64(i%7)*4
+		gpu := 10 + float64(i%5)*15
+		fmt.Fprintf(&b, "%d, %d, %f, %f, %f, \n", i*second, (i+1)*second, pkg, gpu, wall(pkg, gpu))
+	}
+	return b.String()
+}
+
+func findSeries(t *testing.T, ds Dataset, name string) DataSeries {
+	t.Helper()
+	for _, s := range ds {
+		if s.Name() == name {
+			return s
+		}
+	}
+	t.Fatalf("expected series %q in dataset", name)
+	return nil
+}
+
+func TestFitCalibration(t *testing.T) {
+	type testcase struct {
+		name  string
+		model CalibrationModel
+		knots int
+		// tolerance is the acceptable estimation error in Watts. Piecewise knots are placed
+		// at quantiles of the data rather than where the true slope changes, so they cannot
+		// fit exactly.
+		tolerance float64
+		wall      func(pkg, gpu float64) float64
+		samples   [][2]float64
+	}
+	for _, tc := range []testcase{
+		{
+			name:      "linear",
+			model:     LinearModel,
+			tolerance: 0.01,
+			wall:      func(pkg, gpu float64) float64 { return 20 + 1.1*pkg + 1.2*gpu },
+			samples:   [][2]float64{{5, 10}, {29, 70}, {17, 40}},
+		},
+		{
+			name:      "piecewise",
+			model:     PiecewiseModel,
+			knots:     1,
+			tolerance: 0.5,
+			wall: func(pkg, gpu float64) float64 {
+				// Power supply efficiency improves above 50W.
+				total := pkg + gpu
+				return 15 + 1.3*total - 0.2*max(total-50, 0)
+			},
+			samples: [][2]float64{{5, 10}, {29, 70}, {25, 25}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ds, err := LoadTrace(strings.NewReader(calibrationTrace(tc.wall)))
+			if err != nil {
+				t.Fatalf("failed loading trace: %v", err)
+			}
+			reference := findSeries(t, ds, "wall (W)")
+			components := []DataSeries{findSeries(t, ds, "package-0 (J)"), findSeries(t, ds, "gpu (W)")}
+			cal, err := FitCalibration(reference, components, tc.model, tc.knots, 1_000_000_000)
+			if err != nil {
+				t.Fatalf("failed fitting: %v", err)
+			}
+			if cal.RMSError > tc.tolerance {
+				t.Errorf("expected RMS error below %f, got %f", tc.tolerance, cal.RMSError)
+			}
+			if tc.model != LinearModel {
+				linear, err := FitCalibration(reference, components, LinearModel, 0, 1_000_000_000)
+				if err != nil {
+					t.Fatalf("failed fitting linear model: %v", err)
+				}
+				if linear.RMSError <= cal.RMSError {
+					t.Errorf("expected %s model to fit better than linear model (RMS %f), got RMS %f", tc.model, linear.RMSError, cal.RMSError)
+				}
+			}
+			for _, sample := range tc.samples {
+				expected := tc.wall(sample[0], sample[1])
+				if got := cal.Estimate(sample[:]); math.Abs(got-expected) > tc.tolerance {
+					t.Errorf("expected estimate %f for %v, got %f", expected, sample, got)
+				}
+			}
+
+			path := filepath.Join(t.TempDir(), "calibration.json")
+			if err := SaveCalibration(path, cal); err != nil {
+				t.Fatalf("failed saving: %v", err)
+			}
+			loaded, err := LoadCalibration(path)
+			if err != nil {
+				t.Fatalf("failed loading: %v", err)
+			}
+
+			estimate, ok := NewCalibratedSeries(loaded, ds)
+			if !ok {
+				t.Fatalf("expected calibration to apply to its own dataset")
+			}
+			// The second sample of the trace has pkg=9J and gpu=25W.
+			_, mean, _, _, _, ok := estimate.RatesBetween(1_000_000_000, 2_000_000_000)
+			if !ok {
+				t.Fatalf("expected estimate to have data")
+			}
+			if expected := tc.wall(9, 25); math.Abs(mean-expected) > tc.tolerance {
+				t.Errorf("expected estimated wall of %f, got %f", expected, mean)
+			}
+		})
+	}
+}
+
+func TestCalibratedSeriesMissingComponent(t *testing.T) {
+	cal := Calibration{
+		Model:        LinearModel,
+		Components:   []string{"package-0 (J)", "dram (J)"},
+		Coefficients: []float64{1, 1},
+	}
+	ds := Dataset{NewSeries("package-0 (J)", sensors.Joules)}
+	if _, ok := NewCalibratedSeries(cal, ds); ok {
+		t.Errorf("expected calibration with a missing component not to apply")
+	}
+}
@@ -0,0 +1,150 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/control"
+)
+
+// sensorsControl sends control requests to the sensors launched by LaunchSensors. It
+// connects to the sensors once when they are launched, and fails requests immediately once
+// that connection is down, so that callers like benchmarks are never held up retrying.
+type sensorsControl struct {
+	mu sync.Mutex
+	// ready is closed once connecting to the latest sensors has succeeded or failed, and
+	// is nil if no sensors have been launched.
+	ready  chan struct{}
+	client *control.Client
+	// err is why there is no connection once ready is closed.
+	err error
+	// queue holds the requests posted without waiting for their responses.
+	queue chan control.Request
+}
+
+// controlDialAttempts and controlDialInterval bound how long to wait for newly launched
+// sensors to start listening for control requests.
+const (
+	controlDialAttempts = 20
+	controlDialInterval = 100 * time.Millisecond
+)
+
+// connect starts connecting to the control socket at path, to which future requests are
+// sent.
+func (s *sensorsControl) connect(path string) {
+	ready := make(chan struct{})
+	s.mu.Lock()
+	if s.client != nil {
+		s.client.Close()
+		s.client = nil
+	}
+	s.ready = ready
+	s.mu.Unlock()
+	go func() {
+		defer close(ready)
+		var client *control.Client
+		var err error
+		for i := 0; i < controlDialAttempts; i++ {
+			if client, err = control.Dial(path); err == nil {
+				break
+			}
+			time.Sleep(controlDialInterval)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.ready != ready {
+			// Newer sensors were launched while connecting.
+			if client != nil {
+				client.Close()
+			}
+			return
+		}
+		s.client, s.err = client, err
+	}()
+}
+
+// send sends a request to the sensors and waits for the response. It only waits to connect
+// while the sensors are starting.
+func (s *sensorsControl) send(req control.Request) (control.Response, error) {
+	s.mu.Lock()
+	ready := s.ready
+	s.mu.Unlock()
+	if ready == nil {
+		return control.Response{}, fmt.Errorf("sensors are not running")
+	}
+	<-ready
+	s.mu.Lock()
+	client, err := s.client, s.err
+	s.mu.Unlock()
+	if client == nil {
+		return control.Response{}, fmt.Errorf("not connected to sensors: %w", err)
+	}
+	resp, err := client.Send(req)
+	if err != nil && !errors.Is(err, control.ErrRejected) {
+		// The connection is broken, most likely because the sensors exited.
+		s.mu.Lock()
+		if s.client == client {
+			s.client.Close()
+			s.client, s.err = nil, err
+		}
+		s.mu.Unlock()
+	}
+	return resp, err
+}
+
+// post sends a request to the sensors in the background, logging any failure. Posted
+// requests are sent in order.
+func (s *sensorsControl) post(req control.Request) {
+	s.mu.Lock()
+	if s.queue == nil {
+		s.queue = make(chan control.Request, 64)
+		go func(queue <-chan control.Request) {
+			for req := range queue {
+				if _, err := s.send(req); err != nil {
+					log.Printf("failed sending %s to sensors: %v", req.Command, err)
+				}
+			}
+		}(s.queue)
+	}
+	queue := s.queue
+	s.mu.Unlock()
+	select {
+	case queue <- req:
+	default:
+		log.Printf("dropping %s request, since the sensors aren't keeping up", req.Command)
+	}
+}
+
+// PauseSensors stops the sensors from taking readings until ResumeSensors is called.
+func (d *Datasource) PauseSensors() error {
+	_, err := d.sensors.send(control.Request{Command: control.CommandPause})
+	return err
+}
+
+// ResumeSensors resumes taking readings after PauseSensors.
+func (d *Datasource) ResumeSensors() error {
+	_, err := d.sensors.send(control.Request{Command: control.CommandResume})
+	return err
+}
+
+// Mark records a label at the given time in the trace of the running sensors. The mark is
+// sent in the background so that the caller isn't delayed, and failures are logged.
+func (d *Datasource) Mark(timestampNS int64, label string) {
+	d.sensors.post(control.Request{Command: control.CommandMark, Timestamp: timestampNS, Label: label})
+}
+
+// StartBurst asks the sensors to sample their fast sensors at high resolution until
+// StopBurst is called or their maximum burst duration elapses.
+func (d *Datasource) StartBurst() error {
+	_, err := d.sensors.send(control.Request{Command: control.CommandStartBurst})
+	return err
+}
+
+// StopBurst ends a burst started by StartBurst.
+func (d *Datasource) StopBurst() error {
+	_, err := d.sensors.send(control.Request{Command: control.CommandStopBurst})
+	return err
+}
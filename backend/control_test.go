@@ -0,0 +1,80 @@
+package backend
+
+import (
+	"net"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/control"
+)
+
+func TestSensorsControl(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+	var s sensorsControl
+	if _, err := s.send(control.Request{Command: control.CommandPause}); err == nil {
+		t.Errorf("expected requests to fail before sensors are launched")
+	}
+	// Connect before the sensors listen, as LaunchSensors does.
+	s.connect(path)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("failed listening: %v", err)
+	}
+	defer listener.Close()
+	var mu sync.Mutex
+	var labels []string
+	marked := make(chan struct{}, 16)
+	conns := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		conns <- conn
+		control.Serve(conn, conn, func(req control.Request) control.Response {
+			if req.Command == control.CommandMark {
+				mu.Lock()
+				labels = append(labels, req.Label)
+				mu.Unlock()
+				marked <- struct{}{}
+			}
+			return control.Response{}
+		})
+	}()
+
+	if _, err := s.send(control.Request{Command: control.CommandPause}); err != nil {
+		t.Fatalf("expected pause to succeed, got %v", err)
+	}
+	for _, label := range []string{"a", "b", "c"} {
+		s.post(control.Request{Command: control.CommandMark, Label: label})
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-marked:
+		case <-time.After(time.Second):
+			t.Fatalf("expected posted marks to be sent")
+		}
+	}
+	mu.Lock()
+	if expected := []string{"a", "b", "c"}; !slices.Equal(labels, expected) {
+		t.Errorf("expected marks %v in order, got %v", expected, labels)
+	}
+	mu.Unlock()
+
+	// Once the sensors go away, requests fail without trying to reconnect.
+	(<-conns).Close()
+	listener.Close()
+	if _, err := s.send(control.Request{Command: control.CommandResume}); err == nil {
+		t.Errorf("expected resume to fail once the sensors are gone")
+	}
+	start := time.Now()
+	if _, err := s.send(control.Request{Command: control.CommandResume}); err == nil {
+		t.Errorf("expected resume to fail once the sensors are gone")
+	}
+	if elapsed := time.Since(start); elapsed >= controlDialInterval {
+		t.Errorf("expected a request to fail fast once the connection is down, took %v", elapsed)
+	}
+}
@@ -1,10 +1,13 @@
 package backend
 
+import "git.sr.ht/~whereswaldon/watt-wiser/sensors"
+
 type DataSeries interface {
 	Name() string
+	Unit() sensors.Unit
 	Initialized() bool
 	Domain() (min int64, max int64)
-	RatesBetween(timestampA, timestampB int64) (maximum, mean, minimum, sum float64, ok bool)
+	RatesBetween(timestampA, timestampB int64) (maximum, mean, minimum, sum, coverage float64, ok bool)
 	Sum() float64
 	RateRange() (min float64, max float64)
 }
@@ -40,3 +43,14 @@ func (d Dataset) Headings() []string {
 	}
 	return out
 }
+
+// EnergySeries returns only the series that describe energy consumption.
+func (d Dataset) EnergySeries() Dataset {
+	out := make(Dataset, 0, len(d))
+	for _, s := range d {
+		if s.Unit().IsEnergy() {
+			out = append(out, s)
+		}
+	}
+	return out
+}
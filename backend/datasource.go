@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"os/exec"
@@ -32,6 +33,17 @@ type Session struct {
 	// that all sample data is available for the session.
 	Loaded bool
 	Err    error
+	// Marks label moments in the session, like the phases of a benchmark.
+	Marks []Mark
+	// Recovery describes the partial row discarded from the end of a replayed trace, which
+	// a crash while recording it may leave. Its Discarded field is zero if there was none.
+	Recovery Recovery
+}
+
+// Mark labels a moment in a trace.
+type Mark struct {
+	TimestampNS int64
+	Label       string
 }
 
 type RWBox[T any] struct {
@@ -56,6 +68,10 @@ type InputKind uint8
 const (
 	KindSample InputKind = iota
 	KindHeadings
+	KindMark
+	KindWindow
+	KindContainment
+	KindRecovery
 )
 
 type InputData struct {
@@ -63,6 +79,25 @@ type InputData struct {
 	Sample
 	Headings      []string
 	HeadingSeries []int
+	HeadingUnits  []sensors.Unit
+	Mark          Mark
+	Window        AveragingWindow
+	Containment   Containment
+	Recovery      Recovery
+}
+
+// AveragingWindow records that every reading of a series is a trailing average over a
+// window of time rather than an instantaneous value.
+type AveragingWindow struct {
+	Series   int
+	Duration time.Duration
+}
+
+// Containment records that the readings of a series are part of another series' readings.
+type Containment struct {
+	Series int
+	// Container is the heading of the series containing it.
+	Container string
 }
 
 type Sample struct {
@@ -85,6 +120,32 @@ type Datasource struct {
 	watcher       *fsnotify.Watcher
 	appCtx        context.Context
 	seriesCounter atomic.Int32
+	// calibration, if non-nil, is applied to every session containing its components.
+	calibration *Calibration
+	// sensors controls the sensors launched by LaunchSensors.
+	sensors sensorsControl
+	// storage bounds the memory used by the series of new sessions.
+	storage StorageOptions
+	// interpolation integrates the instantaneous power readings of new sessions.
+	interpolation Interpolation
+	// derivations are added to every session containing the series they use.
+	derivations RWBox[derivationSet]
+	// library holds recorded sessions and benchmarks, and is nil if there is no data
+	// directory, in which case they are written next to the executable.
+	library *Library
+	// notes are stored with every recorded session.
+	notes string
+	// durability chooses how often recorded sessions are written out.
+	durability DurabilityOptions
+	// auxiliary makes LaunchSensors record every auxiliary sensor rather than only those
+	// reporting throttling.
+	auxiliary bool
+}
+
+type derivationSet struct {
+	defs []Derivation
+	// changed is closed and replaced whenever a derivation is added.
+	changed chan struct{}
 }
 
 func NewDatasource(appCtx context.Context, mutator *stream.Mutator) (*Datasource, error) {
@@ -93,13 +154,209 @@ func NewDatasource(appCtx context.Context, mutator *stream.Mutator) (*Datasource
 		return nil, fmt.Errorf("failed creating file watcher: %w", err)
 	}
 	ds := &Datasource{
-		pool:    stream.NewMutationPool[string, Session](mutator),
-		watcher: watcher,
-		appCtx:  appCtx,
+		pool:       stream.NewMutationPool[string, Session](mutator),
+		watcher:    watcher,
+		appCtx:     appCtx,
+		durability: DefaultDurability,
+	}
+	ds.derivations.t.changed = make(chan struct{})
+	if dir, err := DataDir(); err != nil {
+		log.Printf("failed locating data dir: %v", err)
+	} else if ds.library, err = OpenLibrary(dir); err != nil {
+		log.Printf("failed opening data dir: %v", err)
+	}
+	if path, err := CalibrationPath(); err != nil {
+		log.Printf("failed locating calibration: %v", err)
+	} else if cal, err := LoadCalibration(path); err == nil {
+		ds.calibration = &cal
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("failed loading calibration: %v", err)
 	}
 	return ds, nil
 }
 
+// SetStorage bounds the memory used by the series of sessions loaded or recorded after it
+// is called. The retention policy only applies to live sessions.
+func (d *Datasource) SetStorage(opts StorageOptions) {
+	d.storage = opts
+}
+
+// SetInterpolation chooses how the instantaneous power readings of sessions loaded or
+// recorded after it is called are integrated. Readings that are averaged over a known
+// window are always held over the interval before them.
+func (d *Datasource) SetInterpolation(mode Interpolation) {
+	d.interpolation = mode
+}
+
+// SetDurability chooses how often sessions recorded after it is called are written out and
+// synced to disk.
+func (d *Datasource) SetDurability(opts DurabilityOptions) {
+	d.durability = opts
+}
+
+// SetDataDir chooses the directory in which sessions recorded after it is called, and their
+// benchmarks, are stored.
+func (d *Datasource) SetDataDir(dir string) error {
+	library, err := OpenLibrary(dir)
+	if err != nil {
+		return err
+	}
+	d.library = library
+	return nil
+}
+
+// SetAuxiliary chooses whether sensors launched after it is called record temperatures, fan
+// speeds, clocks, and utilization alongside energy data. Throttling is recorded either way,
+// so that benchmarks can flag throttled runs.
+func (d *Datasource) SetAuxiliary(auxiliary bool) {
+	d.auxiliary = auxiliary
+}
+
+// SetNotes chooses the notes stored in the index with sessions recorded after it is called.
+func (d *Datasource) SetNotes(notes string) {
+	d.notes = notes
+}
+
+// Library returns the library of recorded sessions, or nil if there is no data directory.
+func (d *Datasource) Library() *Library {
+	return d.library
+}
+
+// StreamIndex emits the index of the library once it has been read.
+func (d *Datasource) StreamIndex(ctx context.Context) <-chan Index {
+	out := make(chan Index, 1)
+	go func() {
+		defer close(out)
+		if d.library == nil {
+			return
+		}
+		index, err := d.library.Index()
+		if err != nil {
+			log.Printf("failed reading session index: %v", err)
+		}
+		select {
+		case out <- index:
+		case <-ctx.Done():
+		}
+	}()
+	return out
+}
+
+// LoadFromLibrary replays a session recorded in the library, first removing any partial row
+// that a crash while recording it left at its end. The session reports what was removed.
+func (d *Datasource) LoadFromLibrary(sessionID string) (string, *stream.Mutation[Session], error) {
+	if d.library == nil {
+		return "", nil, fmt.Errorf("no data dir to load session %s from", sessionID)
+	}
+	recovery, err := RecoverTrace(d.library.SessionPath(sessionID))
+	if errors.Is(err, ErrRecording) {
+		// Another instance is still appending to the session, so it is replayed as far as
+		// it has been written without truncating it.
+		log.Printf("not recovering session %s: %v", sessionID, err)
+	} else if err != nil {
+		log.Printf("failed recovering session %s: %v", sessionID, err)
+	} else if recovery.Discarded > 0 {
+		log.Printf("recovered session %s: %s", sessionID, recovery)
+		if err := d.library.Resize(sessionID, recovery.Size); err != nil {
+			log.Printf("failed indexing recovered session %s: %v", sessionID, err)
+		}
+	}
+	f, err := os.Open(d.library.SessionPath(sessionID))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed opening session %s: %w", sessionID, err)
+	}
+	id, m := d.LoadFromStream(ModeReplaying, &recoveredFile{File: f, recovery: recovery})
+	return id, m, nil
+}
+
+// recoveredFile is a trace whose partial last row was removed before it was opened, which
+// is reported when it is read like a partial row found while reading it.
+type recoveredFile struct {
+	*os.File
+	recovery Recovery
+}
+
+// sessionPath returns the path that a session's trace is recorded into.
+func (d *Datasource) sessionPath(sessionID string) string {
+	if d.library != nil {
+		return d.library.SessionPath(sessionID)
+	}
+	exePath, _ := os.Executable()
+	return filepath.Join(filepath.Dir(exePath), sessionFileFor(sessionID))
+}
+
+// benchmarkPath returns the path that a session's benchmarks are recorded into.
+func (d *Datasource) benchmarkPath(sessionID string) string {
+	if d.library != nil {
+		return d.library.BenchmarkPath(sessionID)
+	}
+	exePath, _ := os.Executable()
+	return filepath.Join(filepath.Dir(exePath), benchmarkFileFor(sessionID))
+}
+
+// AddDerivation adds a derived series to every session, including those already loaded or
+// recording, as soon as the session contains the series it uses.
+func (d *Datasource) AddDerivation(def Derivation) {
+	d.derivations.Write(func(set *derivationSet) {
+		set.defs = append(set.defs, def)
+		if set.changed != nil {
+			close(set.changed)
+		}
+		set.changed = make(chan struct{})
+	})
+}
+
+// currentDerivations returns the derivations so far, and a channel that is closed when one
+// is added.
+func (d *Datasource) currentDerivations() (defs []Derivation, changed <-chan struct{}) {
+	d.derivations.Read(func(set *derivationSet) {
+		defs, changed = set.defs, set.changed
+	})
+	return defs, changed
+}
+
+// applyContainment records which series contains a series of a dataset.
+func applyContainment(data Dataset, seriesIDToSeries map[int]int, containment Containment) {
+	idx, ok := seriesIDToSeries[containment.Series]
+	if !ok {
+		return
+	}
+	if series, ok := data[idx].(containedSeries); ok {
+		series.SetContainer(containment.Container)
+	}
+}
+
+// newSeries creates a series for a session in the given mode.
+func (d *Datasource) newSeries(name string, unit sensors.Unit, mode Mode) WritableDataSeries {
+	opts := d.storage
+	if mode != ModeSensing {
+		opts.Retention = 0
+	}
+	var series interface {
+		WritableDataSeries
+		interpolatingSeries
+	}
+	if !opts.Bounded() {
+		series = NewSeries(name, unit)
+	} else {
+		series = NewBlockSeries(name, unit, opts)
+	}
+	series.SetInterpolation(d.interpolation, 0)
+	return series
+}
+
+// applyWindow corrects the series of a dataset for an averaging window.
+func applyWindow(data Dataset, seriesIDToSeries map[int]int, window AveragingWindow) {
+	idx, ok := seriesIDToSeries[window.Series]
+	if !ok {
+		return
+	}
+	if series, ok := data[idx].(interpolatingSeries); ok {
+		// An average already describes the whole interval since the previous reading.
+		series.SetInterpolation(InterpolateStepNext, window.Duration)
+	}
+}
+
 func (d *Datasource) SessionStream(ctx context.Context) <-chan map[string]*stream.Mutation[Session] {
 	return d.pool.Stream(ctx)
 }
@@ -173,6 +430,15 @@ func (d *Datasource) recordSession(sessionID string, mode Mode, files ...io.Read
 			}
 			// Emit our boxed dataset immediately.
 			out <- session
+			defer func() {
+				for _, series := range session.Data {
+					if closer, ok := series.(io.Closer); ok {
+						if err := closer.Close(); err != nil {
+							log.Printf("failed closing series %s: %v", series.Name(), err)
+						}
+					}
+				}
+			}()
 
 			rawSamples := make(chan InputData, 1024)
 			var wg sync.WaitGroup
@@ -195,56 +461,154 @@ func (d *Datasource) recordSession(sessionID string, mode Mode, files ...io.Read
 				wg.Wait()
 			}()
 
-			var sessionFile *os.File
-			var sessionWriter *bufio.Writer
-			var csvWriter *csv.Writer
-			var err error
+			var sessionWriter *sessionLog
+			// flushTicks flushes rows recorded while samples are few and far between.
+			var flushTicks <-chan time.Time
 			if mode == ModeSensing {
-				exeDir, _ := os.Executable()
-				sessionFile, err = os.Create(filepath.Join(filepath.Dir(exeDir), sessionFileFor(sessionID)))
+				var err error
+				sessionWriter, err = createSessionLog(d.sessionPath(sessionID), d.durability)
 				if err != nil {
 					session.Err = err
 					out <- session
 					return
 				}
-				sessionWriter = bufio.NewWriter(sessionFile)
-				csvWriter = csv.NewWriter(sessionWriter)
+				if d.durability.FlushInterval > 0 {
+					ticker := time.NewTicker(d.durability.FlushInterval)
+					defer ticker.Stop()
+					flushTicks = ticker.C
+				}
 			}
-			flushAll := func() {
-				if mode == ModeSensing {
-					csvWriter.Flush()
-					err := sessionWriter.Flush()
-					err = errors.Join(err, sessionFile.Close())
-					if err != nil {
+			record := SessionRecord{ID: sessionID, Start: time.Now(), Notes: d.notes}
+			record.Host, _ = os.Hostname()
+			var lastIndexed time.Time
+			// index records the session in the library, at most every indexInterval unless
+			// forced.
+			index := func(force bool) {
+				if mode != ModeSensing || d.library == nil || (!force && time.Since(lastIndexed) < indexInterval) {
+					return
+				}
+				lastIndexed = time.Now()
+				if err := sessionWriter.Flush(); err != nil {
+					log.Printf("failed flushing session %s: %v", sessionID, err)
+				}
+				if size, err := sessionWriter.Size(); err == nil {
+					record.Size = size
+				}
+				record.Duration = sessionDuration(session.Data)
+				if err := d.library.RecordSession(record); err != nil {
+					log.Printf("failed indexing session %s: %v", sessionID, err)
+				}
+			}
+			index(true)
+			if mode == ModeSensing {
+				// However recording ends, write out the buffered rows and release the
+				// session's lock so that it can be replayed.
+				defer func() {
+					index(true)
+					if err := sessionWriter.Close(); err != nil && session.Err == nil {
 						session.Err = err
 						out <- session
 					}
-				}
+				}()
 			}
 			headings := []string{"start (ns)", "end (ns)"}
 			seriesIDToHeading := map[int]int{}
 			seriesIDToSeries := map[int]int{}
+			calibrated := false
+			totalIdx := -1
+			// updateTotal adds or replaces the total of the series, which changes whenever
+			// series are added or their containment becomes known.
+			updateTotal := func() {
+				total, ok := NewTotalSeries(session.Data)
+				if !ok {
+					return
+				}
+				if totalIdx < 0 {
+					totalIdx = len(session.Data)
+					session.Data = append(session.Data, total)
+				} else {
+					session.Data[totalIdx] = total
+				}
+			}
+			derivedIdx := map[string]int{}
+			derivations, derivationsChanged := d.currentDerivations()
+			// derive adds the derived series whose inputs are all present, and rebinds those
+			// already added to any series added or replaced since.
+			derive := func() {
+				session.Data = rederive(session.Data, derivations, derivedIdx)
+			}
 			for {
 				select {
 				case <-ctx.Done():
-					flushAll()
 					return
+				case <-flushTicks:
+					if err := sessionWriter.Flush(); err != nil {
+						session.Err = err
+						out <- session
+						return
+					}
+				case <-derivationsChanged:
+					derivations, derivationsChanged = d.currentDerivations()
+					derive()
+					out <- session
 				case sample, more := <-rawSamples:
 					if !more {
 						rawSamples = nil
 						session.Loaded = true
 						log.Printf("Finished reading session %s", sessionID)
+					} else if sample.Kind == KindRecovery {
+						log.Printf("recovered session %s: %s", sessionID, sample.Recovery)
+						session.Recovery = sample.Recovery
+					} else if sample.Kind == KindMark {
+						session.Marks = append(session.Marks, sample.Mark)
+						if mode == ModeSensing {
+							if err := sessionWriter.Write([]string{"mark", strconv.FormatInt(sample.Mark.TimestampNS, 10), sample.Mark.Label}); err != nil {
+								session.Err = err
+								out <- session
+								return
+							}
+						}
+					} else if sample.Kind == KindWindow {
+						applyWindow(session.Data, seriesIDToSeries, sample.Window)
+						if mode == ModeSensing {
+							heading := headings[seriesIDToHeading[sample.Window.Series]]
+							if err := sessionWriter.Write([]string{"window", strconv.FormatInt(sample.Window.Duration.Nanoseconds(), 10), heading}); err != nil {
+								session.Err = err
+								out <- session
+								return
+							}
+						}
+					} else if sample.Kind == KindContainment {
+						applyContainment(session.Data, seriesIDToSeries, sample.Containment)
+						updateTotal()
+						derive()
+						if mode == ModeSensing {
+							heading := headings[seriesIDToHeading[sample.Containment.Series]]
+							if err := sessionWriter.Write([]string{"contained", heading, sample.Containment.Container}); err != nil {
+								session.Err = err
+								out <- session
+								return
+							}
+						}
 					} else if sample.Kind == KindHeadings {
 						for sampleHeadingIdx, heading := range sample.Headings {
 							seriesID := sample.HeadingSeries[sampleHeadingIdx]
 							localHeadingIdx := len(headings)
 							headings = append(headings, heading)
 							seriesIDToHeading[seriesID] = localHeadingIdx
-							seriesIDToSeries[seriesID] = localHeadingIdx - 2
-							session.Data = append(session.Data, NewSeries(heading))
+							seriesIDToSeries[seriesID] = len(session.Data)
+							session.Data = append(session.Data, d.newSeries(heading, sample.HeadingUnits[sampleHeadingIdx], mode))
+						}
+						updateTotal()
+						derive()
+						if d.calibration != nil && !calibrated {
+							if estimate, ok := NewCalibratedSeries(*d.calibration, session.Data); ok {
+								session.Data = append(session.Data, estimate)
+								calibrated = true
+							}
 						}
 						if mode == ModeSensing {
-							if err := csvWriter.Write(headings); err != nil {
+							if err := sessionWriter.Write(headings); err != nil {
 								session.Err = err
 								out <- session
 								return
@@ -262,13 +626,14 @@ func (d *Datasource) recordSession(sessionID string, mode Mode, files ...io.Read
 							record[0] = start
 							record[1] = end
 							record[position] = val
-							if err := csvWriter.Write(record); err != nil {
+							if err := sessionWriter.Write(record); err != nil {
 								session.Err = err
 								out <- session
 								return
 							}
 						}
 					}
+					index(false)
 					out <- session
 				}
 			}
@@ -278,6 +643,27 @@ func (d *Datasource) recordSession(sessionID string, mode Mode, files ...io.Read
 	return box
 }
 
+// indexInterval is how often the library's index is updated while a session is recorded.
+const indexInterval = 30 * time.Second
+
+// sessionDuration returns the time spanned by the series of a dataset.
+func sessionDuration(data Dataset) time.Duration {
+	var start, end int64
+	initialized := false
+	for _, s := range data {
+		if !s.Initialized() {
+			continue
+		}
+		sMin, sMax := s.Domain()
+		if !initialized {
+			start, end = sMin, sMax
+			initialized = true
+		}
+		start, end = min(start, sMin), max(end, sMax)
+	}
+	return time.Duration(end - start)
+}
+
 func (d *Datasource) LoadFromFile(expl *explorer.Explorer) (string, *stream.Mutation[Session], error) {
 	file, err := expl.ChooseFile()
 	if err != nil {
@@ -297,17 +683,67 @@ func (d *Datasource) LoadFromStreamWithID(sessionID string, mode Mode, files ...
 }
 
 func (d *Datasource) LaunchSensors() (string, error) {
-	traceReader, err := launchSensors(d.appCtx)
+	controlDir, err := os.MkdirTemp("", "watt-wiser-")
 	if err != nil {
+		return "", fmt.Errorf("failed creating control socket directory: %w", err)
+	}
+	var removeOnce sync.Once
+	removeControlDir := func() {
+		removeOnce.Do(func() {
+			if err := os.RemoveAll(controlDir); err != nil {
+				log.Printf("failed removing control socket directory: %v", err)
+			}
+		})
+	}
+	controlPath := filepath.Join(controlDir, "control.sock")
+	traceReader, err := launchSensors(d.appCtx, d.sensorsArgs(controlPath))
+	if err != nil {
+		removeControlDir()
 		return "", err
 	}
+	// The sensors are stopped along with the app, and their trace ends when they exit.
+	context.AfterFunc(d.appCtx, removeControlDir)
+	traceReader = &cleanupReader{ReadCloser: traceReader, cleanup: removeControlDir}
+	d.sensors.connect(controlPath)
 	id := generateSessionID()
 	d.recordSession(id, ModeSensing, traceReader)
 	return id, nil
 }
 
-func runSensorsWithName(ctx context.Context, exeName string) (io.ReadCloser, error) {
-	cmd := exec.CommandContext(ctx, exeName)
+// sensorsArgs returns the arguments that LaunchSensors passes to the sensors, besides the
+// process tree to attribute GPU energy to.
+func (d *Datasource) sensorsArgs(controlPath string) []string {
+	args := []string{"-control", controlPath, "-throttling"}
+	if d.auxiliary {
+		args = append(args, "-auxiliary")
+	}
+	return args
+}
+
+// cleanupReader calls cleanup whenever reading ends, either because the reader was closed or
+// because it failed or reached its end.
+type cleanupReader struct {
+	io.ReadCloser
+	cleanup func()
+}
+
+func (r *cleanupReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil {
+		r.cleanup()
+	}
+	return n, err
+}
+
+func (r *cleanupReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.cleanup()
+	return err
+}
+
+func runSensorsWithName(ctx context.Context, exeName string, args []string) (io.ReadCloser, error) {
+	// Benchmarks run as our children, so GPU use by our descendants can be attributed to them.
+	cmd := exec.CommandContext(ctx, exeName, append([]string{"-gpu-process-tree", strconv.Itoa(os.Getpid())}, args...)...)
 	out, err := cmd.StdoutPipe()
 	if err != nil {
 		return nil, fmt.Errorf("failed acquiring stdout pipe: %w", err)
@@ -315,7 +751,7 @@ func runSensorsWithName(ctx context.Context, exeName string) (io.ReadCloser, err
 	return out, cmd.Start()
 }
 
-func launchSensors(ctx context.Context) (io.ReadCloser, error) {
+func launchSensors(ctx context.Context, args []string) (io.ReadCloser, error) {
 	const sensorExeName = "watt-wiser-sensors"
 	execPath, err := os.Executable()
 	if err == nil {
@@ -324,7 +760,7 @@ func launchSensors(ctx context.Context) (io.ReadCloser, error) {
 			sensorExe += ".exe"
 		}
 		log.Printf("Looking for %q", sensorExe)
-		output, err := runSensorsWithName(ctx, sensorExe)
+		output, err := runSensorsWithName(ctx, sensorExe, args)
 		if err == nil {
 			return output, nil
 		}
@@ -336,7 +772,7 @@ func launchSensors(ctx context.Context) (io.ReadCloser, error) {
 		return nil, fmt.Errorf("unable to locate %q in $PATH: %w", sensorExeName, err)
 	}
 
-	output, err := runSensorsWithName(ctx, sensorExe)
+	output, err := runSensorsWithName(ctx, sensorExe, args)
 	if err != nil {
 		return nil, fmt.Errorf("failed launching %q: %w", sensorExe, err)
 	}
@@ -344,65 +780,174 @@ func launchSensors(ctx context.Context) (io.ReadCloser, error) {
 	return output, nil
 }
 
+// LoadTrace reads a complete trace into a dataset without any UI, which allows traces to be
+// analyzed headlessly.
+func LoadTrace(source io.Reader) (Dataset, error) {
+	d := &Datasource{}
+	samples := make(chan InputData, 1024)
+	go d.readSource(source, ModeReplaying, samples)
+	var data Dataset
+	seriesIDToSeries := map[int]int{}
+	for sample := range samples {
+		if sample.Kind == KindMark {
+			continue
+		}
+		if sample.Kind == KindRecovery {
+			log.Printf("recovered trace: %s", sample.Recovery)
+			continue
+		}
+		if sample.Kind == KindWindow {
+			applyWindow(data, seriesIDToSeries, sample.Window)
+			continue
+		}
+		if sample.Kind == KindContainment {
+			applyContainment(data, seriesIDToSeries, sample.Containment)
+			continue
+		}
+		if sample.Kind == KindHeadings {
+			for i, heading := range sample.Headings {
+				seriesIDToSeries[sample.HeadingSeries[i]] = len(data)
+				data = append(data, NewSeries(heading, sample.HeadingUnits[i]))
+			}
+			continue
+		}
+		data[seriesIDToSeries[sample.Series]].(WritableDataSeries).Insert(sample.Sample)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("trace contains no recognized series")
+	}
+	return data, nil
+}
+
+// readSource parses a trace into samples. A replayed trace may end in a partial row left by
+// a crash while it was recorded, which is discarded and reported as a recovery rather than
+// parsed, as are rows that a recoveredFile had removed. A trace being recorded is followed
+// as it grows instead.
 func (d *Datasource) readSource(source io.Reader, mode Mode, samplesChan chan InputData) {
 	defer close(samplesChan)
+	if f, ok := source.(*recoveredFile); ok && f.recovery.Discarded > 0 {
+		samplesChan <- InputData{Kind: KindRecovery, Recovery: f.recovery}
+	}
 	bufRead := NewLineReader(source)
 	csvReader := csv.NewReader(bufRead)
 	csvReader.TrimLeadingSpace = true
+	// Sensors may appear partway through a trace, so rows can grow.
+	csvReader.FieldsPerRecord = -1
+	// rows counts the complete rows read, which end at offset.
+	rows, offset := 0, int64(0)
+	// recovered reports the partial row at the end of a replayed trace, if any. Only whole
+	// lines are handed to the CSV parser, so a partial row is either the unterminated line
+	// held back by bufRead, or a quoted field cut off after spanning lines.
+	recovered := func(err error) bool {
+		var parseErr *csv.ParseError
+		if mode == ModeSensing || !bufRead.AtEOF() || (err != io.EOF && !errors.As(err, &parseErr)) {
+			return false
+		}
+		if discarded := bufRead.Consumed() - offset; discarded > 0 {
+			samplesChan <- InputData{Kind: KindRecovery, Recovery: Recovery{Rows: rows, Size: offset, Discarded: discarded}}
+		}
+		return true
+	}
 	headings, err := csvReader.Read()
 	if err != nil {
-		log.Printf("failed reading CSV data: %v", err)
+		if !recovered(err) {
+			log.Printf("failed reading CSV data: %v", err)
+		}
 		return
 	}
+	rows, offset = 1, csvReader.InputOffset()
 	relevantIndices := make([]int, 2, len(headings))
 	relevantIndices[0] = 0
 	relevantIndices[1] = 1
-	relevantHeadings := make([]string, 0, len(headings))
 	headingSeries := make([]int, 0, len(headings))
-	indexIsEnergy := map[int]bool{}
-	for i, heading := range headings {
-		if i == 0 {
-			continue
+	headingUnits := make([]sensors.Unit, 0, len(headings))
+	seriesByHeading := map[string]int{}
+	knownColumns := 0
+	// addHeadings registers the columns of a heading row that haven't been seen yet. Heading
+	// rows after the first repeat every earlier column in place, followed by new ones.
+	addHeadings := func(headings []string) {
+		// Rows end with a separator, so ignore the trailing empty heading.
+		for len(headings) > 0 && strings.TrimSpace(headings[len(headings)-1]) == "" {
+			headings = headings[:len(headings)-1]
 		}
-		joules := strings.Contains(heading, "(J)")
-		watts := strings.Contains(heading, "(W)")
-		if joules || watts {
-			relevantIndices = append(relevantIndices, i)
-			relevantHeadings = append(relevantHeadings, heading)
-			headingSeries = append(headingSeries, int(d.seriesCounter.Add(1)))
-			if joules {
-				indexIsEnergy[i] = true
-			} else if watts {
-				indexIsEnergy[i] = false
+		newHeadings := make([]string, 0, len(headings))
+		firstNew := len(headingSeries)
+		for i := max(knownColumns, 2); i < len(headings); i++ {
+			heading := headings[i]
+			if unit := headingUnit(heading); unit != sensors.Unknown {
+				relevantIndices = append(relevantIndices, i)
+				newHeadings = append(newHeadings, heading)
+				headingSeries = append(headingSeries, int(d.seriesCounter.Add(1)))
+				headingUnits = append(headingUnits, unit)
+				seriesByHeading[strings.TrimSpace(heading)] = headingSeries[len(headingSeries)-1]
 			}
 		}
+		knownColumns = max(knownColumns, len(headings))
+		samplesChan <- InputData{
+			Kind:          KindHeadings,
+			Headings:      newHeadings,
+			HeadingSeries: headingSeries[firstNew:],
+			HeadingUnits:  headingUnits[firstNew:],
+		}
 	}
-	samplesChan <- InputData{
-		Kind:          KindHeadings,
-		Headings:      relevantHeadings,
-		HeadingSeries: headingSeries,
-	}
+	addHeadings(headings)
 	// Continously parse the CSV data and send it on the channel.
 readLoop:
 	for {
 		rec, err := csvReader.Read()
 		if err != nil {
-			if errors.Is(err, io.EOF) {
-				if mode == ModeSensing {
-					for ev := range d.watcher.Events {
-						if ev.Op == fsnotify.Write {
-							continue readLoop
-						}
+			if recovered(err) {
+				return
+			}
+			if errors.Is(err, io.EOF) && mode == ModeSensing {
+				for ev := range d.watcher.Events {
+					if ev.Op == fsnotify.Write {
+						continue readLoop
 					}
-				} else {
-					return
 				}
 			}
 			log.Printf("could not read sensor data: %v", err)
 			return
 		}
+		rows, offset = rows+1, csvReader.InputOffset()
+		if isMarkRow(rec) {
+			at, err := strconv.ParseInt(rec[1], 10, 64)
+			if err != nil {
+				log.Printf("failed parsing mark timestamp: %v", err)
+				continue
+			}
+			samplesChan <- InputData{Kind: KindMark, Mark: Mark{TimestampNS: at, Label: rec[2]}}
+			continue
+		}
+		if isWindowRow(rec) {
+			window, err := strconv.ParseInt(rec[1], 10, 64)
+			if err != nil {
+				log.Printf("failed parsing averaging window: %v", err)
+				continue
+			}
+			series, ok := seriesByHeading[strings.TrimSpace(rec[2])]
+			if !ok {
+				log.Printf("averaging window for unknown series %q", rec[2])
+				continue
+			}
+			samplesChan <- InputData{Kind: KindWindow, Window: AveragingWindow{Series: series, Duration: time.Duration(window)}}
+			continue
+		}
+		if isContainmentRow(rec) {
+			series, ok := seriesByHeading[strings.TrimSpace(rec[1])]
+			if !ok {
+				log.Printf("containment of unknown series %q", rec[1])
+				continue
+			}
+			samplesChan <- InputData{Kind: KindContainment, Containment: Containment{Series: series, Container: strings.TrimSpace(rec[2])}}
+			continue
+		}
 		startNs, err := strconv.ParseInt(rec[0], 10, 64)
 		if err != nil {
+			if isHeadingRow(rec) {
+				addHeadings(rec)
+				continue
+			}
 			log.Printf("failed parsing timestamp: %v", err)
 			continue
 		}
@@ -411,7 +956,7 @@ readLoop:
 			log.Printf("failed parsing timestamp: %v", err)
 			continue
 		}
-		for i := 2; i < len(relevantIndices); i++ {
+		for i := 2; i < len(relevantIndices) && relevantIndices[i] < len(rec); i++ {
 			record := strings.TrimSpace(rec[relevantIndices[i]])
 			if len(record) < 1 {
 				// Skip null cells.
@@ -422,10 +967,6 @@ readLoop:
 				log.Printf("failed parsing data[%d]=%q: %v", i, rec[i], err)
 				continue
 			}
-			unit := sensors.Joules
-			if !indexIsEnergy[i] {
-				unit = sensors.Watts
-			}
 			samplesChan <- InputData{
 				Kind: KindSample,
 				Sample: Sample{
@@ -433,19 +974,61 @@ readLoop:
 					EndTimestampNS:   endNs,
 					Series:           headingSeries[i-2],
 					Value:            data,
-					Unit:             unit,
+					Unit:             headingUnits[i-2],
 				},
 			}
 		}
 	}
 }
 
+// isMarkRow reports whether a row is a mark, which holds "mark", a timestamp, and a label.
+func isMarkRow(rec []string) bool {
+	return len(rec) >= 3 && rec[0] == "mark"
+}
+
+// isWindowRow reports whether a row declares the averaging window of a series, which holds
+// "window", the window in nanoseconds, and the series' heading.
+func isWindowRow(rec []string) bool {
+	return len(rec) >= 3 && rec[0] == "window"
+}
+
+// isContainmentRow reports whether a row declares that a series is part of another, which
+// holds "contained", the series' heading, and the heading of the series containing it.
+func isContainmentRow(rec []string) bool {
+	return len(rec) >= 3 && rec[0] == "contained"
+}
+
+// isHeadingRow reports whether a record is a row of headings rather than a sample, which is
+// recognized by its leading timestamp heading.
+func isHeadingRow(rec []string) bool {
+	return len(rec) > 0 && strings.HasSuffix(strings.TrimSpace(rec[0]), "(ns)")
+}
+
+// headingUnit extracts the unit from a trace heading of the form "name (unit)", returning
+// sensors.Unknown if the heading has no recognized unit.
+func headingUnit(heading string) sensors.Unit {
+	heading = strings.TrimSpace(heading)
+	if !strings.HasSuffix(heading, ")") {
+		return sensors.Unknown
+	}
+	open := strings.LastIndexByte(heading, '(')
+	if open < 0 {
+		return sensors.Unknown
+	}
+	return sensors.ParseUnit(heading[open+1 : len(heading)-1])
+}
+
 // lineReader is a specialized reader that ensures only entire newline-delimited lines are
 // read at a time. This is useful when attempting to parse a file that is being actively
 // written to as a CSV, as you don't actually attempt to parse any partial lines.
 type lineReader struct {
 	r       *bufio.Reader
 	partial []byte
+	// ready holds the rest of a complete line that didn't fit in the last read.
+	ready []byte
+	// consumed counts the bytes read from r, and eof is set once r has run out.
+	consumed int64
+	eof      bool
 }
 
 var _ io.Reader = (*lineReader)(nil)
@@ -457,16 +1040,28 @@ func NewLineReader(r io.Reader) *lineReader {
 }
 
 func (l *lineReader) Read(b []byte) (int, error) {
-	data, err := l.r.ReadBytes(byte('\n'))
-	if err != nil {
+	if len(l.ready) == 0 {
+		data, err := l.r.ReadBytes(byte('\n'))
+		l.consumed += int64(len(data))
+		l.eof = err != nil
 		l.partial = append(l.partial, data...)
-		return 0, io.EOF
-	}
-	var n int
-	if len(l.partial) > 0 {
-		n = copy(b, l.partial)
-		l.partial = l.partial[:copy(l.partial, l.partial[n:])]
-		b = b[n:]
+		if err != nil {
+			return 0, io.EOF
+		}
+		l.ready, l.partial = l.partial, nil
 	}
-	return n + copy(b, data), nil
+	n := copy(b, l.ready)
+	l.ready = l.ready[n:]
+	return n, nil
+}
+
+// Consumed returns the number of bytes read from the underlying reader, including those of
+// a partial line that hasn't been returned yet.
+func (l *lineReader) Consumed() int64 {
+	return l.consumed
+}
+
+// AtEOF reports whether the last read reached the end of the underlying reader.
+func (l *lineReader) AtEOF() bool {
+	return l.eof
 }
@@ -0,0 +1,313 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"git.sr.ht/~gioverse/skel/stream"
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestReadSourceUnits(t *testing.T) {
+	const trace = `sample start (ns), sample end (ns), package-0 (J), amdgpu#power1_average (W), amdgpu#temp1_input (°C), amdgpu#fan1_input (RPM), cpu0 frequency (Hz), mystery (?), 
+0, 100, 2.5, 7.000000, 41.000000, 850.000000, 3600000000.000000, 1, 
+`
+	d := &Datasource{}
+	samples := make(chan InputData, 16)
+	go d.readSource(io.NopCloser(strings.NewReader(trace)), ModeReplaying, samples)
+	headings := <-samples
+	if headings.Kind != KindHeadings {
+		t.Fatalf("expected headings first, got kind %d", headings.Kind)
+	}
+	expectedHeadings := []string{
+		"package-0 (J)",
+		"amdgpu#power1_average (W)",
+		"amdgpu#temp1_input (°C)",
+		"amdgpu#fan1_input (RPM)",
+		"cpu0 frequency (Hz)",
+	}
+	expectedUnits := []sensors.Unit{sensors.Joules, sensors.Watts, sensors.Celsius, sensors.RPM, sensors.Hertz}
+	if !slices.Equal(headings.Headings, expectedHeadings) {
+		t.Errorf("expected headings %q, got %q", expectedHeadings, headings.Headings)
+	}
+	if !slices.Equal(headings.HeadingUnits, expectedUnits) {
+		t.Errorf("expected units %v, got %v", expectedUnits, headings.HeadingUnits)
+	}
+	expectedValues := []float64{2.5, 7, 41, 850, 3.6e9}
+	i := 0
+	for sample := range samples {
+		if i >= len(expectedValues) {
+			t.Fatalf("expected %d samples, got more", len(expectedValues))
+		}
+		if sample.Series != headings.HeadingSeries[i] {
+			t.Errorf("expected sample %d to belong to series %d, got %d", i, headings.HeadingSeries[i], sample.Series)
+		}
+		if sample.Unit != expectedUnits[i] {
+			t.Errorf("expected sample %d to be in %s, got %s", i, expectedUnits[i], sample.Unit)
+		}
+		if sample.Value != expectedValues[i] {
+			t.Errorf("expected sample %d to be %v, got %v", i, expectedValues[i], sample.Value)
+		}
+		i++
+	}
+	if i != len(expectedValues) {
+		t.Errorf("expected %d samples, got %d", len(expectedValues), i)
+	}
+}
+
+func TestReadSourceNewColumns(t *testing.T) {
+	// The GPU appears partway through the trace, and package-0 is briefly unreadable.
+	const trace = `sample start (ns), sample end (ns), package-0 (J), 
+0, 100, 1.5, 
+sample start (ns), sample end (ns), package-0 (J), gpu (W), 
+100, 200, 2.5, 30, 
+200, 300, , 40, 
+`
+	ds, err := LoadTrace(strings.NewReader(trace))
+	if err != nil {
+		t.Fatalf("failed loading trace: %v", err)
+	}
+	if len(ds) != 2 {
+		t.Fatalf("expected 2 series, got %d", len(ds))
+	}
+	type testcase struct {
+		series     int
+		name       string
+		start, end int64
+		sum        float64
+	}
+	for _, tc := range []testcase{
+		{series: 0, name: "package-0 (J)", start: 0, end: 200, sum: 4},
+		{series: 1, name: "gpu (W)", start: 100, end: 300, sum: 30*100e-9 + 40*100e-9},
+	} {
+		s := ds[tc.series]
+		if s.Name() != tc.name {
+			t.Errorf("expected series %d to be %q, got %q", tc.series, tc.name, s.Name())
+		}
+		start, end := s.Domain()
+		if start != tc.start || end != tc.end {
+			t.Errorf("expected %q to span [%d, %d], got [%d, %d]", tc.name, tc.start, tc.end, start, end)
+		}
+		if _, _, _, sum, _, _ := s.RatesBetween(tc.start, tc.end); math.Abs(sum-tc.sum) > 1e-12 {
+			t.Errorf("expected %q to sum to %v, got %v", tc.name, tc.sum, sum)
+		}
+	}
+}
+
+func TestReadSourceMarks(t *testing.T) {
+	const trace = `sample start (ns), sample end (ns), package-0 (J), 
+0, 100, 1.5, 
+mark,150,"benchmark abc, command start"
+100, 200, 2.5, 
+`
+	d := &Datasource{}
+	samples := make(chan InputData, 16)
+	go d.readSource(io.NopCloser(strings.NewReader(trace)), ModeReplaying, samples)
+	var kinds []InputKind
+	var marks []Mark
+	for sample := range samples {
+		kinds = append(kinds, sample.Kind)
+		if sample.Kind == KindMark {
+			marks = append(marks, sample.Mark)
+		}
+	}
+	if expected := []InputKind{KindHeadings, KindSample, KindMark, KindSample}; !slices.Equal(kinds, expected) {
+		t.Errorf("expected inputs of kinds %v, got %v", expected, kinds)
+	}
+	if expected := []Mark{{TimestampNS: 150, Label: "benchmark abc, command start"}}; !slices.Equal(marks, expected) {
+		t.Errorf("expected marks %+v, got %+v", expected, marks)
+	}
+}
+
+func TestReadSourceRecovery(t *testing.T) {
+	const complete = "sample start (ns), sample end (ns), package-0 (J), \n0, 100, 1.5, \n"
+	for _, tc := range []struct {
+		name     string
+		trace    string
+		mode     Mode
+		samples  int
+		expected Recovery
+	}{
+		{name: "complete", trace: complete, mode: ModeReplaying, samples: 1},
+		{
+			name:     "partial row",
+			trace:    complete + "100, 2",
+			mode:     ModeReplaying,
+			samples:  1,
+			expected: Recovery{Rows: 2, Size: 66, Discarded: 6},
+		},
+		{
+			name:     "partial multi-line mark",
+			trace:    complete + "mark,150,\"first\nsec",
+			mode:     ModeReplaying,
+			samples:  1,
+			expected: Recovery{Rows: 2, Size: 66, Discarded: 19},
+		},
+		{
+			name:     "partial heading",
+			trace:    "sample start (ns), sam",
+			mode:     ModeReplaying,
+			expected: Recovery{Discarded: 22},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &Datasource{}
+			samples := make(chan InputData, 16)
+			go d.readSource(strings.NewReader(tc.trace), tc.mode, samples)
+			var recovery Recovery
+			count := 0
+			for sample := range samples {
+				switch sample.Kind {
+				case KindRecovery:
+					recovery = sample.Recovery
+				case KindSample:
+					count++
+				}
+			}
+			if count != tc.samples {
+				t.Errorf("expected %d samples, got %d", tc.samples, count)
+			}
+			if recovery != tc.expected {
+				t.Errorf("expected recovery %+v, got %+v", tc.expected, recovery)
+			}
+		})
+	}
+}
+
+func TestReadSourceRecoveredFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.csv")
+	if err := os.WriteFile(path, []byte("sample start (ns), sample end (ns), package-0 (J), \n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	expected := Recovery{Rows: 1, Size: 52, Discarded: 3}
+	d := &Datasource{}
+	samples := make(chan InputData, 16)
+	go d.readSource(&recoveredFile{File: f, recovery: expected}, ModeReplaying, samples)
+	if first := <-samples; first.Kind != KindRecovery || first.Recovery != expected {
+		t.Errorf("expected the recovery %+v to be reported first, got %+v", expected, first)
+	}
+	for range samples {
+	}
+}
+
+func TestLoadTraceWindows(t *testing.T) {
+	const trace = `sample start (ns), sample end (ns), instant (W), averaged (W), 
+window,100,averaged (W)
+0, 100, 1, 1, 
+100, 200, 2, 2, 
+`
+	ds, err := LoadTrace(strings.NewReader(trace))
+	if err != nil {
+		t.Fatalf("failed loading trace: %v", err)
+	}
+	expected := [][2]int64{{0, 200}, {-50, 150}}
+	for i, series := range ds {
+		if domainMin, domainMax := series.Domain(); domainMin != expected[i][0] || domainMax != expected[i][1] {
+			t.Errorf("expected %s domain %v, got [%d %d]", series.Name(), expected[i], domainMin, domainMax)
+		}
+	}
+}
+
+func TestLaunchSensorsCleanup(t *testing.T) {
+	// Without sensors to launch, the control socket directory is removed right away.
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	t.Setenv("PATH", "")
+	d := &Datasource{appCtx: context.Background()}
+	if _, err := d.LaunchSensors(); err == nil {
+		t.Fatalf("expected launching missing sensors to fail")
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("expected the control socket directory to be removed, found %v", entries)
+	}
+
+	// Otherwise it is removed once the sensors' trace ends.
+	var cleanups int
+	r := &cleanupReader{ReadCloser: io.NopCloser(strings.NewReader("trace")), cleanup: func() { cleanups++ }}
+	if _, err := io.ReadAll(r); err != nil {
+		t.Fatalf("failed reading: %v", err)
+	}
+	if cleanups != 1 {
+		t.Errorf("expected cleanup once the trace ended, got %d cleanups", cleanups)
+	}
+}
+
+func TestSensorsArgs(t *testing.T) {
+	// Throttling is always recorded so that benchmarks can flag throttled runs.
+	for _, tc := range []struct {
+		auxiliary bool
+		expected  []string
+	}{
+		{expected: []string{"-control", "control.sock", "-throttling"}},
+		{auxiliary: true, expected: []string{"-control", "control.sock", "-throttling", "-auxiliary"}},
+	} {
+		d := &Datasource{}
+		d.SetAuxiliary(tc.auxiliary)
+		if args := d.sensorsArgs("control.sock"); !slices.Equal(args, tc.expected) {
+			t.Errorf("expected arguments %q, got %q", tc.expected, args)
+		}
+	}
+}
+
+func TestRecordSessionReleasesLockOnError(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("no device to fail writes")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+	d := &Datasource{
+		pool:       stream.NewMutationPool[string, Session](stream.NewMutator(ctx, time.Second)),
+		watcher:    watcher,
+		appCtx:     ctx,
+		durability: DurabilityOptions{Sync: SyncNever},
+	}
+	if err := d.SetDataDir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	// Every write of the session fails, so recording it stops at its first row.
+	const sessionID = "failing"
+	path := d.sessionPath(sessionID)
+	if err := os.Symlink("/dev/full", path); err != nil {
+		t.Fatal(err)
+	}
+	trace := "sample start (ns), sample end (ns), package-0 (J), \n0, 100, 1.5, \n"
+	m := d.LoadFromStreamWithID(sessionID, ModeSensing, io.NopCloser(strings.NewReader(trace)))
+	var session Session
+	for session = range m.Stream(ctx) {
+		if session.Err != nil {
+			break
+		}
+	}
+	if session.Err == nil {
+		t.Fatalf("expected recording to fail")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(path + ".lock"); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the session's lock to be released after recording failed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,679 @@
+package backend
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+// Derivation defines a series computed from an expression over other series. Expressions
+// combine series, numbers, and parentheses with +, -, *, and /, which must be surrounded by
+// spaces since series names may contain them. Series are named without their unit, like
+// package-0, or quoted in full, like "package-0 (J)", and * matches any text within a name.
+// Two functions are available:
+//
+//   - sum(a, b, ...) adds its arguments, each of which may match several series, like
+//     sum(gpu*).
+//   - avg(a, 30s) is the moving average of a over the given window.
+type Derivation struct {
+	Name       string
+	Expression string
+	root       exprNode
+}
+
+// ParseDerivation parses a definition of the form "name = expression".
+func ParseDerivation(definition string) (Derivation, error) {
+	name, expression, ok := strings.Cut(definition, "=")
+	if !ok {
+		return Derivation{}, fmt.Errorf("derived series %q is not of the form \"name = expression\"", definition)
+	}
+	return NewDerivation(strings.TrimSpace(name), strings.TrimSpace(expression))
+}
+
+// NewDerivation parses the expression of a derived series.
+func NewDerivation(name, expression string) (Derivation, error) {
+	if name == "" {
+		return Derivation{}, fmt.Errorf("derived series %q has no name", expression)
+	}
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return Derivation{}, fmt.Errorf("failed parsing %q: %w", expression, err)
+	}
+	p := parser{tokens: tokens}
+	root, err := p.parseSum()
+	if err == nil && p.peek().kind != tokenEnd {
+		err = fmt.Errorf("unexpected %q", p.peek().text)
+	}
+	if err != nil {
+		return Derivation{}, fmt.Errorf("failed parsing %q: %w", expression, err)
+	}
+	return Derivation{Name: name, Expression: expression, root: root}, nil
+}
+
+func (d Derivation) String() string {
+	return d.Name + " = " + d.Expression
+}
+
+// DerivedSeries evaluates a derivation over the series of a dataset. Its rates are
+// computed from the mean rates of its inputs over each query, so products and quotients of
+// two varying series are approximations. The extrema assume that the inputs peak together.
+type DerivedSeries struct {
+	name   string
+	unit   sensors.Unit
+	root   exprNode
+	inputs []DataSeries
+	// total is set on the total of a dataset, which is never stacked.
+	total bool
+}
+
+var _ DataSeries = (*DerivedSeries)(nil)
+
+// NewDerivedSeries resolves the series named by a derivation within a dataset. It returns an
+// error if any of them are missing.
+func NewDerivedSeries(def Derivation, ds Dataset) (*DerivedSeries, error) {
+	if def.root == nil {
+		return nil, fmt.Errorf("derived series %q has no expression", def.Name)
+	}
+	// A series can't be derived from itself.
+	candidates := make(Dataset, 0, len(ds))
+	for _, s := range ds {
+		if s.Name() != def.Name {
+			candidates = append(candidates, s)
+		}
+	}
+	root, err := def.root.bind(candidates)
+	if err != nil {
+		return nil, fmt.Errorf("failed deriving %q: %w", def.Name, err)
+	}
+	inputs := root.inputs(nil)
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("failed deriving %q: expression uses no series", def.Name)
+	}
+	return &DerivedSeries{name: def.Name, unit: derivedUnit(inputs), root: root, inputs: inputs}, nil
+}
+
+func (s *DerivedSeries) inputSeries() []DataSeries {
+	if s.total {
+		// The total is never stacked, so it mustn't hide its inputs either.
+		return nil
+	}
+	return s.inputs
+}
+
+// derivedUnit returns Watts if every input describes energy, and otherwise the unit shared by
+// every input, or sensors.Unknown if they differ.
+func derivedUnit(inputs []DataSeries) sensors.Unit {
+	energy := true
+	unit := inputs[0].Unit()
+	for _, s := range inputs {
+		energy = energy && s.Unit().IsEnergy()
+		if s.Unit() != unit {
+			unit = sensors.Unknown
+		}
+	}
+	if energy {
+		return sensors.Watts
+	}
+	return unit
+}
+
+// DeriveAll appends the series of every derivation to a dataset, in order, so that later
+// derivations may use earlier ones.
+func DeriveAll(ds Dataset, defs []Derivation) (Dataset, error) {
+	for _, def := range defs {
+		derived, err := NewDerivedSeries(def, ds)
+		if err != nil {
+			return ds, err
+		}
+		ds = append(ds, derived)
+	}
+	return ds, nil
+}
+
+// rederive appends the series of each derivation whose inputs are all present in ds, and
+// rebinds those already appended, whose indices are kept in derived. Rebinding picks up
+// series that patterns match which were added since, like a hot-plugged GPU, and series
+// that were replaced, like the total. A derivation that no longer binds keeps its previous
+// inputs.
+func rederive(ds Dataset, defs []Derivation, derived map[string]int) Dataset {
+	for _, def := range defs {
+		series, err := NewDerivedSeries(def, ds)
+		if err != nil {
+			continue
+		}
+		if idx, ok := derived[def.Name]; ok {
+			ds[idx] = series
+		} else {
+			derived[def.Name] = len(ds)
+			ds = append(ds, series)
+		}
+	}
+	return ds
+}
+
+func (d *DerivedSeries) Name() string {
+	return d.name
+}
+
+func (d *DerivedSeries) Unit() sensors.Unit {
+	return d.unit
+}
+
+func (d *DerivedSeries) Initialized() bool {
+	for _, s := range d.inputs {
+		if !s.Initialized() {
+			return false
+		}
+	}
+	return true
+}
+
+// Domain returns the interval in which every input has data.
+func (d *DerivedSeries) Domain() (min, max int64) {
+	for i, s := range d.inputs {
+		sMin, sMax := s.Domain()
+		if i == 0 {
+			min, max = sMin, sMax
+			continue
+		}
+		min = maxOf(min, sMin)
+		max = minOf(max, sMax)
+	}
+	return min, max
+}
+
+// RatesBetween evaluates the expression over the interval. The coverage is that of the least
+// covered input.
+func (d *DerivedSeries) RatesBetween(timestampA, timestampB int64) (maximum, mean, minimum, sum, coverage float64, ok bool) {
+	if timestampB < timestampA {
+		timestampA, timestampB = timestampB, timestampA
+	}
+	r, ok := d.root.ratesBetween(timestampA, timestampB)
+	if !ok {
+		return 0, 0, 0, 0, 0, false
+	}
+	if r.coverage == 0 {
+		return 0, 0, 0, 0, 0, true
+	}
+	sum = r.mean * float64(timestampB-timestampA) * r.coverage / 1_000_000_000
+	return r.maximum, r.mean, r.minimum, sum, r.coverage, true
+}
+
+func (d *DerivedSeries) Sum() float64 {
+	start, end := d.Domain()
+	_, _, _, sum, _, _ := d.RatesBetween(start, end)
+	return sum
+}
+
+func (d *DerivedSeries) RateRange() (min, max float64) {
+	return d.root.rateRange()
+}
+
+// rates describes an expression over an interval.
+type rates struct {
+	maximum, mean, minimum, coverage float64
+}
+
+// exprNode is a node of a parsed expression.
+type exprNode interface {
+	// bind returns a copy of the node whose series references are resolved within ds.
+	bind(ds Dataset) (exprNode, error)
+	ratesBetween(timestampA, timestampB int64) (rates, bool)
+	rateRange() (min, max float64)
+	// inputs appends the series read by a bound node to in.
+	inputs(in []DataSeries) []DataSeries
+}
+
+type constant float64
+
+func (c constant) bind(Dataset) (exprNode, error) {
+	return c, nil
+}
+
+func (c constant) ratesBetween(int64, int64) (rates, bool) {
+	v := float64(c)
+	return rates{maximum: v, mean: v, minimum: v, coverage: 1}, true
+}
+
+func (c constant) rateRange() (min, max float64) {
+	return float64(c), float64(c)
+}
+
+func (c constant) inputs(in []DataSeries) []DataSeries {
+	return in
+}
+
+// reference names the series matching a pattern. Once bound, it reads exactly one of them.
+type reference struct {
+	pattern string
+	quoted  bool
+	series  DataSeries
+}
+
+// matches returns the series of ds that the reference names.
+func (r *reference) matches(ds Dataset) []DataSeries {
+	var out []DataSeries
+	for _, s := range ds {
+		name := s.Name()
+		if r.quoted {
+			if name == r.pattern {
+				out = append(out, s)
+			}
+			continue
+		}
+		if ok, _ := path.Match(r.pattern, bareName(name)); ok || name == r.pattern {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func (r *reference) bind(ds Dataset) (exprNode, error) {
+	matches := r.matches(ds)
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no series named %q", r.pattern)
+	case 1:
+		return &reference{pattern: r.pattern, quoted: r.quoted, series: matches[0]}, nil
+	default:
+		return nil, fmt.Errorf("%q names %d series, use sum(%s) to add them", r.pattern, len(matches), r.pattern)
+	}
+}
+
+func (r *reference) ratesBetween(timestampA, timestampB int64) (rates, bool) {
+	maximum, mean, minimum, _, coverage, ok := r.series.RatesBetween(timestampA, timestampB)
+	return rates{maximum: maximum, mean: mean, minimum: minimum, coverage: coverage}, ok
+}
+
+func (r *reference) rateRange() (min, max float64) {
+	return r.series.RateRange()
+}
+
+func (r *reference) inputs(in []DataSeries) []DataSeries {
+	return append(in, r.series)
+}
+
+// total adds its arguments, each reference among which may match several series.
+type total struct {
+	args []exprNode
+}
+
+func (t *total) bind(ds Dataset) (exprNode, error) {
+	var terms []exprNode
+	for _, arg := range t.args {
+		if ref, ok := arg.(*reference); ok {
+			matches := ref.matches(ds)
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no series named %q", ref.pattern)
+			}
+			for _, s := range matches {
+				terms = append(terms, &reference{pattern: ref.pattern, quoted: ref.quoted, series: s})
+			}
+			continue
+		}
+		bound, err := arg.bind(ds)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, bound)
+	}
+	sum := terms[0]
+	for _, term := range terms[1:] {
+		sum = &operation{op: '+', left: sum, right: term}
+	}
+	return sum, nil
+}
+
+// total is replaced by its terms when bound, so it is never evaluated.
+func (t *total) ratesBetween(int64, int64) (rates, bool) { return rates{}, false }
+func (t *total) rateRange() (min, max float64)           { return 0, 0 }
+func (t *total) inputs(in []DataSeries) []DataSeries     { return in }
+
+// movingAverage is the mean of an expression over a trailing window.
+type movingAverage struct {
+	arg    exprNode
+	window int64
+}
+
+func (m *movingAverage) bind(ds Dataset) (exprNode, error) {
+	arg, err := m.arg.bind(ds)
+	if err != nil {
+		return nil, err
+	}
+	return &movingAverage{arg: arg, window: m.window}, nil
+}
+
+// ratesBetween averages over the window ending at timestampB, or over the whole interval if
+// it is longer than the window. The average barely changes within intervals shorter than the
+// window, so its extrema there are its mean.
+func (m *movingAverage) ratesBetween(timestampA, timestampB int64) (rates, bool) {
+	r, ok := m.arg.ratesBetween(min(timestampA, timestampB-m.window), timestampB)
+	if timestampB-timestampA < m.window {
+		r.maximum, r.minimum = r.mean, r.mean
+	}
+	return r, ok
+}
+
+func (m *movingAverage) rateRange() (min, max float64) {
+	return m.arg.rateRange()
+}
+
+func (m *movingAverage) inputs(in []DataSeries) []DataSeries {
+	return m.arg.inputs(in)
+}
+
+type negation struct {
+	arg exprNode
+}
+
+func (n *negation) bind(ds Dataset) (exprNode, error) {
+	arg, err := n.arg.bind(ds)
+	if err != nil {
+		return nil, err
+	}
+	return &negation{arg: arg}, nil
+}
+
+func (n *negation) ratesBetween(timestampA, timestampB int64) (rates, bool) {
+	r, ok := n.arg.ratesBetween(timestampA, timestampB)
+	return rates{maximum: -r.minimum, mean: -r.mean, minimum: -r.maximum, coverage: r.coverage}, ok
+}
+
+func (n *negation) rateRange() (min, max float64) {
+	low, high := n.arg.rateRange()
+	return -high, -low
+}
+
+func (n *negation) inputs(in []DataSeries) []DataSeries {
+	return n.arg.inputs(in)
+}
+
+// operation applies an arithmetic operator to two expressions.
+type operation struct {
+	op          byte
+	left, right exprNode
+}
+
+func (b *operation) bind(ds Dataset) (exprNode, error) {
+	left, err := b.left.bind(ds)
+	if err != nil {
+		return nil, err
+	}
+	right, err := b.right.bind(ds)
+	if err != nil {
+		return nil, err
+	}
+	return &operation{op: b.op, left: left, right: right}, nil
+}
+
+func (b *operation) ratesBetween(timestampA, timestampB int64) (rates, bool) {
+	l, ok := b.left.ratesBetween(timestampA, timestampB)
+	if !ok {
+		return rates{}, false
+	}
+	r, ok := b.right.ratesBetween(timestampA, timestampB)
+	if !ok {
+		return rates{}, false
+	}
+	mean, ok := b.apply(l.mean, r.mean)
+	if !ok {
+		return rates{}, false
+	}
+	low, high := b.bounds(l.minimum, l.maximum, r.minimum, r.maximum, mean)
+	return rates{maximum: high, mean: mean, minimum: low, coverage: min(l.coverage, r.coverage)}, true
+}
+
+func (b *operation) rateRange() (min, max float64) {
+	lLow, lHigh := b.left.rateRange()
+	rLow, rHigh := b.right.rateRange()
+	mid, _ := b.apply((lLow+lHigh)/2, (rLow+rHigh)/2)
+	return b.bounds(lLow, lHigh, rLow, rHigh, mid)
+}
+
+func (b *operation) inputs(in []DataSeries) []DataSeries {
+	return b.right.inputs(b.left.inputs(in))
+}
+
+// apply returns the operator applied to two values, or false if it is undefined for them.
+func (b *operation) apply(l, r float64) (float64, bool) {
+	switch b.op {
+	case '+':
+		return l + r, true
+	case '-':
+		return l - r, true
+	case '*':
+		return l * r, true
+	default:
+		if r == 0 {
+			return 0, false
+		}
+		return l / r, true
+	}
+}
+
+// bounds returns the range of the operator applied to values within two ranges. If the
+// range is unbounded, as when dividing by a range containing zero, it is collapsed to
+// fallback.
+func (b *operation) bounds(lLow, lHigh, rLow, rHigh, fallback float64) (low, high float64) {
+	switch b.op {
+	case '+':
+		return lLow + rLow, lHigh + rHigh
+	case '-':
+		return lLow - rHigh, lHigh - rLow
+	case '/':
+		if rLow <= 0 && rHigh >= 0 {
+			return fallback, fallback
+		}
+		rLow, rHigh = 1/rHigh, 1/rLow
+	}
+	products := []float64{lLow * rLow, lLow * rHigh, lHigh * rLow, lHigh * rHigh}
+	return minOf(products...), maxOf(products...)
+}
+
+type tokenKind uint8
+
+const (
+	tokenEnd tokenKind = iota
+	tokenNumber
+	tokenDuration
+	tokenName
+	tokenQuoted
+	tokenOperator
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// tokenize splits an expression into tokens. Operators only begin tokens, so a - or * within
+// a name is part of the name.
+func tokenize(expression string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expression); {
+		c := expression[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case strings.IndexByte("+-*/(),", c) >= 0:
+			tokens = append(tokens, token{kind: tokenOperator, text: string(c)})
+			i++
+		case c == '"':
+			end := strings.IndexByte(expression[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			tokens = append(tokens, token{kind: tokenQuoted, text: expression[i+1 : i+1+end]})
+			i += end + 2
+		case c == '.' || (c >= '0' && c <= '9'):
+			end := i
+			for end < len(expression) && (expression[end] == '.' || unicode.IsLetter(rune(expression[end])) || unicode.IsDigit(rune(expression[end]))) {
+				end++
+			}
+			kind := tokenNumber
+			if _, err := strconv.ParseFloat(expression[i:end], 64); err != nil {
+				kind = tokenDuration
+			}
+			tokens = append(tokens, token{kind: kind, text: expression[i:end]})
+			i = end
+		default:
+			end := i
+			for end < len(expression) && strings.IndexByte(" \t+/(),\"", expression[end]) < 0 {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenName, text: expression[i:end]})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+// parser builds an expression from tokens by recursive descent.
+type parser struct {
+	tokens []token
+	next   int
+}
+
+func (p *parser) peek() token {
+	if p.next < len(p.tokens) {
+		return p.tokens[p.next]
+	}
+	return token{kind: tokenEnd}
+}
+
+func (p *parser) take() token {
+	t := p.peek()
+	if t.kind != tokenEnd {
+		p.next++
+	}
+	return t
+}
+
+func (p *parser) isOperator(ops string) bool {
+	t := p.peek()
+	return t.kind == tokenOperator && strings.Contains(ops, t.text)
+}
+
+func (p *parser) expect(op string) error {
+	if !p.isOperator(op) {
+		if p.peek().kind == tokenEnd {
+			return fmt.Errorf("expected %q at end", op)
+		}
+		return fmt.Errorf("expected %q, got %q", op, p.peek().text)
+	}
+	p.take()
+	return nil
+}
+
+// parseSum parses terms joined by + and -.
+func (p *parser) parseSum() (exprNode, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("+-") {
+		op := p.take().text[0]
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = &operation{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseProduct parses factors joined by * and /.
+func (p *parser) parseProduct() (exprNode, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("*/") {
+		op := p.take().text[0]
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		left = &operation{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseFactor parses a number, series, function call, negation, or parenthesized
+// expression.
+func (p *parser) parseFactor() (exprNode, error) {
+	t := p.take()
+	switch t.kind {
+	case tokenEnd:
+		return nil, fmt.Errorf("unexpected end")
+	case tokenNumber:
+		v, _ := strconv.ParseFloat(t.text, 64)
+		return constant(v), nil
+	case tokenDuration:
+		return nil, fmt.Errorf("invalid number %q", t.text)
+	case tokenQuoted:
+		return &reference{pattern: t.text, quoted: true}, nil
+	case tokenName:
+		if p.isOperator("(") {
+			return p.parseCall(t.text)
+		}
+		return &reference{pattern: t.text}, nil
+	}
+	switch t.text {
+	case "-":
+		arg, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return &negation{arg: arg}, nil
+	case "(":
+		inner, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
+
+// parseCall parses the arguments of a function.
+func (p *parser) parseCall(name string) (exprNode, error) {
+	p.take()
+	switch name {
+	case "sum":
+		var args []exprNode
+		for {
+			arg, err := p.parseSum()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if !p.isOperator(",") {
+				break
+			}
+			p.take()
+		}
+		return &total{args: args}, p.expect(")")
+	case "avg":
+		arg, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		t := p.take()
+		window, err := time.ParseDuration(t.text)
+		if t.kind != tokenDuration || err != nil || window <= 0 {
+			return nil, fmt.Errorf("expected a window like 30s, got %q", t.text)
+		}
+		return &movingAverage{arg: arg, window: window.Nanoseconds()}, p.expect(")")
+	default:
+		return nil, fmt.Errorf("unknown function %q", name)
+	}
+}
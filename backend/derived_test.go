@@ -0,0 +1,99 @@
+package backend
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDerivedSeries(t *testing.T) {
+	const trace = `sample start (ns), sample end (ns), package-0 (J), core (J), gpu0 (W), gpu1 (W), 
+0, 1000000000, 10, 4, 100, 50, 
+1000000000, 2000000000, 20, 6, 200, 50, 
+`
+	ds, err := LoadTrace(strings.NewReader(trace))
+	if err != nil {
+		t.Fatalf("failed loading trace: %v", err)
+	}
+	type testcase struct {
+		expression          string
+		start, end          int64
+		max, mean, min, sum float64
+	}
+	for _, tc := range []testcase{
+		{expression: "package-0 - core", end: 2e9, max: 16, mean: 10, min: 4, sum: 20},
+		{expression: "sum(gpu*)", end: 2e9, max: 250, mean: 200, min: 150, sum: 400},
+		{expression: "package-0 * 0.5", end: 2e9, max: 10, mean: 7.5, min: 5, sum: 15},
+		{expression: `-core + "package-0 (J)"`, end: 2e9, max: 16, mean: 10, min: 4, sum: 20},
+		{expression: "(gpu0 + gpu1) / 2", start: 1e9, end: 2e9, max: 125, mean: 125, min: 125, sum: 125},
+		{expression: "avg(package-0, 2s)", start: 1e9, end: 2e9, max: 15, mean: 15, min: 15, sum: 15},
+	} {
+		t.Run(tc.expression, func(t *testing.T) {
+			def, err := NewDerivation("derived (W)", tc.expression)
+			if err != nil {
+				t.Fatalf("failed parsing: %v", err)
+			}
+			s, err := NewDerivedSeries(def, ds)
+			if err != nil {
+				t.Fatalf("failed deriving: %v", err)
+			}
+			maximum, mean, minimum, sum, _, ok := s.RatesBetween(tc.start, tc.end)
+			if !ok {
+				t.Errorf("expected in-range data to be okay")
+			}
+			if !closeTo(maximum, tc.max) || !closeTo(mean, tc.mean) || !closeTo(minimum, tc.min) || !closeTo(sum, tc.sum) {
+				t.Errorf("expected max, mean, min, and sum %f %f %f %f, got %f %f %f %f", tc.max, tc.mean, tc.min, tc.sum, maximum, mean, minimum, sum)
+			}
+		})
+	}
+	for _, expression := range []string{"nothing", "gpu*", "package-0 -", "avg(core, 1)", "core core", "max(core)"} {
+		t.Run(expression, func(t *testing.T) {
+			def, err := NewDerivation("derived (W)", expression)
+			if err == nil {
+				_, err = NewDerivedSeries(def, ds)
+			}
+			if err == nil {
+				t.Errorf("expected an error deriving %q", expression)
+			}
+		})
+	}
+}
+
+func TestRederive(t *testing.T) {
+	const trace = `sample start (ns), sample end (ns), package-0 (J), gpu0 (W), gpu1 (W), 
+0, 1000000000, 10, 100, 50, 
+`
+	ds, err := LoadTrace(strings.NewReader(trace))
+	if err != nil {
+		t.Fatalf("failed loading trace: %v", err)
+	}
+	var defs []Derivation
+	for _, definition := range []string{"gpus (W) = sum(gpu*)", `devices (W) = "` + TotalSystemName + `" - package-0`} {
+		def, err := ParseDerivation(definition)
+		if err != nil {
+			t.Fatalf("failed parsing: %v", err)
+		}
+		defs = append(defs, def)
+	}
+	derived := map[string]int{}
+	// The second GPU is plugged in after the derivations are first bound, which adds it to
+	// the total.
+	data := Dataset{ds[0], ds[1]}
+	total, _ := NewTotalSeries(data)
+	data = rederive(append(data, total), defs, derived)
+	data = append(data, ds[2])
+	data[2], _ = NewTotalSeries(data)
+	data = rederive(data, defs, derived)
+	if len(data) != 6 {
+		t.Fatalf("expected 6 series, got %d", len(data))
+	}
+	for name, expected := range map[string]float64{"gpus (W)": 150, "devices (W)": 150} {
+		idx, ok := derived[name]
+		if !ok {
+			t.Errorf("expected %q to be derived", name)
+			continue
+		}
+		if _, mean, _, _, _, _ := data[idx].RatesBetween(0, 1e9); !closeTo(mean, expected) {
+			t.Errorf("expected %q to average %v, got %v", name, expected, mean)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// SyncPolicy chooses when a recorded session is synced to stable storage, so that it
+// survives a crash of the whole system rather than only of Watt Wiser.
+type SyncPolicy string
+
+const (
+	// SyncNever leaves writing the session to disk to the operating system.
+	SyncNever SyncPolicy = "never"
+	// SyncOnClose syncs the session once it ends.
+	SyncOnClose SyncPolicy = "close"
+	// SyncOnFlush syncs the session every time it is flushed.
+	SyncOnFlush SyncPolicy = "flush"
+)
+
+// ParseSyncPolicy parses the name of a sync policy.
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch policy := SyncPolicy(s); policy {
+	case SyncNever, SyncOnClose, SyncOnFlush:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown sync policy %q, expected never, close, or flush", s)
+}
+
+// DurabilityOptions choose how much of a recorded session can be lost if Watt Wiser or the
+// system crashes.
+type DurabilityOptions struct {
+	// FlushInterval is the longest that recorded rows are buffered before being written to
+	// the session's file. Zero writes every row as it is recorded.
+	FlushInterval time.Duration
+	// FlushBytes is the most data buffered before it is written to the session's file. Zero
+	// writes every row as it is recorded.
+	FlushBytes int
+	Sync       SyncPolicy
+}
+
+// DefaultDurability loses at most about a second of a session to a crash.
+var DefaultDurability = DurabilityOptions{
+	FlushInterval: time.Second,
+	FlushBytes:    64 << 10,
+	Sync:          SyncOnFlush,
+}
+
+// sessionLog writes the rows of a recorded session to its file, flushing them whenever
+// they have been buffered for too long or have grown too large.
+type sessionLog struct {
+	file *os.File
+	// lock is held for as long as the session is recorded. See lockTrace.
+	lock *os.File
+	buf  *bufio.Writer
+	// csv formats rows into row, from which they are copied into buf. Writing into buf
+	// directly would flush it along with each row.
+	csv       *csv.Writer
+	row       bytes.Buffer
+	opts      DurabilityOptions
+	lastFlush time.Time
+	// dirty is set when rows have been written since the last flush.
+	dirty bool
+}
+
+func createSessionLog(path string, opts DurabilityOptions) (*sessionLog, error) {
+	lock, err := lockTrace(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		unlockTrace(lock)
+		return nil, fmt.Errorf("failed creating session file: %w", err)
+	}
+	l := &sessionLog{
+		file:      file,
+		lock:      lock,
+		buf:       bufio.NewWriterSize(file, max(opts.FlushBytes, 4096)),
+		opts:      opts,
+		lastFlush: time.Now(),
+	}
+	l.csv = csv.NewWriter(&l.row)
+	return l, nil
+}
+
+// Write records a row, flushing the buffered rows if they are due.
+func (l *sessionLog) Write(record []string) error {
+	if err := l.csv.Write(record); err != nil {
+		return fmt.Errorf("failed writing session row: %w", err)
+	}
+	l.csv.Flush()
+	if _, err := l.buf.Write(l.row.Bytes()); err != nil {
+		return fmt.Errorf("failed writing session row: %w", err)
+	}
+	l.row.Reset()
+	l.dirty = true
+	if l.buf.Buffered() >= l.opts.FlushBytes || time.Since(l.lastFlush) >= l.opts.FlushInterval {
+		return l.Flush()
+	}
+	return nil
+}
+
+// Flush writes any buffered rows to the session's file, syncing it if the policy asks for
+// that.
+func (l *sessionLog) Flush() error {
+	if !l.dirty {
+		return nil
+	}
+	l.lastFlush = time.Now()
+	l.dirty = false
+	if err := l.buf.Flush(); err != nil {
+		return fmt.Errorf("failed flushing session: %w", err)
+	}
+	if l.opts.Sync == SyncOnFlush {
+		if err := l.file.Sync(); err != nil {
+			return fmt.Errorf("failed syncing session: %w", err)
+		}
+	}
+	return nil
+}
+
+// Size returns the size of the session's file, not counting buffered rows.
+func (l *sessionLog) Size() (int64, error) {
+	info, err := l.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Close flushes the buffered rows and closes the session's file.
+func (l *sessionLog) Close() error {
+	err := l.Flush()
+	if err == nil && l.opts.Sync != SyncNever {
+		if err = l.file.Sync(); err != nil {
+			err = fmt.Errorf("failed syncing session: %w", err)
+		}
+	}
+	if closeErr := l.file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed closing session: %w", closeErr)
+	}
+	unlockTrace(l.lock)
+	return err
+}
+
+// ErrRecording is returned when recovering a trace that is still being recorded.
+var ErrRecording = errors.New("trace is still being recorded")
+
+// lockTrace takes the lock that is held on the trace at path while it is recorded, so that
+// another instance of Watt Wiser doesn't mistake the rows still being written for a partial
+// row left by a crash. It fails with ErrRecording if the trace is being recorded.
+func lockTrace(path string) (*os.File, error) {
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening trace lock: %w", err)
+	}
+	if err := lockExclusive(lock, false); err != nil {
+		lock.Close()
+		if errors.Is(err, errLocked) {
+			return nil, ErrRecording
+		}
+		return nil, fmt.Errorf("failed locking trace: %w", err)
+	}
+	return lock, nil
+}
+
+// unlockTrace releases and removes a lock taken by lockTrace. The lock is closed before it is
+// removed, as Windows can't remove open files.
+func unlockTrace(lock *os.File) {
+	lock.Close()
+	os.Remove(lock.Name())
+}
+
+// Recovery describes what was kept of a trace that may have been cut off by a crash.
+type Recovery struct {
+	// Rows is the number of complete rows in the trace.
+	Rows int
+	// Size is the size of the trace in bytes after recovery.
+	Size int64
+	// Discarded is the number of bytes of a partial last row that were truncated.
+	Discarded int64
+}
+
+func (r Recovery) String() string {
+	s := fmt.Sprintf("%d rows (%d bytes)", r.Rows, r.Size)
+	if r.Discarded > 0 {
+		s += fmt.Sprintf(", discarding a partial row of %d bytes", r.Discarded)
+	}
+	return s
+}
+
+// RecoverTrace truncates the trace at path after its last complete row, removing any partial
+// row left by a crash while it was recorded. Rows are delimited the way the trace is parsed,
+// so quoted fields such as mark labels may span lines. A trace that is still being recorded
+// is left alone, failing with ErrRecording, and so is one that is damaged before its last
+// row, as truncating it would discard complete rows.
+func RecoverTrace(path string) (Recovery, error) {
+	var recovery Recovery
+	lock, err := lockTrace(path)
+	if err != nil {
+		return recovery, err
+	}
+	defer unlockTrace(lock)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return recovery, fmt.Errorf("failed opening trace: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return recovery, fmt.Errorf("failed reading trace: %w", err)
+	}
+	csvReader := csv.NewReader(bufio.NewReaderSize(f, 64<<10))
+	csvReader.TrimLeadingSpace = true
+	csvReader.FieldsPerRecord = -1
+	csvReader.ReuseRecord = true
+	// last is the end of the last row parsed, which is only complete if it ends in a newline,
+	// and complete is what is kept if it doesn't.
+	var last, complete Recovery
+	damaged := 0
+	for {
+		_, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			if damaged == 0 {
+				damaged = parseErr.StartLine
+			}
+			continue
+		} else if err != nil {
+			return recovery, fmt.Errorf("failed reading trace: %w", err)
+		}
+		if damaged > 0 {
+			return recovery, fmt.Errorf("trace is damaged at line %d", damaged)
+		}
+		complete = last
+		last = Recovery{Rows: last.Rows + 1, Size: csvReader.InputOffset()}
+	}
+	recovery = last
+	if last.Size > 0 {
+		end := make([]byte, 1)
+		if _, err := f.ReadAt(end, last.Size-1); err != nil {
+			return recovery, fmt.Errorf("failed reading trace: %w", err)
+		}
+		if end[0] != '\n' {
+			recovery = complete
+		}
+	}
+	recovery.Discarded = info.Size() - recovery.Size
+	if recovery.Discarded == 0 {
+		return recovery, nil
+	}
+	if err := f.Truncate(recovery.Size); err != nil {
+		return recovery, fmt.Errorf("failed truncating partial row: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return recovery, fmt.Errorf("failed syncing trace: %w", err)
+	}
+	return recovery, nil
+}
+
+// writeFileAtomic replaces the file at path with data, so that a crash leaves either the
+// old or the new contents rather than a mix. The new contents are synced before they
+// replace the old.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".new"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	// Sync the directory so that the rename itself survives a crash. Windows can't open
+	// directories for syncing.
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
@@ -0,0 +1,167 @@
+package backend
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecoverTrace(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		trace    string
+		expected Recovery
+		kept     string
+	}{
+		{name: "empty"},
+		{
+			name:     "complete",
+			trace:    "start (ns),end (ns),a (J)\n1,2,3\n",
+			expected: Recovery{Rows: 2, Size: 32},
+			kept:     "start (ns),end (ns),a (J)\n1,2,3\n",
+		},
+		{
+			name:     "partial row",
+			trace:    "start (ns),end (ns),a (J)\n1,2,3\n4,5,",
+			expected: Recovery{Rows: 2, Size: 32, Discarded: 4},
+			kept:     "start (ns),end (ns),a (J)\n1,2,3\n",
+		},
+		{
+			name:     "partial heading",
+			trace:    "start (ns),en",
+			expected: Recovery{Discarded: 13},
+		},
+		{
+			name:     "multi-line label",
+			trace:    "start (ns),end (ns),a (J)\nmark,1,\"first\nsecond\"\n2,3,4\n",
+			expected: Recovery{Rows: 3, Size: 54},
+			kept:     "start (ns),end (ns),a (J)\nmark,1,\"first\nsecond\"\n2,3,4\n",
+		},
+		{
+			name:     "partial multi-line label",
+			trace:    "start (ns),end (ns),a (J)\n1,2,3\nmark,4,\"first\nsec",
+			expected: Recovery{Rows: 2, Size: 32, Discarded: 17},
+			kept:     "start (ns),end (ns),a (J)\n1,2,3\n",
+		},
+		{
+			name:     "partial last field",
+			trace:    "start (ns),end (ns),a (J)\n1,2,3\n4,5,6",
+			expected: Recovery{Rows: 2, Size: 32, Discarded: 5},
+			kept:     "start (ns),end (ns),a (J)\n1,2,3\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "trace.csv")
+			if err := os.WriteFile(path, []byte(tc.trace), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			recovery, err := RecoverTrace(path)
+			if err != nil {
+				t.Fatalf("failed recovering trace: %v", err)
+			}
+			if recovery != tc.expected {
+				t.Errorf("expected recovery %+v, got %+v", tc.expected, recovery)
+			}
+			if kept, _ := os.ReadFile(path); string(kept) != tc.kept {
+				t.Errorf("expected trace %q to be kept, got %q", tc.kept, kept)
+			}
+		})
+	}
+}
+
+func TestRecoverTraceDamaged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.csv")
+	trace := "start (ns),end (ns),a (J)\nmark,1,bad\"quote\n1,2,3\n4,5,"
+	if err := os.WriteFile(path, []byte(trace), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RecoverTrace(path); err == nil {
+		t.Errorf("expected recovering a damaged trace to fail")
+	}
+	if kept, _ := os.ReadFile(path); string(kept) != trace {
+		t.Errorf("expected damaged trace to be kept, got %q", kept)
+	}
+}
+
+func TestRecoverTraceRecording(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.csv")
+	log, err := createSessionLog(path, DurabilityOptions{Sync: SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := log.Write([]string{"start (ns)", "end (ns)", "a (J)"}); err != nil {
+		t.Fatal(err)
+	}
+	// Simulate a row that is partly written when the trace is recovered.
+	if _, err := log.file.WriteString("1,2,"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RecoverTrace(path); !errors.Is(err, ErrRecording) {
+		t.Errorf("expected recovering a trace being recorded to fail with %v, got %v", ErrRecording, err)
+	}
+	if size, _ := log.Size(); size != 30 {
+		t.Errorf("expected the trace being recorded to keep 30 bytes, got %d", size)
+	}
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+	recovery, err := RecoverTrace(path)
+	if err != nil {
+		t.Fatalf("failed recovering trace once recorded: %v", err)
+	}
+	if expected := (Recovery{Rows: 1, Size: 26, Discarded: 4}); recovery != expected {
+		t.Errorf("expected recovery %+v, got %+v", expected, recovery)
+	}
+	if _, err := os.Stat(path + ".lock"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the trace lock to be removed, got %v", err)
+	}
+}
+
+func TestSessionLogFlush(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		opts     DurabilityOptions
+		expected int64
+	}{
+		{name: "buffered", opts: DurabilityOptions{FlushInterval: time.Hour, FlushBytes: 1 << 20, Sync: SyncNever}},
+		{name: "size", opts: DurabilityOptions{FlushInterval: time.Hour, FlushBytes: 4, Sync: SyncOnFlush}, expected: 6},
+		{name: "interval", opts: DurabilityOptions{FlushBytes: 1 << 20, Sync: SyncOnFlush}, expected: 6},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			log, err := createSessionLog(filepath.Join(t.TempDir(), "session.csv"), tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer log.Close()
+			if err := log.Write([]string{"1", "2", "3"}); err != nil {
+				t.Fatalf("failed writing row: %v", err)
+			}
+			if size, _ := log.Size(); size != tc.expected {
+				t.Errorf("expected %d bytes written before flushing, got %d", tc.expected, size)
+			}
+			if err := log.Flush(); err != nil {
+				t.Fatalf("failed flushing: %v", err)
+			}
+			if size, _ := log.Size(); size != 6 {
+				t.Errorf("expected 6 bytes written after flushing, got %d", size)
+			}
+		})
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "benchmarks.json")
+	for _, contents := range []string{"[1]", "[1, 2]"} {
+		if err := writeFileAtomic(path, []byte(contents)); err != nil {
+			t.Fatalf("failed writing file: %v", err)
+		}
+		if data, _ := os.ReadFile(path); string(data) != contents {
+			t.Errorf("expected %q, got %q", contents, data)
+		}
+	}
+	if _, err := os.Stat(path + ".new"); !os.IsNotExist(err) {
+		t.Errorf("expected no temporary file to remain, got %v", err)
+	}
+}
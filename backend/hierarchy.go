@@ -0,0 +1,289 @@
+package backend
+
+import (
+	"path"
+	"slices"
+	"strings"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+// TotalSystemName is the name of the series totaling every energy series that no other
+// series contains.
+const TotalSystemName = "total system (W)"
+
+// containmentRules describe well-known sensors whose readings are part of another's, for
+// traces recorded without that metadata. Child and container patterns match series names
+// without their unit, and a child is contained by the series matching the first of its
+// container patterns that matches any. Each package of a multi-socket machine has domains
+// of the same names, so the nth series matching a child pattern is contained by the nth
+// series matching the container pattern, or by the last if there are fewer containers.
+// DRAM domains are powered separately from their package, so they aren't contained by it.
+var containmentRules = []struct {
+	child      string
+	containers []string
+}{
+	// RAPL domains as named on Linux. Intel's integrated GPUs are measured by uncore.
+	{child: "core", containers: []string{"package-*"}},
+	{child: "uncore", containers: []string{"package-*"}},
+	{child: "package-*", containers: []string{"psys"}},
+	// RAPL domains as named on Windows.
+	{child: "intel pp0", containers: []string{"intel pkg-*"}},
+	{child: "intel pp1 (uncore?)", containers: []string{"intel pkg-*"}},
+	{child: "intel pkg-*", containers: []string{"intel platform (psys?)"}},
+	{child: "amd core", containers: []string{"amd pkg-*"}},
+	// AMD's integrated GPUs draw from the CPU package.
+	{child: "AMD Radeon(TM) Graphics *", containers: []string{"amd pkg-*", "package-*"}},
+}
+
+// wholeSystemPatterns match the names of series measuring the whole system, like wall meters
+// and the batteries and adapters powering laptops, for traces recorded without containment
+// metadata. Each pattern matches series names without their unit.
+var wholeSystemPatterns = []string{
+	"scpi meter *",
+	"line meter *",
+	"BAT* discharge",
+	"AC* input",
+	"ADP* input",
+	"ucsi-source-psy-* input",
+}
+
+// containedSeries is implemented by series that know which series contains them.
+type containedSeries interface {
+	// Container returns the name of the series whose readings include this one's, or "" if
+	// there is none.
+	Container() string
+	// SetContainer records the name of the series whose readings include this one's.
+	SetContainer(name string)
+}
+
+// bareName returns a series name without its unit, like "package-0" for "package-0 (J)".
+func bareName(name string) string {
+	if open := strings.LastIndexByte(name, '('); open > 0 && headingUnit(name) != sensors.Unknown {
+		return strings.TrimSpace(name[:open])
+	}
+	return name
+}
+
+// Containers returns the index of the series containing each series of the dataset, or -1
+// for series that no series contains. Containment reported by the sensors takes precedence
+// over the built-in rules. Measured energy series that no rule places within another are
+// contained by the first series measuring the whole system, if any.
+func (d Dataset) Containers() []int {
+	whole := d.index(-1, isWholeSystem)
+	out := make([]int, len(d))
+	for i, s := range d {
+		out[i] = -1
+		if c, ok := s.(containedSeries); ok && c.Container() != "" {
+			out[i] = d.index(i, func(other DataSeries) bool { return other.Name() == c.Container() })
+			if out[i] >= 0 {
+				continue
+			}
+		}
+		if !s.Unit().IsEnergy() {
+			continue
+		}
+	rules:
+		for _, rule := range containmentRules {
+			if !matchesEnergy(s, rule.child) {
+				continue
+			}
+			// Count the earlier series matching the rule to find this one's package.
+			nth := 0
+			for _, other := range d[:i] {
+				if matchesEnergy(other, rule.child) {
+					nth++
+				}
+			}
+			for _, pattern := range rule.containers {
+				var candidates []int
+				for j, other := range d {
+					if j != i && matchesEnergy(other, pattern) {
+						candidates = append(candidates, j)
+					}
+				}
+				if len(candidates) > 0 {
+					out[i] = candidates[min(nth, len(candidates)-1)]
+					break rules
+				}
+			}
+		}
+		if out[i] < 0 && whole >= 0 && isMeasured(s) && !isWholeSystem(s) {
+			out[i] = whole
+		}
+	}
+	return out
+}
+
+// matchesEnergy reports whether a series measures energy and its name without its unit
+// matches the pattern.
+func matchesEnergy(s DataSeries, pattern string) bool {
+	ok, _ := path.Match(pattern, bareName(s.Name()))
+	return ok && s.Unit().IsEnergy()
+}
+
+// isWholeSystem reports whether a series measures the whole system according to its name.
+func isWholeSystem(s DataSeries) bool {
+	if !s.Unit().IsEnergy() {
+		return false
+	}
+	name := bareName(s.Name())
+	for _, pattern := range wholeSystemPatterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isMeasured reports whether a series was measured by a sensor rather than computed from
+// other series.
+func isMeasured(s DataSeries) bool {
+	switch s.(type) {
+	case *DerivedSeries, *CalibratedSeries:
+		return false
+	}
+	return true
+}
+
+// index returns the index of the first series other than the one at skip that satisfies
+// match, or -1.
+func (d Dataset) index(skip int, match func(DataSeries) bool) int {
+	for i, s := range d {
+		if i != skip && match(s) {
+			return i
+		}
+	}
+	return -1
+}
+
+// composedSeries is implemented by series computed from other series of the same dataset,
+// whose readings therefore include those of their inputs.
+type composedSeries interface {
+	inputSeries() []DataSeries
+}
+
+// parents returns the indices of the series whose readings directly include those of each
+// series of the dataset: its container, and the series computed from it. A series computed
+// from others is also included in any series that includes all of its inputs, so that
+// sum(gpu*) is part of a wall meter containing every GPU. Where two computed series include
+// each other that way, only the earlier is kept as the parent of the later.
+func (d Dataset) parents() [][]int {
+	out := make([][]int, len(d))
+	for i, c := range d.Containers() {
+		if c >= 0 {
+			out[i] = append(out[i], c)
+		}
+	}
+	inputs := make([][]int, len(d))
+	for i, s := range d {
+		composed, ok := s.(composedSeries)
+		if !ok {
+			continue
+		}
+		for _, in := range composed.inputSeries() {
+			if j := slices.Index(d, in); j >= 0 && j != i {
+				inputs[i] = append(inputs[i], j)
+				out[j] = append(out[j], i)
+			}
+		}
+	}
+	covering := make([][]int, len(d))
+	for i := range d {
+		if len(inputs[i]) == 0 {
+			continue
+		}
+		common := d.ancestors(inputs[i][0], out)
+		for _, in := range inputs[i][1:] {
+			ancestors := d.ancestors(in, out)
+			for j := range common {
+				common[j] = common[j] && ancestors[j]
+			}
+		}
+		for j, covers := range common {
+			if covers && j != i {
+				covering[i] = append(covering[i], j)
+			}
+		}
+	}
+	for i, cover := range covering {
+		for _, j := range cover {
+			if j > i && slices.Contains(covering[j], i) {
+				continue
+			}
+			out[i] = append(out[i], j)
+		}
+	}
+	return out
+}
+
+// ancestors reports which series include the readings of the series at i, following
+// parents transitively. It stops at series already visited in case containment is cyclic.
+func (d Dataset) ancestors(i int, parents [][]int) []bool {
+	out := make([]bool, len(d))
+	pending := slices.Clone(parents[i])
+	for len(pending) > 0 {
+		j := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if out[j] {
+			continue
+		}
+		out[j] = true
+		pending = append(pending, parents[j]...)
+	}
+	return out
+}
+
+// Stackable reports which series can be stacked or added together without counting any
+// energy twice. These are the enabled energy series that aren't included in another enabled
+// series, either by being contained by it or by being one of the inputs it is computed
+// from. The total is never stackable, since it exists to add up the others. Series beyond
+// the end of enabled are disabled.
+func (d Dataset) Stackable(enabled []bool) []bool {
+	parents := d.parents()
+	isEnabled := func(i int) bool {
+		total, _ := d[i].(*DerivedSeries)
+		return i < len(enabled) && enabled[i] && d[i].Unit().IsEnergy() && (total == nil || !total.total)
+	}
+	out := make([]bool, len(d))
+	for i := range d {
+		if !isEnabled(i) {
+			continue
+		}
+		out[i] = true
+		for j, included := range d.ancestors(i, parents) {
+			if included && j != i && isEnabled(j) {
+				out[i] = false
+				break
+			}
+		}
+	}
+	return out
+}
+
+// NewTotalSeries adds up the measured energy series of a dataset that no other measured
+// series contains, leaving out series computed from others. It returns false if there are
+// fewer than two such series, since their total would only repeat one of them.
+func NewTotalSeries(ds Dataset) (*DerivedSeries, bool) {
+	measured := make([]bool, len(ds))
+	for i, s := range ds {
+		measured[i] = isMeasured(s)
+	}
+	var root exprNode
+	var inputs []DataSeries
+	for i, top := range ds.Stackable(measured) {
+		if !top {
+			continue
+		}
+		var term exprNode = &reference{pattern: ds[i].Name(), quoted: true, series: ds[i]}
+		if root != nil {
+			term = &operation{op: '+', left: root, right: term}
+		}
+		root = term
+		inputs = append(inputs, ds[i])
+	}
+	if len(inputs) < 2 {
+		return nil, false
+	}
+	return &DerivedSeries{name: TotalSystemName, unit: sensors.Watts, root: root, inputs: inputs, total: true}, true
+}
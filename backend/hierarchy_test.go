@@ -0,0 +1,175 @@
+package backend
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDatasetHierarchy(t *testing.T) {
+	// Containment of the RAPL domains comes from the built-in rules, and of the apu from the
+	// trace. DRAM isn't part of the package.
+	const trace = `sample start (ns), sample end (ns), package-0 (J), core (J), dram (J), apu (W), gpu (W), fan (RPM), 
+contained,apu (W),package-0 (J)
+0, 1000000000, 10, 4, 2, 3, 5, 1000, 
+`
+	ds, err := LoadTrace(strings.NewReader(trace))
+	if err != nil {
+		t.Fatalf("failed loading trace: %v", err)
+	}
+	expectedContainers := []int{-1, 0, -1, 0, -1, -1}
+	if containers := ds.Containers(); !slices.Equal(containers, expectedContainers) {
+		t.Errorf("expected containers %v, got %v", expectedContainers, containers)
+	}
+	type testcase struct {
+		name      string
+		enabled   []bool
+		stackable []bool
+	}
+	for _, tc := range []testcase{
+		{
+			name:      "all enabled",
+			enabled:   []bool{true, true, true, true, true, true},
+			stackable: []bool{true, false, true, false, true, false},
+		},
+		{
+			name:      "container disabled",
+			enabled:   []bool{false, true, true, true, true, true},
+			stackable: []bool{false, true, true, true, true, false},
+		},
+		{
+			name:      "some disabled",
+			enabled:   []bool{false, true, false},
+			stackable: []bool{false, true, false, false, false, false},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if stackable := ds.Stackable(tc.enabled); !slices.Equal(stackable, tc.stackable) {
+				t.Errorf("expected stackable %v, got %v", tc.stackable, stackable)
+			}
+		})
+	}
+	total, ok := NewTotalSeries(ds)
+	if !ok {
+		t.Fatalf("expected a total of the package, dram, and gpu")
+	}
+	if sum := total.Sum(); sum != 17 {
+		t.Errorf("expected total of 17, got %f", sum)
+	}
+}
+
+func TestDatasetHierarchyMultiSocket(t *testing.T) {
+	// Each package's domains have the same names, and are listed in package order.
+	const trace = `sample start (ns), sample end (ns), package-0 (J), core (J), uncore (J), package-1 (J), core (J), uncore (J), dram (J), psys (J), 
+0, 1000000000, 10, 4, 1, 12, 5, 2, 3, 30, 
+`
+	ds, err := LoadTrace(strings.NewReader(trace))
+	if err != nil {
+		t.Fatalf("failed loading trace: %v", err)
+	}
+	expectedContainers := []int{7, 0, 0, 7, 3, 3, -1, -1}
+	if containers := ds.Containers(); !slices.Equal(containers, expectedContainers) {
+		t.Errorf("expected containers %v, got %v", expectedContainers, containers)
+	}
+}
+
+func TestDatasetWholeSystem(t *testing.T) {
+	// The battery is recognized by name, and contains every series that isn't part of
+	// another, including the gpu's process tree once the trace says it's part of the gpu.
+	const trace = `sample start (ns), sample end (ns), package-0 (J), core (J), gpu (W), gpu process tree (W), BAT0 discharge (W), 
+contained,gpu process tree (W),gpu (W)
+0, 1000000000, 10, 4, 5, 2, 20, 
+`
+	ds, err := LoadTrace(strings.NewReader(trace))
+	if err != nil {
+		t.Fatalf("failed loading trace: %v", err)
+	}
+	expectedContainers := []int{4, 0, 4, 2, -1}
+	if containers := ds.Containers(); !slices.Equal(containers, expectedContainers) {
+		t.Errorf("expected containers %v, got %v", expectedContainers, containers)
+	}
+	if stackable, expected := ds.Stackable([]bool{true, true, true, true, true}), []bool{false, false, false, false, true}; !slices.Equal(stackable, expected) {
+		t.Errorf("expected only the battery to be stackable, got %v", stackable)
+	}
+	if stackable, expected := ds.Stackable([]bool{true, true, true, true, false}), []bool{true, false, true, false, false}; !slices.Equal(stackable, expected) {
+		t.Errorf("expected the package and gpu to be stackable without the battery, got %v", stackable)
+	}
+	if _, ok := NewTotalSeries(ds); ok {
+		t.Errorf("expected no total, since the battery measures everything")
+	}
+}
+
+func TestStackableComputedSeries(t *testing.T) {
+	const trace = `sample start (ns), sample end (ns), package-0 (J), gpu (W), 
+0, 1000000000, 10, 5, 
+`
+	ds, err := LoadTrace(strings.NewReader(trace))
+	if err != nil {
+		t.Fatalf("failed loading trace: %v", err)
+	}
+	def, err := NewDerivation("gpu share (W)", "gpu * 0.5")
+	if err != nil {
+		t.Fatalf("failed parsing derivation: %v", err)
+	}
+	derived, err := NewDerivedSeries(def, ds)
+	if err != nil {
+		t.Fatalf("failed deriving: %v", err)
+	}
+	estimate, ok := NewCalibratedSeries(Calibration{
+		Model:        LinearModel,
+		Components:   []string{"package-0 (J)", "gpu (W)"},
+		Coefficients: []float64{1, 1},
+	}, ds)
+	if !ok {
+		t.Fatalf("expected calibration to apply")
+	}
+	ds = append(ds, derived, estimate)
+	total, ok := NewTotalSeries(ds)
+	if !ok {
+		t.Fatalf("expected a total of the package and gpu")
+	}
+	if sum := total.Sum(); sum != 15 {
+		t.Errorf("expected total of 15, got %f", sum)
+	}
+	ds = append(ds, total)
+	// Computed series include their inputs, and the estimate includes the gpu share, since
+	// it includes the gpu.
+	for _, tc := range []struct {
+		name              string
+		enabled, expected []bool
+	}{
+		{name: "all", enabled: []bool{true, true, true, true, true}, expected: []bool{false, false, false, true, false}},
+		{name: "no estimate", enabled: []bool{true, true, true, false, true}, expected: []bool{true, false, true, false, false}},
+		{name: "measured", enabled: []bool{true, true, false, false, true}, expected: []bool{true, true, false, false, false}},
+	} {
+		if stackable := ds.Stackable(tc.enabled); !slices.Equal(stackable, tc.expected) {
+			t.Errorf("%s: expected stackable %v, got %v", tc.name, tc.expected, stackable)
+		}
+	}
+}
+
+func TestStackableDerivedWithinWholeSystem(t *testing.T) {
+	const trace = `sample start (ns), sample end (ns), gpu0 (W), gpu1 (W), scpi meter 0 (W), 
+0, 1000000000, 5, 6, 20, 
+`
+	ds, err := LoadTrace(strings.NewReader(trace))
+	if err != nil {
+		t.Fatalf("failed loading trace: %v", err)
+	}
+	def, err := NewDerivation("gpus (W)", "sum(gpu*)")
+	if err != nil {
+		t.Fatalf("failed parsing derivation: %v", err)
+	}
+	derived, err := NewDerivedSeries(def, ds)
+	if err != nil {
+		t.Fatalf("failed deriving: %v", err)
+	}
+	ds = append(ds, derived)
+	// The meter includes both GPUs, and so their sum as well.
+	if stackable, expected := ds.Stackable([]bool{true, true, true, true}), []bool{false, false, true, false}; !slices.Equal(stackable, expected) {
+		t.Errorf("expected only the meter to be stackable, got %v", stackable)
+	}
+	if stackable, expected := ds.Stackable([]bool{true, true, false, true}), []bool{false, false, false, true}; !slices.Equal(stackable, expected) {
+		t.Errorf("expected the sum to be stackable without the meter, got %v", stackable)
+	}
+}
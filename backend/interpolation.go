@@ -0,0 +1,95 @@
+package backend
+
+import (
+	"fmt"
+	"time"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+// Interpolation describes how a series of instantaneous readings, like power in Watts, is
+// integrated between the times that they were read.
+type Interpolation uint8
+
+const (
+	// InterpolateStepNext holds each reading over the interval since the previous one,
+	// which suits readings that average the time since they were last read.
+	InterpolateStepNext Interpolation = iota
+	// InterpolateStepPrevious holds each reading until the next one.
+	InterpolateStepPrevious
+	// InterpolateLinear ramps linearly from each reading to the next.
+	InterpolateLinear
+)
+
+func (i Interpolation) String() string {
+	switch i {
+	case InterpolateStepNext:
+		return "step-next"
+	case InterpolateStepPrevious:
+		return "step-previous"
+	case InterpolateLinear:
+		return "linear"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseInterpolation returns the interpolation whose String form is s.
+func ParseInterpolation(s string) (Interpolation, error) {
+	for i := InterpolateStepNext; i <= InterpolateLinear; i++ {
+		if i.String() == s {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown interpolation %q", s)
+}
+
+// interpolatingSeries is implemented by series that can change how they integrate their
+// readings.
+type interpolatingSeries interface {
+	// SetInterpolation chooses how readings inserted afterwards are integrated, and the
+	// window that they are averaged over, if any.
+	SetInterpolation(mode Interpolation, window time.Duration)
+}
+
+// interpolator adjusts the samples of a series of instantaneous readings before they are
+// stored, so that integrating each stored sample as a constant level follows the chosen
+// interpolation.
+type interpolator struct {
+	mode Interpolation
+	// window is the duration that each reading is a trailing average over. A reading
+	// describes the middle of its window better than the moment it was read, so readings
+	// are shifted back by half of the window.
+	window      time.Duration
+	previous    Sample
+	hasPrevious bool
+}
+
+// apply returns the sample that a series should store for a reading. Quantities, like
+// energy in Joules, are returned unchanged.
+func (i *interpolator) apply(sample Sample) Sample {
+	if sample.Unit == sensors.Joules {
+		return sample
+	}
+	adjusted := sample
+	shift := i.window.Nanoseconds() / 2
+	adjusted.StartTimestampNS -= shift
+	adjusted.EndTimestampNS -= shift
+	// Readings separated by a gap can't be interpolated between.
+	if i.hasPrevious && i.previous.EndTimestampNS == sample.StartTimestampNS {
+		switch i.mode {
+		case InterpolateStepPrevious:
+			adjusted.Value = i.previous.Value
+		case InterpolateLinear:
+			adjusted.Value = (i.previous.Value + sample.Value) / 2
+		}
+	}
+	return adjusted
+}
+
+// remember records a reading that was stored, so that the next reading can be interpolated
+// from it.
+func (i *interpolator) remember(sample Sample) {
+	i.previous = sample
+	i.hasPrevious = true
+}
@@ -0,0 +1,463 @@
+package backend
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DataDir returns the default directory for recorded sessions and benchmarks. This is
+// $XDG_DATA_HOME/watt-wiser on Linux (~/.local/share/watt-wiser if it is unset), the local
+// application data directory on Windows, and the user's application support directory on
+// macOS.
+func DataDir() (string, error) {
+	switch runtime.GOOS {
+	case "windows", "darwin":
+		if dir := os.Getenv("LocalAppData"); dir != "" {
+			return filepath.Join(dir, "watt-wiser"), nil
+		}
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return "", fmt.Errorf("failed locating data dir: %w", err)
+		}
+		return filepath.Join(configDir, "watt-wiser"), nil
+	}
+	if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "watt-wiser"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed locating data dir: %w", err)
+	}
+	return filepath.Join(home, ".local", "share", "watt-wiser"), nil
+}
+
+// SessionRecord describes a recorded session in the library's index.
+type SessionRecord struct {
+	ID       string
+	Host     string
+	Start    time.Time
+	Duration time.Duration
+	// Size is the size of the session's trace in bytes.
+	Size  int64
+	Notes string
+}
+
+// BenchmarkRecord describes a benchmark in the library's index.
+type BenchmarkRecord struct {
+	ID        string
+	SessionID string
+	Host      string
+	Command   string
+	// Commit is the version control revision the benchmarked program was built from, if
+	// it could be determined.
+	Commit   string
+	Tags     []string
+	Notes    string
+	Start    time.Time
+	Duration time.Duration
+	// Series names the series of the summary results, which are the energy used and mean
+	// power drawn by the command above the baseline.
+	Series        []string
+	SummaryJoules []float64
+	SummaryWatts  []float64
+	RunDuration   time.Duration
+}
+
+// HasTag reports whether the benchmark is tagged with tag.
+func (b BenchmarkRecord) HasTag(tag string) bool {
+	return slices.Contains(b.Tags, tag)
+}
+
+// ParseTags splits a comma-separated list of tags, dropping empty ones.
+func ParseTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// Index lists the sessions and benchmarks in a library.
+type Index struct {
+	Sessions   []SessionRecord
+	Benchmarks []BenchmarkRecord
+}
+
+// Recent returns the indexed sessions, newest first.
+func (i Index) Recent() []SessionRecord {
+	sessions := slices.Clone(i.Sessions)
+	slices.SortStableFunc(sessions, func(a, b SessionRecord) int {
+		return b.Start.Compare(a.Start)
+	})
+	return sessions
+}
+
+// BenchmarkQuery selects benchmarks from an index. The zero value selects every benchmark.
+type BenchmarkQuery struct {
+	// Command matches the benchmarked command, or just its file name, either exactly or as
+	// a path.Match pattern like "*/nightly-*/game".
+	Command string
+	// Tags must all be present on a benchmark.
+	Tags []string
+	Host string
+	// Since and Until bound the start of a benchmark when they are set. Since is inclusive
+	// and Until exclusive.
+	Since, Until time.Time
+	// Limit keeps only the most recent benchmarks when it is positive.
+	Limit int
+}
+
+// matches reports whether the query selects a benchmark.
+func (q BenchmarkQuery) matches(b BenchmarkRecord) bool {
+	if q.Command != "" && !matchCommand(q.Command, b.Command) {
+		return false
+	}
+	for _, tag := range q.Tags {
+		if !b.HasTag(tag) {
+			return false
+		}
+	}
+	if q.Host != "" && q.Host != b.Host {
+		return false
+	}
+	if !q.Since.IsZero() && b.Start.Before(q.Since) {
+		return false
+	}
+	return q.Until.IsZero() || b.Start.Before(q.Until)
+}
+
+func matchCommand(pattern, command string) bool {
+	for _, candidate := range []string{command, filepath.Base(command)} {
+		if candidate == pattern {
+			return true
+		}
+		if ok, _ := path.Match(pattern, filepath.ToSlash(candidate)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Query returns the indexed benchmarks selected by the query, oldest first.
+func (i Index) Query(q BenchmarkQuery) []BenchmarkRecord {
+	var out []BenchmarkRecord
+	for _, b := range i.Benchmarks {
+		if q.matches(b) {
+			out = append(out, b)
+		}
+	}
+	slices.SortStableFunc(out, func(a, b BenchmarkRecord) int {
+		return a.Start.Compare(b.Start)
+	})
+	if q.Limit > 0 && len(out) > q.Limit {
+		out = out[len(out)-q.Limit:]
+	}
+	return out
+}
+
+// EnergyPoint is the energy one benchmark's command used from one series.
+type EnergyPoint struct {
+	BenchmarkID string
+	SessionID   string
+	Start, End  time.Time
+	Commit      string
+	Joules      float64
+	Watts       float64
+	// Outlier is set by Trends for points far from the others of their series.
+	Outlier bool
+}
+
+// SummaryJoules returns the energy that the benchmarks selected by the query used from the
+// named series, oldest first. The series may be named with or without its unit, and
+// benchmarks that didn't record it are left out.
+func (i Index) SummaryJoules(q BenchmarkQuery, series string) []EnergyPoint {
+	var out []EnergyPoint
+	for _, b := range i.Query(q) {
+		j := slices.IndexFunc(b.Series, func(name string) bool {
+			return name == series || bareName(name) == series
+		})
+		if point, ok := b.point(j); ok {
+			out = append(out, point)
+		}
+	}
+	return out
+}
+
+// point returns the summary result of the benchmark's series at index j, if it has one.
+func (b BenchmarkRecord) point(j int) (EnergyPoint, bool) {
+	if j < 0 || j >= len(b.SummaryJoules) {
+		return EnergyPoint{}, false
+	}
+	point := EnergyPoint{
+		BenchmarkID: b.ID,
+		SessionID:   b.SessionID,
+		Start:       b.Start,
+		End:         b.Start.Add(b.Duration),
+		Commit:      b.Commit,
+		Joules:      b.SummaryJoules[j],
+	}
+	if j < len(b.SummaryWatts) {
+		point.Watts = b.SummaryWatts[j]
+	}
+	return point, true
+}
+
+// Commands returns the distinct benchmarked commands, most recently benchmarked first.
+func (i Index) Commands() []string {
+	var commands []string
+	for _, b := range i.Query(BenchmarkQuery{}) {
+		commands = slices.DeleteFunc(commands, func(c string) bool { return c == b.Command })
+		commands = append(commands, b.Command)
+	}
+	slices.Reverse(commands)
+	return commands
+}
+
+// Library is a directory of recorded sessions and benchmarks along with an index of them.
+// The index keeps each session's and each benchmark's record in its own small file, so
+// updating a record, like the size of a session every indexInterval while it records, only
+// rewrites that record. Several instances of Watt Wiser may record into the same library, so
+// writes are serialized by a lock file and every file is replaced atomically.
+type Library struct {
+	dir string
+	// mu protects the caches.
+	mu sync.Mutex
+	// sessions and benchmarks cache the records decoded from the index, so that reading
+	// the index only decodes the records that changed since it was last read.
+	sessions   recordCache[SessionRecord]
+	benchmarks recordCache[BenchmarkRecord]
+}
+
+// The directories and files of a library's index.
+const (
+	indexDir = "index"
+	// sessionsDir and benchmarksDir within indexDir hold a file for each record.
+	sessionsDir   = "sessions"
+	benchmarksDir = "benchmarks"
+	// lockFile within indexDir is locked by every process writing to the index.
+	lockFile = "index.lock"
+	// legacyIndexFile is the single file that indexed libraries before records were stored
+	// separately. It is split into records when a library holding one is opened.
+	legacyIndexFile = "sessions.json"
+)
+
+// errLocked is returned when taking a lock that another process holds.
+var errLocked = errors.New("locked by another process")
+
+// OpenLibrary uses dir as a library, creating it if necessary.
+func OpenLibrary(dir string) (*Library, error) {
+	for _, d := range []string{dir, filepath.Join(dir, indexDir, sessionsDir), filepath.Join(dir, indexDir, benchmarksDir)} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			return nil, fmt.Errorf("failed creating data dir: %w", err)
+		}
+	}
+	l := &Library{
+		dir:        dir,
+		sessions:   recordCache[SessionRecord]{},
+		benchmarks: recordCache[BenchmarkRecord]{},
+	}
+	if err := l.migrate(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Dir returns the directory holding the library.
+func (l *Library) Dir() string {
+	return l.dir
+}
+
+// SessionPath returns the path of the trace of a session.
+func (l *Library) SessionPath(sessionID string) string {
+	return filepath.Join(l.dir, sessionFileFor(sessionID))
+}
+
+// BenchmarkPath returns the path of the file holding the benchmarks of a session.
+func (l *Library) BenchmarkPath(sessionID string) string {
+	return filepath.Join(l.dir, benchmarkFileFor(sessionID))
+}
+
+// Index reads the library's index, which is empty if nothing has been recorded yet.
+func (l *Library) Index() (Index, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var index Index
+	var err error
+	if index.Sessions, err = l.sessions.load(filepath.Join(l.dir, indexDir, sessionsDir)); err != nil {
+		return index, fmt.Errorf("failed reading session index: %w", err)
+	}
+	if index.Benchmarks, err = l.benchmarks.load(filepath.Join(l.dir, indexDir, benchmarksDir)); err != nil {
+		return index, fmt.Errorf("failed reading benchmark index: %w", err)
+	}
+	return index, nil
+}
+
+// RecordSession adds a session to the index, or updates it if it is already there.
+func (l *Library) RecordSession(record SessionRecord) error {
+	return l.write(sessionsDir, record.ID, record)
+}
+
+// Resize updates the size of an indexed session, like after it was recovered from a crash.
+func (l *Library) Resize(sessionID string, size int64) error {
+	return l.withLock(func() error {
+		path := l.recordPath(sessionsDir, sessionID)
+		var record SessionRecord
+		data, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed reading session record: %w", err)
+		}
+		if err := json.Unmarshal(data, &record); err != nil {
+			return fmt.Errorf("failed decoding session record: %w", err)
+		}
+		record.Size = size
+		return writeRecord(path, record)
+	})
+}
+
+// RecordBenchmark adds a benchmark to the index, or updates it if it is already there.
+func (l *Library) RecordBenchmark(record BenchmarkRecord) error {
+	return l.write(benchmarksDir, record.ID, record)
+}
+
+// recordPath returns the path of the record with the given ID in one of the index's
+// directories. IDs are hex encoded, since benchmark IDs may hold characters that can't be
+// used in file names, or differ only in case.
+func (l *Library) recordPath(dir, id string) string {
+	return filepath.Join(l.dir, indexDir, dir, hex.EncodeToString([]byte(id))+".json")
+}
+
+// write replaces the record with the given ID in one of the index's directories.
+func (l *Library) write(dir, id string, record any) error {
+	return l.withLock(func() error {
+		return writeRecord(l.recordPath(dir, id), record)
+	})
+}
+
+func writeRecord(path string, record any) error {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed encoding index record: %w", err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed writing index record: %w", err)
+	}
+	return nil
+}
+
+// withLock runs f while holding the library's lock file, which keeps other processes from
+// writing to the index at the same time.
+func (l *Library) withLock(f func() error) error {
+	lock, err := os.OpenFile(filepath.Join(l.dir, indexDir, lockFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed opening index lock: %w", err)
+	}
+	defer lock.Close()
+	if err := lockExclusive(lock, true); err != nil {
+		return fmt.Errorf("failed locking index: %w", err)
+	}
+	return f()
+}
+
+// migrate splits the index of a library written before records were stored separately.
+func (l *Library) migrate() error {
+	return l.withLock(func() error {
+		legacy := filepath.Join(l.dir, legacyIndexFile)
+		data, err := os.ReadFile(legacy)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed reading legacy session index: %w", err)
+		}
+		var index Index
+		if err := json.Unmarshal(data, &index); err != nil {
+			return fmt.Errorf("failed decoding legacy session index: %w", err)
+		}
+		for _, record := range index.Sessions {
+			if err := writeRecord(l.recordPath(sessionsDir, record.ID), record); err != nil {
+				return err
+			}
+		}
+		for _, record := range index.Benchmarks {
+			if err := writeRecord(l.recordPath(benchmarksDir, record.ID), record); err != nil {
+				return err
+			}
+		}
+		if err := os.Remove(legacy); err != nil {
+			return fmt.Errorf("failed removing legacy session index: %w", err)
+		}
+		log.Printf("split legacy index of %d sessions and %d benchmarks into records", len(index.Sessions), len(index.Benchmarks))
+		return nil
+	})
+}
+
+// cachedRecord is a record decoded from a file, along with the modification time and size
+// of the file it was decoded from.
+type cachedRecord[T any] struct {
+	modTime time.Time
+	size    int64
+	record  T
+}
+
+// recordCache holds the records decoded from a directory of the index, by file name.
+type recordCache[T any] map[string]cachedRecord[T]
+
+// load returns every record in dir, decoding only those whose files changed since the last
+// load. Records that can't be decoded are skipped.
+func (c recordCache[T]) load(dir string) ([]T, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	out := make([]T, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if filepath.Ext(name) != ".json" {
+			// Skip the temporary files of records being replaced.
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// The record was removed since the directory was listed.
+			continue
+		}
+		seen[name] = true
+		cached, ok := c[name]
+		if !ok || !cached.modTime.Equal(info.ModTime()) || cached.size != info.Size() {
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				log.Printf("skipping index record %s: %v", name, err)
+				continue
+			}
+			cached = cachedRecord[T]{modTime: info.ModTime(), size: info.Size()}
+			if err := json.Unmarshal(data, &cached.record); err != nil {
+				log.Printf("skipping index record %s: %v", name, err)
+				continue
+			}
+			c[name] = cached
+		}
+		out = append(out, cached.record)
+	}
+	for name := range c {
+		if !seen[name] {
+			delete(c, name)
+		}
+	}
+	return out, nil
+}
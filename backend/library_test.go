@@ -0,0 +1,184 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLibraryIndex(t *testing.T) {
+	library, err := OpenLibrary(filepath.Join(t.TempDir(), "data"))
+	if err != nil {
+		t.Fatalf("failed opening library: %v", err)
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, record := range []SessionRecord{
+		{ID: "old", Host: "a", Start: start},
+		{ID: "new", Host: "a", Start: start.Add(time.Hour)},
+		// Updating a session replaces its record.
+		{ID: "old", Host: "a", Start: start, Duration: time.Minute, Size: 100},
+	} {
+		if err := library.RecordSession(record); err != nil {
+			t.Fatalf("failed recording session: %v", err)
+		}
+	}
+	if err := library.RecordBenchmark(BenchmarkRecord{ID: "b", SessionID: "new", Command: "true"}); err != nil {
+		t.Fatalf("failed recording benchmark: %v", err)
+	}
+	index, err := library.Index()
+	if err != nil {
+		t.Fatalf("failed reading index: %v", err)
+	}
+	var ids []string
+	for _, session := range index.Recent() {
+		ids = append(ids, session.ID)
+	}
+	if expected := []string{"new", "old"}; !slices.Equal(ids, expected) {
+		t.Errorf("expected recent sessions %v, got %v", expected, ids)
+	}
+	if old := index.Recent()[1]; old.Duration != time.Minute || old.Size != 100 {
+		t.Errorf("expected updated session record, got %+v", old)
+	}
+	if len(index.Benchmarks) != 1 || index.Benchmarks[0].SessionID != "new" {
+		t.Errorf("expected one benchmark of session new, got %+v", index.Benchmarks)
+	}
+}
+
+func TestLibraryInstances(t *testing.T) {
+	// Two instances recording into the same library mustn't lose each other's records.
+	dir := filepath.Join(t.TempDir(), "data")
+	first, err := OpenLibrary(dir)
+	if err != nil {
+		t.Fatalf("failed opening library: %v", err)
+	}
+	second, err := OpenLibrary(dir)
+	if err != nil {
+		t.Fatalf("failed opening library: %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			if err := first.RecordBenchmark(BenchmarkRecord{ID: fmt.Sprintf("first/%d", i)}); err != nil {
+				t.Errorf("failed recording benchmark: %v", err)
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			if err := second.RecordSession(SessionRecord{ID: fmt.Sprint(i)}); err != nil {
+				t.Errorf("failed recording session: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	index, err := first.Index()
+	if err != nil {
+		t.Fatalf("failed reading index: %v", err)
+	}
+	if len(index.Sessions) != 20 || len(index.Benchmarks) != 20 {
+		t.Errorf("expected 20 sessions and 20 benchmarks, got %d and %d", len(index.Sessions), len(index.Benchmarks))
+	}
+	// Updates by one instance are seen by the other.
+	if err := second.Resize("3", 42); err != nil {
+		t.Fatalf("failed resizing session: %v", err)
+	}
+	if index, err = first.Index(); err != nil {
+		t.Fatalf("failed reading index: %v", err)
+	}
+	i := slices.IndexFunc(index.Sessions, func(s SessionRecord) bool { return s.ID == "3" })
+	if i < 0 || index.Sessions[i].Size != 42 {
+		t.Errorf("expected session 3 to be resized to 42 bytes, got %+v", index.Sessions)
+	}
+}
+
+func TestLibraryMigration(t *testing.T) {
+	dir := t.TempDir()
+	legacy := `{"Sessions": [{"ID": "s"}], "Benchmarks": [{"ID": "b/1", "SessionID": "s"}]}`
+	if err := os.WriteFile(filepath.Join(dir, "sessions.json"), []byte(legacy), 0o644); err != nil {
+		t.Fatalf("failed writing legacy index: %v", err)
+	}
+	library, err := OpenLibrary(dir)
+	if err != nil {
+		t.Fatalf("failed opening library: %v", err)
+	}
+	index, err := library.Index()
+	if err != nil {
+		t.Fatalf("failed reading index: %v", err)
+	}
+	if len(index.Sessions) != 1 || len(index.Benchmarks) != 1 || index.Benchmarks[0].ID != "b/1" {
+		t.Errorf("expected the legacy session and benchmark, got %+v", index)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sessions.json")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected the legacy index to be removed, got %v", err)
+	}
+}
+
+func TestDataDir(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		t.Skip("XDG_DATA_HOME only applies on Linux and other unixes")
+	}
+	t.Setenv("XDG_DATA_HOME", "/xdg/data")
+	dir, err := DataDir()
+	if err != nil {
+		t.Fatalf("failed locating data dir: %v", err)
+	}
+	if expected := filepath.Join("/xdg/data", "watt-wiser"); dir != expected {
+		t.Errorf("expected data dir %q, got %q", expected, dir)
+	}
+}
+
+func TestIndexQuery(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 2, 0, 0, 0, time.UTC) }
+	series := []string{"package-0 (J)", "gpu (J)"}
+	index := Index{Benchmarks: []BenchmarkRecord{
+		{ID: "3", Command: "/builds/3/game", Commit: "c3", Tags: []string{"nightly"}, Host: "a", Start: day(3), Series: series, SummaryJoules: []float64{30, 3}},
+		{ID: "1", Command: "/builds/1/game", Commit: "c1", Tags: []string{"nightly", "low"}, Host: "a", Start: day(1), Series: series, SummaryJoules: []float64{10, 1}},
+		{ID: "2", Command: "/builds/2/game", Commit: "c2", Tags: []string{"nightly"}, Host: "b", Start: day(2), Series: series[:1], SummaryJoules: []float64{20}},
+		{ID: "x", Command: "/usr/bin/make", Host: "a", Start: day(2)},
+	}}
+	for _, tc := range []struct {
+		name     string
+		query    BenchmarkQuery
+		expected []string
+	}{
+		{name: "all", expected: []string{"1", "2", "x", "3"}},
+		{name: "command name", query: BenchmarkQuery{Command: "game"}, expected: []string{"1", "2", "3"}},
+		{name: "command pattern", query: BenchmarkQuery{Command: "/builds/[12]/*"}, expected: []string{"1", "2"}},
+		{name: "tags", query: BenchmarkQuery{Tags: []string{"low", "nightly"}}, expected: []string{"1"}},
+		{name: "host", query: BenchmarkQuery{Host: "b"}, expected: []string{"2"}},
+		{name: "dates", query: BenchmarkQuery{Since: day(2), Until: day(3)}, expected: []string{"2", "x"}},
+		{name: "limit", query: BenchmarkQuery{Tags: []string{"nightly"}, Limit: 2}, expected: []string{"2", "3"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var ids []string
+			for _, b := range index.Query(tc.query) {
+				ids = append(ids, b.ID)
+			}
+			if !slices.Equal(ids, tc.expected) {
+				t.Errorf("expected benchmarks %v, got %v", tc.expected, ids)
+			}
+		})
+	}
+
+	points := index.SummaryJoules(BenchmarkQuery{Tags: []string{"nightly"}}, "gpu")
+	var commits []string
+	var joules []float64
+	for _, p := range points {
+		commits = append(commits, p.Commit)
+		joules = append(joules, p.Joules)
+	}
+	if expected := []string{"c1", "c3"}; !slices.Equal(commits, expected) {
+		t.Errorf("expected energy history of commits %v, got %v", expected, commits)
+	}
+	if expected := []float64{1, 3}; !slices.Equal(joules, expected) {
+		t.Errorf("expected energy history %v, got %v", expected, joules)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"strings"
 	"testing"
 )
 
@@ -50,3 +51,19 @@ func TestLineReader(t *testing.T) {
 	buf.WriteString("bin\nbaz")
 	expectToRead(t, l, []byte("foobarbin\n"))
 }
+
+func TestLineReaderLongLine(t *testing.T) {
+	line := strings.Repeat("x", 3000) + "\n"
+	l := NewLineReader(strings.NewReader(line + "partial"))
+	read, err := io.ReadAll(io.LimitReader(l, int64(len(line))))
+	if err != nil {
+		t.Fatalf("failed reading: %v", err)
+	}
+	if string(read) != line {
+		t.Errorf("expected a line of %d bytes across small reads, got %d bytes", len(line), len(read))
+	}
+	expectReadEOF(t, l)
+	if consumed := l.Consumed(); consumed != int64(len(line)+len("partial")) {
+		t.Errorf("expected %d bytes consumed, got %d", len(line)+len("partial"), consumed)
+	}
+}
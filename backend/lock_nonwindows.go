@@ -0,0 +1,25 @@
+//go:build !windows
+
+package backend
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockExclusive takes an exclusive advisory lock on f, which is released when f is closed.
+// If wait is false and another process holds the lock, it fails with errLocked instead of
+// waiting for the lock to be released.
+func lockExclusive(f *os.File, wait bool) error {
+	how := unix.LOCK_EX
+	if !wait {
+		how |= unix.LOCK_NB
+	}
+	err := unix.Flock(int(f.Fd()), how)
+	if errors.Is(err, unix.EWOULDBLOCK) {
+		return errLocked
+	}
+	return err
+}
@@ -1,8 +1,10 @@
 package backend
 
 import (
+	"slices"
 	"sort"
 	"sync"
+	"time"
 
 	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
 )
@@ -25,10 +27,10 @@ func NewBenchmarkSeriesFrom(series DataSeries, bd BenchmarkData) *BenchmarkSerie
 	}
 	totalBaselineDuration := float64(bd.PreBaselineEnd - bd.PreBaselineStart + bd.PostBaselineEnd - bd.PostBaselineStart)
 	preDuration := float64(bd.PreBaselineEnd - bd.PreBaselineStart)
-	_, preMean, _, _, _ := series.RatesBetween(bd.PreBaselineStart, bd.PreBaselineEnd)
+	_, preMean, _, _, _, _ := series.RatesBetween(bd.PreBaselineStart, bd.PreBaselineEnd)
 	postDuration := float64(bd.PostBaselineEnd - bd.PostBaselineStart)
-	_, postMean, _, _, _ := series.RatesBetween(bd.PostBaselineStart, bd.PostBaselineEnd)
-	_, _, _, sum, _ := series.RatesBetween(bd.PreBaselineStart, bd.PostBaselineEnd)
+	_, postMean, _, _, _, _ := series.RatesBetween(bd.PostBaselineStart, bd.PostBaselineEnd)
+	_, _, _, sum, _, _ := series.RatesBetween(bd.PreBaselineStart, bd.PostBaselineEnd)
 	b.baselineRate = (preMean*preDuration + postMean*postDuration) / totalBaselineDuration
 	b.baselineSum = sum - (float64(b.bd.PostBaselineEnd-b.bd.PreBaselineStart)*b.baselineRate)/1_000_000_000
 	return b
@@ -49,6 +51,10 @@ func (b *BenchmarkSeries) RateRange() (min, max float64) {
 	return min, max
 }
 
+func (b *BenchmarkSeries) Unit() sensors.Unit {
+	return b.wrapped.Unit()
+}
+
 func (b *BenchmarkSeries) Name() string {
 	return b.namePrefix + b.wrapped.Name()
 }
@@ -57,21 +63,21 @@ func (b *BenchmarkSeries) Sum() float64 {
 	return b.baselineSum
 }
 
-func (b *BenchmarkSeries) RatesBetween(timestampA, timestampB int64) (maximum, mean, minimum, sum float64, ok bool) {
+func (b *BenchmarkSeries) RatesBetween(timestampA, timestampB int64) (maximum, mean, minimum, sum, coverage float64, ok bool) {
 	if timestampA <= 0 && timestampB <= 0 {
-		return 0, 0, 0, 0, false
+		return 0, 0, 0, 0, 0, false
 	}
 	// Normalize the times so that time zero is the baseline start.
 	timestampA += b.bd.PreBaselineStart
 	timestampB += b.bd.PreBaselineStart
 	// Query real values.
-	maximum, mean, minimum, sum, ok = b.wrapped.RatesBetween(timestampA, timestampB)
-	// Factor out baseline usage.
+	maximum, mean, minimum, sum, coverage, ok = b.wrapped.RatesBetween(timestampA, timestampB)
+	// Factor out baseline usage over the time with data.
 	maximum -= b.baselineRate
 	minimum -= b.baselineRate
 	mean -= b.baselineRate
-	sum -= (b.baselineRate * float64(timestampB-timestampA)) / 1_000_000_000
-	return maximum, mean, minimum, sum, ok
+	sum -= (b.baselineRate * float64(timestampB-timestampA) * coverage) / 1_000_000_000
+	return maximum, mean, minimum, sum, coverage, ok
 }
 
 // Series represents one data set in a visualization.
@@ -80,15 +86,22 @@ type Series struct {
 	startTimestamps            []int64
 	endTimestamps              []int64
 	values                     []float64
+	aggregates                 pyramid
+	gaps                       gapList
+	interpolation              interpolator
 	rangeRateMax, rangeRateMin float64
 	domainMin, domainMax       int64
 	sum                        float64
 	name                       string
+	unit                       sensors.Unit
 	initialized                bool
+	// container is the name of the series whose readings include this one's, if known.
+	container string
 }
 
-func NewSeries(name string) *Series {
-	return &Series{name: name}
+// NewSeries creates an empty series of samples in the given unit.
+func NewSeries(name string, unit sensors.Unit) *Series {
+	return &Series{name: name, unit: unit}
 }
 
 func (s *Series) Name() string {
@@ -97,6 +110,28 @@ func (s *Series) Name() string {
 	return s.name
 }
 
+// Unit returns the unit of the samples in the series. Series of Joules and Watts both
+// report rates in Watts.
+func (s *Series) Unit() sensors.Unit {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.unit
+}
+
+// Container returns the name of the series whose readings include this one's, if known.
+func (s *Series) Container() string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.container
+}
+
+// SetContainer records the name of the series whose readings include this one's.
+func (s *Series) SetContainer(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.container = name
+}
+
 func (s *Series) Initialized() bool {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
@@ -121,12 +156,23 @@ func (s *Series) Sum() float64 {
 	return s.sum
 }
 
+// SetInterpolation chooses how instantaneous readings inserted afterwards are integrated,
+// and the window that each of them is a trailing average over, if any.
+func (s *Series) SetInterpolation(mode Interpolation, window time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.interpolation.mode = mode
+	s.interpolation.window = window
+}
+
 // Insert adds a value at a given timestamp to the series. In the event
 // that the series already contains a value at that time, nothing is added
 // and the method returns false. Otherwise, the method returns true.
 func (s *Series) Insert(sample Sample) (inserted bool) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	reading := sample
+	sample = s.interpolation.apply(reading)
 	if !s.initialized {
 		s.domainMin = sample.StartTimestampNS
 		s.domainMax = sample.StartTimestampNS
@@ -136,6 +182,9 @@ func (s *Series) Insert(sample Sample) (inserted bool) {
 		// Reject samples with times overlapping the existing data in the series.
 		return false
 	}
+	if len(s.endTimestamps) > 0 && s.endTimestamps[len(s.endTimestamps)-1] < sample.StartTimestampNS {
+		s.gaps.add(s.endTimestamps[len(s.endTimestamps)-1], sample.StartTimestampNS)
+	}
 	s.domainMin = min(sample.StartTimestampNS, s.domainMin)
 	s.domainMax = max(sample.EndTimestampNS, s.domainMax)
 	var rate float64
@@ -145,7 +194,9 @@ func (s *Series) Insert(sample Sample) (inserted bool) {
 	if sample.Unit == sensors.Joules {
 		rate = (sample.Value / durationSeconds)
 		quantity = sample.Value
-	} else if sample.Unit == sensors.Watts {
+	} else {
+		// Every other unit is an instantaneous level, which we integrate over the sample
+		// so that RatesBetween reports its time-weighted mean.
 		rate = sample.Value
 		quantity = (sample.Value * duration) / 1_000_000_000
 	}
@@ -160,86 +211,251 @@ func (s *Series) Insert(sample Sample) (inserted bool) {
 	s.startTimestamps = append(s.startTimestamps, sample.StartTimestampNS)
 	s.endTimestamps = append(s.endTimestamps, sample.EndTimestampNS)
 	s.values = append(s.values, quantity)
+	s.aggregates.extend(len(s.values), s.sampleAggregate)
 
 	s.sum += quantity
+	s.interpolation.remember(reading)
 	return true
 }
 
+// aggregate summarizes a run of consecutive samples in a series.
+type aggregate struct {
+	// sum is the total quantity of the samples.
+	sum float64
+	// maxRate and minRate are the extreme rates of the samples.
+	maxRate, minRate float64
+}
+
+func (a aggregate) merge(b aggregate) aggregate {
+	return aggregate{
+		sum:     a.sum + b.sum,
+		maxRate: max(a.maxRate, b.maxRate),
+		minRate: min(a.minRate, b.minRate),
+	}
+}
+
+// sampleAggregate returns the aggregate of the single sample at index i.
+func (s *Series) sampleAggregate(i int) aggregate {
+	interval := float64(s.endTimestamps[i] - s.startTimestamps[i])
+	rate := s.values[i] / (interval / 1_000_000_000)
+	return aggregate{sum: s.values[i], maxRate: rate, minRate: rate}
+}
+
+// pyramid holds the aggregates of every aligned power-of-two run of a growing sequence of
+// items, so that any range of the items can be summarized from a logarithmic number of
+// aggregates. The items themselves are summarized by a leaf function supplied by the owner.
+type pyramid struct {
+	// levels[k] holds the aggregates of the completed runs of 2<<k items.
+	levels [][]aggregate
+}
+
+// run returns the aggregate of the 1<<level items starting at index<<level.
+func (p *pyramid) run(level, index int, leaf func(int) aggregate) aggregate {
+	if level == 0 {
+		return leaf(index)
+	}
+	return p.levels[level-1][index]
+}
+
+// extend adds the runs completed by the count-th item. Every run is built exactly once,
+// from the two runs below it.
+func (p *pyramid) extend(count int, leaf func(int) aggregate) {
+	for level := 1; count&(1<<level-1) == 0; level++ {
+		if len(p.levels) < level {
+			p.levels = append(p.levels, nil)
+		}
+		index := (count >> level) - 1
+		merged := p.run(level-1, 2*index, leaf).merge(p.run(level-1, 2*index+1, leaf))
+		p.levels[level-1] = append(p.levels[level-1], merged)
+	}
+}
+
+// between summarizes the items with indices in [lo,hi), which must not be empty, using the
+// largest aligned runs that fit within it.
+func (p *pyramid) between(lo, hi int, leaf func(int) aggregate) aggregate {
+	var result aggregate
+	for first := true; lo < hi; first = false {
+		level := 0
+		for level < len(p.levels) && lo&(2<<level-1) == 0 && lo+2<<level <= hi {
+			level++
+		}
+		next := p.run(level, lo>>level, leaf)
+		if first {
+			result = next
+		} else {
+			result = result.merge(next)
+		}
+		lo += 1 << level
+	}
+	return result
+}
+
 // RatesBetween returns statistics about the rate of consumption in the half-open time interval
 // [timestampA,timestampB). If is no data in the series, this method will
 // always return zero. If timestampA is less than timestampB, the half open
 // interval [timestampB,timestampA) will be returned. If the interval extends
 // beyond the domain of the data, all data return values will be zero and the
-// ok return value will be false.
-func (s *Series) RatesBetween(timestampA, timestampB int64) (maximum, mean, minimum, sum float64, ok bool) {
+// ok return value will be false. Queries take time logarithmic in the number of
+// samples, regardless of how many the interval spans.
+//
+// Coverage is the fraction of the interval for which the series has data. The mean and
+// extrema only describe the covered time, and the sum only includes it. If the interval
+// lies entirely within a gap, everything but ok is zero.
+func (s *Series) RatesBetween(timestampA, timestampB int64) (maximum, mean, minimum, sum, coverage float64, ok bool) {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
 	if timestampA == timestampB {
-		return 0, 0, 0, 0, true
+		return 0, 0, 0, 0, 0, true
 	}
 	if len(s.startTimestamps) < 1 {
-		return 0, 0, 0, 0, false
+		return 0, 0, 0, 0, 0, false
 	}
 	if timestampB < timestampA {
 		timestampA, timestampB = timestampB, timestampA
 	}
-	indexA := sort.Search(len(s.startTimestamps), func(i int) bool {
-		return timestampA < s.endTimestamps[i]
-	})
+	indexA := s.indexEndingAfter(timestampA)
 	if indexA == len(s.startTimestamps) {
-		return 0, 0, 0, 0, false
+		return 0, 0, 0, 0, 0, false
 	}
-	indexB := sort.Search(len(s.startTimestamps), func(i int) bool {
-		return timestampB < s.endTimestamps[i]
-	})
+	indexB := s.indexEndingAfter(timestampB)
 	if indexB == len(s.startTimestamps) {
 		lastEnd := s.endTimestamps[len(s.endTimestamps)-1]
 		if timestampB > lastEnd {
-			return 0, 0, 0, 0, false
+			return 0, 0, 0, 0, 0, false
 		}
 		// If the last timestamp is exactly equal to the end of the final time, then we can proceed.
 		indexB--
 	}
+	covered := s.gaps.covered(timestampA, timestampB, s.domainMin, s.domainMax)
+	if covered == 0 {
+		return 0, 0, 0, 0, 0, true
+	}
+	coverage = float64(covered) / float64(timestampB-timestampA)
 	if indexA == indexB {
 		v := s.values[indexA]
 		interval := float64(s.endTimestamps[indexA] - s.startTimestamps[indexA])
-		queryInterval := timestampB - timestampA
 		mean := v / (interval / 1_000_000_000)
-		ok = true
-		return mean, mean, mean, (v * (float64(queryInterval) / interval)), ok
-	}
-	values := s.values[indexA : indexB+1]
-	hasExtrema := false
-	for i, v := range values {
-		interval := float64(s.endTimestamps[indexA+i] - s.startTimestamps[indexA+i])
-		if i == 0 || i == len(values)-1 {
-			var querySampleInterval int64
-			if i == 0 {
-				querySampleInterval = s.endTimestamps[indexA] - timestampA
-			} else if i == len(values)-1 {
-				querySampleInterval = timestampB - s.startTimestamps[indexB]
-			}
-			if querySampleInterval == 0 {
-				continue
-			}
-			// Scale the value by the proportion of the sample that is within
-			// the queried period.
-			ratio := float64(querySampleInterval) / interval
-			v = v * ratio
-			interval = float64(querySampleInterval)
-		}
-		mean += v
-		rate := v / (interval / 1_000_000_000)
-		if hasExtrema {
-			maximum = max(maximum, rate)
-			minimum = min(minimum, rate)
-		} else {
-			maximum = rate
-			minimum = rate
-			hasExtrema = true
-		}
+		return mean, mean, mean, (v * (float64(covered) / interval)), coverage, true
+	}
+	var summary rateSummary
+	s.includeEdge(&summary, indexA, timestampA, timestampB)
+	s.includeBetween(&summary, indexA+1, indexB)
+	s.includeEdge(&summary, indexB, timestampA, timestampB)
+	mean = summary.sum / (float64(covered) / 1_000_000_000)
+	return summary.maximum, mean, summary.minimum, summary.sum, coverage, true
+}
+
+// Gaps returns the intervals between the first and last samples of the series for which
+// it has no data.
+func (s *Series) Gaps() []Gap {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return slices.Clone(s.gaps.gaps)
+}
+
+// Gap is an interval in which a series has no data, like while a sensor could not be read
+// or its machine was suspended.
+type Gap struct {
+	StartTimestampNS, EndTimestampNS int64
+}
+
+// gapList records the gaps of a series in order, along with their cumulative duration, so
+// that the time they cover within any interval can be found in logarithmic time.
+type gapList struct {
+	gaps []Gap
+	// totals[i] is the combined duration of gaps[:i+1].
+	totals []int64
+}
+
+// add records a gap, which must come after every gap recorded so far.
+func (g *gapList) add(start, end int64) {
+	total := end - start
+	if len(g.totals) > 0 {
+		total += g.totals[len(g.totals)-1]
+	}
+	g.gaps = append(g.gaps, Gap{StartTimestampNS: start, EndTimestampNS: end})
+	g.totals = append(g.totals, total)
+}
+
+// within returns how much of [timestampA,timestampB) lies within gaps.
+func (g *gapList) within(timestampA, timestampB int64) int64 {
+	first := sort.Search(len(g.gaps), func(i int) bool {
+		return timestampA < g.gaps[i].EndTimestampNS
+	})
+	end := sort.Search(len(g.gaps), func(i int) bool {
+		return timestampB <= g.gaps[i].StartTimestampNS
+	})
+	if first >= end {
+		return 0
+	}
+	total := g.totals[end-1]
+	if first > 0 {
+		total -= g.totals[first-1]
+	}
+	// Clip the outermost gaps to the interval.
+	total -= max(0, timestampA-g.gaps[first].StartTimestampNS)
+	total -= max(0, g.gaps[end-1].EndTimestampNS-timestampB)
+	return total
+}
+
+// covered returns how much of [timestampA,timestampB) is covered by the samples of a series
+// with the given domain.
+func (g *gapList) covered(timestampA, timestampB, domainMin, domainMax int64) int64 {
+	timestampA, timestampB = max(timestampA, domainMin), min(timestampB, domainMax)
+	if timestampB <= timestampA {
+		return 0
+	}
+	return timestampB - timestampA - g.within(timestampA, timestampB)
+}
+
+// rateSummary accumulates the statistics reported by RatesBetween.
+type rateSummary struct {
+	maximum, minimum, sum float64
+	hasExtrema            bool
+}
+
+func (r *rateSummary) include(quantity, maxRate, minRate float64) {
+	r.sum += quantity
+	if r.hasExtrema {
+		r.maximum = max(r.maximum, maxRate)
+		r.minimum = min(r.minimum, minRate)
+	} else {
+		r.maximum = maxRate
+		r.minimum = minRate
+		r.hasExtrema = true
+	}
+}
+
+// indexEndingAfter returns the index of the first sample that ends after timestamp, or the
+// number of samples if there is none.
+func (s *Series) indexEndingAfter(timestamp int64) int {
+	return sort.Search(len(s.endTimestamps), func(i int) bool {
+		return timestamp < s.endTimestamps[i]
+	})
+}
+
+// includeEdge includes the part of the sample at index i that overlaps the queried period
+// [timestampA,timestampB) in a summary.
+func (s *Series) includeEdge(summary *rateSummary, i int, timestampA, timestampB int64) {
+	querySampleInterval := min(timestampB, s.endTimestamps[i]) - max(timestampA, s.startTimestamps[i])
+	if querySampleInterval <= 0 {
+		return
+	}
+	// Scale the value by the proportion of the sample that is within
+	// the queried period.
+	interval := float64(s.endTimestamps[i] - s.startTimestamps[i])
+	ratio := float64(querySampleInterval) / interval
+	v := s.values[i] * ratio
+	rate := v / (float64(querySampleInterval) / 1_000_000_000)
+	summary.include(v, rate, rate)
+}
+
+// includeBetween includes the samples with indices in [lo,hi) in a summary. They must lie
+// wholly within the queried period.
+func (s *Series) includeBetween(summary *rateSummary, lo, hi int) {
+	if lo >= hi {
+		return
 	}
-	sum = mean
-	mean /= (float64(timestampB-timestampA) / 1_000_000_000)
-	return maximum, mean, minimum, sum, true
+	between := s.aggregates.between(lo, hi, s.sampleAggregate)
+	summary.include(between.sum, between.maxRate, between.minRate)
 }
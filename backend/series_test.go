@@ -1,7 +1,15 @@
 package backend
 
 import (
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"sort"
 	"testing"
+	"time"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
 )
 
 func makeTestSeries(t *testing.T, interval, sampleCount int64) (*Series, float64) {
@@ -97,7 +105,7 @@ func TestBenchmarkSeries(t *testing.T) {
 			ok:    true,
 		},
 	} {
-		maximum, mean, minimum, sum, ok := bs.RatesBetween(r.start, r.end)
+		maximum, mean, minimum, sum, _, ok := bs.RatesBetween(r.start, r.end)
 		if ok != r.ok {
 			t.Errorf("expected in-range data to be okay")
 		}
@@ -123,7 +131,7 @@ func TestSeries(t *testing.T) {
 	halfSample := interval / 2
 	sum := float64(0)
 	for i := int64(0); i < sampleCount*2; i++ {
-		max, mean, min, _, ok := s.RatesBetween(i*halfSample, (i+1)*halfSample)
+		max, mean, min, _, _, ok := s.RatesBetween(i*halfSample, (i+1)*halfSample)
 		if !ok {
 			t.Errorf("querying values in range should always be okay, value %d was not", i)
 		}
@@ -136,3 +144,267 @@ func TestSeries(t *testing.T) {
 		t.Errorf("expected %f total consumed, got %f", expectedSum, sum)
 	}
 }
+
+func TestSeriesLevels(t *testing.T) {
+	s := NewSeries("temperature", sensors.Celsius)
+	for _, sample := range []Sample{
+		{StartTimestampNS: 0, EndTimestampNS: 1_000_000_000, Value: 40, Unit: sensors.Celsius},
+		{StartTimestampNS: 1_000_000_000, EndTimestampNS: 4_000_000_000, Value: 50, Unit: sensors.Celsius},
+	} {
+		if !s.Insert(sample) {
+			t.Fatalf("inserting non-overlapping samples should always be okay")
+		}
+	}
+	if s.Unit() != sensors.Celsius {
+		t.Errorf("expected unit %s, got %s", sensors.Celsius, s.Unit())
+	}
+	rMin, rMax := s.RateRange()
+	if rMin != 40 || rMax != 50 {
+		t.Errorf("expected range [40, 50], got [%f, %f]", rMin, rMax)
+	}
+	// Levels are weighted by how long they were held.
+	maximum, mean, minimum, _, _, ok := s.RatesBetween(0, 4_000_000_000)
+	if !ok {
+		t.Errorf("expected in-range data to be okay")
+	}
+	if maximum != 50 || minimum != 40 {
+		t.Errorf("expected extrema 50 and 40, got %f and %f", maximum, minimum)
+	}
+	if mean != 47.5 {
+		t.Errorf("expected time-weighted mean 47.5, got %f", mean)
+	}
+}
+
+func TestSeriesGaps(t *testing.T) {
+	const second = int64(time.Second)
+	s := NewSeries("power", sensors.Watts)
+	for _, sample := range []Sample{
+		{StartTimestampNS: 0, EndTimestampNS: second, Value: 10, Unit: sensors.Watts},
+		{StartTimestampNS: 3 * second, EndTimestampNS: 4 * second, Value: 20, Unit: sensors.Watts},
+	} {
+		if !s.Insert(sample) {
+			t.Fatalf("inserting non-overlapping samples should always be okay")
+		}
+	}
+	expectedGaps := []Gap{{StartTimestampNS: second, EndTimestampNS: 3 * second}}
+	if gaps := s.Gaps(); !slices.Equal(gaps, expectedGaps) {
+		t.Errorf("expected gaps %v, got %v", expectedGaps, gaps)
+	}
+	type testcase struct {
+		name                          string
+		start, end                    int64
+		max, mean, min, sum, coverage float64
+	}
+	for _, tc := range []testcase{
+		{name: "spanning gap", start: 0, end: 4 * second, max: 20, mean: 15, min: 10, sum: 30, coverage: 0.5},
+		{name: "within gap", start: second, end: 3 * second},
+		{name: "partly in gap", start: second / 2, end: 2 * second, max: 10, mean: 10, min: 10, sum: 5, coverage: 1.0 / 3},
+		{name: "edges in gap", start: 2 * second, end: 4 * second, max: 20, mean: 20, min: 20, sum: 20, coverage: 0.5},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			maximum, mean, minimum, sum, coverage, ok := s.RatesBetween(tc.start, tc.end)
+			if !ok {
+				t.Errorf("expected in-range data to be okay")
+			}
+			if maximum != tc.max || mean != tc.mean || minimum != tc.min || sum != tc.sum {
+				t.Errorf("expected max, mean, min, and sum %f %f %f %f, got %f %f %f %f", tc.max, tc.mean, tc.min, tc.sum, maximum, mean, minimum, sum)
+			}
+			if !closeTo(coverage, tc.coverage) {
+				t.Errorf("expected coverage %f, got %f", tc.coverage, coverage)
+			}
+		})
+	}
+}
+
+func TestSeriesInterpolation(t *testing.T) {
+	const second = int64(time.Second)
+	readings := []Sample{
+		{StartTimestampNS: 0, EndTimestampNS: second, Value: 10, Unit: sensors.Watts},
+		{StartTimestampNS: second, EndTimestampNS: 2 * second, Value: 20, Unit: sensors.Watts},
+		{StartTimestampNS: 2 * second, EndTimestampNS: 3 * second, Value: 30, Unit: sensors.Watts},
+		{StartTimestampNS: 4 * second, EndTimestampNS: 5 * second, Value: 40, Unit: sensors.Watts},
+	}
+	type testcase struct {
+		mode                 Interpolation
+		window               time.Duration
+		sum                  float64
+		domainMin, domainMax int64
+	}
+	for _, tc := range []testcase{
+		{mode: InterpolateStepNext, sum: 100, domainMax: 5 * second},
+		// Readings after a gap have nothing to interpolate from.
+		{mode: InterpolateStepPrevious, sum: 80, domainMax: 5 * second},
+		{mode: InterpolateLinear, sum: 90, domainMax: 5 * second},
+		{mode: InterpolateStepNext, window: time.Second, sum: 100, domainMin: -second / 2, domainMax: 9 * second / 2},
+	} {
+		t.Run(fmt.Sprintf("%s window %s", tc.mode, tc.window), func(t *testing.T) {
+			s := NewSeries("power", sensors.Watts)
+			s.SetInterpolation(tc.mode, tc.window)
+			for _, reading := range readings {
+				if !s.Insert(reading) {
+					t.Fatalf("inserting non-overlapping samples should always be okay")
+				}
+			}
+			if sum := s.Sum(); sum != tc.sum {
+				t.Errorf("expected sum %f, got %f", tc.sum, sum)
+			}
+			if domainMin, domainMax := s.Domain(); domainMin != tc.domainMin || domainMax != tc.domainMax {
+				t.Errorf("expected domain [%d, %d], got [%d, %d]", tc.domainMin, tc.domainMax, domainMin, domainMax)
+			}
+		})
+	}
+}
+
+// linearRatesBetween implements Series.RatesBetween by visiting every sample in the queried
+// interval, as it originally did. It is kept as a reference for the aggregate pyramid and the
+// gap list.
+func linearRatesBetween(s *Series, timestampA, timestampB int64) (maximum, mean, minimum, sum, coverage float64, ok bool) {
+	if timestampA == timestampB {
+		return 0, 0, 0, 0, 0, true
+	}
+	if len(s.startTimestamps) < 1 {
+		return 0, 0, 0, 0, 0, false
+	}
+	if timestampB < timestampA {
+		timestampA, timestampB = timestampB, timestampA
+	}
+	indexA := sort.Search(len(s.startTimestamps), func(i int) bool {
+		return timestampA < s.endTimestamps[i]
+	})
+	if indexA == len(s.startTimestamps) {
+		return 0, 0, 0, 0, 0, false
+	}
+	indexB := sort.Search(len(s.startTimestamps), func(i int) bool {
+		return timestampB < s.endTimestamps[i]
+	})
+	if indexB == len(s.startTimestamps) {
+		lastEnd := s.endTimestamps[len(s.endTimestamps)-1]
+		if timestampB > lastEnd {
+			return 0, 0, 0, 0, 0, false
+		}
+		indexB--
+	}
+	overlap := func(i int) int64 {
+		return max(0, min(timestampB, s.endTimestamps[i])-max(timestampA, s.startTimestamps[i]))
+	}
+	var covered int64
+	for i := indexA; i <= indexB; i++ {
+		covered += overlap(i)
+	}
+	if covered == 0 {
+		return 0, 0, 0, 0, 0, true
+	}
+	coverage = float64(covered) / float64(timestampB-timestampA)
+	if indexA == indexB {
+		v := s.values[indexA]
+		interval := float64(s.endTimestamps[indexA] - s.startTimestamps[indexA])
+		mean := v / (interval / 1_000_000_000)
+		return mean, mean, mean, (v * (float64(covered) / interval)), coverage, true
+	}
+	values := s.values[indexA : indexB+1]
+	hasExtrema := false
+	for i, v := range values {
+		interval := float64(s.endTimestamps[indexA+i] - s.startTimestamps[indexA+i])
+		if i == 0 || i == len(values)-1 {
+			querySampleInterval := overlap(indexA + i)
+			if querySampleInterval == 0 {
+				continue
+			}
+			ratio := float64(querySampleInterval) / interval
+			v = v * ratio
+			interval = float64(querySampleInterval)
+		}
+		sum += v
+		rate := v / (interval / 1_000_000_000)
+		if hasExtrema {
+			maximum = max(maximum, rate)
+			minimum = min(minimum, rate)
+		} else {
+			maximum = rate
+			minimum = rate
+			hasExtrema = true
+		}
+	}
+	mean = sum / (float64(covered) / 1_000_000_000)
+	return maximum, mean, minimum, sum, coverage, true
+}
+
+// randomSamples generates count samples of irregular duration, separated by occasional
+// gaps.
+func randomSamples(rng *rand.Rand, count int) []Sample {
+	samples := make([]Sample, 0, count)
+	start := int64(0)
+	for i := 0; i < count; i++ {
+		if rng.Intn(10) == 0 {
+			start += rng.Int63n(50_000_000)
+		}
+		end := start + 1 + rng.Int63n(100_000_000)
+		samples = append(samples, Sample{StartTimestampNS: start, EndTimestampNS: end, Value: rng.Float64() * 100, Unit: sensors.Watts})
+		start = end
+	}
+	return samples
+}
+
+// makeRandomSeries builds a series of count random samples.
+func makeRandomSeries(t testing.TB, rng *rand.Rand, count int) *Series {
+	s := NewSeries("random", sensors.Watts)
+	for i, sample := range randomSamples(rng, count) {
+		if !s.Insert(sample) {
+			t.Fatalf("inserting non-overlapping samples should always be okay, but sample %d failed", i)
+		}
+	}
+	return s
+}
+
+func closeTo(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*max(1, math.Abs(a), math.Abs(b))
+}
+
+func TestSeriesMatchesLinearScan(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, count := range []int{1, 2, 3, 7, 64, 1000} {
+		s := makeRandomSeries(t, rng, count)
+		domainMin, domainMax := s.Domain()
+		for i := 0; i < 2000; i++ {
+			a := domainMin - 1_000_000 + rng.Int63n(domainMax-domainMin+2_000_000)
+			b := domainMin - 1_000_000 + rng.Int63n(domainMax-domainMin+2_000_000)
+			if i%4 == 0 {
+				// Align some queries with sample boundaries.
+				a = s.startTimestamps[rng.Intn(count)]
+				b = s.endTimestamps[rng.Intn(count)]
+			}
+			eMax, eMean, eMin, eSum, eCoverage, eOK := linearRatesBetween(s, a, b)
+			aMax, aMean, aMin, aSum, aCoverage, aOK := s.RatesBetween(a, b)
+			if aOK != eOK || aMax != eMax || aMin != eMin || !closeTo(aMean, eMean) || !closeTo(aSum, eSum) || aCoverage != eCoverage {
+				t.Errorf("%d samples [%d,%d): expected %f %f %f %f %f %v, got %f %f %f %f %f %v", count, a, b, eMax, eMean, eMin, eSum, eCoverage, eOK, aMax, aMean, aMin, aSum, aCoverage, aOK)
+			}
+		}
+	}
+}
+
+func BenchmarkRatesBetween(b *testing.B) {
+	const columns = 1000
+	for _, count := range []int{1_000, 100_000, 1_000_000} {
+		s := makeRandomSeries(b, rand.New(rand.NewSource(1)), count)
+		domainMin, domainMax := s.Domain()
+		step := (domainMax - domainMin) / columns
+		// Each iteration queries the whole series as a chart of the given width would.
+		for _, impl := range []struct {
+			name         string
+			ratesBetween func(a, b int64) (float64, float64, float64, float64, float64, bool)
+		}{
+			{name: "linear", ratesBetween: func(a, b int64) (float64, float64, float64, float64, float64, bool) {
+				return linearRatesBetween(s, a, b)
+			}},
+			{name: "pyramid", ratesBetween: s.RatesBetween},
+		} {
+			b.Run(fmt.Sprintf("%s/%d", impl.name, count), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					for c := int64(0); c < columns; c++ {
+						impl.ratesBetween(domainMin+c*step, domainMin+(c+1)*step)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,479 @@
+package backend
+
+import (
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+const (
+	// defaultBlockSamples is the number of samples stored together in each block of a
+	// BlockSeries.
+	defaultBlockSamples = 4096
+	// maxCachedBlocks is the number of spilled blocks that a BlockSeries keeps loaded after
+	// reading them back from disk.
+	maxCachedBlocks = 4
+)
+
+// StorageOptions bound the memory used by the series of long sessions.
+type StorageOptions struct {
+	// MemorySamples is roughly how many of the most recent samples of each series are kept
+	// in memory. Older samples are spilled to compressed files on disk. Zero keeps every
+	// sample in memory.
+	MemorySamples int
+	// SpillDir is the directory in which spilled samples are stored. If empty, the system's
+	// temporary directory is used.
+	SpillDir string
+	// Retention, if positive, discards samples that ended more than this long before the
+	// newest sample in their series.
+	Retention time.Duration
+}
+
+// Bounded reports whether the options limit the memory used by a series at all.
+func (o StorageOptions) Bounded() bool {
+	return o.MemorySamples > 0 || o.Retention > 0
+}
+
+// BlockSeries is a WritableDataSeries that stores its samples in fixed-size blocks, each of
+// which is summarized as it fills. Only the most recent blocks are kept in memory, and older
+// ones are spilled to disk and read back when a query needs their individual samples.
+type BlockSeries struct {
+	lock         sync.Mutex
+	name         string
+	unit         sensors.Unit
+	opts         StorageOptions
+	blockSamples int
+	// blocks holds every block created so far. Blocks before first have been discarded by
+	// the retention policy, and blocks before spilled are no longer in memory.
+	blocks         []*block
+	first, spilled int
+	// summaries aggregates the completed blocks.
+	summaries     pyramid
+	gaps          gapList
+	interpolation interpolator
+	// container is the name of the series whose readings include this one's, if known.
+	container string
+	// dir holds the spilled blocks, and is created by the first spill.
+	dir   string
+	cache map[int]*Series
+	sum   float64
+	// rangeRateMin and rangeRateMax are the rate extrema of the retained blocks.
+	rangeRateMin, rangeRateMax float64
+}
+
+var _ WritableDataSeries = (*BlockSeries)(nil)
+
+// block is a run of consecutive samples within a BlockSeries.
+type block struct {
+	start, end       int64
+	count            int
+	summary          aggregate
+	rateMin, rateMax float64
+	// samples holds the block's samples while it is in memory, and is nil once it has been
+	// spilled or discarded.
+	samples *Series
+	// path is the file holding the block's samples once it has been spilled.
+	path string
+}
+
+// NewBlockSeries creates an empty series of samples in the given unit whose memory use is
+// bounded by opts.
+func NewBlockSeries(name string, unit sensors.Unit, opts StorageOptions) *BlockSeries {
+	return newBlockSeries(name, unit, opts, defaultBlockSamples)
+}
+
+func newBlockSeries(name string, unit sensors.Unit, opts StorageOptions, blockSamples int) *BlockSeries {
+	return &BlockSeries{
+		name:         name,
+		unit:         unit,
+		opts:         opts,
+		blockSamples: blockSamples,
+		cache:        map[int]*Series{},
+	}
+}
+
+func (b *BlockSeries) Name() string {
+	return b.name
+}
+
+func (b *BlockSeries) Unit() sensors.Unit {
+	return b.unit
+}
+
+func (b *BlockSeries) Initialized() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.blocks) > 0
+}
+
+// Domain returns the time covered by the retained samples.
+func (b *BlockSeries) Domain() (min, max int64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.blocks) == 0 {
+		return 0, 0
+	}
+	return b.blocks[b.first].start, b.blocks[len(b.blocks)-1].end
+}
+
+func (b *BlockSeries) RateRange() (min, max float64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.rangeRateMin, b.rangeRateMax
+}
+
+// Sum returns the total quantity of the retained samples.
+func (b *BlockSeries) Sum() float64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.sum
+}
+
+// Container returns the name of the series whose readings include this one's, if known.
+func (b *BlockSeries) Container() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.container
+}
+
+// SetContainer records the name of the series whose readings include this one's.
+func (b *BlockSeries) SetContainer(name string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.container = name
+}
+
+// SetInterpolation chooses how instantaneous readings inserted afterwards are integrated,
+// and the window that each of them is a trailing average over, if any.
+func (b *BlockSeries) SetInterpolation(mode Interpolation, window time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.interpolation.mode = mode
+	b.interpolation.window = window
+}
+
+// Insert adds a sample to the series, returning false if it overlaps the existing data.
+func (b *BlockSeries) Insert(sample Sample) (inserted bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	// Blocks store the interpolated samples, since a reading may depend upon one in the
+	// previous block.
+	reading := sample
+	sample = b.interpolation.apply(reading)
+	var current *block
+	if len(b.blocks) > 0 {
+		last := b.blocks[len(b.blocks)-1]
+		if last.end > sample.StartTimestampNS {
+			return false
+		}
+		if last.count < b.blockSamples {
+			current = last
+		}
+		if last.end < sample.StartTimestampNS {
+			b.gaps.add(last.end, sample.StartTimestampNS)
+		}
+	}
+	if current == nil {
+		current = &block{start: sample.StartTimestampNS, samples: NewSeries(b.name, b.unit)}
+		b.blocks = append(b.blocks, current)
+	}
+	if !current.samples.Insert(sample) {
+		return false
+	}
+	current.count++
+	current.end = sample.EndTimestampNS
+	current.rateMin, current.rateMax = current.samples.RateRange()
+	if len(b.blocks) == 1 && current.count == 1 {
+		b.rangeRateMin, b.rangeRateMax = current.rateMin, current.rateMax
+	} else {
+		b.rangeRateMin = min(b.rangeRateMin, current.rateMin)
+		b.rangeRateMax = max(b.rangeRateMax, current.rateMax)
+	}
+	b.sum += current.samples.values[current.count-1]
+	if current.count == b.blockSamples {
+		b.complete(len(b.blocks) - 1)
+	}
+	b.retain(sample.EndTimestampNS)
+	b.interpolation.remember(reading)
+	return true
+}
+
+// blockSummary returns the aggregate of the completed block at index i.
+func (b *BlockSeries) blockSummary(i int) aggregate {
+	return b.blocks[i].summary
+}
+
+// complete summarizes a block that has filled, and spills the oldest blocks in memory if
+// there are now too many.
+func (b *BlockSeries) complete(i int) {
+	blk := b.blocks[i]
+	blk.summary = blk.samples.aggregates.between(0, blk.count, blk.samples.sampleAggregate)
+	b.summaries.extend(i+1, b.blockSummary)
+	if b.opts.MemorySamples <= 0 {
+		return
+	}
+	memoryBlocks := max(1, (b.opts.MemorySamples+b.blockSamples-1)/b.blockSamples)
+	b.spilled = max(b.spilled, b.first)
+	// Leave room for the block that the next sample will start.
+	for len(b.blocks)+1-b.spilled > memoryBlocks {
+		if err := b.spill(b.spilled); err != nil {
+			log.Printf("failed spilling %s to disk, keeping it in memory: %v", b.name, err)
+			b.opts.MemorySamples = 0
+			return
+		}
+		b.spilled++
+	}
+}
+
+// spill writes the samples of the block at index i to disk and releases their memory.
+func (b *BlockSeries) spill(i int) error {
+	if b.dir == "" {
+		dir, err := os.MkdirTemp(b.opts.SpillDir, "watt-wiser-series-")
+		if err != nil {
+			return fmt.Errorf("failed creating spill directory: %w", err)
+		}
+		b.dir = dir
+	}
+	blk := b.blocks[i]
+	path := filepath.Join(b.dir, strconv.Itoa(i)+".block")
+	if err := writeBlock(path, blk.samples); err != nil {
+		return err
+	}
+	blk.path = path
+	blk.samples = nil
+	return nil
+}
+
+// retain discards the blocks that ended before the retention window, which ends at newest.
+// The block being filled is always retained.
+func (b *BlockSeries) retain(newest int64) {
+	if b.opts.Retention <= 0 {
+		return
+	}
+	cutoff := newest - b.opts.Retention.Nanoseconds()
+	discarded := false
+	for b.first < len(b.blocks)-1 && b.blocks[b.first].end <= cutoff {
+		blk := b.blocks[b.first]
+		b.sum -= blk.summary.sum
+		if blk.path != "" {
+			if err := os.Remove(blk.path); err != nil {
+				log.Printf("failed removing spilled samples of %s: %v", b.name, err)
+			}
+		}
+		blk.samples = nil
+		delete(b.cache, b.first)
+		b.first++
+		discarded = true
+	}
+	if discarded {
+		retained := b.blocks[b.first:]
+		b.rangeRateMin, b.rangeRateMax = retained[0].rateMin, retained[0].rateMax
+		for _, blk := range retained[1:] {
+			b.rangeRateMin = min(b.rangeRateMin, blk.rateMin)
+			b.rangeRateMax = max(b.rangeRateMax, blk.rateMax)
+		}
+	}
+}
+
+// load returns the samples of the block at index i, reading them from disk if it has been
+// spilled.
+func (b *BlockSeries) load(i int) (*Series, error) {
+	blk := b.blocks[i]
+	if blk.samples != nil {
+		return blk.samples, nil
+	}
+	if s, ok := b.cache[i]; ok {
+		return s, nil
+	}
+	s, err := readBlock(blk.path, b.name, b.unit)
+	if err != nil {
+		return nil, err
+	}
+	if len(b.cache) >= maxCachedBlocks {
+		clear(b.cache)
+	}
+	b.cache[i] = s
+	return s, nil
+}
+
+// RatesBetween behaves like Series.RatesBetween. Blocks that lie entirely within the
+// interval are answered from their summaries, so at most two spilled blocks are read from
+// disk.
+func (b *BlockSeries) RatesBetween(timestampA, timestampB int64) (maximum, mean, minimum, sum, coverage float64, ok bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if timestampA == timestampB {
+		return 0, 0, 0, 0, 0, true
+	}
+	blocks := b.blocks[b.first:]
+	if len(blocks) < 1 {
+		return 0, 0, 0, 0, 0, false
+	}
+	if timestampB < timestampA {
+		timestampA, timestampB = timestampB, timestampA
+	}
+	blockA := sort.Search(len(blocks), func(i int) bool {
+		return timestampA < blocks[i].end
+	})
+	if blockA == len(blocks) {
+		return 0, 0, 0, 0, 0, false
+	}
+	blockB := sort.Search(len(blocks), func(i int) bool {
+		return timestampB < blocks[i].end
+	})
+	if blockB == len(blocks) {
+		if timestampB > blocks[len(blocks)-1].end {
+			return 0, 0, 0, 0, 0, false
+		}
+		blockB--
+	}
+	covered := b.gaps.covered(timestampA, timestampB, blocks[0].start, blocks[len(blocks)-1].end)
+	if covered == 0 {
+		return 0, 0, 0, 0, 0, true
+	}
+	samplesA, err := b.load(b.first + blockA)
+	if err != nil {
+		log.Printf("failed loading spilled samples of %s: %v", b.name, err)
+		return 0, 0, 0, 0, 0, false
+	}
+	if blockA == blockB {
+		return samplesA.RatesBetween(timestampA, timestampB)
+	}
+	samplesB, err := b.load(b.first + blockB)
+	if err != nil {
+		log.Printf("failed loading spilled samples of %s: %v", b.name, err)
+		return 0, 0, 0, 0, 0, false
+	}
+	// Summarize the samples exactly as a single Series would, but answer the blocks between
+	// the edges from their summaries.
+	var summary rateSummary
+	indexA := samplesA.indexEndingAfter(timestampA)
+	samplesA.includeEdge(&summary, indexA, timestampA, timestampB)
+	samplesA.includeBetween(&summary, indexA+1, len(samplesA.values))
+	if blockB-blockA > 1 {
+		between := b.summaries.between(b.first+blockA+1, b.first+blockB, b.blockSummary)
+		summary.include(between.sum, between.maxRate, between.minRate)
+	}
+	indexB := min(samplesB.indexEndingAfter(timestampB), len(samplesB.values)-1)
+	samplesB.includeBetween(&summary, 0, indexB)
+	samplesB.includeEdge(&summary, indexB, timestampA, timestampB)
+	coverage = float64(covered) / float64(timestampB-timestampA)
+	mean = summary.sum / (float64(covered) / 1_000_000_000)
+	return summary.maximum, mean, summary.minimum, summary.sum, coverage, true
+}
+
+// Gaps returns the intervals between the first and last retained samples of the series for
+// which it has no data.
+func (b *BlockSeries) Gaps() []Gap {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.blocks) == 0 {
+		return nil
+	}
+	start := b.blocks[b.first].start
+	first := sort.Search(len(b.gaps.gaps), func(i int) bool {
+		return start < b.gaps.gaps[i].EndTimestampNS
+	})
+	return slices.Clone(b.gaps.gaps[first:])
+}
+
+// Close removes the spilled samples of the series from disk.
+func (b *BlockSeries) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(b.dir)
+}
+
+// writeBlock stores the samples of s in a compressed file at path. Timestamps are stored as
+// varint deltas, since consecutive samples are usually adjacent and evenly spaced.
+func writeBlock(path string, s *Series) error {
+	buf := binary.AppendUvarint(nil, uint64(len(s.values)))
+	previousEnd := int64(0)
+	for i, v := range s.values {
+		buf = binary.AppendVarint(buf, s.startTimestamps[i]-previousEnd)
+		buf = binary.AppendVarint(buf, s.endTimestamps[i]-s.startTimestamps[i])
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(v))
+		previousEnd = s.endTimestamps[i]
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed creating %s: %w", path, err)
+	}
+	w, err := flate.NewWriter(f, flate.BestSpeed)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed compressing %s: %w", path, err)
+	}
+	_, err = w.Write(buf)
+	err = errors.Join(err, w.Close(), f.Close())
+	if err != nil {
+		return fmt.Errorf("failed writing %s: %w", path, err)
+	}
+	return nil
+}
+
+// readBlock reads samples stored by writeBlock into a new series.
+func readBlock(path, name string, unit sensors.Unit) (*Series, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening %s: %w", path, err)
+	}
+	defer f.Close()
+	buf, err := io.ReadAll(flate.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("failed reading %s: %w", path, err)
+	}
+	errTruncated := fmt.Errorf("%s is truncated", path)
+	count, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return nil, errTruncated
+	}
+	buf = buf[n:]
+	s := NewSeries(name, unit)
+	s.startTimestamps = make([]int64, 0, count)
+	s.endTimestamps = make([]int64, 0, count)
+	s.values = make([]float64, 0, count)
+	previousEnd := int64(0)
+	for i := uint64(0); i < count; i++ {
+		startDelta, n := binary.Varint(buf)
+		if n <= 0 {
+			return nil, errTruncated
+		}
+		buf = buf[n:]
+		duration, n := binary.Varint(buf)
+		if n <= 0 || len(buf[n:]) < 8 {
+			return nil, errTruncated
+		}
+		start := previousEnd + startDelta
+		if i > 0 && startDelta > 0 {
+			s.gaps.add(previousEnd, start)
+		}
+		previousEnd = start + duration
+		s.startTimestamps = append(s.startTimestamps, start)
+		s.endTimestamps = append(s.endTimestamps, previousEnd)
+		s.values = append(s.values, math.Float64frombits(binary.LittleEndian.Uint64(buf[n:])))
+		buf = buf[n+8:]
+		s.aggregates.extend(len(s.values), s.sampleAggregate)
+	}
+	if len(s.values) > 0 {
+		s.initialized = true
+		s.domainMin, s.domainMax = s.startTimestamps[0], previousEnd
+	}
+	return s, nil
+}
@@ -0,0 +1,87 @@
+package backend
+
+import (
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+func TestBlockSeriesMatchesSeries(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	samples := randomSamples(rng, 1000)
+	reference := NewSeries("random", sensors.Watts)
+	spillDir := t.TempDir()
+	s := newBlockSeries("random", sensors.Watts, StorageOptions{MemorySamples: 32, SpillDir: spillDir}, 16)
+	defer s.Close()
+	for i, sample := range samples {
+		reference.Insert(sample)
+		if !s.Insert(sample) {
+			t.Fatalf("inserting non-overlapping samples should always be okay, but sample %d failed", i)
+		}
+	}
+	if s.Insert(samples[len(samples)-1]) {
+		t.Errorf("expected overlapping sample to be rejected")
+	}
+	if inMemory := len(s.blocks) - s.spilled; inMemory != 2 {
+		t.Errorf("expected 2 blocks in memory, got %d", inMemory)
+	}
+	if !closeTo(s.Sum(), reference.Sum()) {
+		t.Errorf("expected sum %f, got %f", reference.Sum(), s.Sum())
+	}
+	if rMin, rMax := s.RateRange(); rMin != reference.rangeRateMin || rMax != reference.rangeRateMax {
+		t.Errorf("expected rate range [%f, %f], got [%f, %f]", reference.rangeRateMin, reference.rangeRateMax, rMin, rMax)
+	}
+	domainMin, domainMax := reference.Domain()
+	if dMin, dMax := s.Domain(); dMin != domainMin || dMax != domainMax {
+		t.Errorf("expected domain [%d, %d], got [%d, %d]", domainMin, domainMax, dMin, dMax)
+	}
+	for i := 0; i < 2000; i++ {
+		a := domainMin - 1_000_000 + rng.Int63n(domainMax-domainMin+2_000_000)
+		b := domainMin - 1_000_000 + rng.Int63n(domainMax-domainMin+2_000_000)
+		if i%4 == 0 {
+			// Align some queries with sample boundaries.
+			a = samples[rng.Intn(len(samples))].StartTimestampNS
+			b = samples[rng.Intn(len(samples))].EndTimestampNS
+		}
+		eMax, eMean, eMin, eSum, eCoverage, eOK := reference.RatesBetween(a, b)
+		aMax, aMean, aMin, aSum, aCoverage, aOK := s.RatesBetween(a, b)
+		if aOK != eOK || !closeTo(aMax, eMax) || !closeTo(aMin, eMin) || !closeTo(aMean, eMean) || !closeTo(aSum, eSum) || aCoverage != eCoverage {
+			t.Errorf("[%d,%d): expected %f %f %f %f %f %v, got %f %f %f %f %f %v", a, b, eMax, eMean, eMin, eSum, eCoverage, eOK, aMax, aMean, aMin, aSum, aCoverage, aOK)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("failed closing series: %v", err)
+	}
+	if entries, _ := os.ReadDir(spillDir); len(entries) != 0 {
+		t.Errorf("expected spilled samples to be removed, found %d entries", len(entries))
+	}
+}
+
+func TestBlockSeriesRetention(t *testing.T) {
+	s := newBlockSeries("power", sensors.Watts, StorageOptions{Retention: 10 * time.Second}, 4)
+	const second = int64(time.Second)
+	for i := int64(0); i < 30; i++ {
+		s.Insert(Sample{StartTimestampNS: i * second, EndTimestampNS: (i + 1) * second, Value: float64(i), Unit: sensors.Watts})
+	}
+	// Whole blocks of four samples are discarded once they end before the last 10s.
+	dMin, dMax := s.Domain()
+	if dMin != 20*second || dMax != 30*second {
+		t.Errorf("expected domain [%d, %d], got [%d, %d]", 20*second, 30*second, dMin, dMax)
+	}
+	if rMin, rMax := s.RateRange(); rMin != 20 || rMax != 29 {
+		t.Errorf("expected rate range [20, 29], got [%f, %f]", rMin, rMax)
+	}
+	expectedSum := 0.0
+	for i := 20; i < 30; i++ {
+		expectedSum += float64(i)
+	}
+	if s.Sum() != expectedSum {
+		t.Errorf("expected sum %f, got %f", expectedSum, s.Sum())
+	}
+	if _, _, _, _, _, ok := s.RatesBetween(20*second, 30*second); !ok {
+		t.Errorf("expected retained data to be okay")
+	}
+}
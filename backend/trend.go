@@ -0,0 +1,155 @@
+package backend
+
+import (
+	"math"
+	"slices"
+)
+
+// Trend is the history of one series across benchmarks.
+type Trend struct {
+	Series string
+	Points []EnergyPoint
+	// ChangePoints are the indices of the points at which the energy used from the series
+	// shifted to a new level, like after a regression.
+	ChangePoints []int
+}
+
+const (
+	// outlierScore is the modified z-score beyond which a point is an outlier.
+	outlierScore = 3.5
+	// minSegment is the fewest points on each side of a change point.
+	minSegment = 3
+	// changeScore is how many standard errors apart the levels on either side of a change
+	// point must be.
+	changeScore = 5
+	// minChange is the smallest relative change in level reported as a change point, so
+	// that very consistent benchmarks don't report negligible shifts.
+	minChange = 0.02
+)
+
+// Trends returns the history of each series across the benchmarks selected by the query,
+// oldest first. Outliers and change points are found from the energy used by each
+// benchmark, and outliers are ignored when looking for change points.
+func (i Index) Trends(q BenchmarkQuery) []Trend {
+	var out []Trend
+	for _, b := range i.Query(q) {
+		for j, name := range b.Series {
+			point, ok := b.point(j)
+			if !ok {
+				continue
+			}
+			k := slices.IndexFunc(out, func(t Trend) bool { return t.Series == name })
+			if k < 0 {
+				k = len(out)
+				out = append(out, Trend{Series: name})
+			}
+			out[k].Points = append(out[k].Points, point)
+		}
+	}
+	for k := range out {
+		out[k].analyze()
+	}
+	return out
+}
+
+// analyze flags the outliers among the trend's points and finds its change points.
+func (t *Trend) analyze() {
+	values := make([]float64, len(t.Points))
+	for i, p := range t.Points {
+		values[i] = p.Joules
+	}
+	for _, i := range outliers(values) {
+		t.Points[i].Outlier = true
+	}
+	var kept []float64
+	var indices []int
+	for i, p := range t.Points {
+		if !p.Outlier {
+			kept = append(kept, p.Joules)
+			indices = append(indices, i)
+		}
+	}
+	t.ChangePoints = nil
+	for _, i := range changePoints(kept) {
+		t.ChangePoints = append(t.ChangePoints, indices[i])
+	}
+}
+
+// median returns the median of values, which it sorts.
+func median(values []float64) float64 {
+	slices.Sort(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+// outliers returns the indices of the values whose modified z-score, which is based on the
+// median absolute deviation, exceeds outlierScore.
+func outliers(values []float64) []int {
+	if len(values) < minSegment {
+		return nil
+	}
+	center := median(slices.Clone(values))
+	deviations := make([]float64, len(values))
+	for i, v := range values {
+		deviations[i] = math.Abs(v - center)
+	}
+	mad := median(slices.Clone(deviations))
+	if mad == 0 {
+		return nil
+	}
+	var out []int
+	for i, d := range deviations {
+		if 0.6745*d/mad > outlierScore {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// changePoints finds the indices at which the level of values shifts by binary
+// segmentation: each segment is split where the means on either side differ the most
+// relative to their standard error, as long as that difference is significant, and both
+// halves are searched in turn.
+func changePoints(values []float64) []int {
+	var out []int
+	var search func(lo, hi int)
+	search = func(lo, hi int) {
+		best, bestScore := -1, 0.0
+		for k := lo + minSegment; k <= hi-minSegment; k++ {
+			leftMean, leftSS := meanAndSquares(values[lo:k])
+			rightMean, rightSS := meanAndSquares(values[k:hi])
+			diff := math.Abs(leftMean - rightMean)
+			if diff <= minChange*math.Max(math.Abs(leftMean), math.Abs(rightMean)) {
+				continue
+			}
+			variance := (leftSS + rightSS) / float64(hi-lo-2)
+			score := diff / math.Sqrt(variance*(1/float64(k-lo)+1/float64(hi-k)))
+			if score > changeScore && score > bestScore {
+				best, bestScore = k, score
+			}
+		}
+		if best < 0 {
+			return
+		}
+		search(lo, best)
+		out = append(out, best)
+		search(best, hi)
+	}
+	search(0, len(values))
+	return out
+}
+
+// meanAndSquares returns the mean of values and the sum of their squared deviations from it.
+func meanAndSquares(values []float64) (mean, squares float64) {
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	for _, v := range values {
+		squares += (v - mean) * (v - mean)
+	}
+	return mean, squares
+}
@@ -0,0 +1,72 @@
+package backend
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestIndexTrends(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		joules           []float64
+		expectedOutliers []int
+		expectedChanges  []int
+	}{
+		{
+			name:   "steady",
+			joules: []float64{10, 10.2, 9.9, 10.1, 10, 9.8, 10.1, 10},
+		},
+		{
+			name:             "outlier",
+			joules:           []float64{10, 10.2, 9.9, 30, 10.1, 10, 9.8, 10.1},
+			expectedOutliers: []int{3},
+		},
+		{
+			name:            "regression",
+			joules:          []float64{10, 10.2, 9.9, 10.1, 12, 12.1, 11.9, 12.2},
+			expectedChanges: []int{4},
+		},
+		{
+			name:             "regression and outlier",
+			joules:           []float64{10, 10.2, 9.9, 10.1, 2, 12, 12.1, 11.9, 12.2, 14.1, 13.9, 14},
+			expectedOutliers: []int{4},
+			expectedChanges:  []int{5, 9},
+		},
+		{
+			name:   "too few",
+			joules: []float64{10, 20},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var index Index
+			start := time.Date(2024, 1, 1, 2, 0, 0, 0, time.UTC)
+			for i, j := range tc.joules {
+				index.Benchmarks = append(index.Benchmarks, BenchmarkRecord{
+					ID:            string(rune('a' + i)),
+					Command:       "game",
+					Start:         start.AddDate(0, 0, i),
+					Series:        []string{"package-0 (J)"},
+					SummaryJoules: []float64{j},
+					SummaryWatts:  []float64{j / 10},
+				})
+			}
+			trends := index.Trends(BenchmarkQuery{Command: "game"})
+			if len(trends) != 1 || len(trends[0].Points) != len(tc.joules) {
+				t.Fatalf("expected one trend of %d points, got %+v", len(tc.joules), trends)
+			}
+			var outliers []int
+			for i, p := range trends[0].Points {
+				if p.Outlier {
+					outliers = append(outliers, i)
+				}
+			}
+			if !slices.Equal(outliers, tc.expectedOutliers) {
+				t.Errorf("expected outliers %v, got %v", tc.expectedOutliers, outliers)
+			}
+			if !slices.Equal(trends[0].ChangePoints, tc.expectedChanges) {
+				t.Errorf("expected change points %v, got %v", tc.expectedChanges, trends[0].ChangePoints)
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"image/color"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"gioui.org/font"
@@ -155,6 +156,22 @@ func (r resultStyle) Layout(gtx C) D {
 											layout.Rigid(material.Body1(r.th, "Session ID: "+r.results.SessionID).Layout),
 											layout.Rigid(material.Body1(r.th, "Notes: "+r.results.Notes).Layout),
 											layout.Rigid(material.Body1(r.th, "Executable: "+r.results.Command).Layout),
+											layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+												if len(r.results.Results.Throttling) == 0 {
+													return D{}
+												}
+												l := material.Body1(r.th, "Throttled: "+strings.Join(r.results.Results.Throttling, ", "))
+												l.Font.Weight = font.Bold
+												return l.Layout(gtx)
+											}),
+											layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+												if len(r.results.Results.LowCoverage) == 0 {
+													return D{}
+												}
+												l := material.Body1(r.th, "Missing data: "+strings.Join(r.results.Results.LowCoverage, ", "))
+												l.Font.Weight = font.Bold
+												return l.Layout(gtx)
+											}),
 											layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 												if r.results.Err == nil {
 													return D{}
@@ -310,9 +327,11 @@ type Benchmark struct {
 	// State for recording benchmark form.
 	commandEditor component.TextField
 	notesEditor   component.TextField
+	tagsEditor    component.TextField
 	chooseFileBtn widget.Clickable
 	disableStart  bool
 	startBtn      widget.Clickable
+	burstBox      widget.Bool
 
 	// State for loading benchmarks form.
 	loadBtn    widget.Clickable
@@ -353,12 +372,13 @@ func NewBenchmark(ws backend.WindowState, expl *explorer.Explorer) *Benchmark {
 func (b *Benchmark) Update(gtx C, th *material.Theme, activeDataset backend.Dataset) {
 	b.commandEditor.Update(gtx, th, "Executable to Benchmark")
 	b.notesEditor.Update(gtx, th, "Benchmark Notes")
+	b.tagsEditor.Update(gtx, th, "Benchmark Tags (comma-separated)")
 	if b.loadBtn.Clicked(gtx) {
 		b.loadStream = stream.New(b.ws.Controller, b.ws.Benchmark.LoadBenchmarks(b.explorer).Stream)
 	}
 	if b.startBtn.Clicked(gtx) {
 		b.disableStart = true
-		b.runCommand(b.commandEditor.Text(), b.notesEditor.Text())
+		b.runCommand(b.commandEditor.Text(), b.notesEditor.Text(), backend.ParseTags(b.tagsEditor.Text()), b.burstBox.Value)
 	}
 	if b.chooseFileBtn.Clicked(gtx) {
 		f, err := b.explorer.ChooseFile()
@@ -402,8 +422,8 @@ func (b *Benchmark) Update(gtx C, th *material.Theme, activeDataset backend.Data
 	b.resultChart.Update(gtx)
 }
 
-func (b *Benchmark) runCommand(cmd, notes string) {
-	mut, ok := b.ws.Benchmark.Run(cmd, notes, time.Second*2)
+func (b *Benchmark) runCommand(cmd, notes string, tags []string, burst bool) {
+	mut, ok := b.ws.Benchmark.Run(cmd, notes, tags, time.Second*2, burst)
 	if !ok {
 		log.Printf("did not create new benchmarkStream")
 		return
@@ -436,6 +456,11 @@ func (b *Benchmark) Layout(gtx C, th *material.Theme, activeDataset backend.Data
 				return b.notesEditor.Layout(gtx, th, "Benchmark Notes")
 			})
 		}),
+		layout.Rigid(func(gtx C) D {
+			return inset.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return b.tagsEditor.Layout(gtx, th, "Benchmark Tags (comma-separated)")
+			})
+		}),
 		layout.Rigid(func(gtx C) D {
 			return layout.Flex{
 				Alignment: layout.Baseline,
@@ -449,6 +474,9 @@ func (b *Benchmark) Layout(gtx C, th *material.Theme, activeDataset backend.Data
 						return btn.Layout(gtx)
 					})
 				}),
+				layout.Rigid(func(gtx C) D {
+					return inset.Layout(gtx, material.CheckBox(th, &b.burstBox, "High-resolution burst").Layout)
+				}),
 				layout.Flexed(1, func(gtx C) D {
 					return inset.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
 						l := material.Body1(th, "Status: "+b.status.String())
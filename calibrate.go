@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/backend"
+)
+
+// runCalibrate fits a calibration to a recorded trace without launching the UI.
+func runCalibrate(args []string) error {
+	flags := flag.NewFlagSet("calibrate", flag.ExitOnError)
+	reference := flags.String("reference", "", "name of the wall power series to calibrate against, like \"scpi meter ttyUSB0 (W)\"")
+	components := flags.String("components", "", "comma-separated names of the series to estimate wall power from (default: every other energy series)")
+	model := flags.String("model", string(backend.LinearModel), "model to fit, either \"linear\" or \"piecewise\"")
+	knots := flags.Int("knots", 2, "number of slope changes in a piecewise model")
+	window := flags.Duration("window", time.Second, "interval over which to average the series before fitting")
+	output := flags.String("output", "", "file to write the calibration into (default: this machine's calibration file)")
+	var derivations []backend.Derivation
+	flags.Func("derive", "add a series computed from others before fitting, like \"uncore (W) = package-0 - core\" (repeatable)", func(s string) error {
+		def, err := backend.ParseDerivation(s)
+		derivations = append(derivations, def)
+		return err
+	})
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), `%[1]s calibrate: fit a model of wall power to a recorded trace
+Usage:
+
+ %[1]s calibrate -reference <series> [flags] <file>
+
+Flags:
+`, os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if *reference == "" || flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed opening trace: %w", err)
+	}
+	defer f.Close()
+	data, err := backend.LoadTrace(f)
+	if err != nil {
+		return fmt.Errorf("failed loading trace: %w", err)
+	}
+	data, err = backend.DeriveAll(data, derivations)
+	if err != nil {
+		return err
+	}
+
+	var referenceSeries backend.DataSeries
+	var componentSeries []backend.DataSeries
+	wanted := map[string]bool{}
+	if *components != "" {
+		for _, name := range strings.Split(*components, ",") {
+			wanted[strings.TrimSpace(name)] = true
+		}
+	}
+	for _, s := range data.EnergySeries() {
+		switch {
+		case s.Name() == *reference:
+			referenceSeries = s
+		case len(wanted) == 0 || wanted[s.Name()]:
+			delete(wanted, s.Name())
+			componentSeries = append(componentSeries, s)
+		}
+	}
+	if referenceSeries == nil {
+		return fmt.Errorf("trace has no energy series named %q", *reference)
+	}
+	for name := range wanted {
+		return fmt.Errorf("trace has no energy series named %q", name)
+	}
+
+	cal, err := backend.FitCalibration(referenceSeries, componentSeries, backend.CalibrationModel(*model), *knots, window.Nanoseconds())
+	if err != nil {
+		return err
+	}
+	path := *output
+	if path == "" {
+		path, err = backend.CalibrationPath()
+		if err != nil {
+			return err
+		}
+	}
+	if err := backend.SaveCalibration(path, cal); err != nil {
+		return err
+	}
+	fmt.Printf("fit %s model of %q from %s (RMS error %.2f W)\n", cal.Model, cal.Reference, strings.Join(cal.Components, ", "), cal.RMSError)
+	fmt.Println("calibration written to", path)
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"slices"
 	"sort"
 	"strconv"
+	"strings"
 
 	"gioui.org/f32"
 	"gioui.org/gesture"
@@ -23,6 +24,7 @@ import (
 	"gioui.org/widget/material"
 	"gioui.org/x/component"
 	"git.sr.ht/~whereswaldon/watt-wiser/backend"
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
 	"golang.org/x/exp/constraints"
 	"golang.org/x/exp/shiny/materialdesign/icons"
 )
@@ -42,6 +44,8 @@ type timeslice struct {
 	xL, xR         float32
 	y              float32
 	mean           float64
+	// gap is set if the series has no data for the slice, which breaks its line.
+	gap bool
 }
 
 type ChartData struct {
@@ -64,6 +68,8 @@ type ChartData struct {
 	// hover gesture state
 	pos       f32.Point
 	isHovered bool
+	// OnPause, if set, is called whenever the pause button is toggled.
+	OnPause func(paused bool)
 }
 
 func NewChart() *ChartData {
@@ -76,6 +82,23 @@ func (c *ChartData) SetDataset(ds backend.Dataset) {
 	c.Dataset = ds
 }
 
+// ShowAt pauses the chart with the given timestamp at its right edge.
+func (c *ChartData) ShowAt(ts int64) {
+	c.paused = true
+	c.xOrigin = ts
+	c.xOffset = 0
+}
+
+// stackable reports which series can be stacked or totaled without counting any energy
+// twice.
+func (c *ChartData) stackable() []bool {
+	enabled := make([]bool, len(c.Enabled))
+	for i, e := range c.Enabled {
+		enabled[i] = e.Value
+	}
+	return c.Dataset.Stackable(enabled)
+}
+
 func rec(gtx C, w layout.Widget) (D, op.CallOp) {
 	macro := op.Record(gtx.Ops)
 	dims := w(gtx)
@@ -157,6 +180,85 @@ func (c *ChartData) layoutYAxisLabels(gtx C, th *material.Theme, pxPerWatt int,
 	return D{Size: origConstraints.Max}
 }
 
+// auxAxisTicks is the approximate number of labeled ticks on the auxiliary axis.
+const auxAxisTicks = 5
+
+// layoutAuxAxisLabels draws the secondary axis used by series that don't measure energy,
+// labeling a tick every step from zero to rangeMax.
+func (c *ChartData) layoutAuxAxisLabels(gtx C, th *material.Theme, rangeMax, step float64, title string) D {
+	origConstraints := gtx.Constraints
+	// Match the vertical scale used by computeVisible.
+	maxY := gtx.Constraints.Max.Y - gtx.Dp(1)
+	// Flip X and Y to draw our axis horizontally.
+	gtx.Constraints.Max.X, gtx.Constraints.Max.Y = gtx.Constraints.Max.Y, gtx.Constraints.Max.X
+	gtx.Constraints.Min = image.Point{}
+
+	gap := gtx.Dp(10)
+
+	axisMacro := op.Record(gtx.Ops)
+
+	axisLabel := material.Body2(th, title)
+	axisLabel.MaxLines = 1
+	axisDims := layout.Flex{
+		Axis:      layout.Vertical,
+		Alignment: layout.Middle,
+	}.Layout(gtx,
+		layout.Rigid(axisLabel.Layout),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			label := material.Body1(th, "")
+			// The axis is rotated clockwise, so the top of the plot is at the start of
+			// this row.
+			usedX := -gap
+			height := 0
+			ticks := int(math.Round(rangeMax / step))
+			for i := ticks; i >= 0; i-- {
+				value := float64(i) * step
+				label.Text = formatSI(value)
+				labelDims, labelCall := rec(gtx, label.Layout)
+				height = max(height, labelDims.Size.Y)
+				x := maxY - int(float64(maxY)*value/rangeMax) - labelDims.Size.X/2
+				x = min(max(x, 0), gtx.Constraints.Max.X-labelDims.Size.X)
+				if x < usedX+gap {
+					continue
+				}
+				stack := op.Offset(image.Point{X: x}).Push(gtx.Ops)
+				labelCall.Add(gtx.Ops)
+				stack.Pop()
+				usedX = x + labelDims.Size.X
+			}
+			return D{Size: image.Point{
+				X: gtx.Constraints.Max.X,
+				Y: height,
+			}}
+		}),
+	)
+
+	axisCall := axisMacro.Stop()
+
+	defer op.Affine(
+		f32.Affine2D{}.
+			Rotate(f32.Point{}, math.Pi/2).
+			Offset(f32.Point{X: float32(axisDims.Size.Y)}),
+	).Push(gtx.Ops).Pop()
+	axisCall.Add(gtx.Ops)
+
+	return D{Size: origConstraints.Max}
+}
+
+// formatSI formats a value with an SI prefix so that large auxiliary values like
+// frequencies fit on the axis.
+func formatSI(v float64) string {
+	for _, p := range []struct {
+		scale  float64
+		prefix string
+	}{{1e9, "G"}, {1e6, "M"}, {1e3, "k"}} {
+		if math.Abs(v) >= p.scale {
+			return strconv.FormatFloat(v/p.scale, 'g', 4, 64) + p.prefix
+		}
+	}
+	return strconv.FormatFloat(v, 'g', 4, 64)
+}
+
 func (c *ChartData) Update(gtx C) {
 	_, domainMax := c.Domain()
 	for len(c.Enabled) < len(c.Dataset) {
@@ -169,6 +271,9 @@ func (c *ChartData) Update(gtx C) {
 		c.paused = !c.paused
 		c.xOrigin = domainMax + c.xOffset
 		c.xOffset = 0
+		if c.OnPause != nil {
+			c.OnPause(c.paused)
+		}
 	}
 	c.Stacked.Update(gtx)
 	for {
@@ -215,14 +320,21 @@ func (c *ChartData) Layout(gtx C, th *material.Theme) D {
 	keyDims := c.layoutControls(gtx, th)
 	keyCall := macro.Stop()
 
+	axisWidth := axisLabelDims.Size.Y * 2
+	reservedX := axisWidth
+	auxRangeMax, auxStep, auxTitle, hasAux := c.computeAuxRange()
+	if hasAux {
+		reservedX += axisWidth
+	}
+
 	// Lay out the plot in the remaining space after accounting for axis
 	// labels and the key.
 	gtx.Constraints = origConstraints.SubMax(image.Point{
-		X: axisLabelDims.Size.Y * 2,
+		X: reservedX,
 		Y: axisLabelDims.Size.Y,
 	}.Add(image.Pt(0, keyDims.Size.Y)))
 	macro = op.Record(gtx.Ops)
-	dims, domainMin, domainMax, pxPerWatt, rangeMin, rangeMax := c.layoutPlot(gtx, th)
+	dims, domainMin, domainMax, pxPerWatt, rangeMin, rangeMax := c.layoutPlot(gtx, th, auxRangeMax)
 	domainEndSecs := float64(domainMax-dataDomainMax) / 1_000_000_000
 	domainIntervalSecs := float64(domainMax-domainMin) / 1_000_000_000
 	domainStartSecs := domainEndSecs - domainIntervalSecs
@@ -240,12 +352,12 @@ func (c *ChartData) Layout(gtx C, th *material.Theme) D {
 					return layout.Flex{Axis: layout.Vertical, Spacing: layout.SpaceBetween}.Layout(gtx,
 						layout.Flexed(1, func(gtx C) D {
 							gtx.Constraints.Min = image.Point{}
-							gtx.Constraints.Max.X = axisLabelDims.Size.Y * 2
+							gtx.Constraints.Max.X = axisWidth
 							return c.layoutYAxisLabels(gtx, th, pxPerWatt, rangeMin, rangeMax)
 						}),
 						layout.Rigid(func(gtx C) D {
 							gtx.Constraints = layout.Exact(image.Point{
-								X: axisLabelDims.Size.Y * 2,
+								X: axisWidth,
 								Y: axisLabelDims.Size.Y,
 							})
 							icon := pauseIcon
@@ -278,6 +390,21 @@ func (c *ChartData) Layout(gtx C, th *material.Theme) D {
 						}),
 					)
 				}),
+				layout.Rigid(func(gtx C) D {
+					if !hasAux {
+						return D{}
+					}
+					return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+						layout.Flexed(1, func(gtx C) D {
+							gtx.Constraints.Min = image.Point{}
+							gtx.Constraints.Max.X = axisWidth
+							return c.layoutAuxAxisLabels(gtx, th, auxRangeMax, auxStep, auxTitle)
+						}),
+						layout.Rigid(func(gtx C) D {
+							return D{Size: image.Pt(axisWidth, axisLabelDims.Size.Y)}
+						}),
+					)
+				}),
 			)
 		}),
 		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
@@ -363,9 +490,9 @@ func (c *ChartData) layoutControls(gtx C, th *material.Theme) D {
 						return material.Body2(th, "Total of enabled series").Layout(gtx)
 					case totalJoulesCol:
 						sum := 0.0
-						for sumIdx, series := range c.Dataset {
-							if c.Enabled[sumIdx].Value {
-								sum += series.Sum()
+						for sumIdx, stackable := range c.stackable() {
+							if stackable {
+								sum += c.Dataset[sumIdx].Sum()
 							}
 						}
 						l := material.Body2(th, fmt.Sprintf("%.2f", sum))
@@ -373,9 +500,9 @@ func (c *ChartData) layoutControls(gtx C, th *material.Theme) D {
 						return l.Layout(gtx)
 					case totalWattHoursCol:
 						sum := 0.0
-						for sumIdx, series := range c.Dataset {
-							if c.Enabled[sumIdx].Value {
-								sum += series.Sum()
+						for sumIdx, stackable := range c.stackable() {
+							if stackable {
+								sum += c.Dataset[sumIdx].Sum()
 							}
 						}
 						sum = sum / 3600
@@ -389,6 +516,10 @@ func (c *ChartData) layoutControls(gtx C, th *material.Theme) D {
 				c.Enabled[row].Update(gtx)
 				enabled := c.Enabled[row].Value
 				disabledAlpha := uint8(100)
+				if !c.Dataset[row].Unit().IsEnergy() && (col == totalJoulesCol || col == totalWattHoursCol) {
+					// Totals are meaningless for series that don't measure energy.
+					return D{Size: gtx.Constraints.Min}
+				}
 				switch col {
 				case colorCol:
 					return c.Enabled[row].Layout(gtx, func(gtx layout.Context) layout.Dimensions {
@@ -436,7 +567,7 @@ func (c *ChartData) layoutControls(gtx C, th *material.Theme) D {
 		})
 }
 
-func (c *ChartData) layoutPlot(gtx C, th *material.Theme) (dims D, domainMin, domainMax int64, pxPerWatt int, rangeMin, rangeMax float64) {
+func (c *ChartData) layoutPlot(gtx C, th *material.Theme, auxRangeMax float64) (dims D, domainMin, domainMax int64, pxPerWatt int, rangeMin, rangeMax float64) {
 	dist := c.zoom.Update(gtx.Metric, gtx.Source, gtx.Now, gesture.Vertical, image.Rect(0, -1e6, 0, 1e6))
 	if dist != 0 {
 		proportion := 1 + float64(dist)/float64(gtx.Constraints.Max.Y)
@@ -473,7 +604,7 @@ func (c *ChartData) layoutPlot(gtx C, th *material.Theme) (dims D, domainMin, do
 	visibleDomainStart := visibleDomainEnd - visibleDomainInterval
 	var maxY int
 	maxY, pxPerWatt, rangeMax = c.computeRange(gtx)
-	c.computeVisible(gtx, maxY, visibleDomainStart, visibleDomainEnd, rangeMax)
+	c.computeVisible(gtx, maxY, visibleDomainStart, visibleDomainEnd, rangeMax, auxRangeMax)
 	end := visibleDomainEnd - dataDomainMin
 	start := visibleDomainStart - dataDomainMin
 	vpStart := float32(start) / float32(totalDomainInterval)
@@ -489,7 +620,7 @@ func (c *ChartData) layoutPlot(gtx C, th *material.Theme) (dims D, domainMin, do
 				// Draw grid underneath plot.
 				c.layoutYAxisGrid(gtx, maxY, pxPerWatt)
 				if !c.Stacked.Value {
-					c.layoutLinePlot(gtx, maxY, pxPerWatt, rangeMax)
+					c.layoutLinePlot(gtx, maxY)
 				} else {
 					c.layoutStackPlot(gtx, maxY, pxPerWatt, rangeMax)
 				}
@@ -500,7 +631,8 @@ func (c *ChartData) layoutPlot(gtx C, th *material.Theme) (dims D, domainMin, do
 					children := []layout.FlexChild{layout.Rigid(func(gtx layout.Context) layout.Dimensions { return D{} })}
 					var start, end int64
 					hasTimes := false
-					values := []float64{}
+					// heights orders the hover entries to match the plot.
+					heights := []float32{}
 					for i := range c.Dataset {
 						i := i
 						if !c.Enabled[i].Value {
@@ -513,16 +645,24 @@ func (c *ChartData) layoutPlot(gtx C, th *material.Theme) (dims D, domainMin, do
 							continue
 						}
 						data := c.seriesSlices[i][idx]
+						if data.gap {
+							continue
+						}
 						if !hasTimes {
 							start = data.tsStart
 							end = data.tsEnd
 							hasTimes = true
 						}
-						insertIdx, _ := slices.BinarySearch(values, data.mean)
-						values = slices.Insert(values, insertIdx, data.mean)
+						unit := c.Dataset[i].Unit()
+						if unit.IsEnergy() {
+							unit = sensors.Watts
+						}
+						height := float32(maxY) - data.y
+						insertIdx, _ := slices.BinarySearch(heights, height)
+						heights = slices.Insert(heights, insertIdx, height)
 						children = slices.Insert(children, len(children)-insertIdx, layout.Rigid(func(gtx C) D {
 							return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
-								layout.Rigid(material.Body2(th, strconv.FormatFloat(data.mean, 'f', 3, 64)).Layout),
+								layout.Rigid(material.Body2(th, strconv.FormatFloat(data.mean, 'f', 3, 64)+" "+unit.String()).Layout),
 								layout.Rigid(layout.Spacer{Width: 8}.Layout),
 								layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 									size := image.Pt(gtx.Dp(8), gtx.Dp(8))
@@ -618,13 +758,16 @@ func (c *ChartData) computeRange(gtx C) (maxY, pxPerWatt int, rangeMax float64)
 		rangeSum float64
 	)
 
+	stackable := c.stackable()
 	for i, series := range c.Dataset {
-		if !c.Enabled[i].Value {
+		if !c.Enabled[i].Value || !series.Unit().IsEnergy() {
 			continue
 		}
 		_, seriesRateMax := series.RateRange()
 		rangeMax = max(rangeMax, seriesRateMax)
-		rangeSum += seriesRateMax
+		if stackable[i] {
+			rangeSum += seriesRateMax
+		}
 	}
 	if c.Stacked.Value {
 		rangeMax = rangeSum
@@ -639,28 +782,71 @@ func (c *ChartData) computeRange(gtx C) (maxY, pxPerWatt int, rangeMax float64)
 	return maxY, pxPerWatt, rangeMax
 }
 
-func (c *ChartData) computeVisible(gtx C, maxY int, domainMin, domainMax int64, rangeMax float64) {
+// computeAuxRange determines the scale of the secondary axis shared by every enabled series
+// that doesn't measure energy. The returned title lists the units on the axis, and ok is false
+// if there are no such series.
+func (c *ChartData) computeAuxRange() (rangeMax, step float64, title string, ok bool) {
+	var units []string
+	for i, series := range c.Dataset {
+		if !c.Enabled[i].Value || series.Unit().IsEnergy() {
+			continue
+		}
+		ok = true
+		_, seriesRateMax := series.RateRange()
+		rangeMax = max(rangeMax, seriesRateMax)
+		if unit := series.Unit().String(); !slices.Contains(units, unit) {
+			units = append(units, unit)
+		}
+	}
+	if !ok {
+		return 0, 0, "", false
+	}
+	if rangeMax <= 0 {
+		rangeMax = 1
+	}
+	step = niceStep(rangeMax / auxAxisTicks)
+	rangeMax = ceil(rangeMax/step) * step
+	return rangeMax, step, "Auxiliary (" + strings.Join(units, ", ") + ")", true
+}
 
-	rangeMin := float64(0)
-	rangeInterval := float32(rangeMax - rangeMin)
-	if rangeInterval == 0 {
-		rangeInterval = 1
+// niceStep rounds a tick interval up to the nearest 1, 2, or 5 times a power of ten.
+func niceStep(raw float64) float64 {
+	magnitude := math.Pow(10, floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 5} {
+		if step := m * magnitude; step >= raw {
+			return step
+		}
 	}
+	return 10 * magnitude
+}
 
+func (c *ChartData) computeVisible(gtx C, maxY int, domainMin, domainMax int64, rangeMax, auxRangeMax float64) {
+	rangeMin := float64(0)
 	oneDp := float32(gtx.Dp(1))
 	totalIntervals := int(ceil(gtx.Metric.PxToDp(gtx.Constraints.Max.X)))
 	for i, series := range c.Dataset {
 		if c.Enabled[i].Value {
+			// Series that don't measure energy are scaled against the auxiliary axis.
+			seriesRangeMax := rangeMax
+			if !series.Unit().IsEnergy() {
+				seriesRangeMax = auxRangeMax
+			}
+			rangeInterval := float32(seriesRangeMax - rangeMin)
+			if rangeInterval == 0 {
+				rangeInterval = 1
+			}
 			c.seriesSlices[i] = c.seriesSlices[i][:0]
 			intervalMean := 0.0
 			for intervalCount := 1; intervalCount <= totalIntervals; intervalCount++ {
 				tsStart := domainMax - (c.nsPerDp * int64(intervalCount))
 				tsEnd := tsStart + c.nsPerDp
 				var ok bool
-				_, intervalMean, _, _, ok = series.RatesBetween(tsStart, tsEnd)
+				var coverage float64
+				_, intervalMean, _, _, coverage, ok = series.RatesBetween(tsStart, tsEnd)
 				if !ok {
 					continue
 				}
+				gap := coverage == 0
 
 				// Compute the X and Y coordinates for this portion of the data.
 				xL := float32(gtx.Constraints.Max.X) - float32(gtx.Dp(unit.Dp(intervalCount)))
@@ -676,6 +862,7 @@ func (c *ChartData) computeVisible(gtx C, maxY int, domainMin, domainMax int64,
 					xR:      xR,
 					y:       yT,
 					mean:    intervalMean,
+					gap:     gap,
 				})
 
 			}
@@ -703,77 +890,95 @@ func (c *ChartData) layoutYAxisGrid(gtx C, maxY, pxPerWatt int) {
 	}
 }
 
-func (c *ChartData) layoutLinePlot(gtx C, maxY, pxPerWatt int, rangeMax float64) {
-	rangeMin := float64(0)
-	rangeInterval := float32(rangeMax - rangeMin)
-	if rangeInterval == 0 {
-		rangeInterval = 1
-	}
-
-	oneDp := float32(gtx.Dp(1))
-
+func (c *ChartData) layoutLinePlot(gtx C, maxY int) {
 	for i := range c.Dataset {
 		if c.Enabled[i].Value {
-			c.returnPath = c.returnPath[:0]
-			var p clip.Path
-			p.Begin(gtx.Ops)
-			prevIntervalMean := 0.0
-			prevYT := float32(0)
-			prevYB := float32(0)
-			for dataIndex, seriesData := range c.seriesSlices[i] {
-				intervalMean := seriesData.mean
-				xR := seriesData.xR
-				yT := seriesData.y
-				yB := yT + oneDp
-				var nextIntervalMean float64
-				if dataIndex < len(c.seriesSlices[i])-1 {
-					nextIntervalMean = c.seriesSlices[i][dataIndex+1].mean
-				}
-				nextYT := float32(maxY) - (float32(nextIntervalMean-rangeMin)/rangeInterval)*float32(maxY)
-				if nextYT > yT || prevYT > yT {
-					yB = max(nextYT+oneDp, prevYT+oneDp)
-				}
+			c.layoutLine(gtx, maxY, i)
+		}
+	}
+}
 
-				if intervalMean == prevIntervalMean &&
-					nextIntervalMean == intervalMean &&
-					dataIndex > 0 &&
-					dataIndex < len(c.seriesSlices[i])-1 &&
-					prevYB-prevYT == oneDp {
-					// We can safely skip processing the current interval if it
-					// has the same value as the previous and next intervals,
-					// is neither the first nor the last interval in the graph,
-					// and the previous segment had the default line thickness.
-					continue
-				}
+// layoutLine draws the visible data of the series at index i as a line, which is broken
+// wherever the series has a gap.
+func (c *ChartData) layoutLine(gtx C, maxY, i int) {
+	run := c.seriesSlices[i]
+	for len(run) > 0 {
+		end := slices.IndexFunc(run, func(t timeslice) bool {
+			return t.gap
+		})
+		if end < 0 {
+			end = len(run)
+		}
+		if end > 0 {
+			c.layoutLineRun(gtx, maxY, i, run[:end])
+		}
+		run = run[min(end+1, len(run)):]
+	}
+}
 
-				if dataIndex == 0 {
-					// The very first interval needs to add special path segments.
-					p.MoveTo(f32.Pt(xR, yT))
-					prevYT = yT
-				}
-				p.LineTo(f32.Pt(xR, prevYT))
-				p.LineTo(f32.Pt(xR, yT))
-				c.returnPath = append(c.returnPath,
-					f32.Pt(xR, prevYB),
-					f32.Pt(xR, yB),
-				)
-				prevYT = yT
-				prevYB = yB
-				prevIntervalMean = intervalMean
-			}
+// layoutLineRun draws consecutive slices of the series at index i as a line.
+func (c *ChartData) layoutLineRun(gtx C, maxY, i int, run []timeslice) {
+	oneDp := float32(gtx.Dp(1))
 
-			for i := range c.returnPath {
-				p.LineTo(c.returnPath[len(c.returnPath)-(i+1)])
-			}
-			p.Close()
+	c.returnPath = c.returnPath[:0]
+	var p clip.Path
+	p.Begin(gtx.Ops)
+	prevIntervalMean := 0.0
+	prevYT := float32(0)
+	prevYB := float32(0)
+	for dataIndex, seriesData := range run {
+		intervalMean := seriesData.mean
+		xR := seriesData.xR
+		yT := seriesData.y
+		yB := yT + oneDp
+		var nextIntervalMean float64
+		nextYT := float32(maxY)
+		if dataIndex < len(run)-1 {
+			nextIntervalMean = run[dataIndex+1].mean
+			nextYT = run[dataIndex+1].y
+		}
+		if nextYT > yT || prevYT > yT {
+			yB = max(nextYT+oneDp, prevYT+oneDp)
+		}
 
-			stack := clip.Outline{
-				Path: p.End(),
-			}.Op().Push(gtx.Ops)
-			paint.Fill(gtx.Ops, colors[i%len(colors)])
-			stack.Pop()
+		if intervalMean == prevIntervalMean &&
+			nextIntervalMean == intervalMean &&
+			dataIndex > 0 &&
+			dataIndex < len(run)-1 &&
+			prevYB-prevYT == oneDp {
+			// We can safely skip processing the current interval if it
+			// has the same value as the previous and next intervals,
+			// is neither the first nor the last interval in the graph,
+			// and the previous segment had the default line thickness.
+			continue
+		}
+
+		if dataIndex == 0 {
+			// The very first interval needs to add special path segments.
+			p.MoveTo(f32.Pt(xR, yT))
+			prevYT = yT
 		}
+		p.LineTo(f32.Pt(xR, prevYT))
+		p.LineTo(f32.Pt(xR, yT))
+		c.returnPath = append(c.returnPath,
+			f32.Pt(xR, prevYB),
+			f32.Pt(xR, yB),
+		)
+		prevYT = yT
+		prevYB = yB
+		prevIntervalMean = intervalMean
 	}
+
+	for i := range c.returnPath {
+		p.LineTo(c.returnPath[len(c.returnPath)-(i+1)])
+	}
+	p.Close()
+
+	stack := clip.Outline{
+		Path: p.End(),
+	}.Op().Push(gtx.Ops)
+	paint.Fill(gtx.Ops, colors[i%len(colors)])
+	stack.Pop()
 }
 
 func (c *ChartData) layoutStackPlot(gtx C, maxY, pxPerWatt int, rangeMax float64) {
@@ -787,8 +992,10 @@ func (c *ChartData) layoutStackPlot(gtx C, maxY, pxPerWatt int, rangeMax float64
 	}
 	stackSums := make([]float64, len(c.seriesSlices[0]))
 	layers := make([]op.CallOp, 0, len(c.Dataset))
+	// Series contained by other enabled series are already part of their area.
+	stackable := c.stackable()
 	for i := 0; i < len(c.Dataset); i++ {
-		if c.Enabled[i].Value {
+		if stackable[i] {
 			macro := op.Record(gtx.Ops)
 			var p clip.Path
 			p.Begin(gtx.Ops)
@@ -826,4 +1033,11 @@ func (c *ChartData) layoutStackPlot(gtx C, maxY, pxPerWatt int, rangeMax float64
 	for i := len(layers) - 1; i >= 0; i-- {
 		layers[i].Add(gtx.Ops)
 	}
+	// Series that don't measure energy can't be stacked, so draw them as lines on top, along
+	// with the total, which outlines the stack.
+	for i := range c.Dataset {
+		if c.Enabled[i].Value && (!c.Dataset[i].Unit().IsEnergy() || c.Dataset[i].Name() == backend.TotalSystemName) {
+			c.layoutLine(gtx, maxY, i)
+		}
+	}
 }
@@ -6,6 +6,8 @@ function run_checks {
     echo checking $GOOS
     if [ "$GOOS" = "linux" ]; then
         go test ./...
+        # The libsensors hwmon provider is opt-in, so make sure it still builds.
+        go vet -tags libsensors ./hwmon/
     fi
     staticcheck ./...
 }
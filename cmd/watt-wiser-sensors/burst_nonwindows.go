@@ -0,0 +1,16 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyBurst relays requests to start and stop bursts, which arrive as SIGUSR1 and
+// SIGUSR2.
+func notifyBurst(start, stop chan<- os.Signal) {
+	signal.Notify(start, syscall.SIGUSR1)
+	signal.Notify(stop, syscall.SIGUSR2)
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// notifyBurst does nothing, as Windows has no signals to request bursts with.
+func notifyBurst(start, stop chan<- os.Signal) {}
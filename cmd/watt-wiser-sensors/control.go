@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/control"
+)
+
+// controlRequest is a control request waiting to be handled by the main goroutine.
+type controlRequest struct {
+	control.Request
+	reply chan<- control.Response
+}
+
+// listenControl accepts control requests from stdin (if spec is "stdin") or from every
+// connection to a Unix socket at the path spec, and delivers them on the returned channel.
+// Responses to requests on stdin are written to stderr.
+func listenControl(spec string) (<-chan controlRequest, error) {
+	requests := make(chan controlRequest)
+	handle := func(req control.Request) control.Response {
+		reply := make(chan control.Response, 1)
+		requests <- controlRequest{Request: req, reply: reply}
+		return <-reply
+	}
+	if spec == "stdin" {
+		go func() {
+			if err := control.Serve(os.Stdin, os.Stderr, handle); err != nil {
+				log.Printf("failed reading control requests: %v", err)
+			}
+		}()
+		return requests, nil
+	}
+	// Remove any socket left behind by an earlier run.
+	if err := os.Remove(spec); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed removing stale control socket: %w", err)
+	}
+	listener, err := net.Listen("unix", spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed listening for control connections: %w", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				log.Printf("failed accepting control connection: %v", err)
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if err := control.Serve(conn, conn, handle); err != nil {
+					log.Printf("failed serving control connection: %v", err)
+				}
+			}()
+		}
+	}()
+	return requests, nil
+}
+
+// controller carries out control requests. It must only be used by the main goroutine.
+type controller struct {
+	trace  *traceWriter
+	output io.WriteCloser
+	// outputName is the path of the output file, or "-" for stdout.
+	outputName string
+	// burstDuration is the longest burst that may be requested.
+	burstDuration time.Duration
+	// shutdown is set once a shutdown has been requested.
+	shutdown bool
+}
+
+func (c *controller) handle(req control.Request) control.Response {
+	var err error
+	var resp control.Response
+	switch req.Command {
+	case control.CommandPause:
+		c.trace.pause()
+	case control.CommandResume:
+		c.trace.resume()
+	case control.CommandSetInterval:
+		var interval time.Duration
+		interval, err = time.ParseDuration(req.Interval)
+		if err == nil {
+			err = c.trace.setInterval(req.Provider, interval)
+		}
+	case control.CommandMark:
+		at := req.Timestamp
+		if at == 0 {
+			at = c.trace.now()
+		}
+		err = c.trace.writeMark(at, req.Label)
+	case control.CommandRotateOutput:
+		err = c.rotate(req.Path)
+	case control.CommandListSensors:
+		resp.Sensors = c.trace.sensorList()
+	case control.CommandStartBurst:
+		duration := c.burstDuration
+		if req.Interval != "" {
+			var requested time.Duration
+			requested, err = time.ParseDuration(req.Interval)
+			duration = min(requested, duration)
+		}
+		if err == nil {
+			c.trace.startBurst(duration)
+		}
+	case control.CommandStopBurst:
+		c.trace.stopBurst()
+	case control.CommandShutdown:
+		c.shutdown = true
+	default:
+		err = fmt.Errorf("unknown command %q", req.Command)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp
+}
+
+// rotate switches the trace to a new output file at path. If path is empty, the current
+// output file is renamed with a timestamp (in nanoseconds, so that rotations in quick
+// succession get distinct names) and a new one is created in its place. An existing file
+// is never replaced by the renamed output.
+func (c *controller) rotate(path string) error {
+	if path == "" {
+		if c.outputName == "-" {
+			return fmt.Errorf("a path is required when writing to stdout")
+		}
+		now := time.Unix(0, c.trace.now())
+		ext := filepath.Ext(c.outputName)
+		rotated := fmt.Sprintf("%s-%s%09d%s", strings.TrimSuffix(c.outputName, ext), now.Format("20060102150405"), now.Nanosecond(), ext)
+		if _, err := os.Lstat(rotated); err == nil {
+			return fmt.Errorf("failed renaming output: %q already exists", rotated)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed checking for existing output: %w", err)
+		}
+		if err := os.Rename(c.outputName, rotated); err != nil {
+			return fmt.Errorf("failed renaming output: %w", err)
+		}
+		log.Printf("moved previous output to %q", rotated)
+		path = c.outputName
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed creating output: %w", err)
+	}
+	if err := c.output.Close(); err != nil {
+		log.Printf("failed closing previous output: %v", err)
+	}
+	c.output = f
+	c.outputName = path
+	c.trace.out = f
+	// Every output file starts with headings.
+	c.trace.headingsChanged = true
+	return nil
+}
+
+// close closes the output.
+func (c *controller) close() {
+	if err := c.output.Close(); err != nil {
+		log.Printf("failed closing output: %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/control"
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestController(t *testing.T) {
+	var out strings.Builder
+	trace := newTraceWriter(&out, sensors.Gap, 0, 10)
+	var now int64
+	trace.now = func() int64 { return now }
+	trace.add(group{provider: "rapl", sensors: []sensors.Sensor{&scriptedSensor{name: "package", values: []float64{1}}}})
+	ctl := &controller{trace: trace, output: nopWriteCloser{&out}, outputName: "-"}
+	send := func(req control.Request) control.Response {
+		t.Helper()
+		resp := ctl.handle(req)
+		if resp.Error != "" {
+			t.Errorf("expected %s to succeed, got %q", req.Command, resp.Error)
+		}
+		return resp
+	}
+	rapl := trace.providers["rapl"]
+
+	send(control.Request{Command: control.CommandPause})
+	now = 10
+	if readings := rapl.sample(trace); len(readings) != 0 {
+		t.Errorf("expected no readings while paused, got %d", len(readings))
+	}
+	now = 50
+	send(control.Request{Command: control.CommandResume})
+	now = 60
+	readings := rapl.sample(trace)
+	if len(readings) != 1 || readings[0].start != 50 {
+		t.Errorf("expected one reading starting when sampling resumed, got %+v", readings)
+	}
+
+	send(control.Request{Command: control.CommandSetInterval, Provider: "rapl", Interval: "5ns"})
+	now = 65
+	if readings := rapl.sample(trace); len(readings) != 1 {
+		t.Errorf("expected a reading at the new interval, got %d", len(readings))
+	}
+	if resp := ctl.handle(control.Request{Command: control.CommandSetInterval, Provider: "nvml", Interval: "5ns"}); resp.Error == "" {
+		t.Errorf("expected setting the interval of a missing provider to fail")
+	}
+
+	list := send(control.Request{Command: control.CommandListSensors}).Sensors
+	expected := control.Sensor{Heading: "package (W)", Provider: "rapl", Interval: "5ns", Live: true}
+	if len(list) != 1 || list[0] != expected {
+		t.Errorf("expected sensors to be [%+v], got %+v", expected, list)
+	}
+
+	send(control.Request{Command: control.CommandMark, Label: "phase, one", Timestamp: 42})
+	if !strings.HasSuffix(out.String(), "mark,42,\"phase, one\"\n") {
+		t.Errorf("expected trace to end with a mark, got:\n%s", out.String())
+	}
+	if resp := ctl.handle(control.Request{Command: control.CommandRotateOutput}); resp.Error == "" {
+		t.Errorf("expected rotating stdout without a path to fail")
+	}
+	if resp := ctl.handle(control.Request{Command: "explode"}); resp.Error == "" {
+		t.Errorf("expected an unknown command to fail")
+	}
+	send(control.Request{Command: control.CommandShutdown})
+	if !ctl.shutdown {
+		t.Errorf("expected shutdown to be requested")
+	}
+}
+
+func TestControllerRotate(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "trace.csv")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatalf("failed creating output: %v", err)
+	}
+	trace := newTraceWriter(f, sensors.Gap, 0, 10)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).UnixNano()
+	trace.now = func() int64 { return now }
+	ctl := &controller{trace: trace, output: f, outputName: name}
+	defer ctl.close()
+	rotate := func(contents string) control.Response {
+		t.Helper()
+		if _, err := io.WriteString(ctl.output, contents); err != nil {
+			t.Fatalf("failed writing output: %v", err)
+		}
+		return ctl.handle(control.Request{Command: control.CommandRotateOutput})
+	}
+
+	if resp := rotate("first"); resp.Error != "" {
+		t.Fatalf("expected rotation to succeed, got %q", resp.Error)
+	}
+	// A second rotation within the same second must not replace the first.
+	now += 1
+	if resp := rotate("second"); resp.Error != "" {
+		t.Fatalf("expected rotation to succeed, got %q", resp.Error)
+	}
+	for rotated, expected := range map[string]string{
+		"trace-20240102030405000000000.csv": "first",
+		"trace-20240102030405000000001.csv": "second",
+	} {
+		contents, err := os.ReadFile(filepath.Join(dir, rotated))
+		if err != nil {
+			t.Errorf("failed reading rotated output: %v", err)
+		} else if string(contents) != expected {
+			t.Errorf("expected %s to contain %q, got %q", rotated, expected, contents)
+		}
+	}
+
+	if resp := rotate("third"); resp.Error == "" {
+		t.Errorf("expected rotating onto an existing file to fail")
+	}
+	if contents, err := os.ReadFile(filepath.Join(dir, "trace-20240102030405000000001.csv")); err != nil || string(contents) != "second" {
+		t.Errorf("expected the existing rotated output to be kept, got %q (error %v)", contents, err)
+	}
+	if contents, err := os.ReadFile(name); err != nil || string(contents) != "third" {
+		t.Errorf("expected the output to be kept after a failed rotation, got %q (error %v)", contents, err)
+	}
+}
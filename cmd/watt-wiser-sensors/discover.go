@@ -0,0 +1,115 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/cpufreq"
+	"git.sr.ht/~whereswaldon/watt-wiser/hwmon"
+	"git.sr.ht/~whereswaldon/watt-wiser/nvml"
+	"git.sr.ht/~whereswaldon/watt-wiser/powersupply"
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+// discoverer finds the sensors of devices that may be connected or disconnected while
+// recording, like eGPUs, USB-C power supplies, and hotplugged CPUs. Providers that hold
+// resources open for each sensor they return (like RAPL and ADLX) or that are configured
+// explicitly (like power meters) are discovered once instead.
+type discoverer struct {
+	// processTree, if nonzero, is the process whose descendants GPU energy is attributed
+	// to.
+	processTree int
+	// auxiliary enables the discovery of auxiliary sensors.
+	auxiliary bool
+	// throttling enables the discovery of the auxiliary sensors reporting whether GPUs are
+	// throttled, even without auxiliary.
+	throttling bool
+}
+
+// logger returns log.Printf if verbose, and a func discarding its input otherwise. This
+// keeps repeated discovery from logging the same failures over and over.
+func logger(verbose bool) func(format string, args ...any) {
+	if verbose {
+		return log.Printf
+	}
+	return func(string, ...any) {}
+}
+
+func (d discoverer) energySensors(verbose bool) []group {
+	logf := logger(verbose)
+	hwmonSensors, err := hwmon.FindEnergySensors()
+	if err != nil {
+		logf("failed loading HWMON sensors: %v", err)
+	}
+	nvidiaGPUSensors, err := nvml.FindGPUSensors(verbose)
+	if err != nil {
+		logf("failed loading NVIDIA GPU sensors: %v", err)
+	}
+	if d.processTree > 0 {
+		processSensors, err := nvml.FindProcessSensors(uint32(d.processTree), verbose)
+		if err != nil {
+			logf("failed loading NVIDIA GPU process sensors: %v", err)
+		}
+		nvidiaGPUSensors = append(nvidiaGPUSensors, processSensors...)
+	}
+	powerSupplySensors, err := powersupply.FindSensors()
+	if err != nil {
+		logf("failed loading power supply sensors: %v", err)
+	}
+	return []group{
+		{provider: "hwmon", sensors: hwmonSensors},
+		{provider: "nvml", sensors: nvidiaGPUSensors},
+		{provider: "powersupply", sensors: powerSupplySensors},
+	}
+}
+
+func (d discoverer) auxiliarySensors(verbose bool) []group {
+	if !d.auxiliary && !d.throttling {
+		return nil
+	}
+	logf := logger(verbose)
+	if !d.auxiliary {
+		nvidiaAuxSensors, err := nvml.FindAuxiliarySensors(verbose)
+		if err != nil {
+			logf("failed loading NVIDIA GPU auxiliary sensors: %v", err)
+		}
+		var throttleSensors []sensors.Sensor
+		for _, s := range nvidiaAuxSensors {
+			if strings.HasSuffix(s.Name(), sensors.ThrottleSuffix) {
+				throttleSensors = append(throttleSensors, s)
+			}
+		}
+		return []group{{provider: "nvml-auxiliary", sensors: throttleSensors}}
+	}
+	hwmonAuxSensors, err := hwmon.FindAuxiliarySensors()
+	if err != nil {
+		logf("failed loading HWMON auxiliary sensors: %v", err)
+	}
+	cpuFreqSensors, err := cpufreq.FindSensors()
+	if err != nil {
+		logf("failed loading CPU frequency sensors: %v", err)
+	}
+	nvidiaAuxSensors, err := nvml.FindAuxiliarySensors(verbose)
+	if err != nil {
+		logf("failed loading NVIDIA GPU auxiliary sensors: %v", err)
+	}
+	return []group{
+		{provider: "hwmon-auxiliary", sensors: hwmonAuxSensors},
+		{provider: "cpufreq", sensors: cpuFreqSensors},
+		{provider: "nvml-auxiliary", sensors: nvidiaAuxSensors},
+	}
+}
+
+// watch repeats discovery at the given interval, emitting everything found each time.
+func (d discoverer) watch(interval time.Duration) <-chan []group {
+	out := make(chan []group)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			out <- append(d.energySensors(false), d.auxiliarySensors(false)...)
+		}
+	}()
+	return out
+}
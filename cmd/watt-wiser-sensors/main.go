@@ -8,11 +8,11 @@ import (
 	"os"
 	"os/signal"
 	"runtime"
+	"strings"
 	"time"
 
 	"git.sr.ht/~whereswaldon/watt-wiser/adlx"
-	"git.sr.ht/~whereswaldon/watt-wiser/hwmon"
-	"git.sr.ht/~whereswaldon/watt-wiser/nvml"
+	"git.sr.ht/~whereswaldon/watt-wiser/powermeter"
 	"git.sr.ht/~whereswaldon/watt-wiser/rapl"
 	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
 )
@@ -57,6 +57,45 @@ This platform is unsupported; no sensor data is available.
 	flag.PrintDefaults()
 }
 
+// meterFlags collects the values of every -meter flag.
+type meterFlags []string
+
+func (m *meterFlags) String() string {
+	return strings.Join(*m, " ")
+}
+
+func (m *meterFlags) Set(value string) error {
+	*m = append(*m, value)
+	return nil
+}
+
+// intervalFlags collects the values of every -provider-interval flag.
+type intervalFlags map[string]time.Duration
+
+func (f intervalFlags) String() string {
+	var entries []string
+	for provider, interval := range f {
+		entries = append(entries, provider+"="+interval.String())
+	}
+	return strings.Join(entries, " ")
+}
+
+func (f intervalFlags) Set(value string) error {
+	provider, rawInterval, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("expected provider=interval, got %q", value)
+	}
+	interval, err := time.ParseDuration(rawInterval)
+	if err != nil {
+		return fmt.Errorf("invalid interval for %s: %w", provider, err)
+	}
+	if interval <= 0 {
+		return fmt.Errorf("interval for %s must be positive", provider)
+	}
+	f[provider] = interval
+	return nil
+}
+
 func main() {
 	switch runtime.GOOS {
 	case "linux":
@@ -66,25 +105,57 @@ func main() {
 	default:
 		flag.Usage = unsupportedUsage
 	}
-	dur := flag.Duration("sample-interval", 100*time.Millisecond, "Interval between reading new samples from sensors")
+	dur := flag.Duration("sample-interval", 100*time.Millisecond, "Interval between reading new samples from sensors that don't report their own update interval")
+	providerIntervals := intervalFlags{}
+	flag.Var(providerIntervals, "provider-interval", "Interval between reading new samples from every sensor of a provider, as provider=interval, where provider is one of rapl, hwmon, nvml, powersupply, adlx, meter (every -meter) or meterN (the Nth -meter, from 0), hwmon-auxiliary, cpufreq, or nvml-auxiliary (may be repeated)")
 	outputName := flag.String("output", "-", "Output file for CSV sensor data")
+	var meterSpecs meterFlags
+	flag.Var(&meterSpecs, "meter", "External power meter to read, as protocol:path[,baud[,query]] where protocol is scpi or line (may be repeated)")
+	gpuProcessTree := flag.Int("gpu-process-tree", 0, "Attribute NVIDIA GPU energy to the descendants of this process ID, recording it alongside each GPU")
+	auxiliary := flag.Bool("auxiliary", false, "Also record temperatures, fan speeds, clocks, utilization, and throttling alongside energy data")
+	throttling := flag.Bool("throttling", false, "Also record whether NVIDIA GPUs are power-capped or thermally throttled alongside energy data (implied by -auxiliary)")
+	onError := flag.String("on-error", sensors.Retry.String(), "How to handle failed sensor reads that the sensor doesn't classify itself: retry, gap (leave the sample empty), or drop (stop reading the sensor)")
+	maxFailures := flag.Int("max-failures", 50, "Stop reading a sensor after this many consecutive failed reads (0 for no limit)")
+	burstInterval := flag.Duration("burst-interval", time.Millisecond, "Interval between reading new samples from burst providers during a burst")
+	burstDuration := flag.Duration("burst-duration", 5*time.Second, "Maximum duration of a burst, which starts on SIGUSR1 and ends early on SIGUSR2")
+	burstProviders := flag.String("burst-providers", "rapl", "Comma-separated providers to sample at -burst-interval during bursts")
+	controlSpec := flag.String("control", "", "Accept line-delimited JSON control requests from stdin (\"stdin\", with responses on stderr) or a Unix socket at this path")
+	rediscoverInterval := flag.Duration("rediscover-interval", 10*time.Second, "Interval between searches for newly connected devices (0 to disable)")
 	flag.Parse()
-	raplSensors, err := rapl.FindRAPL()
-	if err != nil {
-		log.Printf("failed loading RAPL sensors: %v", err)
-	}
-	hwmonSensors, err := hwmon.FindEnergySensors()
+	fallbackPolicy, err := sensors.ParseErrorPolicy(*onError)
 	if err != nil {
-		log.Printf("failed loading HWMON sensors: %v", err)
+		log.Fatalf("invalid -on-error: %v", err)
 	}
-	nvidiaGPUSensors, err := nvml.FindGPUSensors()
+	discoverer := discoverer{processTree: *gpuProcessTree, auxiliary: *auxiliary, throttling: *throttling}
+	raplSensors, err := rapl.FindRAPL()
 	if err != nil {
-		log.Printf("failed loading NVIDIA GPU sensors: %v", err)
+		log.Printf("failed loading RAPL sensors: %v", err)
 	}
 	amdGPUSensors, err := adlx.FindSensors()
 	if err != nil {
 		log.Printf("failed loading AMD GPU sensors: %v", err)
 	}
+	groups := []group{{provider: "rapl", sensors: raplSensors}}
+	groups = append(groups, discoverer.energySensors(true)...)
+	groups = append(groups, group{provider: "adlx", sensors: amdGPUSensors})
+	for i, spec := range meterSpecs {
+		cfg, err := powermeter.ParseConfig(spec)
+		if err != nil {
+			log.Fatalf("invalid meter: %v", err)
+		}
+		meter, err := powermeter.Open(cfg)
+		if err != nil {
+			log.Fatalf("failed opening meter %q: %v", spec, err)
+		}
+		// Each meter is its own provider so that slow serial links don't delay each other.
+		provider := fmt.Sprintf("meter%d", i)
+		groups = append(groups, group{provider: provider, sensors: []sensors.Sensor{meter}})
+		if _, ok := providerIntervals[provider]; !ok {
+			if interval, ok := providerIntervals["meter"]; ok {
+				providerIntervals[provider] = interval
+			}
+		}
+	}
 
 	var output io.WriteCloser
 	if *outputName == "-" {
@@ -96,75 +167,76 @@ func main() {
 		}
 		output = f
 	}
-	sensorList := make([]sensors.Sensor, 0, len(raplSensors)+len(hwmonSensors)+len(nvidiaGPUSensors))
-	sensorList = append(sensorList, raplSensors...)
-	sensorList = append(sensorList, hwmonSensors...)
-	sensorList = append(sensorList, nvidiaGPUSensors...)
-	sensorList = append(sensorList, amdGPUSensors...)
-
-	if len(sensorList) < 1 {
+	sensorCount := 0
+	for _, g := range groups {
+		sensorCount += len(g.sensors)
+	}
+	if sensorCount < 1 {
 		log.Fatalf("No supported sensors found. Please see https://git.sr.ht/~whereswaldon/watt-wiser or https://github.com/wattwisegames/watt-wiser for supported hardware information")
 	}
-
-	fmt.Fprintf(output, "sample start (ns), sample end (ns), ")
-	for _, s := range sensorList {
-		fmt.Fprintf(output, "%s (%s), ", s.Name(), s.Unit())
+	// Auxiliary sensors are only useful alongside energy data, so they don't count towards
+	// having found supported sensors.
+	groups = append(groups, discoverer.auxiliarySensors(true)...)
+
+	trace := newTraceWriter(output, fallbackPolicy, *maxFailures, *dur)
+	trace.providerIntervals = providerIntervals
+	trace.burstInterval = *burstInterval
+	trace.burstProviders = map[string]bool{}
+	for _, provider := range strings.Split(*burstProviders, ",") {
+		trace.burstProviders[strings.TrimSpace(provider)] = true
+	}
+	for _, g := range groups {
+		trace.add(g)
+	}
+	if err := trace.writeHeadings(); err != nil {
+		log.Fatalf("failed writing headings: %v", err)
 	}
-	fmt.Fprintln(output)
-	samples := make([]float64, len(sensorList))
-	absStartTime := time.Now()
-	lastReadTime := absStartTime.UnixNano()
-	// Pre-read every sensor once to ensure that incremental sensors emit coherent first values.
-	for _, chip := range sensorList {
-		_, err := chip.Read()
+	trace.start()
+	ctl := &controller{
+		trace:         trace,
+		output:        output,
+		outputName:    *outputName,
+		burstDuration: *burstDuration,
+	}
+	var controlRequests <-chan controlRequest
+	if *controlSpec != "" {
+		controlRequests, err = listenControl(*controlSpec)
 		if err != nil {
-			log.Fatalf("failed reading value: %v", err)
-			return
+			log.Fatalf("failed accepting control requests: %v", err)
 		}
 	}
-	sampleRate := *dur
-	ticker := time.NewTicker(sampleRate)
+	var rediscovered <-chan []group
+	if *rediscoverInterval > 0 {
+		rediscovered = discoverer.watch(*rediscoverInterval)
+	}
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt)
-	defer ticker.Stop()
+	burstStart := make(chan os.Signal, 1)
+	burstStop := make(chan os.Signal, 1)
+	notifyBurst(burstStart, burstStop)
 	for {
 		select {
 		case <-sigChan:
 			// We've gotten an interrupt; shut down.
-			if err := output.Close(); err != nil {
-				log.Printf("failed closing output: %v", err)
-			}
+			ctl.close()
 			return
-		case sampleEndTime := <-ticker.C:
-			for chipIdx, chip := range sensorList {
-				v, err := chip.Read()
-				if err != nil {
-					log.Fatalf("failed reading value: %v", err)
-					return
-				}
-				samples[chipIdx] = v
+		case req := <-controlRequests:
+			req.reply <- ctl.handle(req.Request)
+			if ctl.shutdown {
+				ctl.close()
+				return
 			}
-			// Compute durations spent reading sensors relative to our fixed start time.
-			readFinishedAt := time.Since(absStartTime)
-			readStartedAt := sampleEndTime.Sub(absStartTime)
-			// Then compute the end of the sample by adding a monotonic interval to our fixed
-			// start time to avoid clock skew.
-			readStartAbs := absStartTime.UnixNano() + readStartedAt.Nanoseconds()
-			if readDuration := readFinishedAt - readStartedAt; readDuration < sampleRate*2 {
-				// This sample was not interrupted mid-read, so we're good.
-				fmt.Fprintf(output, "%d, %d, ", lastReadTime, readStartAbs)
-				for chipIdx := range sensorList {
-					v := samples[chipIdx]
-					fmt.Fprintf(output, "%f, ", v)
-				}
-				fmt.Fprintln(output)
-			} else {
-				log.Printf("dropping sample with read duration %d >= sample rate %d", readDuration, sampleRate)
+		case found := <-rediscovered:
+			for _, g := range found {
+				trace.add(g)
 			}
-			// Update our lastReadTime to the non-clock-skewed timestamp of when we started the sensor reads.
-			lastReadTime = readStartAbs
-			for i := range samples {
-				samples[i] = 0
+		case <-burstStart:
+			trace.startBurst(*burstDuration)
+		case <-burstStop:
+			trace.stopBurst()
+		case r := <-trace.readings:
+			if err := trace.record(r); err != nil {
+				log.Fatalf("failed writing sample: %v", err)
 			}
 		}
 	}
@@ -0,0 +1,597 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/control"
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+// group is a set of sensors from one provider.
+type group struct {
+	provider string
+	sensors  []sensors.Sensor
+}
+
+// column is a column of the trace along with the sensor filling it.
+type column struct {
+	index   int
+	heading string
+	// sensor fills the column, or is nil if the sensor was dropped.
+	sensor sensors.Sensor
+	// failures counts consecutive failed reads.
+	failures int
+	// lastRead is when the sensor was last read, which starts its next sample. A failed read
+	// moves it too, so that the next sample starts after a gap.
+	lastRead int64
+	// reprime is set when the sensor is an energy counter whose last read failed. Its next
+	// read would fold the energy consumed during the gap into one sample, so it only
+	// restarts the count.
+	reprime bool
+	// interval is the time between reads of the sensor.
+	interval time.Duration
+	// due is when the sensor should next be read.
+	due int64
+	// window is the duration that the sensor's readings are averaged over, if known.
+	window time.Duration
+	// container is the name of the sensor whose readings include this one's, if any.
+	container string
+	// unit is the unit of the sensor's readings.
+	unit sensors.Unit
+	// wholeSystem is set if the sensor measures the whole system, and so includes the
+	// readings of every sensor that isn't part of another.
+	wholeSystem bool
+}
+
+// reading is a sample of a single sensor.
+type reading struct {
+	column     int
+	start, end int64
+	value      float64
+}
+
+// provider samples the columns of one provider. Each provider is sampled independently so
+// that slow sensors (like NVML or serial meters) don't delay the others, and each of its
+// columns is read at its own interval.
+type provider struct {
+	name string
+	// mu protects the columns and their sensors, which are sampled by the provider's
+	// goroutine and attached by rediscovery.
+	mu      sync.Mutex
+	columns []*column
+	// burst is set if the provider is sampled at the burst interval during bursts.
+	burst bool
+	// wake interrupts the provider's wait for its next sample when due times change.
+	wake chan struct{}
+}
+
+// traceWriter writes sensor readings as a CSV trace. Each reading is written as its own
+// row, so every sensor gets its own sample start and end, and the other cells of the row
+// are left empty. Columns can be added partway through a trace, in which case a new heading
+// row is written repeating the earlier columns in place and appending the new ones.
+type traceWriter struct {
+	out       io.Writer
+	columns   []*column
+	providers map[string]*provider
+	// fallback is the error policy for errors that no sensor classifies.
+	fallback sensors.ErrorPolicy
+	// maxFailures is the number of consecutive failed reads after which a sensor is
+	// dropped. Zero disables the limit.
+	maxFailures int
+	// headingsChanged is set when columns have been added since headings were written.
+	headingsChanged bool
+	// now returns the current time in nanoseconds since the epoch.
+	now func() int64
+	// interval is the time between reads of sensors that don't know their own update
+	// interval, in nanoseconds. It can be changed while providers are sampled.
+	interval atomic.Int64
+	// providerIntervals overrides the interval of every sensor of the named providers.
+	providerIntervals map[string]time.Duration
+	// sampling is set once providers are being sampled automatically.
+	sampling bool
+	// paused is set while sensors should not be read.
+	paused atomic.Bool
+	// burstInterval is the time between reads of burst providers' sensors during a burst.
+	burstInterval time.Duration
+	// burstProviders names the providers sampled at the burst interval during bursts.
+	burstProviders map[string]bool
+	// burstUntil is when the current burst ends, in nanoseconds since the epoch.
+	burstUntil atomic.Int64
+	// buffered holds the readings taken during a burst, which are written once it ends to
+	// keep I/O from disturbing the sampling.
+	buffered []reading
+	// readings receives the readings of every provider.
+	readings chan reading
+}
+
+func newTraceWriter(out io.Writer, fallback sensors.ErrorPolicy, maxFailures int, interval time.Duration) *traceWriter {
+	start := time.Now()
+	t := &traceWriter{
+		out:               out,
+		providers:         map[string]*provider{},
+		providerIntervals: map[string]time.Duration{},
+		fallback:          fallback,
+		maxFailures:       maxFailures,
+		// Add a monotonic interval to a fixed start time to avoid clock skew.
+		now: func() int64 {
+			return start.UnixNano() + time.Since(start).Nanoseconds()
+		},
+		readings: make(chan reading, 1024),
+	}
+	t.interval.Store(interval.Nanoseconds())
+	return t
+}
+
+func heading(s sensors.Sensor) string {
+	return fmt.Sprintf("%s (%s)", s.Name(), s.Unit())
+}
+
+// add attaches a group of sensors to the trace, sampling its provider if it is new.
+// Because sensors may be rediscovered many times, each sensor is matched with an existing
+// column of the same provider and heading first. Sensors matching a column whose sensor
+// was dropped replace it, sensors matching a live column are ignored, and the rest get new
+// columns. Sensors are read once before being attached so that incremental sensors emit
+// coherent first values, and are ignored if that read fails.
+func (t *traceWriter) add(g group) {
+	p, ok := t.providers[g.provider]
+	if !ok {
+		p = &provider{name: g.provider, burst: t.burstProviders[g.provider], wake: make(chan struct{}, 1)}
+		t.providers[g.provider] = p
+		if t.sampling {
+			go p.run(t)
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	matched := map[*column]bool{}
+	for _, s := range g.sensors {
+		h := heading(s)
+		var match *column
+		for _, c := range p.columns {
+			if c.heading == h && !matched[c] {
+				match = c
+				break
+			}
+		}
+		if match != nil {
+			matched[match] = true
+			if match.sensor != nil {
+				continue
+			}
+		}
+		if _, err := s.Read(); err != nil {
+			log.Printf("not recording %q because reading it failed: %v", h, err)
+			continue
+		}
+		if match != nil {
+			log.Printf("resuming recording of %q", h)
+			match.sensor = s
+			match.failures = 0
+			match.reprime = false
+			match.lastRead = t.now()
+			match.interval = t.intervalFor(g.provider, s)
+			match.due = match.lastRead + match.interval.Nanoseconds()
+			continue
+		}
+		c := &column{index: len(t.columns), heading: h, sensor: s, unit: s.Unit(), lastRead: t.now(), interval: t.intervalFor(g.provider, s)}
+		c.due = c.lastRead + c.interval.Nanoseconds()
+		if averager, ok := s.(sensors.Averager); ok {
+			c.window = averager.AveragingWindow()
+		}
+		if contained, ok := s.(sensors.Contained); ok {
+			c.container = contained.ContainedIn()
+		}
+		if whole, ok := s.(sensors.WholeSystem); ok {
+			c.wholeSystem = whole.WholeSystem()
+		}
+		t.columns = append(t.columns, c)
+		p.columns = append(p.columns, c)
+		matched[c] = true
+		t.headingsChanged = true
+	}
+}
+
+// intervalFor returns the interval at which to read a sensor of the given provider. This is
+// the provider's override if there is one, and otherwise the sensor's own update interval
+// if it knows it.
+func (t *traceWriter) intervalFor(provider string, s sensors.Sensor) time.Duration {
+	if interval, ok := t.providerIntervals[provider]; ok {
+		return interval
+	}
+	if u, ok := s.(sensors.UpdateIntervaler); ok && u.UpdateInterval() > 0 {
+		return u.UpdateInterval()
+	}
+	return time.Duration(t.interval.Load())
+}
+
+// setInterval changes the interval of every sensor of the named provider or, if provider
+// is empty, of every sensor that doesn't know its own update interval.
+func (t *traceWriter) setInterval(provider string, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+	if provider == "" {
+		t.interval.Store(interval.Nanoseconds())
+	} else {
+		if _, ok := t.providers[provider]; !ok {
+			return fmt.Errorf("no provider named %q", provider)
+		}
+		t.providerIntervals[provider] = interval
+	}
+	for _, p := range t.providers {
+		p.mu.Lock()
+		for _, c := range p.columns {
+			if c.sensor == nil {
+				continue
+			}
+			c.interval = t.intervalFor(p.name, c.sensor)
+			c.due = c.lastRead + c.interval.Nanoseconds()
+		}
+		p.mu.Unlock()
+		// Wake the provider so that a shorter interval takes effect immediately.
+		select {
+		case p.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// pause stops reading sensors until resume is called.
+func (t *traceWriter) pause() {
+	t.paused.Store(true)
+}
+
+// resume restarts reading sensors after pause. Each sensor is read once first, so the time
+// spent paused is left as a gap rather than being folded into the next reading.
+func (t *traceWriter) resume() {
+	for _, p := range t.providers {
+		p.mu.Lock()
+		for _, c := range p.columns {
+			if c.sensor == nil {
+				continue
+			}
+			if _, err := c.sensor.Read(); err != nil {
+				log.Printf("failed reading %q while resuming: %v", c.heading, err)
+			}
+			c.reprime = false
+			c.lastRead = t.now()
+			c.due = c.lastRead + c.interval.Nanoseconds()
+		}
+		p.mu.Unlock()
+	}
+	t.paused.Store(false)
+	for _, p := range t.providers {
+		select {
+		case p.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// sensorList describes every column of the trace, in column order.
+func (t *traceWriter) sensorList() []control.Sensor {
+	list := make([]control.Sensor, len(t.columns))
+	for _, p := range t.providers {
+		p.mu.Lock()
+		for _, c := range p.columns {
+			list[c.index] = control.Sensor{
+				Heading:  c.heading,
+				Provider: p.name,
+				Interval: c.interval.String(),
+				Live:     c.sensor != nil,
+			}
+		}
+		p.mu.Unlock()
+	}
+	return slices.Clip(list)
+}
+
+// start samples every provider, including those added later, in its own goroutine.
+func (t *traceWriter) start() {
+	t.sampling = true
+	for _, p := range t.providers {
+		go p.run(t)
+	}
+}
+
+// run samples the provider forever, waking whenever one of its sensors is due. While the
+// trace is paused, it waits to be woken by resume instead.
+func (p *provider) run(t *traceWriter) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-p.wake:
+			// Due times changed, so the timer may be waiting too long.
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		}
+		for t.paused.Load() {
+			<-p.wake
+		}
+		for _, r := range p.sample(t) {
+			t.readings <- r
+		}
+		timer.Reset(time.Duration(p.nextDue(t) - t.now()))
+	}
+}
+
+// hurry makes every sensor of the provider due at the given time.
+func (p *provider) hurry(now int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.columns {
+		c.due = min(c.due, now)
+	}
+}
+
+// startBurst samples the burst providers at the burst interval for the given duration,
+// buffering every reading until the burst ends.
+func (t *traceWriter) startBurst(duration time.Duration) {
+	now := t.now()
+	t.burstUntil.Store(now + duration.Nanoseconds())
+	for _, p := range t.providers {
+		if !p.burst {
+			continue
+		}
+		p.hurry(now)
+		select {
+		case p.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// stopBurst ends the current burst, if any.
+func (t *traceWriter) stopBurst() {
+	t.burstUntil.Store(0)
+}
+
+// bursting reports whether a burst is in progress at the given time.
+func (t *traceWriter) bursting(now int64) bool {
+	return now < t.burstUntil.Load()
+}
+
+// nextDue returns when the next of the provider's sensors should be read. If none of them
+// are being recorded, it returns when to check again for reattached sensors.
+func (p *provider) nextDue(t *traceWriter) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	next := t.now() + t.interval.Load()
+	for _, c := range p.columns {
+		if c.sensor != nil && c.due < next {
+			next = c.due
+		}
+	}
+	return next
+}
+
+// sample reads every sensor of the provider that is due. Each reading is timestamped at the
+// midpoint of its read, and spans the time since the sensor's previous read. Failed reads
+// produce no reading, leaving a gap until the next read.
+func (p *provider) sample(t *traceWriter) []reading {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t.paused.Load() {
+		return nil
+	}
+	now := t.now()
+	readings := make([]reading, 0, len(p.columns))
+	for _, c := range p.columns {
+		if c.sensor == nil || c.due > now {
+			continue
+		}
+		interval := c.interval
+		if p.burst && t.bursting(now) {
+			interval = t.burstInterval
+		}
+		c.due += interval.Nanoseconds()
+		if c.due <= now {
+			// Skip reads that were missed rather than trying to catch up.
+			c.due = now + interval.Nanoseconds()
+		}
+		before := t.now()
+		v, err := c.sensor.Read()
+		if err != nil {
+			policy := sensors.PolicyFor(c.sensor, err, t.fallback)
+			if policy == sensors.Retry {
+				before = t.now()
+				v, err = c.sensor.Read()
+				if err != nil {
+					policy = sensors.PolicyFor(c.sensor, err, t.fallback)
+				}
+			}
+			if err != nil {
+				t.fail(c, err, policy)
+				c.lastRead = before
+				c.reprime = c.unit == sensors.Joules
+				continue
+			}
+		}
+		after := t.now()
+		at := before + (after-before)/2
+		c.failures = 0
+		if c.reprime {
+			c.reprime = false
+			c.lastRead = at
+			continue
+		}
+		readings = append(readings, reading{column: c.index, start: c.lastRead, end: at, value: v})
+		c.lastRead = at
+	}
+	return readings
+}
+
+// fail records a failed read of the column's sensor, dropping the sensor if the policy or
+// the number of consecutive failures demands it.
+func (t *traceWriter) fail(c *column, err error, policy sensors.ErrorPolicy) {
+	c.failures++
+	if policy == sensors.Drop || (t.maxFailures > 0 && c.failures >= t.maxFailures) {
+		log.Printf("no longer recording %q after %d failed reads: %v", c.heading, c.failures, err)
+		c.sensor = nil
+		return
+	}
+	if c.failures == 1 {
+		// Only log the first failure of a streak to avoid flooding the log.
+		log.Printf("failed reading %q: %v", c.heading, err)
+	}
+}
+
+// live returns the number of sensors still being recorded.
+func (t *traceWriter) live() int {
+	n := 0
+	for _, p := range t.providers {
+		p.mu.Lock()
+		for _, c := range p.columns {
+			if c.sensor != nil {
+				n++
+			}
+		}
+		p.mu.Unlock()
+	}
+	return n
+}
+
+// writeHeadings writes a heading row for the current columns.
+func (t *traceWriter) writeHeadings() error {
+	row := []byte("sample start (ns), sample end (ns), ")
+	for _, c := range t.columns {
+		row = append(row, c.heading...)
+		row = append(row, ", "...)
+	}
+	row = append(row, '\n')
+	t.headingsChanged = false
+	if _, err := t.out.Write(row); err != nil {
+		return err
+	}
+	return t.writeMetadata()
+}
+
+// writeMetadata writes rows describing the columns after a heading row. A row is written for
+// each column whose readings are averaged over a known window, so that consumers can account
+// for the lag that averaging introduces, holding "window", the window in nanoseconds, and
+// the column's heading. Another is written for each column whose readings are part of
+// another column's, so that consumers don't count them twice, holding "contained", the
+// column's heading, and the heading of the column containing it. Energy columns that aren't
+// part of another are contained by the first column measuring the whole system, if any.
+func (t *traceWriter) writeMetadata() error {
+	var rows bytes.Buffer
+	w := csv.NewWriter(&rows)
+	for _, c := range t.columns {
+		if c.window <= 0 {
+			continue
+		}
+		if err := w.Write([]string{"window", strconv.FormatInt(c.window.Nanoseconds(), 10), c.heading}); err != nil {
+			return err
+		}
+	}
+	for _, c := range t.columns {
+		container := t.containerOf(c)
+		if container == nil {
+			continue
+		}
+		if err := w.Write([]string{"contained", c.heading, container.heading}); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	_, err := t.out.Write(rows.Bytes())
+	return err
+}
+
+// containerOf returns the column whose readings include those of c, or nil if there is none.
+func (t *traceWriter) containerOf(c *column) *column {
+	if c.container != "" {
+		for _, container := range t.columns {
+			if container != c && strings.HasPrefix(container.heading, c.container+" (") {
+				return container
+			}
+		}
+	}
+	if c.wholeSystem || !c.unit.IsEnergy() {
+		return nil
+	}
+	for _, whole := range t.columns {
+		if whole.wholeSystem {
+			return whole
+		}
+	}
+	return nil
+}
+
+// writeMark writes a row marking the given time with a label. Mark rows hold "mark", the
+// time in nanoseconds since the epoch, and the label.
+func (t *traceWriter) writeMark(at int64, label string) error {
+	if t.headingsChanged {
+		if err := t.writeHeadings(); err != nil {
+			return err
+		}
+	}
+	var row bytes.Buffer
+	w := csv.NewWriter(&row)
+	if err := w.Write([]string{"mark", strconv.FormatInt(at, 10), label}); err != nil {
+		return err
+	}
+	w.Flush()
+	_, err := t.out.Write(row.Bytes())
+	return err
+}
+
+// maxBuffered bounds the number of readings buffered during a burst. Once it is reached,
+// buffered readings are written immediately, which is preferable to running out of memory.
+const maxBuffered = 1 << 20
+
+// record writes a reading, or buffers it if a burst is in progress. Buffered readings are
+// written as soon as a reading arrives after the burst.
+func (t *traceWriter) record(r reading) error {
+	if t.bursting(t.now()) && len(t.buffered) < maxBuffered {
+		t.buffered = append(t.buffered, r)
+		return nil
+	}
+	for _, buffered := range t.buffered {
+		if err := t.writeReading(buffered); err != nil {
+			return err
+		}
+	}
+	t.buffered = t.buffered[:0]
+	return t.writeReading(r)
+}
+
+// writeReading writes a row containing a single reading, preceded by headings if the
+// columns have changed since the last row.
+func (t *traceWriter) writeReading(r reading) error {
+	if t.headingsChanged {
+		if err := t.writeHeadings(); err != nil {
+			return err
+		}
+	}
+	row := strconv.AppendInt(nil, r.start, 10)
+	row = append(row, ", "...)
+	row = strconv.AppendInt(row, r.end, 10)
+	row = append(row, ", "...)
+	for i := range t.columns {
+		if i == r.column {
+			row = strconv.AppendFloat(row, r.value, 'f', 6, 64)
+		}
+		row = append(row, ", "...)
+	}
+	row = append(row, '\n')
+	_, err := t.out.Write(row)
+	return err
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+// scriptedSensor returns its errors and values in order, returning its last value once
+// both are exhausted. A nil error in errs means a successful read.
+type scriptedSensor struct {
+	name     string
+	errs     []error
+	values   []float64
+	interval time.Duration
+	window   time.Duration
+	within   string
+	whole    bool
+}
+
+func (s *scriptedSensor) Name() string                   { return s.name }
+func (s *scriptedSensor) Unit() sensors.Unit             { return sensors.Watts }
+func (s *scriptedSensor) UpdateInterval() time.Duration  { return s.interval }
+func (s *scriptedSensor) AveragingWindow() time.Duration { return s.window }
+func (s *scriptedSensor) ContainedIn() string            { return s.within }
+func (s *scriptedSensor) WholeSystem() bool              { return s.whole }
+
+func (s *scriptedSensor) Read() (float64, error) {
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		if err != nil {
+			return 0, err
+		}
+	}
+	v := s.values[0]
+	if len(s.values) > 1 {
+		s.values = s.values[1:]
+	}
+	return v, nil
+}
+
+// counterSensor is a scripted energy counter, reading the energy consumed since its previous
+// read.
+type counterSensor struct {
+	scriptedSensor
+}
+
+func (s *counterSensor) Unit() sensors.Unit { return sensors.Joules }
+
+// countingSensor counts its reads, which may happen on any goroutine.
+type countingSensor struct {
+	scriptedSensor
+	reads atomic.Int64
+}
+
+func (s *countingSensor) Read() (float64, error) {
+	s.reads.Add(1)
+	return 1, nil
+}
+
+func TestTraceWriterPolicies(t *testing.T) {
+	errUnknown := errors.New("unknown failure")
+	type testcase struct {
+		name        string
+		errs        []error
+		fallback    sensors.ErrorPolicy
+		maxFailures int
+		// counter reads the sensor as an energy counter.
+		counter bool
+		// expected holds the value of each sample, or "" if it has no reading. A reading
+		// after a sample without one must start after the failed read, leaving a gap.
+		expected []string
+	}
+	for _, tc := range []testcase{
+		{
+			name:     "transient error retried",
+			errs:     []error{nil, syscall.EAGAIN},
+			expected: []string{"2.000000", "3.000000", "4.000000"},
+		},
+		{
+			name:     "repeated transient error leaves gap",
+			errs:     []error{nil, syscall.EAGAIN, syscall.EAGAIN},
+			expected: []string{"", "2.000000", "3.000000"},
+		},
+		{
+			name:     "unknown error with gap fallback",
+			errs:     []error{nil, nil, errUnknown},
+			fallback: sensors.Gap,
+			expected: []string{"2.000000", "", "3.000000"},
+		},
+		{
+			name:     "energy counter primed after gap",
+			errs:     []error{nil, nil, errUnknown},
+			fallback: sensors.Gap,
+			counter:  true,
+			expected: []string{"2.000000", "", "", "4.000000"},
+		},
+		{
+			name:     "missing device dropped",
+			errs:     []error{nil, nil, syscall.ENODEV},
+			expected: []string{"2.000000", "", ""},
+		},
+		{
+			name:        "too many failures",
+			errs:        []error{nil, errUnknown, errUnknown, errUnknown},
+			fallback:    sensors.Gap,
+			maxFailures: 2,
+			expected:    []string{"", "", ""},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var s sensors.Sensor = &scriptedSensor{name: "gpu", errs: tc.errs, values: []float64{1, 2, 3, 4}}
+			if tc.counter {
+				s = &counterSensor{scriptedSensor{name: "gpu", errs: tc.errs, values: []float64{1, 2, 3, 4}}}
+			}
+			trace := newTraceWriter(&strings.Builder{}, tc.fallback, tc.maxFailures, 0)
+			// Every read of the clock advances it, so failed reads take time.
+			var clock int64
+			trace.now = func() int64 {
+				clock += 10
+				return clock
+			}
+			trace.add(group{provider: "nvml", sensors: []sensors.Sensor{s}})
+			lastEnd := trace.columns[0].lastRead
+			for i, expected := range tc.expected {
+				sample := ""
+				readings := trace.providers["nvml"].sample(trace)
+				if len(readings) > 0 {
+					sample = strconv.FormatFloat(readings[0].value, 'f', 6, 64)
+				}
+				if sample != expected {
+					t.Errorf("expected sample %d to be %q, got %q", i, expected, sample)
+				}
+				if len(readings) == 0 {
+					continue
+				}
+				r := readings[0]
+				if afterGap := i > 0 && tc.expected[i-1] == ""; afterGap && r.start <= lastEnd {
+					t.Errorf("expected sample %d to start after a gap following %d, got [%d, %d]", i, lastEnd, r.start, r.end)
+				} else if !afterGap && r.start != lastEnd {
+					t.Errorf("expected sample %d to start at %d, got [%d, %d]", i, lastEnd, r.start, r.end)
+				}
+				lastEnd = r.end
+			}
+		})
+	}
+}
+
+func TestTraceWriterRediscovery(t *testing.T) {
+	var out strings.Builder
+	trace := newTraceWriter(&out, sensors.Gap, 0, 0)
+	// Every read of the clock advances it, so each sensor gets its own timestamps.
+	var clock int64
+	trace.now = func() int64 {
+		clock += 10
+		return clock
+	}
+	cpu := &scriptedSensor{name: "cpu", values: []float64{1}}
+	gpu := &scriptedSensor{name: "gpu", errs: []error{nil, nil, syscall.ENODEV}, values: []float64{2}}
+	trace.add(group{provider: "host", sensors: []sensors.Sensor{cpu, gpu}})
+	if err := trace.writeHeadings(); err != nil {
+		t.Fatalf("failed writing headings: %v", err)
+	}
+	sample := func() {
+		for _, r := range trace.providers["host"].sample(trace) {
+			if err := trace.writeReading(r); err != nil {
+				t.Fatalf("failed writing reading: %v", err)
+			}
+		}
+	}
+	sample()
+	// The GPU disappears.
+	sample()
+	// Rediscovery finds the CPU again, a new fan, and finally the GPU again.
+	trace.add(group{provider: "host", sensors: []sensors.Sensor{
+		&scriptedSensor{name: "cpu", values: []float64{10}},
+		&scriptedSensor{name: "fan", values: []float64{3}},
+		&scriptedSensor{name: "gpu", values: []float64{4}},
+	}})
+	sample()
+	if live := trace.live(); live != 3 {
+		t.Errorf("expected 3 live sensors, got %d", live)
+	}
+	expected := `sample start (ns), sample end (ns), cpu (W), gpu (W), 
+10, 45, 1.000000, , 
+20, 65, , 2.000000, 
+45, 95, 1.000000, , 
+sample start (ns), sample end (ns), cpu (W), gpu (W), fan (W), 
+95, 155, 1.000000, , , 
+130, 175, , 4.000000, , 
+120, 195, , , 3.000000, 
+`
+	if out.String() != expected {
+		t.Errorf("expected trace:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestTraceWriterIntervals(t *testing.T) {
+	trace := newTraceWriter(&strings.Builder{}, sensors.Gap, 0, 10)
+	trace.providerIntervals = map[string]time.Duration{"meter0": 20}
+	var now int64
+	trace.now = func() int64 { return now }
+	trace.add(group{provider: "host", sensors: []sensors.Sensor{
+		&scriptedSensor{name: "fast", values: []float64{1}},
+		&scriptedSensor{name: "slow", values: []float64{2}, interval: 30},
+	}})
+	trace.add(group{provider: "meter0", sensors: []sensors.Sensor{
+		// The provider's interval overrides the sensor's own.
+		&scriptedSensor{name: "meter", values: []float64{3}, interval: 30},
+	}})
+	spans := map[int][][2]int64{}
+	for now = 5; now <= 60; now += 5 {
+		for _, p := range trace.providers {
+			for _, r := range p.sample(trace) {
+				spans[r.column] = append(spans[r.column], [2]int64{r.start, r.end})
+			}
+		}
+	}
+	expected := map[int][][2]int64{
+		0: {{0, 10}, {10, 20}, {20, 30}, {30, 40}, {40, 50}, {50, 60}},
+		1: {{0, 30}, {30, 60}},
+		2: {{0, 20}, {20, 40}, {40, 60}},
+	}
+	for column, expectedSpans := range expected {
+		if !slices.Equal(spans[column], expectedSpans) {
+			t.Errorf("expected column %d to be sampled over %v, got %v", column, expectedSpans, spans[column])
+		}
+	}
+}
+
+func TestTraceWriterWindows(t *testing.T) {
+	var out strings.Builder
+	trace := newTraceWriter(&out, sensors.Gap, 0, 10)
+	trace.now = func() int64 { return 0 }
+	trace.add(group{provider: "nvml", sensors: []sensors.Sensor{
+		&scriptedSensor{name: "instant", values: []float64{1}},
+		&scriptedSensor{name: "averaged", values: []float64{2}, window: time.Second},
+	}})
+	if err := trace.writeHeadings(); err != nil {
+		t.Fatalf("failed writing headings: %v", err)
+	}
+	expected := "sample start (ns), sample end (ns), instant (W), averaged (W), \nwindow,1000000000,averaged (W)\n"
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestTraceWriterContainment(t *testing.T) {
+	var out strings.Builder
+	trace := newTraceWriter(&out, sensors.Gap, 0, 10)
+	trace.now = func() int64 { return 0 }
+	trace.add(group{provider: "rapl", sensors: []sensors.Sensor{
+		&scriptedSensor{name: "package-0", values: []float64{1}},
+		&scriptedSensor{name: "core", values: []float64{2}, within: "package-0"},
+		&scriptedSensor{name: "orphan", values: []float64{3}, within: "missing"},
+	}})
+	if err := trace.writeHeadings(); err != nil {
+		t.Fatalf("failed writing headings: %v", err)
+	}
+	expected := "sample start (ns), sample end (ns), package-0 (W), core (W), orphan (W), \ncontained,core (W),package-0 (W)\n"
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+
+	// Once a wall meter is attached, it contains every sensor that isn't part of another.
+	out.Reset()
+	trace.add(group{provider: "meter0", sensors: []sensors.Sensor{
+		&scriptedSensor{name: "wall", values: []float64{4}, whole: true},
+	}})
+	if err := trace.writeHeadings(); err != nil {
+		t.Fatalf("failed writing headings: %v", err)
+	}
+	expected = "sample start (ns), sample end (ns), package-0 (W), core (W), orphan (W), wall (W), \ncontained,package-0 (W),wall (W)\ncontained,core (W),package-0 (W)\ncontained,orphan (W),wall (W)\n"
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestTraceWriterBurst(t *testing.T) {
+	var out strings.Builder
+	trace := newTraceWriter(&out, sensors.Gap, 0, 10)
+	trace.burstInterval = 1
+	trace.burstProviders = map[string]bool{"rapl": true}
+	var now int64
+	trace.now = func() int64 { return now }
+	trace.add(group{provider: "rapl", sensors: []sensors.Sensor{&scriptedSensor{name: "package", values: []float64{1}}}})
+	trace.add(group{provider: "hwmon", sensors: []sensors.Sensor{&scriptedSensor{name: "gpu", values: []float64{2}}}})
+	trace.startBurst(5)
+	reads := map[string][]int64{}
+	for now = 1; now <= 10; now++ {
+		for name, p := range trace.providers {
+			for _, r := range p.sample(trace) {
+				reads[name] = append(reads[name], now)
+				if err := trace.record(r); err != nil {
+					t.Fatalf("failed recording reading: %v", err)
+				}
+			}
+		}
+		if now < 5 && out.Len() > 0 {
+			t.Errorf("expected readings to be buffered during the burst, got output at %d:\n%s", now, out.String())
+		}
+	}
+	if expected := []int64{1, 2, 3, 4, 5}; !slices.Equal(reads["rapl"], expected) {
+		t.Errorf("expected burst provider to be read at %v, got %v", expected, reads["rapl"])
+	}
+	if expected := []int64{10}; !slices.Equal(reads["hwmon"], expected) {
+		t.Errorf("expected other provider to be read at %v, got %v", expected, reads["hwmon"])
+	}
+	if rows := strings.Count(out.String(), "\n"); rows != 7 {
+		t.Errorf("expected headings and 6 readings after the burst, got %d rows:\n%s", rows, out.String())
+	}
+}
+
+func TestTraceWriterPause(t *testing.T) {
+	trace := newTraceWriter(io.Discard, sensors.Gap, 0, time.Millisecond)
+	// Providers check the time whenever they poll their sensors, so counting checks shows
+	// whether a paused provider is still polling even though it reads nothing.
+	now := trace.now
+	var polls atomic.Int64
+	trace.now = func() int64 {
+		polls.Add(1)
+		return now()
+	}
+	sensor := &countingSensor{scriptedSensor: scriptedSensor{name: "package"}}
+	trace.add(group{provider: "rapl", sensors: []sensors.Sensor{sensor}})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-trace.readings:
+			case <-done:
+				return
+			}
+		}
+	}()
+	waitForReads := func(n int64) bool {
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			if sensor.reads.Load() >= n {
+				return true
+			}
+		}
+		return false
+	}
+	trace.start()
+	if !waitForReads(3) {
+		t.Fatalf("expected the sensor to be read, got %d reads", sensor.reads.Load())
+	}
+
+	trace.pause()
+	// Let a sample in progress finish.
+	time.Sleep(20 * time.Millisecond)
+	reads, polled := sensor.reads.Load(), polls.Load()
+	time.Sleep(50 * time.Millisecond)
+	if n := sensor.reads.Load() - reads; n != 0 {
+		t.Errorf("expected no reads while paused, got %d", n)
+	}
+	if n := polls.Load() - polled; n != 0 {
+		t.Errorf("expected paused provider to stop polling, but it polled %d times", n)
+	}
+
+	trace.resume()
+	// Resuming reads the sensor once itself.
+	if !waitForReads(sensor.reads.Load() + 3) {
+		t.Errorf("expected the sensor to be read again after resuming")
+	}
+}
@@ -0,0 +1,149 @@
+// Package control implements the protocol used to control a running watt-wiser-sensors
+// process. Requests and responses are JSON objects, one per line, and every request
+// receives exactly one response.
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// The commands understood by watt-wiser-sensors.
+const (
+	// CommandPause stops reading sensors until CommandResume.
+	CommandPause = "pause"
+	// CommandResume resumes reading sensors after CommandPause.
+	CommandResume = "resume"
+	// CommandSetInterval sets the interval between reads to Request.Interval, either for
+	// every sensor of Request.Provider or, without a provider, for every sensor that
+	// doesn't know its own update interval.
+	CommandSetInterval = "set-interval"
+	// CommandMark records Request.Label in the trace at Request.Timestamp.
+	CommandMark = "mark"
+	// CommandRotateOutput starts writing the trace to Request.Path. Without a path, the
+	// current output file is renamed with a timestamp and a new one is started in its place.
+	CommandRotateOutput = "rotate-output"
+	// CommandListSensors lists the sensors being recorded in Response.Sensors.
+	CommandListSensors = "list-sensors"
+	// CommandStartBurst starts a burst of high-resolution sampling lasting at most
+	// Request.Interval.
+	CommandStartBurst = "start-burst"
+	// CommandStopBurst ends a burst early.
+	CommandStopBurst = "stop-burst"
+	// CommandShutdown stops recording and exits.
+	CommandShutdown = "shutdown"
+)
+
+// Request is a command sent to watt-wiser-sensors.
+type Request struct {
+	Command string `json:"command"`
+	// Provider is the provider whose interval CommandSetInterval changes, like "rapl" or
+	// "nvml".
+	Provider string `json:"provider,omitempty"`
+	// Interval is a duration like "10ms" used by CommandSetInterval and CommandStartBurst.
+	Interval string `json:"interval,omitempty"`
+	// Label is the text of a mark.
+	Label string `json:"label,omitempty"`
+	// Timestamp is when a mark happened in nanoseconds since the epoch. If zero, the mark
+	// is placed at the time it is received.
+	Timestamp int64 `json:"timestamp,omitempty"`
+	// Path is the output file for CommandRotateOutput.
+	Path string `json:"path,omitempty"`
+}
+
+// Response is the reply to a Request.
+type Response struct {
+	// Error describes why the request failed, and is empty if it succeeded.
+	Error   string   `json:"error,omitempty"`
+	Sensors []Sensor `json:"sensors,omitempty"`
+}
+
+// Sensor describes a sensor being recorded.
+type Sensor struct {
+	// Heading is the sensor's column heading in the trace.
+	Heading  string `json:"heading"`
+	Provider string `json:"provider"`
+	// Interval is the duration between reads of the sensor, like "100ms".
+	Interval string `json:"interval"`
+	// Live is false if the sensor has stopped being read because it failed.
+	Live bool `json:"live"`
+}
+
+// Serve reads requests from r and writes the response to each to w until r is exhausted.
+func Serve(r io.Reader, w io.Writer, handle func(Request) Response) error {
+	scanner := bufio.NewScanner(r)
+	encoder := json.NewEncoder(w)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var req Request
+		var resp Response
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp.Error = fmt.Sprintf("invalid request: %v", err)
+		} else {
+			resp = handle(req)
+		}
+		if err := encoder.Encode(resp); err != nil {
+			return fmt.Errorf("failed writing response: %w", err)
+		}
+	}
+	return scanner.Err()
+}
+
+// Client sends requests to watt-wiser-sensors. It is safe for concurrent use.
+type Client struct {
+	mu      sync.Mutex
+	conn    io.ReadWriteCloser
+	encoder *json.Encoder
+	decoder *json.Decoder
+}
+
+// NewClient returns a client that communicates over conn.
+func NewClient(conn io.ReadWriteCloser) *Client {
+	return &Client{
+		conn:    conn,
+		encoder: json.NewEncoder(conn),
+		decoder: json.NewDecoder(conn),
+	}
+}
+
+// Dial connects to the control socket at the given path.
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed connecting to %s: %w", path, err)
+	}
+	return NewClient(conn), nil
+}
+
+// ErrRejected wraps the errors of requests that watt-wiser-sensors received but could not
+// carry out.
+var ErrRejected = errors.New("request rejected")
+
+// Send sends a request and waits for its response.
+func (c *Client) Send(req Request) (Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.encoder.Encode(req); err != nil {
+		return Response{}, fmt.Errorf("failed sending %s: %w", req.Command, err)
+	}
+	var resp Response
+	if err := c.decoder.Decode(&resp); err != nil {
+		return Response{}, fmt.Errorf("failed receiving response to %s: %w", req.Command, err)
+	}
+	if resp.Error != "" {
+		return resp, fmt.Errorf("%w: %s: %s", ErrRejected, req.Command, resp.Error)
+	}
+	return resp, nil
+}
+
+// Close closes the client's connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,41 @@
+package control
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestClientServe(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+	go Serve(serverConn, serverConn, func(req Request) Response {
+		switch req.Command {
+		case CommandListSensors:
+			return Response{Sensors: []Sensor{{Heading: "package-0 (J)", Provider: "rapl", Interval: "100ms", Live: true}}}
+		case CommandMark:
+			if req.Label == "" {
+				return Response{Error: "marks need a label"}
+			}
+			return Response{}
+		default:
+			return Response{Error: "unknown command"}
+		}
+	})
+	client := NewClient(clientConn)
+	defer client.Close()
+
+	resp, err := client.Send(Request{Command: CommandListSensors})
+	if err != nil {
+		t.Fatalf("failed listing sensors: %v", err)
+	}
+	if len(resp.Sensors) != 1 || resp.Sensors[0].Heading != "package-0 (J)" {
+		t.Errorf("expected one RAPL sensor, got %+v", resp.Sensors)
+	}
+	if _, err := client.Send(Request{Command: CommandMark, Label: "start"}); err != nil {
+		t.Errorf("expected mark to succeed, got %v", err)
+	}
+	if _, err := client.Send(Request{Command: CommandMark}); !errors.Is(err, ErrRejected) {
+		t.Errorf("expected mark without a label to be rejected, got %v", err)
+	}
+}
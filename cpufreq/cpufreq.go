@@ -0,0 +1,77 @@
+//go:build linux
+
+package cpufreq
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+// SysfsRoot is the directory in which the kernel exposes per-CPU cpufreq policies.
+const SysfsRoot = "/sys/devices/system/cpu"
+
+// frequencySensor reports the current frequency of a single logical CPU.
+type frequencySensor struct {
+	cpu  int
+	path string
+}
+
+func (f frequencySensor) Name() string {
+	return fmt.Sprintf("cpu%d frequency", f.cpu)
+}
+
+func (f frequencySensor) Unit() sensors.Unit {
+	return sensors.Hertz
+}
+
+func (f frequencySensor) Read() (float64, error) {
+	raw, err := os.ReadFile(f.path)
+	if err != nil {
+		return 0, fmt.Errorf("failed reading %s: %w", f.path, err)
+	}
+	value, err := strconv.ParseInt(strings.TrimSpace(string(raw)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed parsing %s (%s): %w", f.path, strings.TrimSpace(string(raw)), err)
+	}
+	// cpufreq reports frequencies in kHz.
+	return float64(value) * sensors.KiloToUnprefixed, nil
+}
+
+// FindSensors discovers a frequency sensor for every CPU with a cpufreq policy.
+func FindSensors() ([]sensors.Sensor, error) {
+	return FindSensorsIn(SysfsRoot)
+}
+
+// FindSensorsIn discovers a frequency sensor for every cpu* directory of the given root
+// (usually SysfsRoot) that exposes cpufreq/scaling_cur_freq. Sensors are ordered by CPU
+// number.
+func FindSensorsIn(root string) ([]sensors.Sensor, error) {
+	paths, err := filepath.Glob(filepath.Join(root, "cpu*", "cpufreq", "scaling_cur_freq"))
+	if err != nil {
+		return nil, fmt.Errorf("failed listing cpufreq policies: %w", err)
+	}
+	found := []frequencySensor{}
+	for _, path := range paths {
+		cpuDir := filepath.Base(filepath.Dir(filepath.Dir(path)))
+		cpu, err := strconv.Atoi(strings.TrimPrefix(cpuDir, "cpu"))
+		if err != nil {
+			// Skip entries like cpuidle and cpufreq that aren't CPUs.
+			continue
+		}
+		found = append(found, frequencySensor{cpu: cpu, path: path})
+	}
+	slices.SortFunc(found, func(a, b frequencySensor) int {
+		return a.cpu - b.cpu
+	})
+	out := make([]sensors.Sensor, 0, len(found))
+	for _, f := range found {
+		out = append(out, f)
+	}
+	return out, nil
+}
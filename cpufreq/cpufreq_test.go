@@ -0,0 +1,68 @@
+//go:build linux
+
+package cpufreq
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+func TestFindSensorsIn(t *testing.T) {
+	root := t.TempDir()
+	for path, contents := range map[string]string{
+		"cpu0/cpufreq/scaling_cur_freq":  "3600000",
+		"cpu1/cpufreq/scaling_cur_freq":  "800000",
+		"cpu10/cpufreq/scaling_cur_freq": "4200000",
+		"cpu2/cpufreq/scaling_cur_freq":  "2400000",
+		// CPUs without cpufreq support and non-CPU directories are skipped.
+		"cpu3/online":                      "1",
+		"cpufreq/policy0/scaling_cur_freq": "3600000",
+		"cpuidle/current_driver":           "intel_idle",
+	} {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed creating %s: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(contents+"\n"), 0o444); err != nil {
+			t.Fatalf("failed writing %s: %v", path, err)
+		}
+	}
+	found, err := FindSensorsIn(root)
+	if err != nil {
+		t.Fatalf("expected discovery to succeed, got %v", err)
+	}
+	type expectation struct {
+		name  string
+		value float64
+	}
+	expected := []expectation{
+		{name: "cpu0 frequency", value: 3.6e9},
+		{name: "cpu1 frequency", value: 8e8},
+		{name: "cpu2 frequency", value: 2.4e9},
+		{name: "cpu10 frequency", value: 4.2e9},
+	}
+	if len(found) != len(expected) {
+		for _, s := range found {
+			t.Logf("found %q", s.Name())
+		}
+		t.Fatalf("expected %d sensors, got %d", len(expected), len(found))
+	}
+	for i, s := range found {
+		if s.Name() != expected[i].name {
+			t.Errorf("expected sensor %d to be named %q, got %q", i, expected[i].name, s.Name())
+		}
+		if s.Unit() != sensors.Hertz {
+			t.Errorf("expected sensor %d to be in %s, got %s", i, sensors.Hertz, s.Unit())
+		}
+		value, err := s.Read()
+		if err != nil {
+			t.Fatalf("failed reading %s: %v", s.Name(), err)
+		}
+		if value != expected[i].value {
+			t.Errorf("expected sensor %d to read %v, got %v", i, expected[i].value, value)
+		}
+	}
+}
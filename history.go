@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/backend"
+)
+
+// runHistory prints the benchmarks recorded in the library, or the energy they used from a
+// series, as CSV.
+func runHistory(args []string) error {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	dataDir := flags.String("data-dir", "", "directory of recorded sessions and benchmarks (defaults to $XDG_DATA_HOME/watt-wiser on Linux)")
+	var query backend.BenchmarkQuery
+	flags.StringVar(&query.Command, "command", "", "only include benchmarks of this command, or of commands matching this pattern, like \"*/nightly-*/game\"")
+	flags.Func("tags", "only include benchmarks with all of these comma-separated tags", func(s string) error {
+		query.Tags = backend.ParseTags(s)
+		return nil
+	})
+	flags.StringVar(&query.Host, "host", "", "only include benchmarks run on this host")
+	flags.Func("since", "only include benchmarks started on or after this date (2006-01-02)", func(s string) error {
+		var err error
+		query.Since, err = time.ParseInLocation(time.DateOnly, s, time.Local)
+		return err
+	})
+	flags.Func("until", "only include benchmarks started before this date (2006-01-02)", func(s string) error {
+		var err error
+		query.Until, err = time.ParseInLocation(time.DateOnly, s, time.Local)
+		return err
+	})
+	flags.IntVar(&query.Limit, "limit", 0, "only include this many of the most recent benchmarks (0 includes every benchmark)")
+	series := flags.String("series", "", "print the energy each benchmark used from this series, like \"package-0\"")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), `%[1]s history: list recorded benchmarks as CSV
+Usage:
+
+ %[1]s history [flags]
+
+Flags:
+`, os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	dir := *dataDir
+	if dir == "" {
+		var err error
+		dir, err = backend.DataDir()
+		if err != nil {
+			return err
+		}
+	}
+	library, err := backend.OpenLibrary(dir)
+	if err != nil {
+		return err
+	}
+	index, err := library.Index()
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(os.Stdout)
+	if *series != "" {
+		w.Write([]string{"start", "benchmark", "commit", *series + " (J)"})
+		for _, point := range index.SummaryJoules(query, *series) {
+			w.Write([]string{
+				point.Start.Format(time.RFC3339),
+				point.BenchmarkID,
+				point.Commit,
+				strconv.FormatFloat(point.Joules, 'f', -1, 64),
+			})
+		}
+	} else {
+		w.Write([]string{"start", "benchmark", "host", "command", "commit", "tags", "run duration", "notes"})
+		for _, b := range index.Query(query) {
+			w.Write([]string{
+				b.Start.Format(time.RFC3339),
+				b.ID,
+				b.Host,
+				b.Command,
+				b.Commit,
+				strings.Join(b.Tags, ","),
+				b.RunDuration.String(),
+				b.Notes,
+			})
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -2,17 +2,12 @@
 
 package hwmon
 
-/*
-#cgo LDFLAGS: -lsensors
-#include <stdlib.h>
-#include <sensors/sensors.h>
-*/
-import "C"
 import (
 	"fmt"
 	"log"
+	"math"
 	"strings"
-	"unsafe"
+	"time"
 
 	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
 )
@@ -49,6 +44,45 @@ type Subfeature struct {
 	Mapping int
 	Flags   Flags
 	Parent  Feature
+	// AverageInterval is the window that a power average subfeature averages over, or
+	// zero if it is unknown.
+	AverageInterval time.Duration
+}
+
+// UpdateInterval returns the interval at which the subfeature's value changes, if known.
+func (s Subfeature) UpdateInterval() time.Duration {
+	return s.AverageInterval
+}
+
+// AveragingWindow returns the window that a power average subfeature is averaged over, if
+// known.
+func (s Subfeature) AveragingWindow() time.Duration {
+	if s.Type != SENSORS_SUBFEATURE_POWER_AVERAGE {
+		return 0
+	}
+	return s.AverageInterval
+}
+
+// attachAverageIntervals sets the AverageInterval of each power average subfeature from
+// the power average interval subfeature of the same feature, if there is one.
+func attachAverageIntervals(subfeatures []Subfeature) {
+	intervals := map[string]time.Duration{}
+	for _, s := range subfeatures {
+		if s.Type != SENSORS_SUBFEATURE_POWER_AVERAGE_INTERVAL {
+			continue
+		}
+		seconds, err := s.Read()
+		if err != nil {
+			log.Printf("failed reading %s: %v", s.Name(), err)
+			continue
+		}
+		intervals[s.Parent.Name] = time.Duration(seconds * float64(time.Second))
+	}
+	for i := range subfeatures {
+		if subfeatures[i].Type == SENSORS_SUBFEATURE_POWER_AVERAGE {
+			subfeatures[i].AverageInterval = intervals[subfeatures[i].Parent.Name]
+		}
+	}
 }
 
 func (s Subfeature) Name() string {
@@ -57,37 +91,42 @@ func (s Subfeature) Name() string {
 
 func (s Subfeature) Unit() sensors.Unit {
 	switch s.Parent.Type {
-	case C.SENSORS_FEATURE_IN:
+	case SENSORS_FEATURE_IN:
 		return sensors.Volts
-	case C.SENSORS_FEATURE_POWER:
+	case SENSORS_FEATURE_POWER:
 		return sensors.Watts
-	case C.SENSORS_FEATURE_ENERGY:
+	case SENSORS_FEATURE_ENERGY:
 		return sensors.Joules
-	case C.SENSORS_FEATURE_CURR:
+	case SENSORS_FEATURE_CURR:
 		return sensors.Amps
+	case SENSORS_FEATURE_TEMP:
+		return sensors.Celsius
+	case SENSORS_FEATURE_FAN:
+		return sensors.RPM
 	default:
 		return sensors.Unknown
 	}
 }
 
-func (s Subfeature) Read() (float64, error) {
-	var value C.double
-	rc := C.sensors_get_value(s.Parent.Parent.CChip, C.int(s.Number), &value)
-	if rc < 0 {
-		return 0, fmt.Errorf("failed reading subfeature value: %d", rc)
+// EnergyCounter adapts a cumulative energy subfeature into a sensor reporting the energy
+// consumed since the previous read.
+type EnergyCounter struct {
+	Subfeature
+	last float64
+}
+
+func (e *EnergyCounter) Read() (float64, error) {
+	value, err := e.Subfeature.Read()
+	if err != nil {
+		return 0, err
 	}
-	ret := float64(value)
-	switch s.Parent.Type {
-	case C.SENSORS_FEATURE_IN:
-		ret *= sensors.MicroToUnprefixed
-	case C.SENSORS_FEATURE_POWER:
-		ret *= sensors.MicroToUnprefixed
-	case C.SENSORS_FEATURE_ENERGY:
-		ret *= sensors.MicroToUnprefixed
-	case C.SENSORS_FEATURE_CURR:
-		ret *= sensors.MicroToUnprefixed
+	increment := value - e.last
+	if value < e.last {
+		// The counter was reset, so everything it holds was consumed since the last read.
+		increment = value
 	}
-	return float64(value), nil
+	e.last = value
+	return increment, nil
 }
 
 type Feature struct {
@@ -98,347 +137,376 @@ type Feature struct {
 	Parent Chip
 }
 
-type Chip struct {
-	Name  string
-	CChip *C.sensors_chip_name
-}
+// The feature, subfeature, and flag values below match those declared in libsensors'
+// sensors.h, which allows the same model to be populated either by libsensors or by
+// reading sysfs directly.
+const (
+	SENSORS_FEATURE_IN          FeatureType = 0x00
+	SENSORS_FEATURE_FAN         FeatureType = 0x01
+	SENSORS_FEATURE_TEMP        FeatureType = 0x02
+	SENSORS_FEATURE_POWER       FeatureType = 0x03
+	SENSORS_FEATURE_ENERGY      FeatureType = 0x04
+	SENSORS_FEATURE_CURR        FeatureType = 0x05
+	SENSORS_FEATURE_HUMIDITY    FeatureType = 0x06
+	SENSORS_FEATURE_MAX_MAIN    FeatureType = 0x07
+	SENSORS_FEATURE_VID         FeatureType = 0x10
+	SENSORS_FEATURE_INTRUSION   FeatureType = 0x11
+	SENSORS_FEATURE_MAX_OTHER   FeatureType = 0x12
+	SENSORS_FEATURE_BEEP_ENABLE FeatureType = 0x18
+	SENSORS_FEATURE_MAX         FeatureType = 0x19
+	SENSORS_FEATURE_UNKNOWN     FeatureType = math.MaxInt32
+)
 
-func FindEnergySensors() ([]sensors.Sensor, error) {
-	rc := C.sensors_init(nil)
-	if rc != 0 {
-		log.Fatalf("failed initializing sensors: %d", rc)
-	}
+const (
+	SENSORS_SUBFEATURE_IN_INPUT SubfeatureType = iota + SubfeatureType(SENSORS_FEATURE_IN)<<8
+	SENSORS_SUBFEATURE_IN_MIN
+	SENSORS_SUBFEATURE_IN_MAX
+	SENSORS_SUBFEATURE_IN_LCRIT
+	SENSORS_SUBFEATURE_IN_CRIT
+	SENSORS_SUBFEATURE_IN_AVERAGE
+	SENSORS_SUBFEATURE_IN_LOWEST
+	SENSORS_SUBFEATURE_IN_HIGHEST
+)
 
-	relevantSubfeatures := []sensors.Sensor{}
+const (
+	SENSORS_SUBFEATURE_IN_ALARM SubfeatureType = iota + (SubfeatureType(SENSORS_FEATURE_IN)<<8 | 0x80)
+	SENSORS_SUBFEATURE_IN_MIN_ALARM
+	SENSORS_SUBFEATURE_IN_MAX_ALARM
+	SENSORS_SUBFEATURE_IN_BEEP
+	SENSORS_SUBFEATURE_IN_LCRIT_ALARM
+	SENSORS_SUBFEATURE_IN_CRIT_ALARM
+)
 
-	var chipIterState C.int
-	for {
-		chip := C.sensors_get_detected_chips(nil, &chipIterState)
-		if chip == nil {
-			break
-		}
-		var currentChip Chip
-		var buf [256]C.char
-		rc := C.sensors_snprintf_chip_name(&buf[0], C.ulong(len(buf)), chip)
-		if rc >= 0 {
-			currentChip.Name = C.GoString(&buf[0])
-			currentChip.CChip = chip
-			//			fmt.Printf("chip: %#+v\n", currentChip)
-		} else {
-			continue
-		}
+const (
+	SENSORS_SUBFEATURE_FAN_INPUT SubfeatureType = iota + SubfeatureType(SENSORS_FEATURE_FAN)<<8
+	SENSORS_SUBFEATURE_FAN_MIN
+	SENSORS_SUBFEATURE_FAN_MAX
+)
 
-		hasCurrent := false
-		var currentSensor Subfeature
-		hasVoltage := false
-		var voltageSensor Subfeature
-		var featureIterState C.int
-		for {
-			feature := C.sensors_get_features(chip, &featureIterState)
-			if feature == nil {
-				break
-			}
-			switch feature._type {
-			case C.SENSORS_FEATURE_IN:
-			case C.SENSORS_FEATURE_POWER:
-			case C.SENSORS_FEATURE_ENERGY:
-			case C.SENSORS_FEATURE_CURR:
-			default:
-				continue
-			}
-			currentFeature := Feature{
-				Parent: currentChip,
-				Name:   C.GoString(feature.name),
-				Number: int(feature.number),
-				Type:   FeatureType(feature._type),
-			}
-			cLabel := C.sensors_get_label(chip, feature)
-			if cLabel != nil {
-				currentFeature.Label = C.GoString(cLabel)
-				C.free(unsafe.Pointer(cLabel))
-			}
-			var subfeatureIterState C.int
-			switch feature._type {
-			case C.SENSORS_FEATURE_POWER, C.SENSORS_FEATURE_ENERGY:
-				for {
-					subfeature := C.sensors_get_all_subfeatures(chip, feature, &subfeatureIterState)
-					if subfeature == nil {
-						break
-					}
-					switch subfeature._type {
-					case C.SENSORS_SUBFEATURE_POWER_INPUT:
-					case C.SENSORS_SUBFEATURE_POWER_AVERAGE:
-					case C.SENSORS_SUBFEATURE_POWER_AVERAGE_INTERVAL:
-					case C.SENSORS_SUBFEATURE_ENERGY_INPUT:
-					default:
-						continue
-					}
-					currentSubfeature := Subfeature{
-						Parent:  currentFeature,
-						SubName: C.GoString(subfeature.name),
-						Number:  int(subfeature.number),
-						Type:    SubfeatureType(subfeature._type),
-						Mapping: int(subfeature.mapping),
-						Flags:   Flags(subfeature.flags),
-					}
-					relevantSubfeatures = append(relevantSubfeatures, currentSubfeature)
-				}
-			case C.SENSORS_FEATURE_IN, C.SENSORS_FEATURE_CURR:
-				for {
-					subfeature := C.sensors_get_all_subfeatures(chip, feature, &subfeatureIterState)
-					if subfeature == nil {
-						break
-					}
-					currentSubfeature := Subfeature{
-						Parent:  currentFeature,
-						SubName: C.GoString(subfeature.name),
-						Number:  int(subfeature.number),
-						Type:    SubfeatureType(subfeature._type),
-						Mapping: int(subfeature.mapping),
-						Flags:   Flags(subfeature.flags),
-					}
-					switch subfeature._type {
-					case C.SENSORS_SUBFEATURE_CURR_INPUT:
-						hasCurrent = true
-						currentSensor = currentSubfeature
-					case C.SENSORS_SUBFEATURE_IN_INPUT:
-						hasVoltage = true
-						voltageSensor = currentSubfeature
-					default:
-						continue
-					}
-				}
-			}
-		}
-		if hasCurrent && hasVoltage {
-			relevantSubfeatures = append(relevantSubfeatures, SyntheticPower{
-				Current: currentSensor,
-				Voltage: voltageSensor,
-			})
-		}
-	}
-	return relevantSubfeatures, nil
-}
+const (
+	SENSORS_SUBFEATURE_FAN_ALARM SubfeatureType = iota + (SubfeatureType(SENSORS_FEATURE_FAN)<<8 | 0x80)
+	SENSORS_SUBFEATURE_FAN_FAULT
+	SENSORS_SUBFEATURE_FAN_DIV
+	SENSORS_SUBFEATURE_FAN_BEEP
+	SENSORS_SUBFEATURE_FAN_PULSES
+	SENSORS_SUBFEATURE_FAN_MIN_ALARM
+	SENSORS_SUBFEATURE_FAN_MAX_ALARM
+)
+
+const (
+	SENSORS_SUBFEATURE_TEMP_INPUT SubfeatureType = iota + SubfeatureType(SENSORS_FEATURE_TEMP)<<8
+	SENSORS_SUBFEATURE_TEMP_MAX
+	SENSORS_SUBFEATURE_TEMP_MAX_HYST
+	SENSORS_SUBFEATURE_TEMP_MIN
+	SENSORS_SUBFEATURE_TEMP_CRIT
+	SENSORS_SUBFEATURE_TEMP_CRIT_HYST
+	SENSORS_SUBFEATURE_TEMP_LCRIT
+	SENSORS_SUBFEATURE_TEMP_EMERGENCY
+	SENSORS_SUBFEATURE_TEMP_EMERGENCY_HYST
+	SENSORS_SUBFEATURE_TEMP_LOWEST
+	SENSORS_SUBFEATURE_TEMP_HIGHEST
+	SENSORS_SUBFEATURE_TEMP_MIN_HYST
+	SENSORS_SUBFEATURE_TEMP_LCRIT_HYST
+)
+
+const (
+	SENSORS_SUBFEATURE_TEMP_ALARM SubfeatureType = iota + (SubfeatureType(SENSORS_FEATURE_TEMP)<<8 | 0x80)
+	SENSORS_SUBFEATURE_TEMP_MAX_ALARM
+	SENSORS_SUBFEATURE_TEMP_MIN_ALARM
+	SENSORS_SUBFEATURE_TEMP_CRIT_ALARM
+	SENSORS_SUBFEATURE_TEMP_FAULT
+	SENSORS_SUBFEATURE_TEMP_TYPE
+	SENSORS_SUBFEATURE_TEMP_OFFSET
+	SENSORS_SUBFEATURE_TEMP_BEEP
+	SENSORS_SUBFEATURE_TEMP_EMERGENCY_ALARM
+	SENSORS_SUBFEATURE_TEMP_LCRIT_ALARM
+)
+
+const (
+	SENSORS_SUBFEATURE_POWER_AVERAGE SubfeatureType = iota + SubfeatureType(SENSORS_FEATURE_POWER)<<8
+	SENSORS_SUBFEATURE_POWER_AVERAGE_HIGHEST
+	SENSORS_SUBFEATURE_POWER_AVERAGE_LOWEST
+	SENSORS_SUBFEATURE_POWER_INPUT
+	SENSORS_SUBFEATURE_POWER_INPUT_HIGHEST
+	SENSORS_SUBFEATURE_POWER_INPUT_LOWEST
+	SENSORS_SUBFEATURE_POWER_CAP
+	SENSORS_SUBFEATURE_POWER_CAP_HYST
+	SENSORS_SUBFEATURE_POWER_MAX
+	SENSORS_SUBFEATURE_POWER_CRIT
+	SENSORS_SUBFEATURE_POWER_MIN
+	SENSORS_SUBFEATURE_POWER_LCRIT
+)
+
+const (
+	SENSORS_SUBFEATURE_POWER_AVERAGE_INTERVAL SubfeatureType = iota + (SubfeatureType(SENSORS_FEATURE_POWER)<<8 | 0x80)
+	SENSORS_SUBFEATURE_POWER_ALARM
+	SENSORS_SUBFEATURE_POWER_CAP_ALARM
+	SENSORS_SUBFEATURE_POWER_MAX_ALARM
+	SENSORS_SUBFEATURE_POWER_CRIT_ALARM
+	SENSORS_SUBFEATURE_POWER_MIN_ALARM
+	SENSORS_SUBFEATURE_POWER_LCRIT_ALARM
+)
+
+const (
+	SENSORS_SUBFEATURE_ENERGY_INPUT SubfeatureType = SubfeatureType(SENSORS_FEATURE_ENERGY) << 8
+)
+
+const (
+	SENSORS_SUBFEATURE_CURR_INPUT SubfeatureType = iota + SubfeatureType(SENSORS_FEATURE_CURR)<<8
+	SENSORS_SUBFEATURE_CURR_MIN
+	SENSORS_SUBFEATURE_CURR_MAX
+	SENSORS_SUBFEATURE_CURR_LCRIT
+	SENSORS_SUBFEATURE_CURR_CRIT
+	SENSORS_SUBFEATURE_CURR_AVERAGE
+	SENSORS_SUBFEATURE_CURR_LOWEST
+	SENSORS_SUBFEATURE_CURR_HIGHEST
+)
+
+const (
+	SENSORS_SUBFEATURE_CURR_ALARM SubfeatureType = iota + (SubfeatureType(SENSORS_FEATURE_CURR)<<8 | 0x80)
+	SENSORS_SUBFEATURE_CURR_MIN_ALARM
+	SENSORS_SUBFEATURE_CURR_MAX_ALARM
+	SENSORS_SUBFEATURE_CURR_BEEP
+	SENSORS_SUBFEATURE_CURR_LCRIT_ALARM
+	SENSORS_SUBFEATURE_CURR_CRIT_ALARM
+)
+
+const (
+	SENSORS_SUBFEATURE_HUMIDITY_INPUT  SubfeatureType = SubfeatureType(SENSORS_FEATURE_HUMIDITY) << 8
+	SENSORS_SUBFEATURE_VID             SubfeatureType = SubfeatureType(SENSORS_FEATURE_VID) << 8
+	SENSORS_SUBFEATURE_INTRUSION_ALARM SubfeatureType = SubfeatureType(SENSORS_FEATURE_INTRUSION) << 8
+	SENSORS_SUBFEATURE_INTRUSION_BEEP  SubfeatureType = SENSORS_SUBFEATURE_INTRUSION_ALARM + 1
+	SENSORS_SUBFEATURE_BEEP_ENABLE     SubfeatureType = SubfeatureType(SENSORS_FEATURE_BEEP_ENABLE) << 8
+	SENSORS_SUBFEATURE_UNKNOWN         SubfeatureType = math.MaxInt32
+)
+
+const (
+	SENSORS_MODE_R          Flags = 1
+	SENSORS_MODE_W          Flags = 2
+	SENSORS_COMPUTE_MAPPING Flags = 4
+)
 
-type FeatureType C.sensors_feature_type
+// FeatureType mirrors libsensors' sensors_feature_type.
+type FeatureType int32
 
 func (f FeatureType) String() string {
 	switch f {
-	case C.SENSORS_FEATURE_IN:
+	case SENSORS_FEATURE_IN:
 		return "voltage"
-	case C.SENSORS_FEATURE_FAN:
+	case SENSORS_FEATURE_FAN:
 		return "fan speed"
-	case C.SENSORS_FEATURE_TEMP:
+	case SENSORS_FEATURE_TEMP:
 		return "temperature"
-	case C.SENSORS_FEATURE_POWER:
+	case SENSORS_FEATURE_POWER:
 		return "power"
-	case C.SENSORS_FEATURE_ENERGY:
+	case SENSORS_FEATURE_ENERGY:
 		return "energy"
-	case C.SENSORS_FEATURE_CURR:
+	case SENSORS_FEATURE_CURR:
 		return "current"
-	case C.SENSORS_FEATURE_HUMIDITY:
+	case SENSORS_FEATURE_HUMIDITY:
 		return "humidity"
-	case C.SENSORS_FEATURE_MAX_MAIN:
+	case SENSORS_FEATURE_MAX_MAIN:
 		return "max main"
-	case C.SENSORS_FEATURE_VID:
+	case SENSORS_FEATURE_VID:
 		return "cpu core reference voltage"
-	case C.SENSORS_FEATURE_INTRUSION:
+	case SENSORS_FEATURE_INTRUSION:
 		return "intrusion"
-	case C.SENSORS_FEATURE_MAX_OTHER:
+	case SENSORS_FEATURE_MAX_OTHER:
 		return "max other"
-	case C.SENSORS_FEATURE_BEEP_ENABLE:
+	case SENSORS_FEATURE_BEEP_ENABLE:
 		return "beep enable"
-	case C.SENSORS_FEATURE_MAX:
+	case SENSORS_FEATURE_MAX:
 		return "feature maximum"
-	case C.SENSORS_FEATURE_UNKNOWN:
+	case SENSORS_FEATURE_UNKNOWN:
 		return "feature unknown"
 	default:
 		return "unknown feature type"
 	}
 }
 
-type SubfeatureType C.sensors_subfeature_type
+// SubfeatureType mirrors libsensors' sensors_subfeature_type.
+type SubfeatureType int32
 
 func (s SubfeatureType) String() string {
 	switch s {
-	case C.SENSORS_SUBFEATURE_IN_INPUT:
+	case SENSORS_SUBFEATURE_IN_INPUT:
 		return "voltage"
-	case C.SENSORS_SUBFEATURE_IN_MIN:
+	case SENSORS_SUBFEATURE_IN_MIN:
 		return "minimum voltage"
-	case C.SENSORS_SUBFEATURE_IN_MAX:
+	case SENSORS_SUBFEATURE_IN_MAX:
 		return "maximum voltage"
-	case C.SENSORS_SUBFEATURE_IN_LCRIT:
+	case SENSORS_SUBFEATURE_IN_LCRIT:
 		return "critical minimum voltage"
-	case C.SENSORS_SUBFEATURE_IN_CRIT:
+	case SENSORS_SUBFEATURE_IN_CRIT:
 		return "critical maximum voltage"
-	case C.SENSORS_SUBFEATURE_IN_AVERAGE:
+	case SENSORS_SUBFEATURE_IN_AVERAGE:
 		return "average voltage"
-	case C.SENSORS_SUBFEATURE_IN_LOWEST:
+	case SENSORS_SUBFEATURE_IN_LOWEST:
 		return "historical minimum voltage"
-	case C.SENSORS_SUBFEATURE_IN_HIGHEST:
+	case SENSORS_SUBFEATURE_IN_HIGHEST:
 		return "historical maximum voltage"
-	case C.SENSORS_SUBFEATURE_IN_ALARM:
+	case SENSORS_SUBFEATURE_IN_ALARM:
 		return "voltage alarm "
-	case C.SENSORS_SUBFEATURE_IN_MIN_ALARM:
+	case SENSORS_SUBFEATURE_IN_MIN_ALARM:
 		return "voltage minimum alarm"
-	case C.SENSORS_SUBFEATURE_IN_MAX_ALARM:
+	case SENSORS_SUBFEATURE_IN_MAX_ALARM:
 		return "voltage maximum alarm"
-	case C.SENSORS_SUBFEATURE_IN_BEEP:
+	case SENSORS_SUBFEATURE_IN_BEEP:
 		return "voltage beep"
-	case C.SENSORS_SUBFEATURE_IN_LCRIT_ALARM:
+	case SENSORS_SUBFEATURE_IN_LCRIT_ALARM:
 		return "critical minimum voltage alarm"
-	case C.SENSORS_SUBFEATURE_IN_CRIT_ALARM:
+	case SENSORS_SUBFEATURE_IN_CRIT_ALARM:
 		return "critical maximum voltage alarm"
 
-	case C.SENSORS_SUBFEATURE_FAN_INPUT:
+	case SENSORS_SUBFEATURE_FAN_INPUT:
 		return "SENSORS_SUBFEATURE_FAN_INPUT"
-	case C.SENSORS_SUBFEATURE_FAN_MIN:
+	case SENSORS_SUBFEATURE_FAN_MIN:
 		return "SENSORS_SUBFEATURE_FAN_MIN"
-	case C.SENSORS_SUBFEATURE_FAN_MAX:
+	case SENSORS_SUBFEATURE_FAN_MAX:
 		return "SENSORS_SUBFEATURE_FAN_MAX"
-	case C.SENSORS_SUBFEATURE_FAN_ALARM:
+	case SENSORS_SUBFEATURE_FAN_ALARM:
 		return "SENSORS_SUBFEATURE_FAN_ALARM"
-	case C.SENSORS_SUBFEATURE_FAN_FAULT:
+	case SENSORS_SUBFEATURE_FAN_FAULT:
 		return "SENSORS_SUBFEATURE_FAN_FAULT"
-	case C.SENSORS_SUBFEATURE_FAN_DIV:
+	case SENSORS_SUBFEATURE_FAN_DIV:
 		return "SENSORS_SUBFEATURE_FAN_DIV"
-	case C.SENSORS_SUBFEATURE_FAN_BEEP:
+	case SENSORS_SUBFEATURE_FAN_BEEP:
 		return "SENSORS_SUBFEATURE_FAN_BEEP"
-	case C.SENSORS_SUBFEATURE_FAN_PULSES:
+	case SENSORS_SUBFEATURE_FAN_PULSES:
 		return "SENSORS_SUBFEATURE_FAN_PULSES"
-	case C.SENSORS_SUBFEATURE_FAN_MIN_ALARM:
+	case SENSORS_SUBFEATURE_FAN_MIN_ALARM:
 		return "SENSORS_SUBFEATURE_FAN_MIN_ALARM"
-	case C.SENSORS_SUBFEATURE_FAN_MAX_ALARM:
+	case SENSORS_SUBFEATURE_FAN_MAX_ALARM:
 		return "SENSORS_SUBFEATURE_FAN_MAX_ALARM"
-	case C.SENSORS_SUBFEATURE_TEMP_INPUT:
+	case SENSORS_SUBFEATURE_TEMP_INPUT:
 		return "SENSORS_SUBFEATURE_TEMP_INPUT"
-	case C.SENSORS_SUBFEATURE_TEMP_MAX:
+	case SENSORS_SUBFEATURE_TEMP_MAX:
 		return "SENSORS_SUBFEATURE_TEMP_MAX"
-	case C.SENSORS_SUBFEATURE_TEMP_MAX_HYST:
+	case SENSORS_SUBFEATURE_TEMP_MAX_HYST:
 		return "SENSORS_SUBFEATURE_TEMP_MAX_HYST"
-	case C.SENSORS_SUBFEATURE_TEMP_MIN:
+	case SENSORS_SUBFEATURE_TEMP_MIN:
 		return "SENSORS_SUBFEATURE_TEMP_MIN"
-	case C.SENSORS_SUBFEATURE_TEMP_CRIT:
+	case SENSORS_SUBFEATURE_TEMP_CRIT:
 		return "SENSORS_SUBFEATURE_TEMP_CRIT"
-	case C.SENSORS_SUBFEATURE_TEMP_CRIT_HYST:
+	case SENSORS_SUBFEATURE_TEMP_CRIT_HYST:
 		return "SENSORS_SUBFEATURE_TEMP_CRIT_HYST"
-	case C.SENSORS_SUBFEATURE_TEMP_LCRIT:
+	case SENSORS_SUBFEATURE_TEMP_LCRIT:
 		return "SENSORS_SUBFEATURE_TEMP_LCRIT"
-	case C.SENSORS_SUBFEATURE_TEMP_EMERGENCY:
+	case SENSORS_SUBFEATURE_TEMP_EMERGENCY:
 		return "SENSORS_SUBFEATURE_TEMP_EMERGENCY"
-	case C.SENSORS_SUBFEATURE_TEMP_EMERGENCY_HYST:
+	case SENSORS_SUBFEATURE_TEMP_EMERGENCY_HYST:
 		return "SENSORS_SUBFEATURE_TEMP_EMERGENCY_HYST"
-	case C.SENSORS_SUBFEATURE_TEMP_LOWEST:
+	case SENSORS_SUBFEATURE_TEMP_LOWEST:
 		return "SENSORS_SUBFEATURE_TEMP_LOWEST"
-	case C.SENSORS_SUBFEATURE_TEMP_HIGHEST:
+	case SENSORS_SUBFEATURE_TEMP_HIGHEST:
 		return "SENSORS_SUBFEATURE_TEMP_HIGHEST"
-	case C.SENSORS_SUBFEATURE_TEMP_MIN_HYST:
+	case SENSORS_SUBFEATURE_TEMP_MIN_HYST:
 		return "SENSORS_SUBFEATURE_TEMP_MIN_HYST"
-	case C.SENSORS_SUBFEATURE_TEMP_LCRIT_HYST:
+	case SENSORS_SUBFEATURE_TEMP_LCRIT_HYST:
 		return "SENSORS_SUBFEATURE_TEMP_LCRIT_HYST"
-	case C.SENSORS_SUBFEATURE_TEMP_ALARM:
+	case SENSORS_SUBFEATURE_TEMP_ALARM:
 		return "SENSORS_SUBFEATURE_TEMP_ALARM"
-	case C.SENSORS_SUBFEATURE_TEMP_MAX_ALARM:
+	case SENSORS_SUBFEATURE_TEMP_MAX_ALARM:
 		return "SENSORS_SUBFEATURE_TEMP_MAX_ALARM"
-	case C.SENSORS_SUBFEATURE_TEMP_MIN_ALARM:
+	case SENSORS_SUBFEATURE_TEMP_MIN_ALARM:
 		return "SENSORS_SUBFEATURE_TEMP_MIN_ALARM"
-	case C.SENSORS_SUBFEATURE_TEMP_CRIT_ALARM:
+	case SENSORS_SUBFEATURE_TEMP_CRIT_ALARM:
 		return "SENSORS_SUBFEATURE_TEMP_CRIT_ALARM"
-	case C.SENSORS_SUBFEATURE_TEMP_FAULT:
+	case SENSORS_SUBFEATURE_TEMP_FAULT:
 		return "SENSORS_SUBFEATURE_TEMP_FAULT"
-	case C.SENSORS_SUBFEATURE_TEMP_TYPE:
+	case SENSORS_SUBFEATURE_TEMP_TYPE:
 		return "SENSORS_SUBFEATURE_TEMP_TYPE"
-	case C.SENSORS_SUBFEATURE_TEMP_OFFSET:
+	case SENSORS_SUBFEATURE_TEMP_OFFSET:
 		return "SENSORS_SUBFEATURE_TEMP_OFFSET"
-	case C.SENSORS_SUBFEATURE_TEMP_BEEP:
+	case SENSORS_SUBFEATURE_TEMP_BEEP:
 		return "SENSORS_SUBFEATURE_TEMP_BEEP"
-	case C.SENSORS_SUBFEATURE_TEMP_EMERGENCY_ALARM:
+	case SENSORS_SUBFEATURE_TEMP_EMERGENCY_ALARM:
 		return "SENSORS_SUBFEATURE_TEMP_EMERGENCY_ALARM"
-	case C.SENSORS_SUBFEATURE_TEMP_LCRIT_ALARM:
+	case SENSORS_SUBFEATURE_TEMP_LCRIT_ALARM:
 		return "SENSORS_SUBFEATURE_TEMP_LCRIT_ALARM"
 
-	case C.SENSORS_SUBFEATURE_POWER_AVERAGE:
+	case SENSORS_SUBFEATURE_POWER_AVERAGE:
 		return "power average"
-	case C.SENSORS_SUBFEATURE_POWER_AVERAGE_HIGHEST:
+	case SENSORS_SUBFEATURE_POWER_AVERAGE_HIGHEST:
 		return "historical maximum power average"
-	case C.SENSORS_SUBFEATURE_POWER_AVERAGE_LOWEST:
+	case SENSORS_SUBFEATURE_POWER_AVERAGE_LOWEST:
 		return "historical minimum power average"
-	case C.SENSORS_SUBFEATURE_POWER_INPUT:
+	case SENSORS_SUBFEATURE_POWER_INPUT:
 		return "power input"
-	case C.SENSORS_SUBFEATURE_POWER_INPUT_HIGHEST:
+	case SENSORS_SUBFEATURE_POWER_INPUT_HIGHEST:
 		return "historical maximum power input"
-	case C.SENSORS_SUBFEATURE_POWER_INPUT_LOWEST:
+	case SENSORS_SUBFEATURE_POWER_INPUT_LOWEST:
 		return "historical minimum power input"
-	case C.SENSORS_SUBFEATURE_POWER_CAP:
+	case SENSORS_SUBFEATURE_POWER_CAP:
 		return "power cap"
-	case C.SENSORS_SUBFEATURE_POWER_CAP_HYST:
+	case SENSORS_SUBFEATURE_POWER_CAP_HYST:
 		return "power cap hysteresis"
-	case C.SENSORS_SUBFEATURE_POWER_MAX:
+	case SENSORS_SUBFEATURE_POWER_MAX:
 		return "maximum power"
-	case C.SENSORS_SUBFEATURE_POWER_CRIT:
+	case SENSORS_SUBFEATURE_POWER_CRIT:
 		return "critical maximum power"
-	case C.SENSORS_SUBFEATURE_POWER_MIN:
+	case SENSORS_SUBFEATURE_POWER_MIN:
 		return "minimum power"
-	case C.SENSORS_SUBFEATURE_POWER_LCRIT:
+	case SENSORS_SUBFEATURE_POWER_LCRIT:
 		return "critical minimum power"
-	case C.SENSORS_SUBFEATURE_POWER_AVERAGE_INTERVAL:
+	case SENSORS_SUBFEATURE_POWER_AVERAGE_INTERVAL:
 		return "power average interval"
-	case C.SENSORS_SUBFEATURE_POWER_ALARM:
+	case SENSORS_SUBFEATURE_POWER_ALARM:
 		return "power alarm"
-	case C.SENSORS_SUBFEATURE_POWER_CAP_ALARM:
+	case SENSORS_SUBFEATURE_POWER_CAP_ALARM:
 		return "power cap alarm"
-	case C.SENSORS_SUBFEATURE_POWER_MAX_ALARM:
+	case SENSORS_SUBFEATURE_POWER_MAX_ALARM:
 		return "maximum power alarm"
-	case C.SENSORS_SUBFEATURE_POWER_CRIT_ALARM:
+	case SENSORS_SUBFEATURE_POWER_CRIT_ALARM:
 		return "critical maximum power alarm"
-	case C.SENSORS_SUBFEATURE_POWER_MIN_ALARM:
+	case SENSORS_SUBFEATURE_POWER_MIN_ALARM:
 		return "minimum power alarm"
-	case C.SENSORS_SUBFEATURE_POWER_LCRIT_ALARM:
+	case SENSORS_SUBFEATURE_POWER_LCRIT_ALARM:
 		return "critical minimum power alarm"
-	case C.SENSORS_SUBFEATURE_ENERGY_INPUT:
+	case SENSORS_SUBFEATURE_ENERGY_INPUT:
 		return "energy input"
-	case C.SENSORS_SUBFEATURE_CURR_INPUT:
+	case SENSORS_SUBFEATURE_CURR_INPUT:
 		return "current input"
-	case C.SENSORS_SUBFEATURE_CURR_MIN:
+	case SENSORS_SUBFEATURE_CURR_MIN:
 		return "minimum current"
-	case C.SENSORS_SUBFEATURE_CURR_MAX:
+	case SENSORS_SUBFEATURE_CURR_MAX:
 		return "maximum current"
-	case C.SENSORS_SUBFEATURE_CURR_LCRIT:
+	case SENSORS_SUBFEATURE_CURR_LCRIT:
 		return "critical minimum current"
-	case C.SENSORS_SUBFEATURE_CURR_CRIT:
+	case SENSORS_SUBFEATURE_CURR_CRIT:
 		return "critical maximum current"
-	case C.SENSORS_SUBFEATURE_CURR_AVERAGE:
+	case SENSORS_SUBFEATURE_CURR_AVERAGE:
 		return "current average"
-	case C.SENSORS_SUBFEATURE_CURR_LOWEST:
+	case SENSORS_SUBFEATURE_CURR_LOWEST:
 		return "historical minimum current"
-	case C.SENSORS_SUBFEATURE_CURR_HIGHEST:
+	case SENSORS_SUBFEATURE_CURR_HIGHEST:
 		return "historical maximum current"
-	case C.SENSORS_SUBFEATURE_CURR_ALARM:
+	case SENSORS_SUBFEATURE_CURR_ALARM:
 		return "current alarm"
-	case C.SENSORS_SUBFEATURE_CURR_MIN_ALARM:
+	case SENSORS_SUBFEATURE_CURR_MIN_ALARM:
 		return "minimum current alarm"
-	case C.SENSORS_SUBFEATURE_CURR_MAX_ALARM:
+	case SENSORS_SUBFEATURE_CURR_MAX_ALARM:
 		return "maximum current alarm"
-	case C.SENSORS_SUBFEATURE_CURR_BEEP:
+	case SENSORS_SUBFEATURE_CURR_BEEP:
 		return "current beep"
-	case C.SENSORS_SUBFEATURE_CURR_LCRIT_ALARM:
+	case SENSORS_SUBFEATURE_CURR_LCRIT_ALARM:
 		return "critical minimum current alarm"
-	case C.SENSORS_SUBFEATURE_CURR_CRIT_ALARM:
+	case SENSORS_SUBFEATURE_CURR_CRIT_ALARM:
 		return "critical maximum current alarm"
 
-	case C.SENSORS_SUBFEATURE_HUMIDITY_INPUT:
+	case SENSORS_SUBFEATURE_HUMIDITY_INPUT:
 		return "SENSORS_SUBFEATURE_HUMIDITY_INPUT"
 
-	case C.SENSORS_SUBFEATURE_VID:
+	case SENSORS_SUBFEATURE_VID:
 		return "cpu core reference voltage"
 
-	case C.SENSORS_SUBFEATURE_INTRUSION_ALARM:
+	case SENSORS_SUBFEATURE_INTRUSION_ALARM:
 		return "SENSORS_SUBFEATURE_INTRUSION_ALARM"
-	case C.SENSORS_SUBFEATURE_INTRUSION_BEEP:
+	case SENSORS_SUBFEATURE_INTRUSION_BEEP:
 		return "SENSORS_SUBFEATURE_INTRUSION_BEEP"
-	case C.SENSORS_SUBFEATURE_BEEP_ENABLE:
+	case SENSORS_SUBFEATURE_BEEP_ENABLE:
 		return "SENSORS_SUBFEATURE_BEEP_ENABLE"
-	case C.SENSORS_SUBFEATURE_UNKNOWN:
+	case SENSORS_SUBFEATURE_UNKNOWN:
 		return "SENSORS_SUBFEATURE_UNKNOWN"
 	default:
 		return "unknown subfeature type"
@@ -449,16 +517,16 @@ type Flags uint
 
 func (f Flags) String() string {
 	var b strings.Builder
-	if f&C.SENSORS_MODE_R != 0 {
+	if f&SENSORS_MODE_R != 0 {
 		b.WriteString("R")
 	}
-	if f&C.SENSORS_MODE_W != 0 {
+	if f&SENSORS_MODE_W != 0 {
 		if b.Len() > 0 {
 			b.WriteString("|")
 		}
 		b.WriteString("W")
 	}
-	if f&C.SENSORS_COMPUTE_MAPPING != 0 {
+	if f&SENSORS_COMPUTE_MAPPING != 0 {
 		if b.Len() > 0 {
 			b.WriteString("|")
 		}
@@ -7,3 +7,7 @@ import "git.sr.ht/~whereswaldon/watt-wiser/sensors"
 func FindEnergySensors() ([]sensors.Sensor, error) {
 	return nil, nil
 }
+
+func FindAuxiliarySensors() ([]sensors.Sensor, error) {
+	return nil, nil
+}
@@ -0,0 +1,237 @@
+//go:build linux && libsensors
+
+package hwmon
+
+/*
+#cgo LDFLAGS: -lsensors
+#include <stdlib.h>
+#include <sensors/sensors.h>
+*/
+import "C"
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+func (s Subfeature) Read() (float64, error) {
+	var value C.double
+	rc := C.sensors_get_value(s.Parent.Parent.CChip, C.int(s.Number), &value)
+	if rc < 0 {
+		return 0, fmt.Errorf("failed reading subfeature value: %d", rc)
+	}
+	// libsensors already scales each feature type into unprefixed SI units.
+	return float64(value), nil
+}
+
+type Chip struct {
+	Name  string
+	CChip *C.sensors_chip_name
+}
+
+var (
+	initOnce sync.Once
+	initRC   C.int
+)
+
+// initSensors initializes libsensors exactly once, no matter how many discovery functions
+// are invoked.
+func initSensors() error {
+	initOnce.Do(func() {
+		initRC = C.sensors_init(nil)
+	})
+	if initRC != 0 {
+		return fmt.Errorf("failed initializing sensors: %d", initRC)
+	}
+	return nil
+}
+
+// chipName formats the name of a libsensors chip, returning false if it cannot be formatted.
+func chipName(chip *C.sensors_chip_name) (string, bool) {
+	var buf [256]C.char
+	rc := C.sensors_snprintf_chip_name(&buf[0], C.ulong(len(buf)), chip)
+	if rc < 0 {
+		return "", false
+	}
+	return C.GoString(&buf[0]), true
+}
+
+// newFeature converts a libsensors feature into our model of one.
+func newFeature(chip Chip, feature *C.sensors_feature) Feature {
+	currentFeature := Feature{
+		Parent: chip,
+		Name:   C.GoString(feature.name),
+		Number: int(feature.number),
+		Type:   FeatureType(feature._type),
+	}
+	cLabel := C.sensors_get_label(chip.CChip, feature)
+	if cLabel != nil {
+		currentFeature.Label = C.GoString(cLabel)
+		C.free(unsafe.Pointer(cLabel))
+	}
+	return currentFeature
+}
+
+// newSubfeature converts a libsensors subfeature into our model of one.
+func newSubfeature(feature Feature, subfeature *C.sensors_subfeature) Subfeature {
+	return Subfeature{
+		Parent:  feature,
+		SubName: C.GoString(subfeature.name),
+		Number:  int(subfeature.number),
+		Type:    SubfeatureType(subfeature._type),
+		Mapping: int(subfeature.mapping),
+		Flags:   Flags(subfeature.flags),
+	}
+}
+
+func FindEnergySensors() ([]sensors.Sensor, error) {
+	if err := initSensors(); err != nil {
+		return nil, err
+	}
+
+	relevantSubfeatures := []sensors.Sensor{}
+
+	var chipIterState C.int
+	for {
+		chip := C.sensors_get_detected_chips(nil, &chipIterState)
+		if chip == nil {
+			break
+		}
+		name, ok := chipName(chip)
+		if !ok {
+			continue
+		}
+		currentChip := Chip{Name: name, CChip: chip}
+
+		hasCurrent := false
+		var currentSensor Subfeature
+		hasVoltage := false
+		var voltageSensor Subfeature
+		var featureIterState C.int
+		for {
+			feature := C.sensors_get_features(chip, &featureIterState)
+			if feature == nil {
+				break
+			}
+			switch feature._type {
+			case C.SENSORS_FEATURE_IN:
+			case C.SENSORS_FEATURE_POWER:
+			case C.SENSORS_FEATURE_ENERGY:
+			case C.SENSORS_FEATURE_CURR:
+			default:
+				continue
+			}
+			currentFeature := newFeature(currentChip, feature)
+			var subfeatureIterState C.int
+			switch feature._type {
+			case C.SENSORS_FEATURE_POWER, C.SENSORS_FEATURE_ENERGY:
+				var featureSubfeatures []Subfeature
+				for {
+					subfeature := C.sensors_get_all_subfeatures(chip, feature, &subfeatureIterState)
+					if subfeature == nil {
+						break
+					}
+					switch subfeature._type {
+					case C.SENSORS_SUBFEATURE_POWER_INPUT:
+					case C.SENSORS_SUBFEATURE_POWER_AVERAGE:
+					case C.SENSORS_SUBFEATURE_POWER_AVERAGE_INTERVAL:
+					case C.SENSORS_SUBFEATURE_ENERGY_INPUT:
+					default:
+						continue
+					}
+					featureSubfeatures = append(featureSubfeatures, newSubfeature(currentFeature, subfeature))
+				}
+				attachAverageIntervals(featureSubfeatures)
+				for _, currentSubfeature := range featureSubfeatures {
+					switch currentSubfeature.Type {
+					case SENSORS_SUBFEATURE_ENERGY_INPUT:
+						relevantSubfeatures = append(relevantSubfeatures, &EnergyCounter{Subfeature: currentSubfeature})
+					case SENSORS_SUBFEATURE_POWER_AVERAGE_INTERVAL:
+						// The interval describes the average rather than being a sensor itself.
+					default:
+						relevantSubfeatures = append(relevantSubfeatures, currentSubfeature)
+					}
+				}
+			case C.SENSORS_FEATURE_IN, C.SENSORS_FEATURE_CURR:
+				for {
+					subfeature := C.sensors_get_all_subfeatures(chip, feature, &subfeatureIterState)
+					if subfeature == nil {
+						break
+					}
+					currentSubfeature := newSubfeature(currentFeature, subfeature)
+					switch subfeature._type {
+					case C.SENSORS_SUBFEATURE_CURR_INPUT:
+						hasCurrent = true
+						currentSensor = currentSubfeature
+					case C.SENSORS_SUBFEATURE_IN_INPUT:
+						hasVoltage = true
+						voltageSensor = currentSubfeature
+					default:
+						continue
+					}
+				}
+			}
+		}
+		if hasCurrent && hasVoltage {
+			relevantSubfeatures = append(relevantSubfeatures, SyntheticPower{
+				Current: currentSensor,
+				Voltage: voltageSensor,
+			})
+		}
+	}
+	return relevantSubfeatures, nil
+}
+
+// FindAuxiliarySensors discovers temperature and fan speed sensors through libsensors.
+func FindAuxiliarySensors() ([]sensors.Sensor, error) {
+	if err := initSensors(); err != nil {
+		return nil, err
+	}
+
+	relevantSubfeatures := []sensors.Sensor{}
+
+	var chipIterState C.int
+	for {
+		chip := C.sensors_get_detected_chips(nil, &chipIterState)
+		if chip == nil {
+			break
+		}
+		name, ok := chipName(chip)
+		if !ok {
+			continue
+		}
+		currentChip := Chip{Name: name, CChip: chip}
+
+		var featureIterState C.int
+		for {
+			feature := C.sensors_get_features(chip, &featureIterState)
+			if feature == nil {
+				break
+			}
+			switch feature._type {
+			case C.SENSORS_FEATURE_TEMP:
+			case C.SENSORS_FEATURE_FAN:
+			default:
+				continue
+			}
+			currentFeature := newFeature(currentChip, feature)
+			var subfeatureIterState C.int
+			for {
+				subfeature := C.sensors_get_all_subfeatures(chip, feature, &subfeatureIterState)
+				if subfeature == nil {
+					break
+				}
+				switch subfeature._type {
+				case C.SENSORS_SUBFEATURE_TEMP_INPUT:
+				case C.SENSORS_SUBFEATURE_FAN_INPUT:
+				default:
+					continue
+				}
+				relevantSubfeatures = append(relevantSubfeatures, newSubfeature(currentFeature, subfeature))
+			}
+		}
+	}
+	return relevantSubfeatures, nil
+}
@@ -0,0 +1,38 @@
+//go:build linux && libsensors
+
+package hwmon
+
+import (
+	"testing"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+// TestLibsensorsScaling checks that the power sensors libsensors finds on this machine read in
+// watts. Unlike the sysfs build, this build relies on libsensors to scale raw readings, and
+// sysfs can't be faked for it, so the test is skipped on machines without power sensors.
+func TestLibsensorsScaling(t *testing.T) {
+	found, err := FindEnergySensors()
+	if err != nil {
+		t.Skipf("libsensors is unavailable: %v", err)
+	}
+	checked := 0
+	for _, s := range found {
+		if s.Unit() != sensors.Watts {
+			continue
+		}
+		value, err := s.Read()
+		if err != nil {
+			t.Errorf("failed reading %s: %v", s.Name(), err)
+			continue
+		}
+		// A reading left in microwatts would put a device drawing a watt at a megawatt.
+		if value < 0 || value > 10000 {
+			t.Errorf("expected %s to read a plausible number of watts, got %v", s.Name(), value)
+		}
+		checked++
+	}
+	if checked == 0 {
+		t.Skip("no power sensors to check")
+	}
+}
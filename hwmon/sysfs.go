@@ -0,0 +1,310 @@
+//go:build linux && !libsensors
+
+package hwmon
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+// SysfsRoot is the directory in which the kernel exposes hwmon chips.
+const SysfsRoot = "/sys/class/hwmon"
+
+type Chip struct {
+	Name string
+	// Path is the sysfs directory containing the chip's attribute files.
+	Path string
+}
+
+func (s Subfeature) Read() (float64, error) {
+	path := filepath.Join(s.Parent.Parent.Path, s.SubName)
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed reading %s: %w", path, err)
+	}
+	value, err := strconv.ParseInt(strings.TrimSpace(string(raw)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed parsing %s (%s): %w", path, strings.TrimSpace(string(raw)), err)
+	}
+	return float64(value) * sysfsScale(s), nil
+}
+
+// sysfsScale returns the factor that converts a raw sysfs attribute value of the given
+// subfeature into unprefixed SI units.
+func sysfsScale(s Subfeature) float64 {
+	if s.Type == SENSORS_SUBFEATURE_POWER_AVERAGE_INTERVAL {
+		// Averaging intervals are reported in milliseconds.
+		return sensors.MilliToUnprefixed
+	}
+	switch s.Parent.Type {
+	case SENSORS_FEATURE_IN, SENSORS_FEATURE_CURR, SENSORS_FEATURE_TEMP:
+		return sensors.MilliToUnprefixed
+	case SENSORS_FEATURE_POWER, SENSORS_FEATURE_ENERGY:
+		return sensors.MicroToUnprefixed
+	default:
+		return 1
+	}
+}
+
+var attributePattern = regexp.MustCompile(`^([a-z]+)([0-9]+)_([a-z_]+)$`)
+
+// featurePrefixes maps sysfs attribute prefixes to the feature types we collect.
+var featurePrefixes = map[string]FeatureType{
+	"in":     SENSORS_FEATURE_IN,
+	"power":  SENSORS_FEATURE_POWER,
+	"energy": SENSORS_FEATURE_ENERGY,
+	"curr":   SENSORS_FEATURE_CURR,
+	"temp":   SENSORS_FEATURE_TEMP,
+	"fan":    SENSORS_FEATURE_FAN,
+}
+
+// subfeatureSuffixes maps sysfs attribute names (with the feature number removed) to the
+// subfeature types we collect.
+var subfeatureSuffixes = map[string]SubfeatureType{
+	"in_input":               SENSORS_SUBFEATURE_IN_INPUT,
+	"power_input":            SENSORS_SUBFEATURE_POWER_INPUT,
+	"power_average":          SENSORS_SUBFEATURE_POWER_AVERAGE,
+	"power_average_interval": SENSORS_SUBFEATURE_POWER_AVERAGE_INTERVAL,
+	"energy_input":           SENSORS_SUBFEATURE_ENERGY_INPUT,
+	"curr_input":             SENSORS_SUBFEATURE_CURR_INPUT,
+	"temp_input":             SENSORS_SUBFEATURE_TEMP_INPUT,
+	"fan_input":              SENSORS_SUBFEATURE_FAN_INPUT,
+}
+
+// FindEnergySensors discovers hwmon sensors by reading sysfs directly.
+func FindEnergySensors() ([]sensors.Sensor, error) {
+	return FindEnergySensorsIn(SysfsRoot)
+}
+
+// FindEnergySensorsIn discovers hwmon sensors within the hwmon* chip directories of the
+// given root, which is usually SysfsRoot.
+func FindEnergySensorsIn(root string) ([]sensors.Sensor, error) {
+	chips, err := findChips(root)
+	if err != nil {
+		return nil, err
+	}
+	relevantSubfeatures := []sensors.Sensor{}
+	for _, currentChip := range chips {
+		subfeatures, err := readSubfeatures(currentChip)
+		if err != nil {
+			log.Printf("failed reading features of %q: %v", currentChip.Path, err)
+			continue
+		}
+		attachAverageIntervals(subfeatures)
+
+		hasCurrent := false
+		var currentSensor Subfeature
+		hasVoltage := false
+		var voltageSensor Subfeature
+		for _, subfeature := range subfeatures {
+			switch subfeature.Type {
+			case SENSORS_SUBFEATURE_POWER_INPUT, SENSORS_SUBFEATURE_POWER_AVERAGE:
+				relevantSubfeatures = append(relevantSubfeatures, subfeature)
+			case SENSORS_SUBFEATURE_ENERGY_INPUT:
+				relevantSubfeatures = append(relevantSubfeatures, &EnergyCounter{Subfeature: subfeature})
+			case SENSORS_SUBFEATURE_CURR_INPUT:
+				hasCurrent = true
+				currentSensor = subfeature
+			case SENSORS_SUBFEATURE_IN_INPUT:
+				hasVoltage = true
+				voltageSensor = subfeature
+			}
+		}
+		if hasCurrent && hasVoltage {
+			relevantSubfeatures = append(relevantSubfeatures, SyntheticPower{
+				Current: currentSensor,
+				Voltage: voltageSensor,
+			})
+		}
+	}
+	return relevantSubfeatures, nil
+}
+
+// FindAuxiliarySensors discovers hwmon temperature and fan speed sensors by reading sysfs
+// directly.
+func FindAuxiliarySensors() ([]sensors.Sensor, error) {
+	return FindAuxiliarySensorsIn(SysfsRoot)
+}
+
+// FindAuxiliarySensorsIn discovers hwmon temperature and fan speed sensors within the hwmon*
+// chip directories of the given root, which is usually SysfsRoot.
+func FindAuxiliarySensorsIn(root string) ([]sensors.Sensor, error) {
+	chips, err := findChips(root)
+	if err != nil {
+		return nil, err
+	}
+	relevantSubfeatures := []sensors.Sensor{}
+	for _, currentChip := range chips {
+		subfeatures, err := readSubfeatures(currentChip)
+		if err != nil {
+			log.Printf("failed reading features of %q: %v", currentChip.Path, err)
+			continue
+		}
+		for _, subfeature := range subfeatures {
+			switch subfeature.Type {
+			case SENSORS_SUBFEATURE_TEMP_INPUT, SENSORS_SUBFEATURE_FAN_INPUT:
+				relevantSubfeatures = append(relevantSubfeatures, subfeature)
+			}
+		}
+	}
+	return relevantSubfeatures, nil
+}
+
+// findChips lists the hwmon chips within root, skipping any whose name cannot be read.
+func findChips(root string) ([]Chip, error) {
+	chipDirs, err := filepath.Glob(filepath.Join(root, "hwmon*"))
+	if err != nil {
+		return nil, fmt.Errorf("failed listing hwmon chips: %w", err)
+	}
+	chips := make([]Chip, 0, len(chipDirs))
+	for _, dir := range chipDirs {
+		name, err := os.ReadFile(filepath.Join(dir, "name"))
+		if err != nil {
+			log.Printf("failed resolving name for %q: %v", dir, err)
+			continue
+		}
+		chips = append(chips, Chip{
+			Name: chipName(dir, strings.TrimSpace(string(name))),
+			Path: dir,
+		})
+	}
+	return chips, nil
+}
+
+// readSubfeatures returns the relevant subfeatures of a chip ordered by feature type, feature
+// number, and subfeature type, mirroring the order in which libsensors reports them.
+func readSubfeatures(chip Chip) ([]Subfeature, error) {
+	entries, err := os.ReadDir(chip.Path)
+	if err != nil {
+		return nil, err
+	}
+	type featureKey struct {
+		prefix string
+		number int
+	}
+	features := map[featureKey]*Feature{}
+	subfeatureKeys := map[string]featureKey{}
+	var subfeatures []Subfeature
+	for _, entry := range entries {
+		matches := attributePattern.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			continue
+		}
+		prefix, suffix := matches[1], matches[3]
+		featureType, ok := featurePrefixes[prefix]
+		if !ok {
+			continue
+		}
+		number, err := strconv.Atoi(matches[2])
+		if err != nil {
+			continue
+		}
+		key := featureKey{prefix: prefix, number: number}
+		feature, ok := features[key]
+		if !ok {
+			feature = &Feature{
+				Name:   prefix + matches[2],
+				Label:  prefix + matches[2],
+				Number: number,
+				Type:   featureType,
+				Parent: chip,
+			}
+			features[key] = feature
+		}
+		if suffix == "label" {
+			label, err := os.ReadFile(filepath.Join(chip.Path, entry.Name()))
+			if err != nil {
+				log.Printf("failed reading label %q: %v", entry.Name(), err)
+				continue
+			}
+			feature.Label = strings.TrimSpace(string(label))
+			continue
+		}
+		subfeatureType, ok := subfeatureSuffixes[prefix+"_"+suffix]
+		if !ok {
+			continue
+		}
+		subfeatureKeys[entry.Name()] = key
+		subfeatures = append(subfeatures, Subfeature{
+			SubName: entry.Name(),
+			Type:    subfeatureType,
+			Mapping: number,
+			Flags:   attributeFlags(entry),
+		})
+	}
+	// Attach parents only after all labels have been read.
+	for i := range subfeatures {
+		subfeatures[i].Parent = *features[subfeatureKeys[subfeatures[i].SubName]]
+	}
+	slices.SortFunc(subfeatures, func(a, b Subfeature) int {
+		if a.Parent.Type != b.Parent.Type {
+			return int(a.Parent.Type - b.Parent.Type)
+		}
+		if a.Parent.Number != b.Parent.Number {
+			return a.Parent.Number - b.Parent.Number
+		}
+		return int(a.Type - b.Type)
+	})
+	for i := range subfeatures {
+		subfeatures[i].Number = i
+	}
+	return subfeatures, nil
+}
+
+func attributeFlags(entry os.DirEntry) Flags {
+	info, err := entry.Info()
+	if err != nil {
+		return 0
+	}
+	var flags Flags
+	if info.Mode().Perm()&0o444 != 0 {
+		flags |= SENSORS_MODE_R
+	}
+	if info.Mode().Perm()&0o222 != 0 {
+		flags |= SENSORS_MODE_W
+	}
+	return flags
+}
+
+// chipName builds a chip name in the same format that libsensors uses, like
+// "amdgpu-pci-0300", so that traces are comparable regardless of how they were collected.
+func chipName(dir, prefix string) string {
+	device, err := filepath.EvalSymlinks(filepath.Join(dir, "device"))
+	if err != nil {
+		return prefix + "-virtual-0"
+	}
+	subsystem, err := filepath.EvalSymlinks(filepath.Join(device, "subsystem"))
+	if err != nil {
+		return prefix + "-virtual-0"
+	}
+	deviceName := filepath.Base(device)
+	switch filepath.Base(subsystem) {
+	case "pci":
+		var domain, bus, slot, function int
+		if _, err := fmt.Sscanf(deviceName, "%x:%x:%x.%x", &domain, &bus, &slot, &function); err == nil {
+			return fmt.Sprintf("%s-pci-%04x", prefix, domain<<16+bus<<8+slot<<3+function)
+		}
+	case "i2c":
+		var bus, addr int
+		if _, err := fmt.Sscanf(deviceName, "%d-%x", &bus, &addr); err == nil {
+			return fmt.Sprintf("%s-i2c-%d-%02x", prefix, bus, addr)
+		}
+	case "platform", "of_platform":
+		addr := 0
+		if i := strings.LastIndexByte(deviceName, '.'); i >= 0 {
+			addr, _ = strconv.Atoi(deviceName[i+1:])
+		}
+		return fmt.Sprintf("%s-isa-%04x", prefix, addr)
+	case "acpi":
+		return prefix + "-acpi-0"
+	}
+	return prefix + "-virtual-0"
+}
@@ -0,0 +1,444 @@
+//go:build linux && !libsensors
+
+package hwmon
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+// fixtureChip describes one hwmon chip directory to create beneath a fake sysfs root.
+type fixtureChip struct {
+	dir string
+	// device is the name of the device directory the chip's device link points to, or empty
+	// for virtual chips.
+	device string
+	// subsystem is the bus the device belongs to.
+	subsystem string
+	// files maps attribute file names to their contents.
+	files map[string]string
+}
+
+// makeFixture builds a fake sysfs tree and returns the path to use as the hwmon class root.
+func makeFixture(t *testing.T, chips ...fixtureChip) string {
+	t.Helper()
+	root := t.TempDir()
+	classDir := filepath.Join(root, "class", "hwmon")
+	for _, chip := range chips {
+		chipDir := filepath.Join(classDir, chip.dir)
+		if err := os.MkdirAll(chipDir, 0o755); err != nil {
+			t.Fatalf("failed creating chip dir: %v", err)
+		}
+		for name, contents := range chip.files {
+			if err := os.WriteFile(filepath.Join(chipDir, name), []byte(contents+"\n"), 0o444); err != nil {
+				t.Fatalf("failed writing %s: %v", name, err)
+			}
+		}
+		if chip.device == "" {
+			continue
+		}
+		busDir := filepath.Join(root, "bus", chip.subsystem)
+		deviceDir := filepath.Join(root, "devices", chip.device)
+		for _, dir := range []string{busDir, deviceDir} {
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				t.Fatalf("failed creating %s: %v", dir, err)
+			}
+		}
+		if err := os.Symlink(busDir, filepath.Join(deviceDir, "subsystem")); err != nil {
+			t.Fatalf("failed linking subsystem: %v", err)
+		}
+		if err := os.Symlink(deviceDir, filepath.Join(chipDir, "device")); err != nil {
+			t.Fatalf("failed linking device: %v", err)
+		}
+	}
+	return classDir
+}
+
+func TestFindEnergySensorsIn(t *testing.T) {
+	root := makeFixture(t,
+		fixtureChip{
+			dir:       "hwmon0",
+			device:    "0000:03:00.0",
+			subsystem: "pci",
+			files: map[string]string{
+				"name":                    "amdgpu",
+				"power1_average":          "7000000",
+				"power1_cap":              "186000000",
+				"power1_label":            "PPT",
+				"power1_average_interval": "1000",
+				"in0_input":               "818",
+				"in0_label":               "vddgfx",
+				"temp1_input":             "45000",
+			},
+		},
+		fixtureChip{
+			dir:       "hwmon1",
+			device:    "coretemp.0",
+			subsystem: "platform",
+			files: map[string]string{
+				"name":        "coretemp",
+				"temp1_input": "52000",
+			},
+		},
+		fixtureChip{
+			dir: "hwmon2",
+			files: map[string]string{
+				"name":          "BAT0",
+				"in0_input":     "12500",
+				"curr1_input":   "1200",
+				"energy1_input": "5000000",
+			},
+		},
+		fixtureChip{
+			dir:       "hwmon3",
+			device:    "6-0040",
+			subsystem: "i2c",
+			files: map[string]string{
+				"name":         "ina3221",
+				"power1_input": "2500000",
+			},
+		},
+		fixtureChip{
+			// Chips without a name attribute are skipped.
+			dir: "hwmon4",
+			files: map[string]string{
+				"power1_input": "2500000",
+			},
+		},
+	)
+	found, err := FindEnergySensorsIn(root)
+	if err != nil {
+		t.Fatalf("expected discovery to succeed, got %v", err)
+	}
+	type expectation struct {
+		name string
+		unit sensors.Unit
+	}
+	expected := []expectation{
+		{name: "amdgpu-pci-0300#power1_average", unit: sensors.Watts},
+		{name: "BAT0-virtual-0#energy1_input", unit: sensors.Joules},
+		{name: "synthesized power (BAT0-virtual-0#curr1_input x BAT0-virtual-0#in0_input)", unit: sensors.Watts},
+		{name: "ina3221-i2c-6-40#power1_input", unit: sensors.Watts},
+	}
+	if len(found) != len(expected) {
+		for _, s := range found {
+			t.Logf("found %q", s.Name())
+		}
+		t.Fatalf("expected %d sensors, got %d", len(expected), len(found))
+	}
+	for i, s := range found {
+		if s.Name() != expected[i].name {
+			t.Errorf("expected sensor %d to be named %q, got %q", i, expected[i].name, s.Name())
+		}
+		if s.Unit() != expected[i].unit {
+			t.Errorf("expected sensor %d to be in %s, got %s", i, expected[i].unit, s.Unit())
+		}
+	}
+	power, ok := found[0].(Subfeature)
+	if !ok {
+		t.Fatalf("expected power sensor to be a Subfeature, got %T", found[0])
+	}
+	if power.Parent.Label != "PPT" {
+		t.Errorf("expected label from power1_label, got %q", power.Parent.Label)
+	}
+	if power.Type != SENSORS_SUBFEATURE_POWER_AVERAGE {
+		t.Errorf("expected subfeature type %s, got %s", SENSORS_SUBFEATURE_POWER_AVERAGE, power.Type)
+	}
+	if power.Flags&SENSORS_MODE_R == 0 {
+		t.Errorf("expected readable flag, got %s", power.Flags)
+	}
+	if power.UpdateInterval() != time.Second {
+		t.Errorf("expected update interval from power1_average_interval, got %v", power.UpdateInterval())
+	}
+	synthetic, ok := found[2].(SyntheticPower)
+	if !ok {
+		t.Fatalf("expected synthetic power sensor, got %T", found[2])
+	}
+	if label := synthetic.Voltage.(Subfeature).Parent.Label; label != "in0" {
+		t.Errorf("expected unlabeled feature to use its name as a label, got %q", label)
+	}
+}
+
+func TestFindAuxiliarySensorsIn(t *testing.T) {
+	root := makeFixture(t,
+		fixtureChip{
+			dir:       "hwmon0",
+			device:    "0000:03:00.0",
+			subsystem: "pci",
+			files: map[string]string{
+				"name":           "amdgpu",
+				"fan1_input":     "850",
+				"fan1_max":       "3300",
+				"power1_average": "7000000",
+				"temp1_crit":     "100000",
+				"temp1_input":    "41000",
+				"temp1_label":    "edge",
+				"temp2_input":    "43000",
+				"temp2_label":    "junction",
+			},
+		},
+		fixtureChip{
+			dir: "hwmon1",
+			files: map[string]string{
+				"name":        "BAT0",
+				"in0_input":   "12500",
+				"curr1_input": "1200",
+			},
+		},
+	)
+	found, err := FindAuxiliarySensorsIn(root)
+	if err != nil {
+		t.Fatalf("expected discovery to succeed, got %v", err)
+	}
+	type expectation struct {
+		name  string
+		unit  sensors.Unit
+		value float64
+	}
+	expected := []expectation{
+		{name: "amdgpu-pci-0300#fan1_input", unit: sensors.RPM, value: 850},
+		{name: "amdgpu-pci-0300#temp1_input", unit: sensors.Celsius, value: 41},
+		{name: "amdgpu-pci-0300#temp2_input", unit: sensors.Celsius, value: 43},
+	}
+	if len(found) != len(expected) {
+		for _, s := range found {
+			t.Logf("found %q", s.Name())
+		}
+		t.Fatalf("expected %d sensors, got %d", len(expected), len(found))
+	}
+	for i, s := range found {
+		if s.Name() != expected[i].name {
+			t.Errorf("expected sensor %d to be named %q, got %q", i, expected[i].name, s.Name())
+		}
+		if s.Unit() != expected[i].unit {
+			t.Errorf("expected sensor %d to be in %s, got %s", i, expected[i].unit, s.Unit())
+		}
+		value, err := s.Read()
+		if err != nil {
+			t.Fatalf("failed reading %s: %v", s.Name(), err)
+		}
+		if value != expected[i].value {
+			t.Errorf("expected sensor %d to read %v, got %v", i, expected[i].value, value)
+		}
+	}
+}
+
+func TestChipName(t *testing.T) {
+	root := makeFixture(t,
+		fixtureChip{dir: "hwmon0", device: "0000:03:00.0", subsystem: "pci"},
+		fixtureChip{dir: "hwmon1", device: "0001:c1:00.1", subsystem: "pci"},
+		fixtureChip{dir: "hwmon2", device: "coretemp.0", subsystem: "platform"},
+		fixtureChip{dir: "hwmon3", device: "nct6775.656", subsystem: "platform"},
+		fixtureChip{dir: "hwmon4", device: "LNXTHERM:00", subsystem: "acpi"},
+		fixtureChip{dir: "hwmon5", device: "6-0040", subsystem: "i2c"},
+		fixtureChip{dir: "hwmon6"},
+	)
+	for dir, expected := range map[string]string{
+		"hwmon0": "chip-pci-0300",
+		"hwmon1": "chip-pci-1c101",
+		"hwmon2": "chip-isa-0000",
+		"hwmon3": "chip-isa-0290",
+		"hwmon4": "chip-acpi-0",
+		"hwmon5": "chip-i2c-6-40",
+		"hwmon6": "chip-virtual-0",
+	} {
+		if name := chipName(filepath.Join(root, dir), "chip"); name != expected {
+			t.Errorf("expected %s to be named %q, got %q", dir, expected, name)
+		}
+	}
+}
+
+func TestSysfsScaling(t *testing.T) {
+	type testCase struct {
+		attribute string
+		raw       string
+		unit      sensors.Unit
+		expected  float64
+	}
+	files := map[string]string{"name": "scaling"}
+	cases := []testCase{
+		// Voltages are reported in millivolts.
+		{attribute: "in0_input", raw: "12500", unit: sensors.Volts, expected: 12.5},
+		// Currents are reported in milliamps.
+		{attribute: "curr1_input", raw: "1500", unit: sensors.Amps, expected: 1.5},
+		// Power is reported in microwatts.
+		{attribute: "power1_input", raw: "2500000", unit: sensors.Watts, expected: 2.5},
+		{attribute: "power2_average", raw: "7000000", unit: sensors.Watts, expected: 7},
+		// Energy is reported in microjoules.
+		{attribute: "energy1_input", raw: "3250000", unit: sensors.Joules, expected: 3.25},
+		// Temperatures are reported in millidegrees Celsius.
+		{attribute: "temp1_input", raw: "45500", unit: sensors.Celsius, expected: 45.5},
+		// Fan speeds are reported in RPM.
+		{attribute: "fan1_input", raw: "1200", unit: sensors.RPM, expected: 1200},
+	}
+	for _, tc := range cases {
+		files[tc.attribute] = tc.raw
+	}
+	root := makeFixture(t, fixtureChip{dir: "hwmon0", files: files})
+	subfeatures, err := readSubfeatures(Chip{Name: "scaling", Path: filepath.Join(root, "hwmon0")})
+	if err != nil {
+		t.Fatalf("failed reading subfeatures: %v", err)
+	}
+	bySubName := map[string]Subfeature{}
+	for _, s := range subfeatures {
+		bySubName[s.SubName] = s
+	}
+	for _, tc := range cases {
+		t.Run(tc.attribute, func(t *testing.T) {
+			s, ok := bySubName[tc.attribute]
+			if !ok {
+				t.Fatalf("subfeature %s not discovered", tc.attribute)
+			}
+			if s.Unit() != tc.unit {
+				t.Errorf("expected unit %s, got %s", tc.unit, s.Unit())
+			}
+			value, err := s.Read()
+			if err != nil {
+				t.Fatalf("failed reading: %v", err)
+			}
+			if value != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, value)
+			}
+		})
+	}
+}
+
+// TestAMDGPULayout checks an amdgpu hwmon chip laid out as the driver documents it, with the
+// average power of the GPU in microwatts alongside attributes that aren't energy.
+func TestAMDGPULayout(t *testing.T) {
+	const heading = "amdgpu-pci-0300#power1_average (W)"
+	root := makeFixture(t, fixtureChip{
+		dir:       "hwmon3",
+		device:    "0000:03:00.0",
+		subsystem: "pci",
+		files: map[string]string{
+			"name":        "amdgpu",
+			"fan1_input":  "0",
+			"freq1_input": "500000000",
+			"freq1_label": "sclk",
+			"in0_input":   "6",
+			"in0_label":   "vddgfx",
+			// Microwatts, so 35 W.
+			"power1_average": "35000000",
+			"power1_cap":     "186000000",
+			"power1_cap_max": "186000000",
+			"power1_cap_min": "0",
+			"temp1_crit":     "100000",
+			"temp1_input":    "41000",
+			"temp1_label":    "edge",
+			"temp2_input":    "43000",
+			"temp2_label":    "junction",
+		},
+	})
+	found, err := FindEnergySensorsIn(root)
+	if err != nil {
+		t.Fatalf("expected discovery to succeed, got %v", err)
+	}
+	if len(found) != 1 {
+		t.Fatalf("expected only the power average sensor, got %d sensors", len(found))
+	}
+	if got := fmt.Sprintf("%s (%s)", found[0].Name(), found[0].Unit()); got != heading {
+		t.Errorf("expected heading %q, got %q", heading, got)
+	}
+	value, err := found[0].Read()
+	if err != nil {
+		t.Fatalf("failed reading: %v", err)
+	}
+	if value != 35 {
+		t.Errorf("expected 35 W, got %v W", value)
+	}
+}
+
+// TestBatteryLayout checks the hwmon chip that the kernel registers for a laptop battery, which
+// reports voltage and current but no power.
+func TestBatteryLayout(t *testing.T) {
+	root := makeFixture(t, fixtureChip{
+		dir: "hwmon5",
+		files: map[string]string{
+			"name":        "BAT0",
+			"curr1_input": "1420",
+			"in0_input":   "12370",
+		},
+	})
+	found, err := FindEnergySensorsIn(root)
+	if err != nil {
+		t.Fatalf("expected discovery to succeed, got %v", err)
+	}
+	if len(found) != 1 {
+		t.Fatalf("expected only the synthesized power sensor, got %d sensors", len(found))
+	}
+	synthetic, ok := found[0].(SyntheticPower)
+	if !ok {
+		t.Fatalf("expected synthetic power sensor, got %T", found[0])
+	}
+	for _, tc := range []struct {
+		sensor   sensors.Sensor
+		unit     sensors.Unit
+		expected float64
+	}{
+		{sensor: synthetic.Current, unit: sensors.Amps, expected: 1.42},
+		{sensor: synthetic.Voltage, unit: sensors.Volts, expected: 12.37},
+		{sensor: synthetic, unit: sensors.Watts, expected: 1.42 * 12.37},
+	} {
+		if tc.sensor.Unit() != tc.unit {
+			t.Errorf("expected %s to be in %s, got %s", tc.sensor.Name(), tc.unit, tc.sensor.Unit())
+		}
+		value, err := tc.sensor.Read()
+		if err != nil {
+			t.Fatalf("failed reading %s: %v", tc.sensor.Name(), err)
+		}
+		if math.Abs(value-tc.expected) > 1e-9 {
+			t.Errorf("expected %s to read %v, got %v", tc.sensor.Name(), tc.expected, value)
+		}
+	}
+}
+
+func TestEnergyCounter(t *testing.T) {
+	root := makeFixture(t, fixtureChip{
+		dir: "hwmon0",
+		files: map[string]string{
+			"name":          "energy",
+			"energy1_input": "1000000",
+		},
+	})
+	found, err := FindEnergySensorsIn(root)
+	if err != nil {
+		t.Fatalf("expected discovery to succeed, got %v", err)
+	}
+	if len(found) != 1 {
+		t.Fatalf("expected one energy sensor, got %d sensors", len(found))
+	}
+	counterPath := filepath.Join(root, "hwmon0", "energy1_input")
+	for _, step := range []struct {
+		raw      string
+		expected float64
+	}{
+		// The first read reports the entire counter, which is discarded by the pre-read in
+		// the sampling loop.
+		{raw: "1000000", expected: 1},
+		{raw: "3500000", expected: 2.5},
+		{raw: "3500000", expected: 0},
+		// A reset counter reports everything accumulated since the reset.
+		{raw: "500000", expected: .5},
+	} {
+		if err := os.Chmod(counterPath, 0o644); err != nil {
+			t.Fatalf("failed making counter writable: %v", err)
+		}
+		if err := os.WriteFile(counterPath, []byte(step.raw+"\n"), 0o644); err != nil {
+			t.Fatalf("failed updating counter: %v", err)
+		}
+		value, err := found[0].Read()
+		if err != nil {
+			t.Fatalf("failed reading: %v", err)
+		}
+		if value != step.expected {
+			t.Errorf("expected counter at %s to report %v J, got %v J", step.raw, step.expected, value)
+		}
+	}
+}
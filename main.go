@@ -19,8 +19,42 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "calibrate" {
+		if err := runCalibrate(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "history" {
+		if err := runHistory(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	var traceInto string
 	flag.StringVar(&traceInto, "trace", "", "collect a go runtime trace into the given file")
+	var storage backend.StorageOptions
+	flag.IntVar(&storage.MemorySamples, "memory-samples", 0, "keep roughly this many recent samples of each series in memory, spilling older ones to disk (0 keeps every sample in memory)")
+	flag.StringVar(&storage.SpillDir, "spill-dir", "", "directory for samples spilled to disk (defaults to the system temporary directory)")
+	flag.DurationVar(&storage.Retention, "retention", 0, "discard live samples older than this (0 keeps every sample)")
+	var interpolation string
+	flag.StringVar(&interpolation, "interpolation", backend.InterpolateStepNext.String(), "how to integrate power between readings: step-next, step-previous, or linear")
+	durability := backend.DefaultDurability
+	flag.DurationVar(&durability.FlushInterval, "flush-interval", durability.FlushInterval, "longest time to buffer recorded samples before writing them to the session file (0 writes every sample)")
+	flag.IntVar(&durability.FlushBytes, "flush-bytes", durability.FlushBytes, "most bytes of recorded samples to buffer before writing them to the session file (0 writes every sample)")
+	var syncPolicy string
+	flag.StringVar(&syncPolicy, "fsync", string(durability.Sync), "when to sync recorded sessions to disk: never, close (when the session ends), or flush (whenever samples are written)")
+	var dataDir, notes string
+	flag.StringVar(&dataDir, "data-dir", "", "directory for recorded sessions and benchmarks (defaults to $XDG_DATA_HOME/watt-wiser on Linux)")
+	flag.StringVar(&notes, "notes", "", "notes to store with recorded sessions in the session index")
+	var auxiliary bool
+	flag.BoolVar(&auxiliary, "auxiliary", false, "record temperatures, fan speeds, clocks, and utilization alongside energy data when launching the sensors (throttling is always recorded)")
+	var derivations []backend.Derivation
+	flag.Func("derive", "add a series computed from others, like \"uncore (W) = package-0 - core\" (repeatable)", func(s string) error {
+		def, err := backend.ParseDerivation(s)
+		derivations = append(derivations, def)
+		return err
+	})
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), `%[1]s: visualize a csv energy trace file
 Usage:
@@ -31,11 +65,26 @@ OR
 
  watt-wiser-sensors | %[1]s [flags]
 
+OR
+
+ %[1]s calibrate -reference <series> [flags] <file>
+
+OR
+
+ %[1]s history [flags]
+
 Flags:
 `, os.Args[0])
 		flag.PrintDefaults()
 	}
 	flag.Parse()
+	interpolationMode, err := backend.ParseInterpolation(interpolation)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if durability.Sync, err = backend.ParseSyncPolicy(syncPolicy); err != nil {
+		log.Fatal(err)
+	}
 	var f *os.File
 	if traceInto != "" {
 		pprof.StartCPUProfile(io.Discard)
@@ -61,6 +110,19 @@ Flags:
 	if err != nil {
 		log.Fatalf("unable to initialize application backend: %v", err)
 	}
+	bundle.Datasource.SetStorage(storage)
+	bundle.Datasource.SetDurability(durability)
+	if dataDir != "" {
+		if err := bundle.Datasource.SetDataDir(dataDir); err != nil {
+			log.Fatal(err)
+		}
+	}
+	bundle.Datasource.SetNotes(notes)
+	bundle.Datasource.SetAuxiliary(auxiliary)
+	bundle.Datasource.SetInterpolation(interpolationMode)
+	for _, def := range derivations {
+		bundle.Datasource.AddDerivation(def)
+	}
 	go func() {
 		w := app.NewWindow(app.Title("Watt Wiser"))
 		files := []io.ReadCloser{}
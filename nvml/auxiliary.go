@@ -0,0 +1,145 @@
+package nvml
+
+import (
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+const (
+	// powerThrottleReasons are the throttle reasons indicating that a GPU is limited by
+	// its power budget.
+	powerThrottleReasons = NVML_CLOCKS_THROTTLE_REASON_SW_POWER_CAP | NVML_CLOCKS_THROTTLE_REASON_HW_POWER_BRAKE_SLOWDOWN
+	// thermalThrottleReasons are the throttle reasons indicating that a GPU is limited by
+	// its temperature. NVML_CLOCKS_THROTTLE_REASON_HW_SLOWDOWN is excluded from both sets
+	// because it may be caused by either.
+	thermalThrottleReasons = NVML_CLOCKS_THROTTLE_REASON_SW_THERMAL_SLOWDOWN | NVML_CLOCKS_THROTTLE_REASON_HW_THERMAL_SLOWDOWN
+)
+
+// querySensor reports the result of an arbitrary device query.
+type querySensor struct {
+	name  string
+	unit  sensors.Unit
+	query func() (float64, error)
+}
+
+var _ sensors.Sensor = querySensor{}
+
+func (q querySensor) Name() string {
+	return q.name
+}
+
+func (q querySensor) Unit() sensors.Unit {
+	return q.unit
+}
+
+func (q querySensor) Read() (float64, error) {
+	return q.query()
+}
+
+// throttleQuery returns a query reading 100 while any of the throttle reasons in mask are
+// active and 0 otherwise.
+func throttleQuery(reasons func() (uint64, error), mask uint64) func() (float64, error) {
+	return func() (float64, error) {
+		active, err := reasons()
+		if err != nil {
+			return 0, err
+		}
+		if active&mask != 0 {
+			return 100, nil
+		}
+		return 0, nil
+	}
+}
+
+// FindAuxiliarySensors discovers the clocks, utilization, temperature, and throttling state
+// of each NVIDIA GPU. Queries that a GPU does not support are skipped. Unless verbose, it
+// does not log the GPUs that cannot be queried.
+func FindAuxiliarySensors(verbose bool) ([]sensors.Sensor, error) {
+	d, err := loadDriver()
+	if err != nil {
+		return nil, err
+	}
+	return findAuxiliarySensors(d, logger(verbose))
+}
+
+func findAuxiliarySensors(d driver, logf func(format string, args ...any)) ([]sensors.Sensor, error) {
+	gpus, err := enumerateGPUs(d, logf)
+	if err != nil {
+		return nil, err
+	}
+	out := []sensors.Sensor{}
+	for _, g := range gpus {
+		for _, candidate := range auxiliaryCandidates(d, g) {
+			if _, err := candidate.Read(); err == nil {
+				out = append(out, candidate)
+			}
+		}
+	}
+	return out, nil
+}
+
+// auxiliaryCandidates returns a sensor for every auxiliary query, whether or not the GPU
+// supports it.
+func auxiliaryCandidates(d driver, g gpu) []sensors.Sensor {
+	device, name := g.handle, g.name
+	var candidates []sensors.Sensor
+	for _, clock := range []struct {
+		name string
+		kind nvmlClockType
+	}{
+		{"graphics clock", NVML_CLOCK_GRAPHICS},
+		{"SM clock", NVML_CLOCK_SM},
+		{"memory clock", NVML_CLOCK_MEM},
+	} {
+		kind := clock.kind
+		candidates = append(candidates, querySensor{
+			name: name + " " + clock.name,
+			unit: sensors.Hertz,
+			query: func() (float64, error) {
+				mhz, err := d.DeviceGetClockInfo(device, kind)
+				return float64(mhz) * 1_000_000, err
+			},
+		})
+	}
+	candidates = append(candidates,
+		querySensor{
+			name: name + " GPU utilization",
+			unit: sensors.Percent,
+			query: func() (float64, error) {
+				utilization, err := d.DeviceGetUtilizationRates(device)
+				return float64(utilization.Gpu), err
+			},
+		},
+		querySensor{
+			name: name + " memory utilization",
+			unit: sensors.Percent,
+			query: func() (float64, error) {
+				utilization, err := d.DeviceGetUtilizationRates(device)
+				return float64(utilization.Memory), err
+			},
+		},
+	)
+	candidates = append(candidates, querySensor{
+		name: name + " temperature",
+		unit: sensors.Celsius,
+		query: func() (float64, error) {
+			celsius, err := d.DeviceGetTemperature(device, NVML_TEMPERATURE_GPU)
+			return float64(celsius), err
+		},
+	})
+	reasons := func() (uint64, error) {
+		return d.DeviceGetCurrentClocksThrottleReasons(device)
+	}
+	candidates = append(candidates,
+		querySensor{
+			name:  name + " power" + sensors.ThrottleSuffix,
+			unit:  sensors.Percent,
+			query: throttleQuery(reasons, powerThrottleReasons),
+		},
+		querySensor{
+			name:  name + " thermal" + sensors.ThrottleSuffix,
+			unit:  sensors.Percent,
+			query: throttleQuery(reasons, thermalThrottleReasons),
+		},
+	)
+	return candidates
+}
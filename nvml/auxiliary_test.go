@@ -0,0 +1,38 @@
+package nvml
+
+import "testing"
+
+func TestThrottleQuery(t *testing.T) {
+	type testcase struct {
+		name           string
+		reasons        uint64
+		power, thermal float64
+	}
+	for _, tc := range []testcase{
+		{name: "none", reasons: 0},
+		{name: "idle", reasons: NVML_CLOCKS_THROTTLE_REASON_GPU_IDLE},
+		{name: "power cap", reasons: NVML_CLOCKS_THROTTLE_REASON_SW_POWER_CAP, power: 100},
+		{name: "power brake", reasons: NVML_CLOCKS_THROTTLE_REASON_HW_POWER_BRAKE_SLOWDOWN, power: 100},
+		{name: "thermal", reasons: NVML_CLOCKS_THROTTLE_REASON_HW_THERMAL_SLOWDOWN, thermal: 100},
+		{name: "ambiguous hardware slowdown", reasons: NVML_CLOCKS_THROTTLE_REASON_HW_SLOWDOWN},
+		{name: "both", reasons: NVML_CLOCKS_THROTTLE_REASON_SW_POWER_CAP | NVML_CLOCKS_THROTTLE_REASON_SW_THERMAL_SLOWDOWN, power: 100, thermal: 100},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			reasons := func() (uint64, error) { return tc.reasons, nil }
+			power, err := throttleQuery(reasons, powerThrottleReasons)()
+			if err != nil {
+				t.Fatalf("failed reading power throttling: %v", err)
+			}
+			if power != tc.power {
+				t.Errorf("expected power throttling %v, got %v", tc.power, power)
+			}
+			thermal, err := throttleQuery(reasons, thermalThrottleReasons)()
+			if err != nil {
+				t.Fatalf("failed reading thermal throttling: %v", err)
+			}
+			if thermal != tc.thermal {
+				t.Errorf("expected thermal throttling %v, got %v", tc.thermal, thermal)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package nvml
+
+import (
+	"errors"
+	"sync"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+// driver is the set of NVML queries used by this package. The library implementation
+// calls into the dynamically loaded NVML, and tests substitute a fake so that sensor
+// discovery and reading can be exercised without NVIDIA hardware. Queries that the loaded
+// library does not provide fail with NVML_ERROR_FUNCTION_NOT_FOUND.
+type driver interface {
+	Init() error
+	SystemGetNVMLVersion() (string, error)
+	DeviceGetCount() (uint64, error)
+	DeviceGetHandleByIndex(i uint64) (uintptr, error)
+	DeviceGetName(device uintptr) (string, error)
+	// DeviceGetTotalEnergyConsumption returns the millijoules consumed since the driver
+	// was loaded.
+	DeviceGetTotalEnergyConsumption(device uintptr) (uint64, error)
+	// DeviceGetPowerUsage returns the current power draw in milliwatts.
+	DeviceGetPowerUsage(device uintptr) (uint32, error)
+	// DeviceGetProcessUtilization returns the utilization samples recorded since lastSeen
+	// (in microseconds).
+	DeviceGetProcessUtilization(device uintptr, lastSeen uint64) ([]nvmlProcessUtilizationSample, error)
+	// DeviceGetRunningProcesses returns the compute and graphics processes with a context
+	// on the device.
+	DeviceGetRunningProcesses(device uintptr) ([]nvmlProcessInfo, error)
+	DeviceGetClockInfo(device uintptr, clock nvmlClockType) (uint32, error)
+	DeviceGetUtilizationRates(device uintptr) (nvmlUtilization, error)
+	DeviceGetTemperature(device uintptr, sensor nvmlTemperatureSensor) (uint32, error)
+	DeviceGetCurrentClocksThrottleReasons(device uintptr) (uint64, error)
+}
+
+// libraryDriver implements driver using the wrapper funcs populated by platformInit.
+type libraryDriver struct{}
+
+var _ driver = libraryDriver{}
+
+// loadDriver loads the NVML library and returns a driver using it.
+func loadDriver() (driver, error) {
+	if err := load(); err != nil {
+		return nil, err
+	}
+	return libraryDriver{}, nil
+}
+
+var (
+	// initMu protects initialized.
+	initMu sync.Mutex
+	// initialized tracks whether nvmlInit has succeeded. NVML counts its initializations,
+	// so it is only initialized once rather than on every discovery. A failed
+	// initialization is retried, since the driver may load later.
+	initialized bool
+)
+
+func (libraryDriver) Init() error {
+	initMu.Lock()
+	defer initMu.Unlock()
+	if initialized {
+		return nil
+	}
+	if err := nvmlInit(); err != nil {
+		return err
+	}
+	initialized = true
+	return nil
+}
+
+func (libraryDriver) SystemGetNVMLVersion() (string, error) {
+	return nvmlSystemGetNVMLVersion()
+}
+
+func (libraryDriver) DeviceGetCount() (uint64, error) {
+	return nvmlDeviceGetCount()
+}
+
+func (libraryDriver) DeviceGetHandleByIndex(i uint64) (uintptr, error) {
+	return nvmlDeviceGetHandleByIndex(i)
+}
+
+func (libraryDriver) DeviceGetName(device uintptr) (string, error) {
+	return nvmlDeviceGetName(device)
+}
+
+func (libraryDriver) DeviceGetTotalEnergyConsumption(device uintptr) (uint64, error) {
+	if nvmlDeviceGetTotalEnergyConsumption == nil {
+		return 0, NVML_ERROR_FUNCTION_NOT_FOUND
+	}
+	return nvmlDeviceGetTotalEnergyConsumption(device)
+}
+
+func (libraryDriver) DeviceGetPowerUsage(device uintptr) (uint32, error) {
+	return nvmlDeviceGetPowerUsage(device)
+}
+
+func (libraryDriver) DeviceGetProcessUtilization(device uintptr, lastSeen uint64) ([]nvmlProcessUtilizationSample, error) {
+	if nvmlDeviceGetProcessUtilization == nil {
+		return nil, NVML_ERROR_FUNCTION_NOT_FOUND
+	}
+	return nvmlDeviceGetProcessUtilization(device, lastSeen)
+}
+
+func (libraryDriver) DeviceGetRunningProcesses(device uintptr) ([]nvmlProcessInfo, error) {
+	var out []nvmlProcessInfo
+	found := false
+	for _, query := range []func(uintptr) ([]nvmlProcessInfo, error){
+		nvmlDeviceGetComputeRunningProcesses,
+		nvmlDeviceGetGraphicsRunningProcesses,
+	} {
+		if query == nil {
+			continue
+		}
+		found = true
+		infos, err := query(device)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, infos...)
+	}
+	if !found {
+		return nil, NVML_ERROR_FUNCTION_NOT_FOUND
+	}
+	return out, nil
+}
+
+func (libraryDriver) DeviceGetClockInfo(device uintptr, clock nvmlClockType) (uint32, error) {
+	if nvmlDeviceGetClockInfo == nil {
+		return 0, NVML_ERROR_FUNCTION_NOT_FOUND
+	}
+	return nvmlDeviceGetClockInfo(device, clock)
+}
+
+func (libraryDriver) DeviceGetUtilizationRates(device uintptr) (nvmlUtilization, error) {
+	if nvmlDeviceGetUtilizationRates == nil {
+		return nvmlUtilization{}, NVML_ERROR_FUNCTION_NOT_FOUND
+	}
+	return nvmlDeviceGetUtilizationRates(device)
+}
+
+func (libraryDriver) DeviceGetTemperature(device uintptr, sensor nvmlTemperatureSensor) (uint32, error) {
+	if nvmlDeviceGetTemperature == nil {
+		return 0, NVML_ERROR_FUNCTION_NOT_FOUND
+	}
+	return nvmlDeviceGetTemperature(device, sensor)
+}
+
+func (libraryDriver) DeviceGetCurrentClocksThrottleReasons(device uintptr) (uint64, error) {
+	if nvmlDeviceGetCurrentClocksThrottleReasons == nil {
+		return 0, NVML_ERROR_FUNCTION_NOT_FOUND
+	}
+	return nvmlDeviceGetCurrentClocksThrottleReasons(device)
+}
+
+// errorPolicy classifies NVML errors for the sensors of this package.
+func errorPolicy(err error) (sensors.ErrorPolicy, bool) {
+	var nvmlErr nvmlError
+	if !errors.As(err, &nvmlErr) {
+		return 0, false
+	}
+	switch nvmlErr {
+	case NVML_ERROR_GPU_IS_LOST, NVML_ERROR_RESET_REQUIRED, NVML_ERROR_GPU_NOT_FOUND, NVML_ERROR_DRIVER_NOT_LOADED, NVML_ERROR_NOT_SUPPORTED:
+		return sensors.Drop, true
+	case NVML_ERROR_TIMEOUT, NVML_ERROR_IN_USE, NVML_ERROR_NOT_READY, NVML_ERROR_NO_DATA:
+		return sensors.Retry, true
+	default:
+		return 0, false
+	}
+}
+
+func (s *sensor) ErrorPolicy(err error) (sensors.ErrorPolicy, bool) {
+	return errorPolicy(err)
+}
+
+func (p *processSensor) ErrorPolicy(err error) (sensors.ErrorPolicy, bool) {
+	return errorPolicy(err)
+}
+
+func (q querySensor) ErrorPolicy(err error) (sensors.ErrorPolicy, bool) {
+	return errorPolicy(err)
+}
@@ -0,0 +1,124 @@
+package nvml
+
+// result is a scripted response to a query.
+type result[T any] struct {
+	value T
+	err   error
+}
+
+// script replays its results in order, repeating the final one once exhausted. An empty
+// script reports that the query is unsupported.
+type script[T any] []result[T]
+
+func (s *script[T]) next() (T, error) {
+	if len(*s) == 0 {
+		var zero T
+		return zero, NVML_ERROR_NOT_SUPPORTED
+	}
+	r := (*s)[0]
+	if len(*s) > 1 {
+		*s = (*s)[1:]
+	}
+	return r.value, r.err
+}
+
+// values scripts a query to succeed with each of the values in turn.
+func values[T any](vals ...T) script[T] {
+	s := make(script[T], len(vals))
+	for i, v := range vals {
+		s[i].value = v
+	}
+	return s
+}
+
+// failure scripts a query to fail with err.
+func failure[T any](err error) script[T] {
+	return script[T]{{err: err}}
+}
+
+// fakeDevice holds the scripted query responses of a fake GPU.
+type fakeDevice struct {
+	name string
+	// handleErr, if set, is returned when acquiring the device's handle.
+	handleErr          error
+	energy             script[uint64]
+	power              script[uint32]
+	processUtilization script[[]nvmlProcessUtilizationSample]
+	runningProcesses   script[[]nvmlProcessInfo]
+	clock              script[uint32]
+	utilization        script[nvmlUtilization]
+	temperature        script[uint32]
+	throttleReasons    script[uint64]
+	// lastSeen records the lastSeen argument of the latest process utilization query.
+	lastSeen uint64
+}
+
+// fakeDriver is a scriptable driver for testing without NVIDIA hardware.
+type fakeDriver struct {
+	initErr error
+	devices []*fakeDevice
+}
+
+var _ driver = (*fakeDriver)(nil)
+
+// device returns the fake device for a handle. Handles are one more than the device's
+// index, so that the zero handle is invalid.
+func (f *fakeDriver) device(handle uintptr) *fakeDevice {
+	return f.devices[handle-1]
+}
+
+func (f *fakeDriver) Init() error {
+	return f.initErr
+}
+
+func (f *fakeDriver) SystemGetNVMLVersion() (string, error) {
+	return "12.535.0", nil
+}
+
+func (f *fakeDriver) DeviceGetCount() (uint64, error) {
+	return uint64(len(f.devices)), nil
+}
+
+func (f *fakeDriver) DeviceGetHandleByIndex(i uint64) (uintptr, error) {
+	if err := f.devices[i].handleErr; err != nil {
+		return 0, err
+	}
+	return uintptr(i + 1), nil
+}
+
+func (f *fakeDriver) DeviceGetName(device uintptr) (string, error) {
+	return f.device(device).name, nil
+}
+
+func (f *fakeDriver) DeviceGetTotalEnergyConsumption(device uintptr) (uint64, error) {
+	return f.device(device).energy.next()
+}
+
+func (f *fakeDriver) DeviceGetPowerUsage(device uintptr) (uint32, error) {
+	return f.device(device).power.next()
+}
+
+func (f *fakeDriver) DeviceGetProcessUtilization(device uintptr, lastSeen uint64) ([]nvmlProcessUtilizationSample, error) {
+	f.device(device).lastSeen = lastSeen
+	return f.device(device).processUtilization.next()
+}
+
+func (f *fakeDriver) DeviceGetRunningProcesses(device uintptr) ([]nvmlProcessInfo, error) {
+	return f.device(device).runningProcesses.next()
+}
+
+func (f *fakeDriver) DeviceGetClockInfo(device uintptr, clock nvmlClockType) (uint32, error) {
+	return f.device(device).clock.next()
+}
+
+func (f *fakeDriver) DeviceGetUtilizationRates(device uintptr) (nvmlUtilization, error) {
+	return f.device(device).utilization.next()
+}
+
+func (f *fakeDriver) DeviceGetTemperature(device uintptr, sensor nvmlTemperatureSensor) (uint32, error) {
+	return f.device(device).temperature.next()
+}
+
+func (f *fakeDriver) DeviceGetCurrentClocksThrottleReasons(device uintptr) (uint64, error) {
+	return f.device(device).throttleReasons.next()
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"time"
 
 	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
 )
@@ -24,6 +25,15 @@ var (
 	nvmlDeviceGetArchitecture           func(device uintptr) (nvmlDeviceArchitecture, error)
 	nvmlDeviceGetTotalEnergyConsumption func(device uintptr) (uint64, error)
 	nvmlDeviceGetPowerUsage             func(device uintptr) (uint32, error)
+	// Per-process queries, used to attribute device energy to processes.
+	nvmlDeviceGetProcessUtilization       func(device uintptr, lastSeen uint64) ([]nvmlProcessUtilizationSample, error)
+	nvmlDeviceGetComputeRunningProcesses  func(device uintptr) ([]nvmlProcessInfo, error)
+	nvmlDeviceGetGraphicsRunningProcesses func(device uintptr) ([]nvmlProcessInfo, error)
+	// Device state queries, used for auxiliary sensors.
+	nvmlDeviceGetClockInfo                    func(device uintptr, clock nvmlClockType) (uint32, error)
+	nvmlDeviceGetUtilizationRates             func(device uintptr) (nvmlUtilization, error)
+	nvmlDeviceGetTemperature                  func(device uintptr, sensor nvmlTemperatureSensor) (uint32, error)
+	nvmlDeviceGetCurrentClocksThrottleReasons func(device uintptr) (uint64, error)
 )
 
 func load() error {
@@ -33,77 +43,112 @@ func load() error {
 	return initErr
 }
 
-func FindGPUSensors() ([]sensors.Sensor, error) {
-	if err := load(); err != nil {
-		return nil, err
+// gpu is a device found by enumerateGPUs.
+type gpu struct {
+	handle uintptr
+	name   string
+}
+
+// logger returns log.Printf if verbose, and a func discarding its input otherwise. This
+// lets repeated discovery avoid logging the same devices over and over.
+func logger(verbose bool) func(format string, args ...any) {
+	if verbose {
+		return log.Printf
 	}
-	if err := nvmlInit(); err != nil {
+	return func(string, ...any) {}
+}
+
+// enumerateGPUs initializes NVML and lists the devices that can be queried, logging any
+// that cannot.
+func enumerateGPUs(d driver, logf func(format string, args ...any)) ([]gpu, error) {
+	if err := d.Init(); err != nil {
 		return nil, fmt.Errorf("failed initializing nvml: %w", err)
 	}
-	version, err := nvmlSystemGetNVMLVersion()
-	if err != nil {
-		return nil, fmt.Errorf("failed querying nvml version: %w", err)
-	}
-	log.Printf("Using NVML version %q", version)
-	count, err := nvmlDeviceGetCount()
+	count, err := d.DeviceGetCount()
 	if err != nil {
 		return nil, fmt.Errorf("failed counting gpus: %w", err)
 	}
-	out := []sensors.Sensor{}
+	out := make([]gpu, 0, count)
 	for i := uint64(0); i < count; i++ {
-		device, err := nvmlDeviceGetHandleByIndex(i)
+		device, err := d.DeviceGetHandleByIndex(i)
 		if err != nil {
-			log.Printf("failed acquiring handle to NVIDIA GPU at index %d: %v", i, err)
+			logf("failed acquiring handle to NVIDIA GPU at index %d: %v", i, err)
 			continue
 		}
-		name, err := nvmlDeviceGetName(device)
+		name, err := d.DeviceGetName(device)
 		if err != nil {
-			log.Printf("failed loading NVIDIA GPU name at index %d: %v", i, err)
+			logf("failed loading NVIDIA GPU name at index %d: %v", i, err)
 			continue
 		}
-		s := &sensor{
-			name:   name,
-			device: device,
-		}
-		if nvmlDeviceGetTotalEnergyConsumption != nil {
-			// If we can't query architecture, but we can check energy, just try reading
-			// energy to check if it's supported.
-			_, err = nvmlDeviceGetTotalEnergyConsumption(device)
-			if err != nil {
-				err = fmt.Errorf("unable to read total energy consumption: %w", err)
-				_, perr := nvmlDeviceGetPowerUsage(device)
-				if perr != nil {
-					err = errors.Join(fmt.Errorf("unable to read power usage: %w", perr), err)
-					// This device does not support power monitoring of any kind.
-					log.Printf("discarding NVIDIA GPU %q because does not support power monitoring: %v", name, err)
-					continue
-				}
-				s.unit = sensors.Watts
-			} else {
-				s.unit = sensors.Joules
-			}
-			out = append(out, s)
+		out = append(out, gpu{handle: device, name: name})
+	}
+	return out, nil
+}
+
+// FindGPUSensors returns the energy and power sensors of each NVIDIA GPU. Unless verbose,
+// it does not log the GPUs it discards or the NVML version.
+func FindGPUSensors(verbose bool) ([]sensors.Sensor, error) {
+	d, err := loadDriver()
+	if err != nil {
+		return nil, err
+	}
+	return findGPUSensors(d, logger(verbose))
+}
+
+// findGPUSensors returns an energy sensor for each GPU supporting energy queries, and a
+// power sensor for each GPU supporting power queries.
+func findGPUSensors(d driver, logf func(format string, args ...any)) ([]sensors.Sensor, error) {
+	gpus, err := enumerateGPUs(d, logf)
+	if err != nil {
+		return nil, err
+	}
+	version, err := d.SystemGetNVMLVersion()
+	if err != nil {
+		return nil, fmt.Errorf("failed querying nvml version: %w", err)
+	}
+	logf("Using NVML version %q", version)
+	out := []sensors.Sensor{}
+	for _, g := range gpus {
+		energySensor, energyErr := newSensor(d, g, sensors.Joules)
+		if energyErr == nil {
+			out = append(out, energySensor)
 		}
-		s = &sensor{
-			name:   name,
-			device: device,
+		powerSensor, powerErr := newSensor(d, g, sensors.Watts)
+		if powerErr == nil {
+			out = append(out, powerSensor)
 		}
-		_, err = nvmlDeviceGetPowerUsage(device)
-		if err != nil {
+		if energyErr != nil && powerErr != nil {
 			// This device does not support power monitoring of any kind.
-			log.Printf("discarding NVIDIA GPU %q because does not support power monitoring: %v", name, err)
-			continue
+			logf("discarding NVIDIA GPU %q because does not support power monitoring: %v", g.name, errors.Join(energyErr, powerErr))
 		}
-		s.unit = sensors.Watts
-		out = append(out, s)
 	}
 	return out, nil
 }
 
+// newSensor returns a sensor reporting the energy (in Joules) or power (in Watts) of the
+// GPU, or an error if the GPU cannot report it. This reads the sensor once, so energy
+// sensors will report the energy consumed since their creation.
+func newSensor(d driver, g gpu, unit sensors.Unit) (*sensor, error) {
+	s := &sensor{
+		name:   g.name,
+		unit:   unit,
+		device: g.handle,
+		driver: d,
+	}
+	if _, err := s.Read(); err != nil {
+		if unit == sensors.Joules {
+			return nil, fmt.Errorf("unable to read total energy consumption: %w", err)
+		}
+		return nil, fmt.Errorf("unable to read power usage: %w", err)
+	}
+	return s, nil
+}
+
 type sensor struct {
 	name       string
 	unit       sensors.Unit
 	device     uintptr
+	driver     driver
 	lastReadMJ uint64
 }
 
@@ -117,13 +162,13 @@ func (s *sensor) Unit() sensors.Unit {
 
 func (s *sensor) Read() (float64, error) {
 	if s.unit == sensors.Watts {
-		mW, err := nvmlDeviceGetPowerUsage(s.device)
+		mW, err := s.driver.DeviceGetPowerUsage(s.device)
 		if err != nil {
 			return 0, err
 		}
 		return float64(mW) / 1_000, nil
 	}
-	mJ, err := nvmlDeviceGetTotalEnergyConsumption(s.device)
+	mJ, err := s.driver.DeviceGetTotalEnergyConsumption(s.device)
 	if err != nil {
 		return 0, err
 	}
@@ -131,17 +176,46 @@ func (s *sensor) Read() (float64, error) {
 	return float64(mJ) / 1_000, nil
 }
 
-var _ sensors.Sensor = (*sensor)(nil)
+// powerUsageInterval is the window that NVML averages power usage over.
+const powerUsageInterval = time.Second
+
+// UpdateInterval returns the interval at which power usage readings change, or zero for
+// energy counters, which can be read at any interval.
+func (s *sensor) UpdateInterval() time.Duration {
+	if s.unit == sensors.Watts {
+		return powerUsageInterval
+	}
+	return 0
+}
+
+// AveragingWindow returns the window that power usage readings are averaged over, or zero
+// for energy counters.
+func (s *sensor) AveragingWindow() time.Duration {
+	return s.UpdateInterval()
+}
+
+var (
+	_ sensors.Sensor           = (*sensor)(nil)
+	_ sensors.UpdateIntervaler = (*sensor)(nil)
+	_ sensors.Averager         = (*sensor)(nil)
+)
 
 const (
-	symbolNvmlInit_v2                         string = "nvmlInit_v2"
-	symbolNvmlSystemGetNVMLVersion            string = "nvmlSystemGetNVMLVersion"
-	symbolNvmlDeviceGetCount_v2               string = "nvmlDeviceGetCount_v2"
-	symbolNvmlDeviceGetHandleByIndex_v2       string = "nvmlDeviceGetHandleByIndex_v2"
-	symbolNvmlDeviceGetName                   string = "nvmlDeviceGetName"
-	symbolNvmlDeviceGetTotalEnergyConsumption string = "nvmlDeviceGetTotalEnergyConsumption"
-	symbolNvmlDeviceGetPowerUsage             string = "nvmlDeviceGetPowerUsage"
-	symbolNvmlDeviceGetArchitecture           string = "nvmlDeviceGetArchitecture"
+	symbolNvmlInit_v2                          string = "nvmlInit_v2"
+	symbolNvmlSystemGetNVMLVersion             string = "nvmlSystemGetNVMLVersion"
+	symbolNvmlDeviceGetCount_v2                string = "nvmlDeviceGetCount_v2"
+	symbolNvmlDeviceGetHandleByIndex_v2        string = "nvmlDeviceGetHandleByIndex_v2"
+	symbolNvmlDeviceGetName                    string = "nvmlDeviceGetName"
+	symbolNvmlDeviceGetTotalEnergyConsumption  string = "nvmlDeviceGetTotalEnergyConsumption"
+	symbolNvmlDeviceGetPowerUsage              string = "nvmlDeviceGetPowerUsage"
+	symbolNvmlDeviceGetArchitecture            string = "nvmlDeviceGetArchitecture"
+	symbolNvmlDeviceGetProcessUtilization      string = "nvmlDeviceGetProcessUtilization"
+	symbolNvmlDeviceGetComputeRunningProcs_v3  string = "nvmlDeviceGetComputeRunningProcesses_v3"
+	symbolNvmlDeviceGetGraphicsRunningProcs_v3 string = "nvmlDeviceGetGraphicsRunningProcesses_v3"
+	symbolNvmlDeviceGetClockInfo               string = "nvmlDeviceGetClockInfo"
+	symbolNvmlDeviceGetUtilizationRates        string = "nvmlDeviceGetUtilizationRates"
+	symbolNvmlDeviceGetTemperature             string = "nvmlDeviceGetTemperature"
+	symbolNvmlDeviceGetCurrentClocksThrottle   string = "nvmlDeviceGetCurrentClocksThrottleReasons"
 )
 
 var (
@@ -156,6 +230,13 @@ var (
 	optionalSymbols = []string{
 		symbolNvmlDeviceGetTotalEnergyConsumption,
 		symbolNvmlDeviceGetArchitecture,
+		symbolNvmlDeviceGetProcessUtilization,
+		symbolNvmlDeviceGetComputeRunningProcs_v3,
+		symbolNvmlDeviceGetGraphicsRunningProcs_v3,
+		symbolNvmlDeviceGetClockInfo,
+		symbolNvmlDeviceGetUtilizationRates,
+		symbolNvmlDeviceGetTemperature,
+		symbolNvmlDeviceGetCurrentClocksThrottle,
 	}
 )
 
@@ -173,6 +254,60 @@ const (
 
 type nvmlDeviceArchitecture uint32
 
+// nvmlProcessUtilizationSample mirrors nvmlProcessUtilizationSample_t.
+type nvmlProcessUtilizationSample struct {
+	Pid       uint32
+	TimeStamp uint64 // CPU timestamp in microseconds
+	SmUtil    uint32 // SM (3D/compute) utilization percentage
+	MemUtil   uint32 // Frame buffer memory utilization percentage
+	EncUtil   uint32 // Encoder utilization percentage
+	DecUtil   uint32 // Decoder utilization percentage
+}
+
+// nvmlProcessInfo mirrors nvmlProcessInfo_v3_t.
+type nvmlProcessInfo struct {
+	Pid               uint32
+	UsedGpuMemory     uint64 // Bytes of GPU memory used, or NVML_VALUE_NOT_AVAILABLE
+	GpuInstanceId     uint32
+	ComputeInstanceId uint32
+}
+
+type nvmlClockType uint32
+
+const (
+	NVML_CLOCK_GRAPHICS nvmlClockType = 0 // Graphics clock domain
+	NVML_CLOCK_SM       nvmlClockType = 1 // SM clock domain
+	NVML_CLOCK_MEM      nvmlClockType = 2 // Memory clock domain
+)
+
+type nvmlTemperatureSensor uint32
+
+const (
+	NVML_TEMPERATURE_GPU nvmlTemperatureSensor = 0 // Temperature sensor for the GPU die
+)
+
+// nvmlUtilization mirrors nvmlUtilization_t.
+type nvmlUtilization struct {
+	Gpu    uint32 // Percent of time over the past sample period during which kernels were executing
+	Memory uint32 // Percent of time over the past sample period during which memory was being read or written
+}
+
+// Bits of the clocks throttle reasons bitmask.
+const (
+	NVML_CLOCKS_THROTTLE_REASON_GPU_IDLE                    uint64 = 0x1   // Nothing is running on the GPU
+	NVML_CLOCKS_THROTTLE_REASON_APPLICATIONS_CLOCKS_SETTING uint64 = 0x2   // Clocks are limited by the applications clocks setting
+	NVML_CLOCKS_THROTTLE_REASON_SW_POWER_CAP                uint64 = 0x4   // The software power scaling algorithm is reducing clocks
+	NVML_CLOCKS_THROTTLE_REASON_HW_SLOWDOWN                 uint64 = 0x8   // Hardware slowdown, due to temperature or power
+	NVML_CLOCKS_THROTTLE_REASON_SYNC_BOOST                  uint64 = 0x10  // Clocks are synchronized with other GPUs
+	NVML_CLOCKS_THROTTLE_REASON_SW_THERMAL_SLOWDOWN         uint64 = 0x20  // Software thermal slowdown
+	NVML_CLOCKS_THROTTLE_REASON_HW_THERMAL_SLOWDOWN         uint64 = 0x40  // Hardware thermal slowdown
+	NVML_CLOCKS_THROTTLE_REASON_HW_POWER_BRAKE_SLOWDOWN     uint64 = 0x80  // Hardware power brake slowdown
+	NVML_CLOCKS_THROTTLE_REASON_DISPLAY_CLOCK_SETTING       uint64 = 0x100 // Clocks are limited by the display clock setting
+)
+
+// NVML_VALUE_NOT_AVAILABLE is reported in place of values that could not be queried.
+const NVML_VALUE_NOT_AVAILABLE = ^uint64(0)
+
 type nvmlError uint32
 
 const (
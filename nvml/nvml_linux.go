@@ -1,4 +1,4 @@
-//go:build linux
+//go:build linux && cgo
 
 package nvml
 
@@ -90,6 +90,49 @@ typedef nvmlReturn_t (*nvmlDeviceGetArchitecture_type)(nvmlDevice_t device, nvml
 nvmlReturn_t call_nvmlDeviceGetArchitecture(void *func, nvmlDevice_t device, nvmlDeviceArchitecture_t *arch) {
 	return ((nvmlDeviceGetArchitecture_type) func)(device, arch);
 }
+
+// The sample and process info buffers are passed as void pointers to arrays of the
+// equivalent Go structs, which share their layout.
+typedef nvmlReturn_t (*nvmlDeviceGetProcessUtilization_type)(nvmlDevice_t device, void *utilization, unsigned int *processSamplesCount, unsigned long long lastSeenTimeStamp);
+
+nvmlReturn_t call_nvmlDeviceGetProcessUtilization(void *func, nvmlDevice_t device, void *utilization, unsigned int *processSamplesCount, unsigned long long lastSeenTimeStamp) {
+	return ((nvmlDeviceGetProcessUtilization_type) func)(device, utilization, processSamplesCount, lastSeenTimeStamp);
+}
+
+typedef nvmlReturn_t (*nvmlDeviceGetRunningProcesses_v3_type)(nvmlDevice_t device, unsigned int *infoCount, void *infos);
+
+nvmlReturn_t call_nvmlDeviceGetRunningProcesses_v3(void *func, nvmlDevice_t device, unsigned int *infoCount, void *infos) {
+	return ((nvmlDeviceGetRunningProcesses_v3_type) func)(device, infoCount, infos);
+}
+
+typedef struct {
+	unsigned int gpu;
+	unsigned int memory;
+} nvmlUtilization_t;
+
+typedef nvmlReturn_t (*nvmlDeviceGetClockInfo_type)(nvmlDevice_t device, unsigned int type, unsigned int *clock);
+
+nvmlReturn_t call_nvmlDeviceGetClockInfo(void *func, nvmlDevice_t device, unsigned int type, unsigned int *clock) {
+	return ((nvmlDeviceGetClockInfo_type) func)(device, type, clock);
+}
+
+typedef nvmlReturn_t (*nvmlDeviceGetUtilizationRates_type)(nvmlDevice_t device, nvmlUtilization_t *utilization);
+
+nvmlReturn_t call_nvmlDeviceGetUtilizationRates(void *func, nvmlDevice_t device, nvmlUtilization_t *utilization) {
+	return ((nvmlDeviceGetUtilizationRates_type) func)(device, utilization);
+}
+
+typedef nvmlReturn_t (*nvmlDeviceGetTemperature_type)(nvmlDevice_t device, unsigned int sensorType, unsigned int *temp);
+
+nvmlReturn_t call_nvmlDeviceGetTemperature(void *func, nvmlDevice_t device, unsigned int sensorType, unsigned int *temp) {
+	return ((nvmlDeviceGetTemperature_type) func)(device, sensorType, temp);
+}
+
+typedef nvmlReturn_t (*nvmlDeviceGetCurrentClocksThrottleReasons_type)(nvmlDevice_t device, unsigned long long *clocksThrottleReasons);
+
+nvmlReturn_t call_nvmlDeviceGetCurrentClocksThrottleReasons(void *func, nvmlDevice_t device, unsigned long long *clocksThrottleReasons) {
+	return ((nvmlDeviceGetCurrentClocksThrottleReasons_type) func)(device, clocksThrottleReasons);
+}
 */
 import "C"
 import (
@@ -220,5 +263,117 @@ func platformInit() error {
 			return 0, rc
 		}
 	}
+	if getUtilization, ok := resolved[symbolNvmlDeviceGetProcessUtilization]; ok {
+		nvmlDeviceGetProcessUtilization = func(device uintptr, lastSeen uint64) ([]nvmlProcessUtilizationSample, error) {
+			samples := make([]nvmlProcessUtilizationSample, 32)
+			for {
+				count := C.uint(len(samples))
+				rc := nvmlError(C.call_nvmlDeviceGetProcessUtilization(
+					getUtilization,
+					*(*C.nvmlDevice_t)(unsafe.Pointer(&device)),
+					unsafe.Pointer(&samples[0]),
+					&count,
+					C.ulonglong(lastSeen)),
+				)
+				if errors.Is(rc, NVML_ERROR_INSUFFICIENT_SIZE) && int(count) > len(samples) {
+					samples = make([]nvmlProcessUtilizationSample, count)
+					continue
+				}
+				if errors.Is(rc, NVML_SUCCESS) {
+					return samples[:count], nil
+				}
+				return nil, rc
+			}
+		}
+	}
+	if getClock, ok := resolved[symbolNvmlDeviceGetClockInfo]; ok {
+		nvmlDeviceGetClockInfo = func(device uintptr, clock nvmlClockType) (uint32, error) {
+			var mhz C.uint
+			rc := nvmlError(C.call_nvmlDeviceGetClockInfo(
+				getClock,
+				*(*C.nvmlDevice_t)(unsafe.Pointer(&device)),
+				C.uint(clock),
+				&mhz),
+			)
+			if errors.Is(rc, NVML_SUCCESS) {
+				return uint32(mhz), nil
+			}
+			return 0, rc
+		}
+	}
+	if getUtilization, ok := resolved[symbolNvmlDeviceGetUtilizationRates]; ok {
+		nvmlDeviceGetUtilizationRates = func(device uintptr) (nvmlUtilization, error) {
+			var utilization C.nvmlUtilization_t
+			rc := nvmlError(C.call_nvmlDeviceGetUtilizationRates(
+				getUtilization,
+				*(*C.nvmlDevice_t)(unsafe.Pointer(&device)),
+				&utilization),
+			)
+			if errors.Is(rc, NVML_SUCCESS) {
+				return nvmlUtilization{Gpu: uint32(utilization.gpu), Memory: uint32(utilization.memory)}, nil
+			}
+			return nvmlUtilization{}, rc
+		}
+	}
+	if getTemperature, ok := resolved[symbolNvmlDeviceGetTemperature]; ok {
+		nvmlDeviceGetTemperature = func(device uintptr, sensor nvmlTemperatureSensor) (uint32, error) {
+			var celsius C.uint
+			rc := nvmlError(C.call_nvmlDeviceGetTemperature(
+				getTemperature,
+				*(*C.nvmlDevice_t)(unsafe.Pointer(&device)),
+				C.uint(sensor),
+				&celsius),
+			)
+			if errors.Is(rc, NVML_SUCCESS) {
+				return uint32(celsius), nil
+			}
+			return 0, rc
+		}
+	}
+	if getThrottle, ok := resolved[symbolNvmlDeviceGetCurrentClocksThrottle]; ok {
+		nvmlDeviceGetCurrentClocksThrottleReasons = func(device uintptr) (uint64, error) {
+			var reasons C.ulonglong
+			rc := nvmlError(C.call_nvmlDeviceGetCurrentClocksThrottleReasons(
+				getThrottle,
+				*(*C.nvmlDevice_t)(unsafe.Pointer(&device)),
+				&reasons),
+			)
+			if errors.Is(rc, NVML_SUCCESS) {
+				return uint64(reasons), nil
+			}
+			return 0, rc
+		}
+	}
+	nvmlDeviceGetComputeRunningProcesses = runningProcessesWrapper(resolved, symbolNvmlDeviceGetComputeRunningProcs_v3)
+	nvmlDeviceGetGraphicsRunningProcesses = runningProcessesWrapper(resolved, symbolNvmlDeviceGetGraphicsRunningProcs_v3)
 	return nil
 }
+
+// runningProcessesWrapper wraps either of the running process queries, which share a
+// signature. It returns nil if the symbol was not resolved.
+func runningProcessesWrapper(resolved map[string]unsafe.Pointer, symbol string) func(device uintptr) ([]nvmlProcessInfo, error) {
+	getProcesses, ok := resolved[symbol]
+	if !ok {
+		return nil
+	}
+	return func(device uintptr) ([]nvmlProcessInfo, error) {
+		infos := make([]nvmlProcessInfo, 32)
+		for {
+			count := C.uint(len(infos))
+			rc := nvmlError(C.call_nvmlDeviceGetRunningProcesses_v3(
+				getProcesses,
+				*(*C.nvmlDevice_t)(unsafe.Pointer(&device)),
+				&count,
+				unsafe.Pointer(&infos[0])),
+			)
+			if errors.Is(rc, NVML_ERROR_INSUFFICIENT_SIZE) && int(count) > len(infos) {
+				infos = make([]nvmlProcessInfo, count)
+				continue
+			}
+			if errors.Is(rc, NVML_SUCCESS) {
+				return infos[:count], nil
+			}
+			return nil, rc
+		}
+	}
+}
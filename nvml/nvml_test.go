@@ -0,0 +1,180 @@
+package nvml
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+func TestFindGPUSensors(t *testing.T) {
+	type reading struct {
+		name  string
+		unit  sensors.Unit
+		value float64
+	}
+	type testcase struct {
+		name     string
+		devices  []*fakeDevice
+		expected []reading
+	}
+	for _, tc := range []testcase{
+		{
+			name: "energy and power",
+			devices: []*fakeDevice{
+				{name: "GPU", energy: values[uint64](1_000, 3_500), power: values[uint32](50_000)},
+			},
+			expected: []reading{
+				{name: "GPU", unit: sensors.Joules, value: 2.5},
+				{name: "GPU", unit: sensors.Watts, value: 50},
+			},
+		},
+		{
+			name: "power only",
+			devices: []*fakeDevice{
+				{name: "GPU", energy: failure[uint64](NVML_ERROR_NOT_SUPPORTED), power: values[uint32](50_000)},
+			},
+			expected: []reading{
+				{name: "GPU", unit: sensors.Watts, value: 50},
+			},
+		},
+		{
+			name: "energy only",
+			devices: []*fakeDevice{
+				{name: "GPU", energy: values[uint64](1_000, 3_500), power: failure[uint32](NVML_ERROR_NOT_SUPPORTED)},
+			},
+			expected: []reading{
+				{name: "GPU", unit: sensors.Joules, value: 2.5},
+			},
+		},
+		{
+			name: "neither",
+			devices: []*fakeDevice{
+				{name: "GPU", energy: failure[uint64](NVML_ERROR_NOT_SUPPORTED), power: failure[uint32](NVML_ERROR_NOT_SUPPORTED)},
+			},
+		},
+		{
+			name: "lost during discovery",
+			devices: []*fakeDevice{
+				{name: "GPU 0", handleErr: NVML_ERROR_GPU_IS_LOST},
+				{name: "GPU 1", energy: failure[uint64](NVML_ERROR_GPU_IS_LOST), power: failure[uint32](NVML_ERROR_GPU_IS_LOST)},
+				{name: "GPU 2", power: values[uint32](25_000)},
+			},
+			expected: []reading{
+				{name: "GPU 2", unit: sensors.Watts, value: 25},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			found, err := findGPUSensors(&fakeDriver{devices: tc.devices}, t.Logf)
+			if err != nil {
+				t.Fatalf("failed finding sensors: %v", err)
+			}
+			if len(found) != len(tc.expected) {
+				t.Fatalf("expected %d sensors, got %d", len(tc.expected), len(found))
+			}
+			for i, s := range found {
+				expected := tc.expected[i]
+				if s.Name() != expected.name || s.Unit() != expected.unit {
+					t.Errorf("expected sensor %d to be %q (%s), got %q (%s)", i, expected.name, expected.unit, s.Name(), s.Unit())
+				}
+				value, err := s.Read()
+				if err != nil {
+					t.Errorf("failed reading sensor %d: %v", i, err)
+				} else if value != expected.value {
+					t.Errorf("expected sensor %d to read %v, got %v", i, expected.value, value)
+				}
+			}
+		})
+	}
+}
+
+func TestFindGPUSensorsInitFailure(t *testing.T) {
+	_, err := findGPUSensors(&fakeDriver{initErr: NVML_ERROR_DRIVER_NOT_LOADED}, t.Logf)
+	if !errors.Is(err, NVML_ERROR_DRIVER_NOT_LOADED) {
+		t.Errorf("expected driver not loaded error, got %v", err)
+	}
+}
+
+func TestGPULostAtRuntime(t *testing.T) {
+	device := &fakeDevice{
+		name: "GPU",
+		energy: script[uint64]{
+			{value: 1_000},
+			{value: 2_000},
+			{err: NVML_ERROR_GPU_IS_LOST},
+		},
+		power: script[uint32]{
+			{value: 50_000},
+			{err: NVML_ERROR_GPU_IS_LOST},
+		},
+	}
+	found, err := findGPUSensors(&fakeDriver{devices: []*fakeDevice{device}}, t.Logf)
+	if err != nil {
+		t.Fatalf("failed finding sensors: %v", err)
+	}
+	if len(found) != 2 {
+		t.Fatalf("expected energy and power sensors, got %d sensors", len(found))
+	}
+	energy, power := found[0], found[1]
+	if value, err := energy.Read(); err != nil || value != 1 {
+		t.Errorf("expected energy of 1 J before the GPU was lost, got %v (error %v)", value, err)
+	}
+	for _, s := range []sensors.Sensor{energy, power} {
+		if _, err := s.Read(); !errors.Is(err, NVML_ERROR_GPU_IS_LOST) {
+			t.Errorf("expected %s sensor to report the GPU lost, got %v", s.Unit(), err)
+		}
+	}
+}
+
+func TestFindAuxiliarySensors(t *testing.T) {
+	device := &fakeDevice{
+		name:            "GPU",
+		temperature:     values[uint32](65),
+		throttleReasons: values(NVML_CLOCKS_THROTTLE_REASON_SW_POWER_CAP),
+	}
+	found, err := findAuxiliarySensors(&fakeDriver{devices: []*fakeDevice{device}}, t.Logf)
+	if err != nil {
+		t.Fatalf("failed finding sensors: %v", err)
+	}
+	expected := []struct {
+		name  string
+		unit  sensors.Unit
+		value float64
+	}{
+		{"GPU temperature", sensors.Celsius, 65},
+		{"GPU power throttled", sensors.Percent, 100},
+		{"GPU thermal throttled", sensors.Percent, 0},
+	}
+	if len(found) != len(expected) {
+		t.Fatalf("expected %d sensors, got %d", len(expected), len(found))
+	}
+	for i, s := range found {
+		if s.Name() != expected[i].name || s.Unit() != expected[i].unit {
+			t.Errorf("expected sensor %d to be %q (%s), got %q (%s)", i, expected[i].name, expected[i].unit, s.Name(), s.Unit())
+		}
+		if value, err := s.Read(); err != nil || value != expected[i].value {
+			t.Errorf("expected sensor %d to read %v, got %v (error %v)", i, expected[i].value, value, err)
+		}
+	}
+}
+
+func TestErrorPolicy(t *testing.T) {
+	s := &sensor{}
+	type testcase struct {
+		err      error
+		expected sensors.ErrorPolicy
+	}
+	for _, tc := range []testcase{
+		{err: NVML_ERROR_GPU_IS_LOST, expected: sensors.Drop},
+		{err: fmt.Errorf("failed querying process utilization: %w", NVML_ERROR_GPU_IS_LOST), expected: sensors.Drop},
+		{err: NVML_ERROR_TIMEOUT, expected: sensors.Retry},
+		{err: NVML_ERROR_UNKNOWN, expected: sensors.Gap},
+		{err: errors.New("unrelated"), expected: sensors.Gap},
+	} {
+		if policy := sensors.PolicyFor(s, tc.err, sensors.Gap); policy != tc.expected {
+			t.Errorf("expected %v to be handled with %s, got %s", tc.err, tc.expected, policy)
+		}
+	}
+}
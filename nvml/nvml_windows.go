@@ -125,5 +125,86 @@ func platformInit() error {
 			return uJ, nil
 		}
 	}
+	if getUtilizationFunc, ok := resolved[symbolNvmlDeviceGetProcessUtilization]; ok {
+		nvmlDeviceGetProcessUtilization = func(device uintptr, lastSeen uint64) ([]nvmlProcessUtilizationSample, error) {
+			samples := make([]nvmlProcessUtilizationSample, 32)
+			for {
+				count := uint32(len(samples))
+				rc, _, _ := getUtilizationFunc.Call(device, uintptr(unsafe.Pointer(&samples[0])), uintptr(unsafe.Pointer(&count)), uintptr(lastSeen))
+				if rc := nvmlError(rc); rc == NVML_ERROR_INSUFFICIENT_SIZE && int(count) > len(samples) {
+					samples = make([]nvmlProcessUtilizationSample, count)
+					continue
+				} else if rc != NVML_SUCCESS {
+					return nil, rc
+				}
+				return samples[:count], nil
+			}
+		}
+	}
+	if getClockFunc, ok := resolved[symbolNvmlDeviceGetClockInfo]; ok {
+		nvmlDeviceGetClockInfo = func(device uintptr, clock nvmlClockType) (uint32, error) {
+			var mhz uint32
+			rc, _, _ := getClockFunc.Call(device, uintptr(clock), uintptr(unsafe.Pointer(&mhz)))
+			if rc := nvmlError(rc); rc != NVML_SUCCESS {
+				return 0, rc
+			}
+			return mhz, nil
+		}
+	}
+	if getUtilizationFunc, ok := resolved[symbolNvmlDeviceGetUtilizationRates]; ok {
+		nvmlDeviceGetUtilizationRates = func(device uintptr) (nvmlUtilization, error) {
+			var utilization nvmlUtilization
+			rc, _, _ := getUtilizationFunc.Call(device, uintptr(unsafe.Pointer(&utilization)))
+			if rc := nvmlError(rc); rc != NVML_SUCCESS {
+				return nvmlUtilization{}, rc
+			}
+			return utilization, nil
+		}
+	}
+	if getTemperatureFunc, ok := resolved[symbolNvmlDeviceGetTemperature]; ok {
+		nvmlDeviceGetTemperature = func(device uintptr, sensor nvmlTemperatureSensor) (uint32, error) {
+			var celsius uint32
+			rc, _, _ := getTemperatureFunc.Call(device, uintptr(sensor), uintptr(unsafe.Pointer(&celsius)))
+			if rc := nvmlError(rc); rc != NVML_SUCCESS {
+				return 0, rc
+			}
+			return celsius, nil
+		}
+	}
+	if getThrottleFunc, ok := resolved[symbolNvmlDeviceGetCurrentClocksThrottle]; ok {
+		nvmlDeviceGetCurrentClocksThrottleReasons = func(device uintptr) (uint64, error) {
+			var reasons uint64
+			rc, _, _ := getThrottleFunc.Call(device, uintptr(unsafe.Pointer(&reasons)))
+			if rc := nvmlError(rc); rc != NVML_SUCCESS {
+				return 0, rc
+			}
+			return reasons, nil
+		}
+	}
+	nvmlDeviceGetComputeRunningProcesses = runningProcessesWrapper(resolved, symbolNvmlDeviceGetComputeRunningProcs_v3)
+	nvmlDeviceGetGraphicsRunningProcesses = runningProcessesWrapper(resolved, symbolNvmlDeviceGetGraphicsRunningProcs_v3)
 	return nil
 }
+
+// runningProcessesWrapper wraps either of the running process queries, which share a
+// signature. It returns nil if the symbol was not resolved.
+func runningProcessesWrapper(resolved map[string]*windows.LazyProc, symbol string) func(device uintptr) ([]nvmlProcessInfo, error) {
+	getProcessesFunc, ok := resolved[symbol]
+	if !ok {
+		return nil
+	}
+	return func(device uintptr) ([]nvmlProcessInfo, error) {
+		infos := make([]nvmlProcessInfo, 32)
+		for {
+			count := uint32(len(infos))
+			rc, _, _ := getProcessesFunc.Call(device, uintptr(unsafe.Pointer(&count)), uintptr(unsafe.Pointer(&infos[0])))
+			if rc := nvmlError(rc); rc == NVML_ERROR_INSUFFICIENT_SIZE && int(count) > len(infos) {
+				infos = make([]nvmlProcessInfo, count)
+				continue
+			} else if rc != NVML_SUCCESS {
+				return nil, rc
+			}
+			return infos[:count], nil
+		}
+	}
+}
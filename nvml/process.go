@@ -0,0 +1,159 @@
+package nvml
+
+import (
+	"errors"
+	"fmt"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+// FindProcessSensors returns a sensor for each NVIDIA GPU reporting the share of its energy
+// (or power) attributable to the descendants of the process root. The root itself is
+// excluded, which allows an application to launch the processes of interest without its
+// own rendering being counted. Unless verbose, it does not log the GPUs it skips.
+func FindProcessSensors(root uint32, verbose bool) ([]sensors.Sensor, error) {
+	d, err := loadDriver()
+	if err != nil {
+		return nil, err
+	}
+	return findProcessSensors(d, root, parentPID, logger(verbose))
+}
+
+func findProcessSensors(d driver, root uint32, parentPID func(pid uint32) (uint32, error), logf func(format string, args ...any)) ([]sensors.Sensor, error) {
+	gpus, err := enumerateGPUs(d, logf)
+	if err != nil {
+		return nil, err
+	}
+	out := []sensors.Sensor{}
+	for _, g := range gpus {
+		device, err := newSensor(d, g, sensors.Joules)
+		if err != nil {
+			device, err = newSensor(d, g, sensors.Watts)
+		}
+		if err != nil {
+			logf("not attributing NVIDIA GPU %q to processes because it does not support power monitoring: %v", g.name, err)
+			continue
+		}
+		s := newProcessSensor(device, g.handle, root, d, parentPID)
+		if _, err := s.treeShare(); err != nil {
+			logf("not attributing NVIDIA GPU %q to processes: %v", g.name, err)
+			continue
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// processSensor scales the readings of a device sensor by the share of the device's activity
+// belonging to a process tree.
+type processSensor struct {
+	device    sensors.Sensor
+	handle    uintptr
+	root      uint32
+	driver    driver
+	parentPID func(pid uint32) (uint32, error)
+	// lastSeen is the timestamp of the latest utilization sample consumed.
+	lastSeen uint64
+}
+
+func newProcessSensor(device sensors.Sensor, handle uintptr, root uint32, d driver, parentPID func(pid uint32) (uint32, error)) *processSensor {
+	return &processSensor{
+		device:    device,
+		handle:    handle,
+		root:      root,
+		driver:    d,
+		parentPID: parentPID,
+	}
+}
+
+var (
+	_ sensors.Sensor    = (*processSensor)(nil)
+	_ sensors.Contained = (*processSensor)(nil)
+)
+
+func (p *processSensor) Name() string {
+	return p.device.Name() + " process tree"
+}
+
+// ContainedIn returns the name of the GPU, since the process tree's share of its energy is
+// part of the GPU's.
+func (p *processSensor) ContainedIn() string {
+	return p.device.Name()
+}
+
+func (p *processSensor) Unit() sensors.Unit {
+	return p.device.Unit()
+}
+
+func (p *processSensor) Read() (float64, error) {
+	// Always read the device so that energy counters stay current.
+	value, err := p.device.Read()
+	if err != nil {
+		return 0, err
+	}
+	share, err := p.treeShare()
+	if err != nil {
+		return 0, err
+	}
+	return value * share, nil
+}
+
+// treeShare returns the fraction of the device's activity since the last call that belongs
+// to the process tree. Activity is measured by SM plus memory utilization when the device
+// reports per-process utilization, and by GPU memory allocation otherwise.
+func (p *processSensor) treeShare() (float64, error) {
+	weights := map[uint32]float64{}
+	samples, err := p.driver.DeviceGetProcessUtilization(p.handle, p.lastSeen)
+	switch {
+	case err == nil:
+		for _, sample := range samples {
+			weights[sample.Pid] += float64(sample.SmUtil + sample.MemUtil)
+			p.lastSeen = max(p.lastSeen, sample.TimeStamp)
+		}
+	case errors.Is(err, NVML_ERROR_NOT_FOUND):
+		// No process has used the device since the last sample.
+		return 0, nil
+	case errors.Is(err, NVML_ERROR_NOT_SUPPORTED), errors.Is(err, NVML_ERROR_FUNCTION_NOT_FOUND):
+		infos, err := p.driver.DeviceGetRunningProcesses(p.handle)
+		if err != nil {
+			return 0, fmt.Errorf("failed querying running processes: %w", err)
+		}
+		for _, info := range infos {
+			if info.UsedGpuMemory != NVML_VALUE_NOT_AVAILABLE {
+				weights[info.Pid] = max(weights[info.Pid], float64(info.UsedGpuMemory))
+			}
+		}
+	default:
+		return 0, fmt.Errorf("failed querying process utilization: %w", err)
+	}
+	var total, tree float64
+	for pid, weight := range weights {
+		total += weight
+		if p.inTree(pid) {
+			tree += weight
+		}
+	}
+	if total == 0 {
+		return 0, nil
+	}
+	return tree / total, nil
+}
+
+// maxTreeDepth bounds the walk up the process tree in case of cycles caused by PID reuse.
+const maxTreeDepth = 64
+
+// inTree reports whether pid is a descendant of the root process.
+func (p *processSensor) inTree(pid uint32) bool {
+	for depth := 0; depth < maxTreeDepth && pid != 0; depth++ {
+		parent, err := p.parentPID(pid)
+		if err != nil {
+			// The process has probably exited.
+			return false
+		}
+		if parent == p.root {
+			return true
+		}
+		pid = parent
+	}
+	return false
+}
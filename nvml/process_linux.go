@@ -0,0 +1,31 @@
+package nvml
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// parentPID reads the parent of a process from procfs.
+func parentPID(pid uint32) (uint32, error) {
+	stat, err := os.ReadFile("/proc/" + strconv.FormatUint(uint64(pid), 10) + "/stat")
+	if err != nil {
+		return 0, err
+	}
+	// The command name is parenthesized and may itself contain spaces and parentheses, so
+	// the fields of interest follow its last closing parenthesis.
+	end := bytes.LastIndexByte(stat, ')')
+	if end < 0 {
+		return 0, fmt.Errorf("malformed stat for process %d", pid)
+	}
+	fields := bytes.Fields(stat[end+1:])
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("malformed stat for process %d", pid)
+	}
+	parent, err := strconv.ParseUint(string(fields[1]), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("failed parsing parent of process %d: %w", pid, err)
+	}
+	return uint32(parent), nil
+}
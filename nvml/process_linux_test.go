@@ -0,0 +1,16 @@
+package nvml
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParentPID(t *testing.T) {
+	parent, err := parentPID(uint32(os.Getpid()))
+	if err != nil {
+		t.Fatalf("failed reading parent: %v", err)
+	}
+	if expected := uint32(os.Getppid()); parent != expected {
+		t.Errorf("expected parent %d, got %d", expected, parent)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !windows
+
+package nvml
+
+import "fmt"
+
+func parentPID(pid uint32) (uint32, error) {
+	return 0, fmt.Errorf("unsupported platform for process queries")
+}
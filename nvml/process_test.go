@@ -0,0 +1,164 @@
+package nvml
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+// constantSensor always reads the same value.
+type constantSensor float64
+
+func (c constantSensor) Name() string           { return "GPU" }
+func (c constantSensor) Unit() sensors.Unit     { return sensors.Joules }
+func (c constantSensor) Read() (float64, error) { return float64(c), nil }
+
+// parentsFrom returns a parentPID func answering from a fixed process tree.
+func parentsFrom(parents map[uint32]uint32) func(pid uint32) (uint32, error) {
+	return func(pid uint32) (uint32, error) {
+		parent, ok := parents[pid]
+		if !ok {
+			return 0, fmt.Errorf("process %d not found", pid)
+		}
+		return parent, nil
+	}
+}
+
+func TestProcessSensor(t *testing.T) {
+	const root = 100
+	// 101 and its child 102 descend from the root, while 200 does not.
+	parents := parentsFrom(map[uint32]uint32{
+		root: 1,
+		101:  root,
+		102:  101,
+		200:  1,
+	})
+	type testcase struct {
+		name     string
+		device   *fakeDevice
+		expected float64
+		err      bool
+	}
+	for _, tc := range []testcase{
+		{
+			name: "utilization",
+			device: &fakeDevice{
+				processUtilization: values([]nvmlProcessUtilizationSample{
+					{Pid: 101, TimeStamp: 5, SmUtil: 20, MemUtil: 10},
+					{Pid: 102, TimeStamp: 6, SmUtil: 10},
+					{Pid: 200, TimeStamp: 7, SmUtil: 50, MemUtil: 10},
+				}),
+			},
+			expected: 4,
+		},
+		{
+			name: "root excluded",
+			device: &fakeDevice{
+				processUtilization: values([]nvmlProcessUtilizationSample{
+					{Pid: root, TimeStamp: 5, SmUtil: 50},
+					{Pid: 101, TimeStamp: 5, SmUtil: 50},
+				}),
+			},
+			expected: 5,
+		},
+		{
+			name: "exited process",
+			device: &fakeDevice{
+				processUtilization: values([]nvmlProcessUtilizationSample{
+					{Pid: 999, TimeStamp: 5, SmUtil: 50},
+				}),
+			},
+			expected: 0,
+		},
+		{
+			name: "idle",
+			device: &fakeDevice{
+				processUtilization: failure[[]nvmlProcessUtilizationSample](NVML_ERROR_NOT_FOUND),
+			},
+			expected: 0,
+		},
+		{
+			name: "memory fallback",
+			device: &fakeDevice{
+				processUtilization: failure[[]nvmlProcessUtilizationSample](NVML_ERROR_NOT_SUPPORTED),
+				runningProcesses: values([]nvmlProcessInfo{
+					{Pid: 101, UsedGpuMemory: 300},
+					{Pid: 200, UsedGpuMemory: 100},
+					{Pid: 102, UsedGpuMemory: NVML_VALUE_NOT_AVAILABLE},
+				}),
+			},
+			expected: 7.5,
+		},
+		{
+			name: "query failure",
+			device: &fakeDevice{
+				processUtilization: failure[[]nvmlProcessUtilizationSample](NVML_ERROR_GPU_IS_LOST),
+			},
+			err: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &fakeDriver{devices: []*fakeDevice{tc.device}}
+			s := newProcessSensor(constantSensor(10), 1, root, d, parents)
+			value, err := s.Read()
+			if tc.err {
+				if !errors.Is(err, NVML_ERROR_GPU_IS_LOST) {
+					t.Errorf("expected GPU lost error, got %v", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if diff := value - tc.expected; diff > 1e-6 || diff < -1e-6 {
+				t.Errorf("expected %f, got %f", tc.expected, value)
+			}
+		})
+	}
+}
+
+func TestProcessSensorLastSeen(t *testing.T) {
+	device := &fakeDevice{
+		processUtilization: values([]nvmlProcessUtilizationSample{
+			{Pid: 101, TimeStamp: 7, SmUtil: 20},
+			{Pid: 101, TimeStamp: 9, SmUtil: 20},
+		}),
+	}
+	d := &fakeDriver{devices: []*fakeDevice{device}}
+	s := newProcessSensor(constantSensor(10), 1, 100, d, parentsFrom(map[uint32]uint32{101: 100}))
+	for i, expected := range []uint64{0, 9} {
+		if _, err := s.Read(); err != nil {
+			t.Fatalf("failed reading: %v", err)
+		}
+		if device.lastSeen != expected {
+			t.Errorf("expected read %d to query samples after %d, got %d", i, expected, device.lastSeen)
+		}
+	}
+}
+
+func TestFindProcessSensors(t *testing.T) {
+	d := &fakeDriver{devices: []*fakeDevice{
+		// Supports neither per-process query, so it cannot be attributed.
+		{name: "GPU 0", power: values[uint32](10_000)},
+		{
+			name:               "GPU 1",
+			energy:             values[uint64](1_000, 3_000),
+			processUtilization: failure[[]nvmlProcessUtilizationSample](NVML_ERROR_NOT_FOUND),
+		},
+	}}
+	found, err := findProcessSensors(d, 100, parentsFrom(nil), t.Logf)
+	if err != nil {
+		t.Fatalf("failed finding sensors: %v", err)
+	}
+	if len(found) != 1 {
+		t.Fatalf("expected 1 sensor, got %d", len(found))
+	}
+	if found[0].Name() != "GPU 1 process tree" || found[0].Unit() != sensors.Joules {
+		t.Errorf("expected \"GPU 1 process tree\" (J), got %q (%s)", found[0].Name(), found[0].Unit())
+	}
+	// The process tree's energy is part of the GPU's, so it must not be added to it.
+	if container := found[0].(sensors.Contained).ContainedIn(); container != "GPU 1" {
+		t.Errorf("expected the process tree to be contained in \"GPU 1\", got %q", container)
+	}
+}
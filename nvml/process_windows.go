@@ -0,0 +1,25 @@
+package nvml
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// parentPID finds the parent of a process in a snapshot of the running processes.
+func parentPID(pid uint32) (uint32, error) {
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return 0, fmt.Errorf("failed snapshotting processes: %w", err)
+	}
+	defer windows.CloseHandle(snapshot)
+	var entry windows.ProcessEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
+	for err = windows.Process32First(snapshot, &entry); err == nil; err = windows.Process32Next(snapshot, &entry) {
+		if entry.ProcessID == pid {
+			return entry.ParentProcessID, nil
+		}
+	}
+	return 0, fmt.Errorf("process %d not found", pid)
+}
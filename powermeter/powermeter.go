@@ -0,0 +1,301 @@
+// Package powermeter reads external power meters attached to serial ports. Unlike the
+// on-chip estimates provided by other sensors, these measure power where it is actually
+// delivered, such as at the wall or at a lab power supply's output.
+package powermeter
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+// Protocol identifies how to communicate with a meter.
+type Protocol uint8
+
+const (
+	// SCPI meters answer a query command with a single reading, as lab power supplies and
+	// bench multimeters do.
+	SCPI Protocol = iota
+	// LineStream meters continuously emit lines of readings like "5.12V 0.53A 2.71W", as
+	// many USB power meters do.
+	LineStream
+)
+
+func (p Protocol) String() string {
+	switch p {
+	case SCPI:
+		return "scpi"
+	case LineStream:
+		return "line"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	DefaultBaud = 9600
+	// DefaultQuery asks an SCPI instrument for its measured power in Watts.
+	DefaultQuery = "MEAS:POW?"
+	// DefaultTimeout bounds how long an SCPI meter may take to answer a query.
+	DefaultTimeout = time.Second
+)
+
+// Config describes a meter to open.
+type Config struct {
+	Protocol Protocol
+	// Path is the serial device the meter is attached to, like /dev/ttyUSB0.
+	Path string
+	Baud int
+	// Query is the command that asks an SCPI meter for its power reading in Watts.
+	Query string
+	// Timeout bounds how long an SCPI meter may take to answer a query.
+	Timeout time.Duration
+}
+
+// ParseConfig parses a meter specification of the form "protocol:path[,baud[,query]]", like
+// "line:/dev/ttyACM0" or "scpi:/dev/ttyUSB0,115200,MEAS:POW:DC?". The protocol is either
+// "scpi" or "line", and the query only applies to SCPI meters.
+func ParseConfig(spec string) (Config, error) {
+	protocol, rest, ok := strings.Cut(spec, ":")
+	if !ok {
+		return Config{}, fmt.Errorf("meter %q must be of the form protocol:path[,baud[,query]]", spec)
+	}
+	cfg := Config{
+		Baud:    DefaultBaud,
+		Query:   DefaultQuery,
+		Timeout: DefaultTimeout,
+	}
+	switch protocol {
+	case "scpi":
+		cfg.Protocol = SCPI
+	case "line":
+		cfg.Protocol = LineStream
+	default:
+		return Config{}, fmt.Errorf("meter %q has unknown protocol %q", spec, protocol)
+	}
+	fields := strings.SplitN(rest, ",", 3)
+	cfg.Path = fields[0]
+	if cfg.Path == "" {
+		return Config{}, fmt.Errorf("meter %q has no device path", spec)
+	}
+	if len(fields) > 1 {
+		baud, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return Config{}, fmt.Errorf("meter %q has invalid baud rate: %w", spec, err)
+		}
+		cfg.Baud = baud
+	}
+	if len(fields) > 2 {
+		if cfg.Protocol != SCPI {
+			return Config{}, fmt.Errorf("meter %q has a query, but only scpi meters are queried", spec)
+		}
+		cfg.Query = fields[2]
+	}
+	return cfg, nil
+}
+
+// name returns the sensor name for the meter, like "scpi meter ttyUSB0".
+func (c Config) name() string {
+	return c.Protocol.String() + " meter " + filepath.Base(c.Path)
+}
+
+// port is a serial connection to a meter.
+type port interface {
+	io.ReadWriteCloser
+	SetReadDeadline(time.Time) error
+}
+
+// newMeter wraps an open port in the sensor for the configured protocol.
+func newMeter(cfg Config, p port) (sensors.Sensor, error) {
+	switch cfg.Protocol {
+	case SCPI:
+		timeout := cfg.Timeout
+		if timeout == 0 {
+			timeout = DefaultTimeout
+		}
+		return &scpiMeter{
+			name:    cfg.name(),
+			port:    p,
+			reader:  bufio.NewReader(p),
+			query:   cfg.Query,
+			timeout: timeout,
+		}, nil
+	case LineStream:
+		m := &lineMeter{
+			name: cfg.name(),
+			port: p,
+		}
+		go m.run()
+		return m, nil
+	default:
+		return nil, fmt.Errorf("unknown meter protocol %d", cfg.Protocol)
+	}
+}
+
+// scpiMeter polls an SCPI instrument for a power reading on every read.
+type scpiMeter struct {
+	name    string
+	port    port
+	reader  *bufio.Reader
+	query   string
+	timeout time.Duration
+}
+
+func (m *scpiMeter) Name() string {
+	return m.name
+}
+
+// WholeSystem returns true, since meters measure the power drawn from the wall.
+func (m *scpiMeter) WholeSystem() bool {
+	return true
+}
+
+func (m *scpiMeter) Unit() sensors.Unit {
+	return sensors.Watts
+}
+
+func (m *scpiMeter) Read() (float64, error) {
+	if _, err := io.WriteString(m.port, m.query+"\n"); err != nil {
+		return 0, fmt.Errorf("failed querying %s: %w", m.name, err)
+	}
+	if err := m.port.SetReadDeadline(time.Now().Add(m.timeout)); err != nil {
+		return 0, fmt.Errorf("failed setting deadline for %s: %w", m.name, err)
+	}
+	line, err := m.reader.ReadString('\n')
+	if err != nil {
+		// Discard any partial response so that it isn't mistaken for the next one.
+		m.reader.Reset(m.port)
+		return 0, fmt.Errorf("failed reading response from %s: %w", m.name, err)
+	}
+	response := strings.TrimSuffix(strings.TrimSpace(line), "W")
+	value, err := strconv.ParseFloat(strings.TrimSpace(response), 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed parsing response from %s (%s): %w", m.name, strings.TrimSpace(line), err)
+	}
+	return value, nil
+}
+
+func (m *scpiMeter) Close() error {
+	return m.port.Close()
+}
+
+// lineMeter consumes the readings continuously emitted by a meter, reporting the mean of
+// those received between reads.
+type lineMeter struct {
+	name  string
+	port  io.ReadCloser
+	lock  sync.Mutex
+	sum   float64
+	count int
+	last  float64
+	err   error
+}
+
+func (m *lineMeter) Name() string {
+	return m.name
+}
+
+// WholeSystem returns true, since meters measure the power drawn from the wall.
+func (m *lineMeter) WholeSystem() bool {
+	return true
+}
+
+func (m *lineMeter) Unit() sensors.Unit {
+	return sensors.Watts
+}
+
+func (m *lineMeter) run() {
+	scanner := bufio.NewScanner(m.port)
+	for scanner.Scan() {
+		watts, ok := parseReading(scanner.Text())
+		if !ok {
+			continue
+		}
+		m.lock.Lock()
+		m.sum += watts
+		m.count++
+		m.lock.Unlock()
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.err = scanner.Err()
+	if m.err == nil {
+		m.err = io.ErrUnexpectedEOF
+	}
+}
+
+func (m *lineMeter) Read() (float64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.count > 0 {
+		m.last = m.sum / float64(m.count)
+		m.sum = 0
+		m.count = 0
+		return m.last, nil
+	}
+	if m.err != nil {
+		return 0, fmt.Errorf("failed reading %s: %w", m.name, m.err)
+	}
+	// The meter hasn't reported since the last read, so assume that the power draw is
+	// unchanged.
+	return m.last, nil
+}
+
+func (m *lineMeter) Close() error {
+	return m.port.Close()
+}
+
+var (
+	// prefixReading matches readings with a leading unit, like "W=2.71" or "A: 0.53".
+	prefixReading = regexp.MustCompile(`\b([WAV])\s*[:=]\s*(-?\d+(?:\.\d+)?)`)
+	// suffixReading matches readings with a trailing unit, like "2.71W" or "530 mA".
+	suffixReading = regexp.MustCompile(`(-?\d+(?:\.\d+)?)\s*(m?)([WAV])\b`)
+)
+
+// parseReading extracts the power in Watts from a line emitted by a meter, computing it
+// from the voltage and current if the line doesn't report power directly.
+func parseReading(line string) (watts float64, ok bool) {
+	values := map[string]float64{}
+	for _, match := range prefixReading.FindAllStringSubmatch(line, -1) {
+		value, err := strconv.ParseFloat(match[2], 64)
+		if err != nil {
+			continue
+		}
+		values[match[1]] = value
+	}
+	if len(values) == 0 {
+		// Only look for trailing units if there were no leading ones, as a line like
+		// "W:2.71 V:5.12" would otherwise be misread as 2.71 Volts.
+		for _, match := range suffixReading.FindAllStringSubmatch(line, -1) {
+			value, err := strconv.ParseFloat(match[1], 64)
+			if err != nil {
+				continue
+			}
+			if match[2] == "m" {
+				value *= sensors.MilliToUnprefixed
+			}
+			values[match[3]] = value
+		}
+	}
+	if watts, ok := values["W"]; ok {
+		return watts, true
+	}
+	volts, hasVolts := values["V"]
+	amps, hasAmps := values["A"]
+	if hasVolts && hasAmps {
+		return volts * amps, true
+	}
+	return 0, false
+}
+
+// ErrUnsupported is returned when opening meters on platforms without serial support.
+var ErrUnsupported = errors.New("serial power meters are not supported on this platform")
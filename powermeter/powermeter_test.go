@@ -0,0 +1,215 @@
+package powermeter
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+func TestParseConfig(t *testing.T) {
+	type testCase struct {
+		spec     string
+		expected Config
+		err      bool
+	}
+	for _, tc := range []testCase{
+		{
+			spec: "scpi:/dev/ttyUSB0",
+			expected: Config{
+				Protocol: SCPI,
+				Path:     "/dev/ttyUSB0",
+				Baud:     DefaultBaud,
+				Query:    DefaultQuery,
+				Timeout:  DefaultTimeout,
+			},
+		},
+		{
+			spec: "scpi:/dev/ttyUSB0,115200,MEAS:POW:DC?",
+			expected: Config{
+				Protocol: SCPI,
+				Path:     "/dev/ttyUSB0",
+				Baud:     115200,
+				Query:    "MEAS:POW:DC?",
+				Timeout:  DefaultTimeout,
+			},
+		},
+		{
+			spec: "line:/dev/ttyACM0,115200",
+			expected: Config{
+				Protocol: LineStream,
+				Path:     "/dev/ttyACM0",
+				Baud:     115200,
+				Query:    DefaultQuery,
+				Timeout:  DefaultTimeout,
+			},
+		},
+		{spec: "/dev/ttyUSB0", err: true},
+		{spec: "modbus:/dev/ttyUSB0", err: true},
+		{spec: "scpi:", err: true},
+		{spec: "scpi:/dev/ttyUSB0,fast", err: true},
+		{spec: "line:/dev/ttyACM0,9600,MEAS:POW?", err: true},
+	} {
+		t.Run(tc.spec, func(t *testing.T) {
+			cfg, err := ParseConfig(tc.spec)
+			if tc.err {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", cfg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected spec to parse, got %v", err)
+			}
+			if cfg != tc.expected {
+				t.Errorf("expected %+v, got %+v", tc.expected, cfg)
+			}
+		})
+	}
+}
+
+func TestParseReading(t *testing.T) {
+	type testCase struct {
+		line     string
+		expected float64
+		ok       bool
+	}
+	for _, tc := range []testCase{
+		{line: "5.12V 0.53A 2.71W", expected: 2.71, ok: true},
+		{line: "5.00V 500mA", expected: 2.5, ok: true},
+		{line: "V=5.00,A=0.50", expected: 2.5, ok: true},
+		{line: "W:2.71 V:5.12 A:0.53", expected: 2.71, ok: true},
+		{line: "Power 12.5 W", expected: 12.5, ok: true},
+		{line: "5.12V", ok: false},
+		{line: "", ok: false},
+		{line: "USB meter v2.1 ready", ok: false},
+	} {
+		t.Run(tc.line, func(t *testing.T) {
+			watts, ok := parseReading(tc.line)
+			if ok != tc.ok {
+				t.Fatalf("expected ok=%v, got %v", tc.ok, ok)
+			}
+			if math.Abs(watts-tc.expected) > 1e-9 {
+				t.Errorf("expected %v W, got %v W", tc.expected, watts)
+			}
+		})
+	}
+}
+
+func TestSCPIMeter(t *testing.T) {
+	meterSide, deviceSide := net.Pipe()
+	defer deviceSide.Close()
+	meter, err := newMeter(Config{
+		Protocol: SCPI,
+		Path:     "/dev/ttyUSB0",
+		Query:    DefaultQuery,
+		Timeout:  50 * time.Millisecond,
+	}, meterSide)
+	if err != nil {
+		t.Fatalf("failed creating meter: %v", err)
+	}
+	defer meter.(*scpiMeter).Close()
+	if name := meter.Name(); name != "scpi meter ttyUSB0" {
+		t.Errorf("expected name %q, got %q", "scpi meter ttyUSB0", name)
+	}
+	if whole, ok := meter.(sensors.WholeSystem); !ok || !whole.WholeSystem() {
+		t.Errorf("expected the meter to measure the whole system")
+	}
+	responses := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(deviceSide)
+		for scanner.Scan() {
+			if scanner.Text() != DefaultQuery {
+				continue
+			}
+			if response, ok := <-responses; ok {
+				fmt.Fprintf(deviceSide, "%s\n", response)
+			}
+		}
+	}()
+	for _, tc := range []struct {
+		response string
+		expected float64
+	}{
+		{response: "12.5", expected: 12.5},
+		{response: "13.250W", expected: 13.25},
+		{response: " 0.000 ", expected: 0},
+	} {
+		responses <- tc.response
+		value, err := meter.Read()
+		if err != nil {
+			t.Fatalf("failed reading: %v", err)
+		}
+		if value != tc.expected {
+			t.Errorf("expected response %q to read %v, got %v", tc.response, tc.expected, value)
+		}
+	}
+	// A meter that doesn't answer should time out rather than stall sampling.
+	if _, err := meter.Read(); err == nil {
+		t.Errorf("expected an unanswered query to fail")
+	}
+	close(responses)
+}
+
+func TestLineMeter(t *testing.T) {
+	meterSide, deviceSide := net.Pipe()
+	meter, err := newMeter(Config{Protocol: LineStream, Path: "/dev/ttyACM0"}, meterSide)
+	if err != nil {
+		t.Fatalf("failed creating meter: %v", err)
+	}
+	defer meter.(*lineMeter).Close()
+	if whole, ok := meter.(sensors.WholeSystem); !ok || !whole.WholeSystem() {
+		t.Errorf("expected the meter to measure the whole system")
+	}
+	for _, line := range []string{"booting", "5.00V 0.50A", "W=3.00"} {
+		fmt.Fprintf(deviceSide, "%s\n", line)
+	}
+	waitForReadings(t, meter.(*lineMeter), 2)
+	value, err := meter.Read()
+	if err != nil {
+		t.Fatalf("failed reading: %v", err)
+	}
+	if value != 2.75 {
+		t.Errorf("expected the mean of readings since the last read, got %v", value)
+	}
+	value, err = meter.Read()
+	if err != nil {
+		t.Fatalf("failed reading: %v", err)
+	}
+	if value != 2.75 {
+		t.Errorf("expected the previous value when no new readings arrived, got %v", value)
+	}
+	deviceSide.Close()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := meter.Read(); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected reads to fail once the meter disconnected")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitForReadings waits until the meter has accumulated count readings.
+func waitForReadings(t *testing.T, m *lineMeter, count int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		m.lock.Lock()
+		n := m.count
+		m.lock.Unlock()
+		if n >= count {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d readings, got %d", count, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,88 @@
+//go:build linux
+
+package powermeter
+
+import (
+	"fmt"
+	"os"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+	"golang.org/x/sys/unix"
+)
+
+var baudRates = map[int]uint32{
+	1200:   unix.B1200,
+	2400:   unix.B2400,
+	4800:   unix.B4800,
+	9600:   unix.B9600,
+	19200:  unix.B19200,
+	38400:  unix.B38400,
+	57600:  unix.B57600,
+	115200: unix.B115200,
+	230400: unix.B230400,
+	460800: unix.B460800,
+	921600: unix.B921600,
+}
+
+// Open connects to the meter described by cfg.
+func Open(cfg Config) (sensors.Sensor, error) {
+	p, err := openSerial(cfg.Path, cfg.Baud)
+	if err != nil {
+		return nil, err
+	}
+	meter, err := newMeter(cfg, p)
+	if err != nil {
+		p.Close()
+		return nil, err
+	}
+	return meter, nil
+}
+
+// openSerial opens the serial device at path in raw 8N1 mode at the given baud rate.
+func openSerial(path string, baud int) (*os.File, error) {
+	speed, ok := baudRates[baud]
+	if !ok {
+		return nil, fmt.Errorf("unsupported baud rate %d", baud)
+	}
+	// Opening the device without blocking allows the runtime poller to manage it, which
+	// enables read deadlines.
+	f, err := os.OpenFile(path, os.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening %s: %w", path, err)
+	}
+	conn, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed accessing %s: %w", path, err)
+	}
+	var termiosErr error
+	if err := conn.Control(func(fd uintptr) {
+		termiosErr = configureTermios(int(fd), speed)
+	}); err != nil {
+		termiosErr = err
+	}
+	if termiosErr != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed configuring %s: %w", path, termiosErr)
+	}
+	return f, nil
+}
+
+// configureTermios puts the terminal in raw mode (like cfmakeraw) with 8 data bits, no
+// parity, one stop bit, and the given speed.
+func configureTermios(fd int, speed uint32) error {
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return err
+	}
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB | unix.CSTOPB | unix.CBAUD
+	t.Cflag |= unix.CS8 | unix.CREAD | unix.CLOCAL | speed
+	t.Ispeed = speed
+	t.Ospeed = speed
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+	return unix.IoctlSetTermios(fd, unix.TCSETS, t)
+}
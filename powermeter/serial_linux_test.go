@@ -0,0 +1,126 @@
+//go:build linux
+
+package powermeter
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// fakeDevice is a pseudoterminal standing in for a serial power meter. The code under test
+// opens path just as it would open a real meter, while the test scripts the meter's side
+// of the conversation through master.
+type fakeDevice struct {
+	master *os.File
+	path   string
+}
+
+func newFakeDevice(t *testing.T) *fakeDevice {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("pseudoterminals are unavailable: %v", err)
+	}
+	t.Cleanup(func() { master.Close() })
+	conn, err := master.SyscallConn()
+	if err != nil {
+		t.Fatalf("failed accessing pseudoterminal: %v", err)
+	}
+	var number uint32
+	var ptyErr error
+	if err := conn.Control(func(fd uintptr) {
+		// Equivalent to unlockpt and ptsname.
+		if ptyErr = unix.IoctlSetPointerInt(int(fd), unix.TIOCSPTLCK, 0); ptyErr != nil {
+			return
+		}
+		number, ptyErr = unix.IoctlGetUint32(int(fd), unix.TIOCGPTN)
+	}); err != nil {
+		ptyErr = err
+	}
+	if ptyErr != nil {
+		t.Fatalf("failed allocating pseudoterminal: %v", ptyErr)
+	}
+	return &fakeDevice{
+		master: master,
+		path:   fmt.Sprintf("/dev/pts/%d", number),
+	}
+}
+
+// answer replies to every line the device receives with the result of respond, until the
+// device is closed.
+func (f *fakeDevice) answer(respond func(query string) string) {
+	go func() {
+		scanner := bufio.NewScanner(f.master)
+		for scanner.Scan() {
+			fmt.Fprintf(f.master, "%s\r\n", respond(scanner.Text()))
+		}
+	}()
+}
+
+func TestOpenSCPI(t *testing.T) {
+	device := newFakeDevice(t)
+	reading := 0.0
+	device.answer(func(query string) string {
+		if query != "MEAS:POW:DC?" {
+			return "ERR"
+		}
+		reading += 1.5
+		return fmt.Sprintf("%.3f", reading)
+	})
+	meter, err := Open(Config{
+		Protocol: SCPI,
+		Path:     device.path,
+		Baud:     115200,
+		Query:    "MEAS:POW:DC?",
+		Timeout:  time.Second,
+	})
+	if err != nil {
+		t.Fatalf("failed opening meter: %v", err)
+	}
+	defer meter.(*scpiMeter).Close()
+	for _, expected := range []float64{1.5, 3, 4.5} {
+		value, err := meter.Read()
+		if err != nil {
+			t.Fatalf("failed reading: %v", err)
+		}
+		if value != expected {
+			t.Errorf("expected %v W, got %v W", expected, value)
+		}
+	}
+}
+
+func TestOpenLineStream(t *testing.T) {
+	device := newFakeDevice(t)
+	meter, err := Open(Config{
+		Protocol: LineStream,
+		Path:     device.path,
+		Baud:     DefaultBaud,
+	})
+	if err != nil {
+		t.Fatalf("failed opening meter: %v", err)
+	}
+	defer meter.(*lineMeter).Close()
+	for _, line := range []string{"12.00V 1.000A 12.00W", "12.00V 1.500A 18.00W"} {
+		fmt.Fprintf(device.master, "%s\r\n", line)
+	}
+	waitForReadings(t, meter.(*lineMeter), 2)
+	value, err := meter.Read()
+	if err != nil {
+		t.Fatalf("failed reading: %v", err)
+	}
+	if value != 15 {
+		t.Errorf("expected 15 W, got %v W", value)
+	}
+}
+
+func TestOpenUnsupportedBaud(t *testing.T) {
+	device := newFakeDevice(t)
+	if _, err := Open(Config{Protocol: LineStream, Path: device.path, Baud: 12345}); err == nil {
+		t.Errorf("expected an unsupported baud rate to fail")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package powermeter
+
+import "git.sr.ht/~whereswaldon/watt-wiser/sensors"
+
+// Open connects to the meter described by cfg.
+func Open(cfg Config) (sensors.Sensor, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,224 @@
+//go:build linux
+
+package powersupply
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/hwmon"
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+// SysfsRoot is the directory in which the kernel exposes power supplies.
+const SysfsRoot = "/sys/class/power_supply"
+
+// microWattHoursToJoules converts the µWh reported by energy_now into Joules.
+const microWattHoursToJoules = sensors.MicroToUnprefixed * 3600
+
+// attribute reads a single numeric power_supply attribute.
+type attribute struct {
+	supply string
+	path   string
+	unit   sensors.Unit
+	// scale converts the raw attribute value into unprefixed SI units.
+	scale float64
+}
+
+func (a attribute) Name() string {
+	return a.supply + "#" + filepath.Base(a.path)
+}
+
+func (a attribute) Unit() sensors.Unit {
+	return a.unit
+}
+
+func (a attribute) Read() (float64, error) {
+	value, err := readInt(a.path)
+	if err != nil {
+		return 0, err
+	}
+	// Some drivers report currents and power as negative while discharging.
+	return math.Abs(float64(value)) * a.scale, nil
+}
+
+// energyCounter reports the energy drawn from a battery since the previous read, based on
+// the decline of its remaining charge. The remaining charge rising (while charging) is
+// reported as zero.
+type energyCounter struct {
+	attribute
+	last   float64
+	primed bool
+}
+
+func (e *energyCounter) Read() (float64, error) {
+	value, err := e.attribute.Read()
+	if err != nil {
+		return 0, err
+	}
+	increment := e.last - value
+	if !e.primed || increment < 0 {
+		increment = 0
+	}
+	e.last = value
+	e.primed = true
+	return increment, nil
+}
+
+// gated reports the readings of a power supply only while one of its attributes has an
+// expected value, and zero otherwise. This ensures that a battery only reports power
+// while it is powering the system, and an adapter only while it is connected.
+type gated struct {
+	sensors.Sensor
+	name      string
+	gatePath  string
+	gateValue string
+}
+
+func (g *gated) Name() string {
+	return g.name
+}
+
+// WholeSystem returns true, since batteries and adapters power every component of the
+// system.
+func (g *gated) WholeSystem() bool {
+	return true
+}
+
+func (g *gated) Read() (float64, error) {
+	// Always read the wrapped sensor so that incremental sensors stay coherent.
+	value, err := g.Sensor.Read()
+	if err != nil {
+		return 0, err
+	}
+	state, err := os.ReadFile(g.gatePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed reading %s: %w", g.gatePath, err)
+	}
+	if strings.TrimSpace(string(state)) != g.gateValue {
+		return 0, nil
+	}
+	return value, nil
+}
+
+// FindSensors discovers power sensors for the batteries and adapters powering the system.
+func FindSensors() ([]sensors.Sensor, error) {
+	return FindSensorsIn(SysfsRoot)
+}
+
+// FindSensorsIn discovers power sensors for the supplies within the given root, which is
+// usually SysfsRoot. Batteries report their discharge power, and adapters report their
+// input power if they measure it. Batteries in peripherals like wireless mice are ignored.
+func FindSensorsIn(root string) ([]sensors.Sensor, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed listing power supplies: %w", err)
+	}
+	found := []sensors.Sensor{}
+	for _, entry := range entries {
+		dir := filepath.Join(root, entry.Name())
+		supplyType, err := os.ReadFile(filepath.Join(dir, "type"))
+		if err != nil {
+			log.Printf("failed resolving type of %q: %v", dir, err)
+			continue
+		}
+		if scope, err := os.ReadFile(filepath.Join(dir, "scope")); err == nil && strings.TrimSpace(string(scope)) == "Device" {
+			// This supply powers a peripheral, not the system.
+			continue
+		}
+		supply := entry.Name()
+		switch strings.TrimSpace(string(supplyType)) {
+		case "Battery":
+			source := powerSource(supply, dir)
+			if source == nil {
+				energyPath := filepath.Join(dir, "energy_now")
+				if !exists(energyPath) {
+					log.Printf("battery %q reports neither power nor energy", supply)
+					continue
+				}
+				source = &energyCounter{attribute: attribute{
+					supply: supply,
+					path:   energyPath,
+					unit:   sensors.Joules,
+					scale:  microWattHoursToJoules,
+				}}
+			}
+			found = append(found, &gated{
+				Sensor:    source,
+				name:      supply + " discharge",
+				gatePath:  filepath.Join(dir, "status"),
+				gateValue: "Discharging",
+			})
+		case "Mains", "USB":
+			source := powerSource(supply, dir)
+			if source == nil {
+				// Most adapters only report whether they're online.
+				continue
+			}
+			found = append(found, &gated{
+				Sensor:    source,
+				name:      supply + " input",
+				gatePath:  filepath.Join(dir, "online"),
+				gateValue: "1",
+			})
+		}
+	}
+	return found, nil
+}
+
+// powerSource returns a sensor reporting the power flowing through the supply in dir, or
+// nil if the supply doesn't expose enough information to compute it.
+func powerSource(supply, dir string) sensors.Sensor {
+	if path := filepath.Join(dir, "power_now"); exists(path) {
+		return attribute{
+			supply: supply,
+			path:   path,
+			unit:   sensors.Watts,
+			scale:  sensors.MicroToUnprefixed,
+		}
+	}
+	currentPath := filepath.Join(dir, "current_now")
+	voltagePath := filepath.Join(dir, "voltage_now")
+	if exists(currentPath) && exists(voltagePath) {
+		return hwmon.SyntheticPower{
+			Current: attribute{
+				supply: supply,
+				path:   currentPath,
+				unit:   sensors.Amps,
+				scale:  sensors.MicroToUnprefixed,
+			},
+			Voltage: attribute{
+				supply: supply,
+				path:   voltagePath,
+				unit:   sensors.Volts,
+				scale:  sensors.MicroToUnprefixed,
+			},
+		}
+	}
+	return nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func readInt(path string) (int64, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed reading %s: %w", path, err)
+	}
+	value, err := strconv.ParseInt(strings.TrimSpace(string(raw)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed parsing %s (%s): %w", path, strings.TrimSpace(string(raw)), err)
+	}
+	return value, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package powersupply
+
+import "git.sr.ht/~whereswaldon/watt-wiser/sensors"
+
+func FindSensors() ([]sensors.Sensor, error) {
+	return nil, nil
+}
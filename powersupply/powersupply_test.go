@@ -0,0 +1,167 @@
+//go:build linux
+
+package powersupply
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/hwmon"
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+// makeFixture builds a fake power_supply class directory with one subdirectory per supply,
+// each holding the given attribute files.
+func makeFixture(t *testing.T, supplies map[string]map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for supply, files := range supplies {
+		dir := filepath.Join(root, supply)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("failed creating supply dir: %v", err)
+		}
+		for name, contents := range files {
+			writeAttribute(t, filepath.Join(dir, name), contents)
+		}
+	}
+	return root
+}
+
+func writeAttribute(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(contents+"\n"), 0o644); err != nil {
+		t.Fatalf("failed writing %s: %v", path, err)
+	}
+}
+
+func TestFindSensorsIn(t *testing.T) {
+	root := makeFixture(t, map[string]map[string]string{
+		"AC": {
+			"type":   "Mains",
+			"online": "0",
+		},
+		"BAT0": {
+			"type":        "Battery",
+			"status":      "Discharging",
+			"power_now":   "-9250000",
+			"voltage_now": "12370000",
+			"energy_now":  "41000000",
+		},
+		"BAT1": {
+			"type":        "Battery",
+			"status":      "Discharging",
+			"current_now": "1420000",
+			"voltage_now": "12370000",
+		},
+		"hidpp_battery_0": {
+			"type":        "Battery",
+			"scope":       "Device",
+			"status":      "Discharging",
+			"voltage_now": "3900000",
+		},
+		"ucsi-source-psy-USBC000:001": {
+			"type":        "USB",
+			"online":      "1",
+			"current_now": "3000000",
+			"voltage_now": "20000000",
+		},
+	})
+	found, err := FindSensorsIn(root)
+	if err != nil {
+		t.Fatalf("expected discovery to succeed, got %v", err)
+	}
+	type expectation struct {
+		name  string
+		value float64
+	}
+	expected := []expectation{
+		// Negative power from discharging batteries is reported as a positive draw.
+		{name: "BAT0 discharge", value: 9.25},
+		{name: "BAT1 discharge", value: 1.42 * 12.37},
+		{name: "ucsi-source-psy-USBC000:001 input", value: 60},
+	}
+	if len(found) != len(expected) {
+		for _, s := range found {
+			t.Logf("found %q", s.Name())
+		}
+		t.Fatalf("expected %d sensors, got %d", len(expected), len(found))
+	}
+	for i, s := range found {
+		if s.Name() != expected[i].name {
+			t.Errorf("expected sensor %d to be named %q, got %q", i, expected[i].name, s.Name())
+		}
+		if s.Unit() != sensors.Watts {
+			t.Errorf("expected sensor %d to be in %s, got %s", i, sensors.Watts, s.Unit())
+		}
+		if whole, ok := s.(sensors.WholeSystem); !ok || !whole.WholeSystem() {
+			t.Errorf("expected sensor %d to measure the whole system", i)
+		}
+		value, err := s.Read()
+		if err != nil {
+			t.Fatalf("failed reading %s: %v", s.Name(), err)
+		}
+		if math.Abs(value-expected[i].value) > 1e-9 {
+			t.Errorf("expected sensor %d to read %v, got %v", i, expected[i].value, value)
+		}
+	}
+	if _, ok := found[1].(*gated).Sensor.(hwmon.SyntheticPower); !ok {
+		t.Errorf("expected current and voltage to be combined by hwmon.SyntheticPower, got %T", found[1].(*gated).Sensor)
+	}
+}
+
+func TestFindSensorsInMissingRoot(t *testing.T) {
+	found, err := FindSensorsIn(filepath.Join(t.TempDir(), "power_supply"))
+	if err != nil {
+		t.Errorf("expected a missing power_supply class to be ignored, got %v", err)
+	}
+	if len(found) != 0 {
+		t.Errorf("expected no sensors, got %d", len(found))
+	}
+}
+
+func TestDischargeGating(t *testing.T) {
+	root := makeFixture(t, map[string]map[string]string{
+		"BAT0": {
+			"type":       "Battery",
+			"status":     "Discharging",
+			"energy_now": "41000000",
+		},
+	})
+	found, err := FindSensorsIn(root)
+	if err != nil {
+		t.Fatalf("expected discovery to succeed, got %v", err)
+	}
+	if len(found) != 1 {
+		t.Fatalf("expected one sensor, got %d", len(found))
+	}
+	battery := found[0]
+	if battery.Unit() != sensors.Joules {
+		t.Errorf("expected a battery without power readings to report %s, got %s", sensors.Joules, battery.Unit())
+	}
+	dir := filepath.Join(root, "BAT0")
+	for _, step := range []struct {
+		status   string
+		energy   string
+		expected float64
+	}{
+		// The first read only primes the counter.
+		{status: "Discharging", energy: "41000000", expected: 0},
+		{status: "Discharging", energy: "40999000", expected: 3.6},
+		// Charging adds energy, but none of it was consumed from the battery.
+		{status: "Charging", energy: "41001000", expected: 0},
+		{status: "Full", energy: "41001000", expected: 0},
+		{status: "Discharging", energy: "41000500", expected: 1.8},
+	} {
+		writeAttribute(t, filepath.Join(dir, "status"), step.status)
+		writeAttribute(t, filepath.Join(dir, "energy_now"), step.energy)
+		value, err := battery.Read()
+		if err != nil {
+			t.Fatalf("failed reading: %v", err)
+		}
+		if math.Abs(value-step.expected) > 1e-9 {
+			t.Errorf("expected %s battery at %s µWh to report %v J, got %v J", step.status, step.energy, step.expected, value)
+		}
+	}
+}
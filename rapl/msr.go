@@ -0,0 +1,139 @@
+/*
+This file is adapted from code available here:
+https://github.com/hubblo-org/scaphandre/blob/5525c68b5a96bbbba39bce6ae65d6a4ecdb1dab2/src/sensors/msr_rapl.rs#L15
+
+As such, it is available under the terms of the original license (Apache 2.0):
+https://github.com/hubblo-org/scaphandre/blob/5525c68b5a96bbbba39bce6ae65d6a4ecdb1dab2/LICENSE
+*/
+
+package rapl
+
+import (
+	"math"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+type manufacturer uint8
+
+const (
+	Intel manufacturer = iota
+	AMD
+)
+
+const (
+	// Intel MSRs
+	MSR_RAPL_POWER_UNIT        uint64 = 0x606
+	MSR_PKG_ENERGY_STATUS      uint64 = 0x611
+	MSR_DRAM_ENERGY_STATUS     uint64 = 0x00000619
+	MSR_PP0_ENERGY_STATUS      uint64 = 0x00000639
+	MSR_PP1_ENERGY_STATUS      uint64 = 0x00000641
+	MSR_PLATFORM_ENERGY_STATUS uint64 = 0x0000064d
+
+	// AMD MSRs
+	MSR_AMD_RAPL_POWER_UNIT    uint64 = 0xc0010299
+	MSR_AMD_PKG_ENERGY_STATUS  uint64 = 0xc001029b
+	MSR_AMD_CORE_ENERGY_STATUS uint64 = 0xc001029a
+)
+
+// powerUnitMSR returns the MSR holding the RAPL unit register for the given manufacturer.
+func powerUnitMSR(m manufacturer) uint64 {
+	if m == AMD {
+		return MSR_AMD_RAPL_POWER_UNIT
+	}
+	return MSR_RAPL_POWER_UNIT
+}
+
+func extractRAPLPowerUnit(data uint64) float64 {
+	// Discard higher bits that are reserved for future use.
+	data &= 0xffffffff
+	// Power is in bits 0-3
+	power := data & 0x0f
+	denom := math.Pow(2, float64(power))
+	return 1 / denom
+}
+
+func extractRAPLEnergyUnit(data uint64) float64 {
+	// Discard higher bits that are reserved for future use.
+	data &= 0xffffffff
+	// Energy is in bits 8-12
+	energy := (data >> 8) & 0x1f
+	denom := math.Pow(2, float64(energy))
+	return 1 / denom
+}
+
+func extractRAPLTimeUnit(data uint64) float64 {
+	// Discard higher bits that are reserved for future use.
+	data &= 0xffffffff
+	// Time is in bits 16-19
+	time := (data >> 16) & 0x0f
+	denom := math.Pow(2, float64(time))
+	return 1 / denom
+}
+
+func extractEnergyData(data uint64, unit float64) float64 {
+	data &= 0xffffffff
+	return float64(data) * unit
+}
+
+// extractEnergyDelta returns the energy consumed between two reads of a 32-bit
+// energy status counter, accounting for the counter wrapping past zero.
+func extractEnergyDelta(previous, current uint64, unit float64) float64 {
+	delta := uint32(current&0xffffffff) - uint32(previous&0xffffffff)
+	return float64(delta) * unit
+}
+
+type msrInfo struct {
+	msr  uint64
+	name string
+	unit sensors.Unit
+}
+
+// msrsFor returns the energy status MSRs worth probing on the given manufacturer's CPUs.
+func msrsFor(m manufacturer) []msrInfo {
+	switch m {
+	case Intel:
+		return []msrInfo{
+			{
+				name: "intel pkg-0",
+				msr:  MSR_PKG_ENERGY_STATUS,
+				unit: sensors.Joules,
+			},
+			{
+				name: "intel dram",
+				msr:  MSR_DRAM_ENERGY_STATUS,
+				unit: sensors.Joules,
+			},
+			{
+				name: "intel pp0",
+				msr:  MSR_PP0_ENERGY_STATUS,
+				unit: sensors.Joules,
+			},
+			{
+				name: "intel pp1 (uncore?)",
+				msr:  MSR_PP1_ENERGY_STATUS,
+				unit: sensors.Joules,
+			},
+			{
+				name: "intel platform (psys?)",
+				msr:  MSR_PLATFORM_ENERGY_STATUS,
+				unit: sensors.Joules,
+			},
+		}
+	case AMD:
+		return []msrInfo{
+			{
+				name: "amd pkg-0",
+				msr:  MSR_AMD_PKG_ENERGY_STATUS,
+				unit: sensors.Joules,
+			},
+			{
+				name: "amd core",
+				msr:  MSR_AMD_CORE_ENERGY_STATUS,
+				unit: sensors.Joules,
+			},
+		}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,192 @@
+package rapl
+
+import (
+	"testing"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+func TestExtractUnits(t *testing.T) {
+	type testCase struct {
+		name                string
+		register            uint64
+		power, energy, time float64
+	}
+	for _, tc := range []testCase{
+		{
+			name:     "zero",
+			register: 0,
+			power:    1,
+			energy:   1,
+			time:     1,
+		},
+		{
+			// This is the default value of MSR_RAPL_POWER_UNIT on most Intel client CPUs.
+			name:     "intel default",
+			register: 0x000a0e03,
+			power:    1.0 / 8,
+			energy:   1.0 / 16384,
+			time:     1.0 / 1024,
+		},
+		{
+			// This is the value of MSR_AMD_RAPL_POWER_UNIT reported by Zen CPUs.
+			name:     "amd zen",
+			register: 0x000a1003,
+			power:    1.0 / 8,
+			energy:   1.0 / 65536,
+			time:     1.0 / 1024,
+		},
+		{
+			name:     "reserved bits ignored",
+			register: 0xffffffff_000a0e03,
+			power:    1.0 / 8,
+			energy:   1.0 / 16384,
+			time:     1.0 / 1024,
+		},
+		{
+			name:     "fields masked",
+			register: 0x00ffffff,
+			power:    1.0 / 32768,
+			energy:   1.0 / 2147483648,
+			time:     1.0 / 32768,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := extractRAPLPowerUnit(tc.register); got != tc.power {
+				t.Errorf("expected power unit %v, got %v", tc.power, got)
+			}
+			if got := extractRAPLEnergyUnit(tc.register); got != tc.energy {
+				t.Errorf("expected energy unit %v, got %v", tc.energy, got)
+			}
+			if got := extractRAPLTimeUnit(tc.register); got != tc.time {
+				t.Errorf("expected time unit %v, got %v", tc.time, got)
+			}
+		})
+	}
+}
+
+func TestExtractEnergy(t *testing.T) {
+	const unit = 1.0 / 16384
+	type testCase struct {
+		name              string
+		previous, current uint64
+		expectedData      float64
+		expectedDelta     float64
+	}
+	for _, tc := range []testCase{
+		{
+			name:          "no change",
+			previous:      1000,
+			current:       1000,
+			expectedData:  1000 * unit,
+			expectedDelta: 0,
+		},
+		{
+			name:          "increment",
+			previous:      16384,
+			current:       16384 * 3,
+			expectedData:  3,
+			expectedDelta: 2,
+		},
+		{
+			name:          "reserved bits ignored",
+			previous:      0xdeadbeef_00004000,
+			current:       0x12345678_00008000,
+			expectedData:  2,
+			expectedDelta: 1,
+		},
+		{
+			name:          "32-bit wrap",
+			previous:      0xffffc000,
+			current:       0x00004000,
+			expectedData:  1,
+			expectedDelta: 2,
+		},
+		{
+			name:          "32-bit wrap to zero",
+			previous:      0xffffffff,
+			current:       0,
+			expectedData:  0,
+			expectedDelta: unit,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := extractEnergyData(tc.current, unit); got != tc.expectedData {
+				t.Errorf("expected energy %v, got %v", tc.expectedData, got)
+			}
+			if got := extractEnergyDelta(tc.previous, tc.current, unit); got != tc.expectedDelta {
+				t.Errorf("expected energy delta %v, got %v", tc.expectedDelta, got)
+			}
+		})
+	}
+}
+
+func TestMSRTables(t *testing.T) {
+	type testCase struct {
+		name          string
+		manufacturer  manufacturer
+		powerUnitMSR  uint64
+		expectedNames []string
+		expectedMSRs  []uint64
+	}
+	for _, tc := range []testCase{
+		{
+			name:         "intel",
+			manufacturer: Intel,
+			powerUnitMSR: MSR_RAPL_POWER_UNIT,
+			expectedNames: []string{
+				"intel pkg-0",
+				"intel dram",
+				"intel pp0",
+				"intel pp1 (uncore?)",
+				"intel platform (psys?)",
+			},
+			expectedMSRs: []uint64{
+				MSR_PKG_ENERGY_STATUS,
+				MSR_DRAM_ENERGY_STATUS,
+				MSR_PP0_ENERGY_STATUS,
+				MSR_PP1_ENERGY_STATUS,
+				MSR_PLATFORM_ENERGY_STATUS,
+			},
+		},
+		{
+			name:         "amd",
+			manufacturer: AMD,
+			powerUnitMSR: MSR_AMD_RAPL_POWER_UNIT,
+			expectedNames: []string{
+				"amd pkg-0",
+				"amd core",
+			},
+			expectedMSRs: []uint64{
+				MSR_AMD_PKG_ENERGY_STATUS,
+				MSR_AMD_CORE_ENERGY_STATUS,
+			},
+		},
+		{
+			name:         "unknown",
+			manufacturer: manufacturer(255),
+			powerUnitMSR: MSR_RAPL_POWER_UNIT,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := powerUnitMSR(tc.manufacturer); got != tc.powerUnitMSR {
+				t.Errorf("expected power unit MSR %#x, got %#x", tc.powerUnitMSR, got)
+			}
+			msrs := msrsFor(tc.manufacturer)
+			if len(msrs) != len(tc.expectedMSRs) {
+				t.Fatalf("expected %d MSRs, got %d", len(tc.expectedMSRs), len(msrs))
+			}
+			for i, info := range msrs {
+				if info.msr != tc.expectedMSRs[i] {
+					t.Errorf("expected MSR[%d] to be %#x, got %#x", i, tc.expectedMSRs[i], info.msr)
+				}
+				if info.name != tc.expectedNames[i] {
+					t.Errorf("expected MSR[%d] to be named %q, got %q", i, tc.expectedNames[i], info.name)
+				}
+				if info.unit != sensors.Joules {
+					t.Errorf("expected MSR[%d] to be in %s, got %s", i, sensors.Joules, info.unit)
+				}
+			}
+		})
+	}
+}
@@ -21,12 +21,22 @@ type watchFile struct {
 	file       *os.File
 	lastValue  int64
 	maxRange   int64
+	// container is the name of the domain enclosing this one, if any.
+	container string
 }
 
+var _ sensors.Contained = (*watchFile)(nil)
+
 func (w *watchFile) Name() string {
 	return w.deviceName
 }
 
+// ContainedIn returns the name of the enclosing domain, like the package containing a core
+// domain.
+func (w *watchFile) ContainedIn() string {
+	return w.container
+}
+
 func (w *watchFile) Unit() sensors.Unit {
 	return sensors.Joules
 }
@@ -54,10 +64,18 @@ func (w *watchFile) Read() (float64, error) {
 	return float64(increment) * sensors.MicroToUnprefixed, nil
 }
 
+// sysfsRoot is the directory in which the kernel exposes RAPL domains.
+const sysfsRoot = "/sys/devices/virtual/powercap/intel-rapl"
+
 func FindRAPL() ([]sensors.Sensor, error) {
+	return findRAPLIn(sysfsRoot)
+}
+
+// findRAPLIn discovers the RAPL domains within the given root, which is usually sysfsRoot.
+func findRAPLIn(root string) ([]sensors.Sensor, error) {
 	watchFiles := []sensors.Sensor{}
 	if err := filepath.WalkDir(
-		"/sys/devices/virtual/powercap/intel-rapl",
+		root,
 		func(path string, d fs.DirEntry, err error) error {
 			if err != nil {
 				return nil
@@ -88,6 +106,15 @@ func FindRAPL() ([]sensors.Sensor, error) {
 				file:       file,
 				maxRange:   maxRangeInt,
 			}
+			// Subdomains are nested within the directory of their enclosing domain. DRAM is
+			// nested within its package too, but is powered separately, so the package's
+			// energy doesn't include it.
+			parentDir := filepath.Dir(filepath.Dir(path))
+			if _, err := os.Stat(filepath.Join(parentDir, "energy_uj")); err == nil && w.deviceName != "dram" {
+				if parentName, err := os.ReadFile(filepath.Join(parentDir, "name")); err == nil {
+					w.container = strings.TrimSpace(string(parentName))
+				}
+			}
 			watchFiles = append(watchFiles, w)
 			return nil
 		},
@@ -0,0 +1,58 @@
+//go:build linux
+
+package rapl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
+)
+
+func TestFindRAPLNesting(t *testing.T) {
+	root := t.TempDir()
+	// A two-socket machine, where each package has a core domain and the first also has a
+	// DRAM domain.
+	for dir, name := range map[string]string{
+		"intel-rapl:0":                "package-0",
+		"intel-rapl:0/intel-rapl:0:0": "core",
+		"intel-rapl:0/intel-rapl:0:1": "dram",
+		"intel-rapl:1":                "package-1",
+		"intel-rapl:1/intel-rapl:1:0": "core",
+	} {
+		dir = filepath.Join(root, dir)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("failed creating domain dir: %v", err)
+		}
+		for file, contents := range map[string]string{"name": name, "energy_uj": "1000", "max_energy_range_uj": "262143328850"} {
+			if err := os.WriteFile(filepath.Join(dir, file), []byte(contents+"\n"), 0o644); err != nil {
+				t.Fatalf("failed writing %s: %v", file, err)
+			}
+		}
+	}
+	found, err := findRAPLIn(root)
+	if err != nil {
+		t.Fatalf("failed finding RAPL domains: %v", err)
+	}
+	type expectation struct {
+		name, container string
+	}
+	expected := []expectation{
+		{name: "package-0"},
+		{name: "core", container: "package-0"},
+		// DRAM isn't part of its package's energy.
+		{name: "dram"},
+		{name: "package-1"},
+		{name: "core", container: "package-1"},
+	}
+	if len(found) != len(expected) {
+		t.Fatalf("expected %d domains, got %d", len(expected), len(found))
+	}
+	for i, s := range found {
+		container := s.(sensors.Contained).ContainedIn()
+		if s.Name() != expected[i].name || container != expected[i].container {
+			t.Errorf("expected domain %d to be %q within %q, got %q within %q", i, expected[i].name, expected[i].container, s.Name(), container)
+		}
+	}
+}
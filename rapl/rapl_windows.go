@@ -12,35 +12,12 @@ package rapl
 
 import (
 	"fmt"
-	"math"
 	"unsafe"
 
 	"git.sr.ht/~whereswaldon/watt-wiser/sensors"
 	"golang.org/x/sys/windows"
 )
 
-type manufacturer uint8
-
-const (
-	Intel manufacturer = iota
-	AMD
-)
-
-const (
-	// Intel MSRs
-	MSR_RAPL_POWER_UNIT        uint64 = 0x606
-	MSR_PKG_ENERGY_STATUS      uint64 = 0x611
-	MSR_DRAM_ENERGY_STATUS     uint64 = 0x00000619
-	MSR_PP0_ENERGY_STATUS      uint64 = 0x00000639
-	MSR_PP1_ENERGY_STATUS      uint64 = 0x00000641
-	MSR_PLATFORM_ENERGY_STATUS uint64 = 0x0000064d
-
-	// AMD MSRs
-	MSR_AMD_RAPL_POWER_UNIT    uint64 = 0xc0010299
-	MSR_AMD_PKG_ENERGY_STATUS  uint64 = 0xc001029b
-	MSR_AMD_CORE_ENERGY_STATUS uint64 = 0xc001029a
-)
-
 // These constants borrowed from:
 // https://docs.rs/windows-sys/latest/windows_sys/Win32/System/Ioctl/
 const (
@@ -57,7 +34,7 @@ type RAPLSensor struct {
 	energyUnit float64
 	timeUnit   float64
 	handle     windows.Handle
-	previous   float64
+	previous   uint64
 }
 
 func getHandle(driverName string) (windows.Handle, error) {
@@ -111,44 +88,6 @@ func sendRequest(
 	return responseData, nil
 }
 
-func extractRAPLPowerUnit(data uint64) float64 {
-	// Discard higher bits that are reserved for future use.
-	data &= 0xffffffff
-	// Power is in bits 0-3
-	power := data & 0x0f
-	denom := math.Pow(2, float64(power))
-	return 1 / denom
-}
-
-func extractRAPLEnergyUnit(data uint64) float64 {
-	// Discard higher bits that are reserved for future use.
-	data &= 0xffffffff
-	// Energy is in bits 8-12
-	energy := (data >> 8) & 0x1f
-	denom := math.Pow(2, float64(energy))
-	return 1 / denom
-}
-
-func extractRAPLTimeUnit(data uint64) float64 {
-	// Discard higher bits that are reserved for future use.
-	data &= 0xffffffff
-	// Time is in bits 16-19
-	time := (data >> 16) & 0x0f
-	denom := math.Pow(2, float64(time))
-	return 1 / denom
-}
-
-func extractEnergyData(data uint64, unit float64) float64 {
-	data &= 0xffffffff
-	return float64(data) * unit
-}
-
-type msrInfo struct {
-	msr  uint64
-	name string
-	unit sensors.Unit
-}
-
 func FindRAPL() ([]sensors.Sensor, error) {
 	sensor := RAPLSensor{
 		driverName: `\\.\ScaphandreDriver`,
@@ -160,14 +99,14 @@ func FindRAPL() ([]sensors.Sensor, error) {
 	manufacturer := Intel
 	response, err := sendRequest(
 		handle,
-		MSR_RAPL_POWER_UNIT,
+		powerUnitMSR(Intel),
 	)
 	if err != nil {
 		origErr := err
 		// Might be an AMD system, try that.
 		response, err = sendRequest(
 			handle,
-			MSR_AMD_RAPL_POWER_UNIT,
+			powerUnitMSR(AMD),
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed communicating with rapl driver: %w %w", origErr, err)
@@ -179,50 +118,7 @@ func FindRAPL() ([]sensors.Sensor, error) {
 	sensor.timeUnit = extractRAPLTimeUnit(response)
 	sensor.handle = handle
 
-	tryMSRs := []msrInfo{}
-	switch manufacturer {
-	case Intel:
-		tryMSRs = append(tryMSRs,
-			msrInfo{
-				name: "intel pkg-0",
-				msr:  MSR_PKG_ENERGY_STATUS,
-				unit: sensors.Joules,
-			},
-			msrInfo{
-				name: "intel dram",
-				msr:  MSR_DRAM_ENERGY_STATUS,
-				unit: sensors.Joules,
-			},
-			msrInfo{
-				name: "intel pp0",
-				msr:  MSR_PP0_ENERGY_STATUS,
-				unit: sensors.Joules,
-			},
-			msrInfo{
-				name: "intel pp1 (uncore?)",
-				msr:  MSR_PP1_ENERGY_STATUS,
-				unit: sensors.Joules,
-			},
-			msrInfo{
-				name: "intel platform (psys?)",
-				msr:  MSR_PLATFORM_ENERGY_STATUS,
-				unit: sensors.Joules,
-			},
-		)
-	case AMD:
-		tryMSRs = append(tryMSRs,
-			msrInfo{
-				name: "amd pkg-0",
-				msr:  MSR_AMD_PKG_ENERGY_STATUS,
-				unit: sensors.Joules,
-			},
-			msrInfo{
-				name: "amd core",
-				msr:  MSR_AMD_CORE_ENERGY_STATUS,
-				unit: sensors.Joules,
-			},
-		)
-	}
+	tryMSRs := msrsFor(manufacturer)
 	var sensorList []sensors.Sensor
 	for _, msr := range tryMSRs {
 		sensorCopy := sensor
@@ -247,9 +143,8 @@ func (r *RAPLSensor) Read() (float64, error) {
 		return 0, fmt.Errorf("failed reading energy: %w", err)
 	}
 	if r.unit == sensors.Joules {
-		raw := extractEnergyData(response, r.energyUnit)
-		inc := raw - r.previous
-		r.previous = raw
+		inc := extractEnergyDelta(r.previous, response, r.energyUnit)
+		r.previous = response
 		return inc, nil
 	}
 	return extractEnergyData(response, r.powerUnit), nil
@@ -0,0 +1,70 @@
+package sensors
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// ErrorPolicy describes how to handle a failed sensor read.
+type ErrorPolicy uint8
+
+const (
+	// Retry reads the sensor again immediately, recording a gap if that fails too.
+	Retry ErrorPolicy = iota
+	// Gap records a gap in the sensor's data and reads it as usual next time.
+	Gap
+	// Drop stops reading the sensor, which suits devices that have disappeared.
+	Drop
+)
+
+func (p ErrorPolicy) String() string {
+	switch p {
+	case Retry:
+		return "retry"
+	case Gap:
+		return "gap"
+	case Drop:
+		return "drop"
+	default:
+		return "?"
+	}
+}
+
+// ParseErrorPolicy returns the policy whose String form is s.
+func ParseErrorPolicy(s string) (ErrorPolicy, error) {
+	for p := Retry; p <= Drop; p++ {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown error policy %q", s)
+}
+
+// ErrorClassifier may be implemented by sensors that know how their errors should be
+// handled. It returns false for errors it does not recognize.
+type ErrorClassifier interface {
+	ErrorPolicy(err error) (ErrorPolicy, bool)
+}
+
+// PolicyFor chooses how to handle an error from reading s. Sensors implementing
+// ErrorClassifier are consulted first. Otherwise, errors known to be transient are
+// retried, errors indicating that a device is gone drop the sensor, and anything else is
+// handled with the fallback policy.
+func PolicyFor(s Sensor, err error, fallback ErrorPolicy) ErrorPolicy {
+	if classifier, ok := s.(ErrorClassifier); ok {
+		if policy, ok := classifier.ErrorPolicy(err); ok {
+			return policy
+		}
+	}
+	switch {
+	case errors.Is(err, syscall.EAGAIN), errors.Is(err, syscall.EINTR), errors.Is(err, syscall.EBUSY), errors.Is(err, os.ErrDeadlineExceeded):
+		return Retry
+	case errors.Is(err, syscall.ENODEV), errors.Is(err, syscall.ENXIO), errors.Is(err, fs.ErrNotExist):
+		return Drop
+	default:
+		return fallback
+	}
+}
@@ -1,5 +1,10 @@
 package sensors
 
+import (
+	"strings"
+	"time"
+)
+
 type Unit uint8
 
 func (u Unit) String() string {
@@ -12,23 +17,63 @@ func (u Unit) String() string {
 		return "A"
 	case Volts:
 		return "V"
+	case Celsius:
+		return "°C"
+	case RPM:
+		return "RPM"
+	case Hertz:
+		return "Hz"
+	case Percent:
+		return "%"
 	default:
 		return "?"
 	}
 }
 
+// IsEnergy reports whether values in the unit describe energy consumption, either as a
+// quantity (Joules) or as a rate (Watts).
+func (u Unit) IsEnergy() bool {
+	return u == Joules || u == Watts
+}
+
 const (
 	Joules Unit = iota
 	Watts
 	Amps
 	Volts
+	Celsius
+	RPM
+	Hertz
+	Percent
 	Unknown
 )
 
+// ThrottleSuffix ends the name of every sensor reporting whether a device is throttled.
+// Such sensors read 100% while the device is throttled and 0% otherwise, so that their
+// mean over an interval is the share of it spent throttled.
+const ThrottleSuffix = " throttled"
+
+// ParseUnit returns the unit whose String form is s, or Unknown if there is no such unit.
+func ParseUnit(s string) Unit {
+	s = strings.TrimSpace(s)
+	for u := Joules; u < Unknown; u++ {
+		if u.String() == s {
+			return u
+		}
+	}
+	return Unknown
+}
+
 const (
 	// MicroToUnprefixed is the conversion factor from a micro SI unit to an unprefixed
 	// one.
 	MicroToUnprefixed = 1.0 / 1_000_000
+	// MilliToUnprefixed is the conversion factor from a milli SI unit to an unprefixed
+	// one.
+	MilliToUnprefixed = 1.0 / 1_000
+	// KiloToUnprefixed is the conversion factor from a kilo SI unit to an unprefixed
+	// one.
+	KiloToUnprefixed = 1_000.0
 )
 
 type Sensor interface {
@@ -36,3 +81,36 @@ type Sensor interface {
 	Unit() Unit
 	Read() (float64, error)
 }
+
+// UpdateIntervaler is implemented by sensors whose values only change at a known interval,
+// like power readings averaged over a window. Reading them more often than that records
+// the same value repeatedly.
+type UpdateIntervaler interface {
+	// UpdateInterval returns the interval between changes in the sensor's value, or zero
+	// if it is unknown.
+	UpdateInterval() time.Duration
+}
+
+// Averager is implemented by sensors whose readings are trailing averages, like power
+// averaged by the device over a window ending when it is read.
+type Averager interface {
+	// AveragingWindow returns the duration that each reading is averaged over, or zero if
+	// readings are instantaneous or the window is unknown.
+	AveragingWindow() time.Duration
+}
+
+// Contained is implemented by sensors whose readings are part of another sensor's, like the
+// cores within a CPU package, so that their energy isn't counted twice in totals.
+type Contained interface {
+	// ContainedIn returns the name of the sensor whose readings include this one's, or ""
+	// if there is none.
+	ContainedIn() string
+}
+
+// WholeSystem is implemented by sensors whose readings include those of every component of
+// the system, like wall power meters and the battery or adapter powering a laptop, so that
+// the components aren't counted on top of them in totals.
+type WholeSystem interface {
+	// WholeSystem reports whether the sensor measures the power of the whole system.
+	WholeSystem() bool
+}
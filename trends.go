@@ -0,0 +1,380 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"time"
+
+	"gioui.org/f32"
+	"gioui.org/io/event"
+	"gioui.org/io/pointer"
+	"gioui.org/layout"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/text"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"gioui.org/x/component"
+	"git.sr.ht/~gioverse/skel/stream"
+	"git.sr.ht/~whereswaldon/watt-wiser/backend"
+)
+
+const (
+	metricJoules = "joules"
+	metricWatts  = "watts"
+)
+
+// outlierColor rings the points that are far from the rest of their series.
+var outlierColor = color.NRGBA{R: 200, A: 255}
+
+// trendHit locates a point drawn in the trend plot.
+type trendHit struct {
+	pos           f32.Point
+	series, point int
+}
+
+// Trends shows how the energy used by the benchmarks of a command changed over time.
+type Trends struct {
+	ws backend.WindowState
+
+	indexStream *stream.Stream[backend.Index]
+	index       backend.Index
+	refreshBtn  widget.Clickable
+
+	// State for choosing the benchmarks to show.
+	command     widget.Enum
+	commands    []string
+	commandList widget.List
+	tagsEditor  component.TextField
+	tags        string
+	metric      widget.Enum
+
+	trends  []backend.Trend
+	enabled []widget.Bool
+	keyList widget.List
+
+	// hits locates the points drawn in the last frame, and hovered is the index of the one
+	// under the pointer, or -1.
+	hits    []trendHit
+	hovered int
+
+	// OnOpen, if set, is called with a point when it is clicked.
+	OnOpen func(backend.EnergyPoint)
+}
+
+func NewTrends(ws backend.WindowState) *Trends {
+	t := &Trends{
+		ws:          ws,
+		indexStream: stream.New(ws.Controller, ws.Bundle.Datasource.StreamIndex),
+		metric:      widget.Enum{Value: metricJoules},
+		hovered:     -1,
+	}
+	t.commandList.Axis = layout.Vertical
+	t.keyList.Axis = layout.Horizontal
+	return t
+}
+
+func (t *Trends) Update(gtx C, th *material.Theme) {
+	changed := false
+	if t.refreshBtn.Clicked(gtx) {
+		t.indexStream = stream.New(t.ws.Controller, t.ws.Bundle.Datasource.StreamIndex)
+	}
+	if index, isNew := t.indexStream.ReadNew(gtx); isNew {
+		t.index = index
+		t.commands = index.Commands()
+		if t.command.Value == "" && len(t.commands) > 0 {
+			t.command.Value = t.commands[0]
+		}
+		changed = true
+	}
+	if t.command.Update(gtx) {
+		changed = true
+	}
+	t.metric.Update(gtx)
+	t.tagsEditor.Update(gtx, th, "Only benchmarks tagged (comma-separated)")
+	if tags := t.tagsEditor.Text(); tags != t.tags {
+		t.tags = tags
+		changed = true
+	}
+	if changed {
+		t.trends = t.index.Trends(backend.BenchmarkQuery{
+			Command: t.command.Value,
+			Tags:    backend.ParseTags(t.tags),
+		})
+		t.enabled = make([]widget.Bool, len(t.trends))
+		for i := range t.enabled {
+			t.enabled[i].Value = true
+		}
+		t.hits = t.hits[:0]
+		t.hovered = -1
+	}
+	for {
+		ev, ok := gtx.Event(pointer.Filter{
+			Target: t,
+			Kinds:  pointer.Move | pointer.Press | pointer.Leave,
+		})
+		if !ok {
+			break
+		}
+		e, ok := ev.(pointer.Event)
+		if !ok {
+			continue
+		}
+		switch e.Kind {
+		case pointer.Move:
+			t.hovered = t.nearest(gtx, e.Position)
+		case pointer.Leave:
+			t.hovered = -1
+		case pointer.Press:
+			if i := t.nearest(gtx, e.Position); i >= 0 && t.OnOpen != nil {
+				hit := t.hits[i]
+				t.OnOpen(t.trends[hit.series].Points[hit.point])
+			}
+		}
+	}
+}
+
+// nearest returns the index of the drawn point closest to pos, or -1 if none is close
+// enough to be pointing at.
+func (t *Trends) nearest(gtx C, pos f32.Point) int {
+	best, bestDist := -1, float32(gtx.Dp(8))
+	for i, hit := range t.hits {
+		d := hit.pos.Sub(pos)
+		if dist := float32(math.Hypot(float64(d.X), float64(d.Y))); dist <= bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	return best
+}
+
+// value returns the chosen metric of a point.
+func (t *Trends) value(p backend.EnergyPoint) float64 {
+	if t.metric.Value == metricWatts {
+		return p.Watts
+	}
+	return p.Joules
+}
+
+// unit returns the unit of the chosen metric.
+func (t *Trends) unit() string {
+	if t.metric.Value == metricWatts {
+		return "W"
+	}
+	return "J"
+}
+
+func (t *Trends) Layout(gtx C, th *material.Theme) D {
+	t.Update(gtx, th)
+	inset := layout.UniformInset(2)
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		layout.Rigid(func(gtx C) D {
+			return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+				layout.Flexed(1, func(gtx C) D {
+					return inset.Layout(gtx, func(gtx C) D {
+						return t.tagsEditor.Layout(gtx, th, "Only benchmarks tagged (comma-separated)")
+					})
+				}),
+				layout.Rigid(material.RadioButton(th, &t.metric, metricJoules, "Energy (J)").Layout),
+				layout.Rigid(material.RadioButton(th, &t.metric, metricWatts, "Mean power (W)").Layout),
+				layout.Rigid(func(gtx C) D {
+					return inset.Layout(gtx, material.Button(th, &t.refreshBtn, "Refresh").Layout)
+				}),
+			)
+		}),
+		layout.Flexed(1, func(gtx C) D {
+			if len(t.commands) == 0 {
+				return layout.Center.Layout(gtx, material.Body1(th, "No benchmarks recorded yet.").Layout)
+			}
+			return layout.Flex{}.Layout(gtx,
+				layout.Rigid(func(gtx C) D {
+					gtx.Constraints.Max.X /= 4
+					return material.List(th, &t.commandList).Layout(gtx, len(t.commands), func(gtx C, i int) D {
+						return material.RadioButton(th, &t.command, t.commands[i], t.commands[i]).Layout(gtx)
+					})
+				}),
+				layout.Flexed(1, func(gtx C) D {
+					return inset.Layout(gtx, func(gtx C) D {
+						return t.layoutChart(gtx, th)
+					})
+				}),
+			)
+		}),
+		layout.Rigid(func(gtx C) D {
+			return material.List(th, &t.keyList).Layout(gtx, len(t.trends), func(gtx C, i int) D {
+				box := material.CheckBox(th, &t.enabled[i], t.trends[i].Series)
+				box.IconColor = colors[i%len(colors)]
+				return inset.Layout(gtx, box.Layout)
+			})
+		}),
+		layout.Rigid(func(gtx C) D {
+			return inset.Layout(gtx, material.Body2(th, t.description()).Layout)
+		}),
+	)
+}
+
+// description describes the hovered point, or explains how to use the chart.
+func (t *Trends) description() string {
+	if t.hovered < 0 || t.hovered >= len(t.hits) {
+		return "Points ringed in red are outliers, and vertical lines mark changes in energy use. Click a point to open its session."
+	}
+	hit := t.hits[t.hovered]
+	trend := t.trends[hit.series]
+	p := trend.Points[hit.point]
+	description := fmt.Sprintf("%s: %s%s on %s", trend.Series, formatSI(t.value(p)), t.unit(), p.Start.Format(time.DateTime))
+	if p.Commit != "" {
+		description += " at commit " + p.Commit
+	}
+	if p.Outlier {
+		description += " (outlier)"
+	}
+	return description
+}
+
+// layoutChart lays out the plot with its axis labels.
+func (t *Trends) layoutChart(gtx C, th *material.Theme) D {
+	var first, last time.Time
+	rangeMin, rangeMax := 0.0, 0.0
+	for i, trend := range t.trends {
+		if !t.enabled[i].Value {
+			continue
+		}
+		for _, p := range trend.Points {
+			if first.IsZero() || p.Start.Before(first) {
+				first = p.Start
+			}
+			if p.Start.After(last) {
+				last = p.Start
+			}
+			rangeMin = min(rangeMin, t.value(p))
+			rangeMax = max(rangeMax, t.value(p))
+		}
+	}
+	if rangeMax == rangeMin {
+		rangeMax = rangeMin + 1
+	}
+	// Leave room for the points at the edges.
+	padding := (rangeMax - rangeMin) * .05
+	rangeMax += padding
+	if rangeMin < 0 {
+		rangeMin -= padding
+	}
+	title := material.Body2(th, fmt.Sprintf("Benchmarks of %s", t.command.Value))
+	title.Alignment = text.Middle
+	title.MaxLines = 1
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		layout.Flexed(1, func(gtx C) D {
+			return layout.Flex{}.Layout(gtx,
+				layout.Rigid(func(gtx C) D {
+					return layout.Flex{Axis: layout.Vertical, Spacing: layout.SpaceBetween, Alignment: layout.End}.Layout(gtx,
+						layout.Rigid(material.Body2(th, formatSI(rangeMax)+t.unit()).Layout),
+						layout.Rigid(material.Body2(th, formatSI(rangeMin)+t.unit()).Layout),
+					)
+				}),
+				layout.Flexed(1, func(gtx C) D {
+					return t.layoutPlot(gtx, th, first, last, rangeMin, rangeMax)
+				}),
+			)
+		}),
+		layout.Rigid(func(gtx C) D {
+			return layout.Flex{Alignment: layout.Baseline}.Layout(gtx,
+				layout.Rigid(material.Body2(th, first.Format(time.DateOnly)).Layout),
+				layout.Flexed(1, title.Layout),
+				layout.Rigid(material.Body2(th, last.Format(time.DateOnly)).Layout),
+			)
+		}),
+	)
+}
+
+// layoutPlot draws each enabled series as a line through its points, which are placed by
+// when their benchmark started. Outliers are ringed and left out of the line, and change
+// points are marked with a vertical line.
+func (t *Trends) layoutPlot(gtx C, th *material.Theme, first, last time.Time, rangeMin, rangeMax float64) D {
+	size := gtx.Constraints.Max
+	radius := float32(gtx.Dp(3))
+	inner := layout.FPt(size).Sub(f32.Pt(2*radius, 2*radius))
+	span := last.Sub(first)
+	position := func(p backend.EnergyPoint) f32.Point {
+		x := inner.X / 2
+		if span > 0 {
+			x = inner.X * float32(p.Start.Sub(first)) / float32(span)
+		}
+		y := inner.Y * float32(1-(t.value(p)-rangeMin)/(rangeMax-rangeMin))
+		return f32.Pt(x+radius, y+radius)
+	}
+	defer clip.Rect{Max: size}.Push(gtx.Ops).Pop()
+	event.Op(gtx.Ops, t)
+	if t.hovered >= 0 {
+		pointer.CursorPointer.Add(gtx.Ops)
+	}
+	paint.FillShape(gtx.Ops, color.NRGBA{A: 50}, clip.Stroke{
+		Path:  clip.RRect{Rect: image.Rectangle{Max: size}}.Path(gtx.Ops),
+		Width: float32(gtx.Dp(1)),
+	}.Op())
+	if rangeMin < 0 {
+		zero := position(backend.EnergyPoint{Start: first}).Y
+		paint.FillShape(gtx.Ops, color.NRGBA{A: 100}, clip.Rect{
+			Min: image.Pt(0, int(zero)),
+			Max: image.Pt(size.X, int(zero)+gtx.Dp(1)),
+		}.Op())
+	}
+
+	t.hits = t.hits[:0]
+	for i, trend := range t.trends {
+		if !t.enabled[i].Value {
+			continue
+		}
+		c := colors[i%len(colors)]
+		for _, cp := range trend.ChangePoints {
+			x := (position(trend.Points[cp-1]).X + position(trend.Points[cp]).X) / 2
+			marker := c
+			marker.A = 120
+			paint.FillShape(gtx.Ops, marker, clip.Rect{
+				Min: image.Pt(int(x)-gtx.Dp(1), 0),
+				Max: image.Pt(int(x)+gtx.Dp(1), size.Y),
+			}.Op())
+		}
+		var line clip.Path
+		line.Begin(gtx.Ops)
+		started := false
+		for _, p := range trend.Points {
+			if p.Outlier {
+				continue
+			}
+			if !started {
+				line.MoveTo(position(p))
+				started = true
+			} else {
+				line.LineTo(position(p))
+			}
+		}
+		paint.FillShape(gtx.Ops, c, clip.Stroke{Path: line.End(), Width: float32(gtx.Dp(1.5))}.Op())
+		for j, p := range trend.Points {
+			pos := position(p)
+			t.hits = append(t.hits, trendHit{pos: pos, series: i, point: j})
+			paint.FillShape(gtx.Ops, c, circle(pos, radius).Op(gtx.Ops))
+			if p.Outlier {
+				paint.FillShape(gtx.Ops, outlierColor, clip.Stroke{
+					Path:  circle(pos, 2*radius).Path(gtx.Ops),
+					Width: float32(gtx.Dp(1.5)),
+				}.Op())
+			}
+		}
+	}
+	if t.hovered >= 0 && t.hovered < len(t.hits) {
+		paint.FillShape(gtx.Ops, th.Fg, clip.Stroke{
+			Path:  circle(t.hits[t.hovered].pos, 3*radius).Path(gtx.Ops),
+			Width: float32(gtx.Dp(1)),
+		}.Op())
+	}
+	return D{Size: size}
+}
+
+// circle returns the ellipse of the given radius around center.
+func circle(center f32.Point, radius float32) clip.Ellipse {
+	return clip.Ellipse{
+		Min: image.Pt(int(center.X-radius), int(center.Y-radius)),
+		Max: image.Pt(int(center.X+radius), int(center.Y+radius)),
+	}
+}
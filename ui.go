@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"image"
 	"image/color"
 	"log"
+	"time"
 
 	"gioui.org/font/gofont"
 	"gioui.org/layout"
@@ -13,6 +15,7 @@ import (
 	"gioui.org/text"
 	"gioui.org/widget"
 	"gioui.org/widget/material"
+	"gioui.org/x/component"
 	"gioui.org/x/explorer"
 	"git.sr.ht/~gioverse/skel/stream"
 	"git.sr.ht/~whereswaldon/watt-wiser/backend"
@@ -26,6 +29,7 @@ type (
 const (
 	tabMonitor   = "monitor"
 	tabBenchmark = "benchmark"
+	tabTrends    = "trends"
 )
 
 // UI is responsible for holding the state of and drawing the top-level UI.
@@ -37,12 +41,23 @@ type UI struct {
 
 	chart       *ChartData
 	benchmark   *Benchmark
+	trends      *Trends
 	tab         widget.Enum
 	launchBtn   widget.Clickable
 	explorerBtn widget.Clickable
 	launching   bool
 	sensorsErr  string
 
+	// State for the list of recently recorded sessions.
+	indexStream *stream.Stream[backend.Index]
+	recent      []backend.SessionRecord
+	recentBtns  []widget.Clickable
+	recentList  widget.List
+
+	// State for deriving new series.
+	deriveEditor component.TextField
+	deriveBtn    widget.Clickable
+
 	th *material.Theme
 }
 
@@ -60,8 +75,13 @@ func NewUI(ws backend.WindowState, expl *explorer.Explorer, sessionID string) *U
 			return ws.Bundle.Datasource.StreamSession(ctx, sessionID)
 		})
 	}
+	ui.indexStream = stream.New(ws.Controller, ws.Bundle.Datasource.StreamIndex)
+	ui.recentList.Axis = layout.Vertical
 	ui.chart = NewChart()
+	ui.chart.OnPause = ui.pauseSensors
 	ui.benchmark = NewBenchmark(ws, expl)
+	ui.trends = NewTrends(ws)
+	ui.trends.OnOpen = ui.openBenchmark
 	return ui
 }
 
@@ -82,6 +102,8 @@ func (ui *UI) Update(gtx C) {
 	ui.tab.Update(gtx)
 	if ui.session.Err != nil {
 		ui.sensorsErr = ui.session.Err.Error()
+	} else if ui.session.Recovery.Discarded > 0 {
+		ui.sensorsErr = "Recovered a trace cut off by a crash: " + ui.session.Recovery.String()
 	}
 	if !ui.launching && ui.launchBtn.Clicked(gtx) {
 		ui.launching = true
@@ -94,6 +116,24 @@ func (ui *UI) Update(gtx C) {
 			})
 		}
 	}
+	ui.deriveEditor.Update(gtx, ui.th, "Derived series, like: uncore (W) = package-0 - core")
+	if ui.deriveBtn.Clicked(gtx) {
+		ui.addDerivation()
+	}
+	if index, isNew := ui.indexStream.ReadNew(gtx); isNew {
+		ui.recent = index.Recent()[:min(len(index.Sessions), maxRecentSessions)]
+		ui.recentBtns = make([]widget.Clickable, len(ui.recent))
+	}
+	for i := range ui.recentBtns {
+		if ui.recentBtns[i].Clicked(gtx) {
+			_, mut, err := ui.ws.Bundle.Datasource.LoadFromLibrary(ui.recent[i].ID)
+			if err != nil {
+				log.Printf("failed loading recent session: %v", err)
+			} else {
+				ui.sessionStream = stream.New(ui.ws.Controller, mut.Stream)
+			}
+		}
+	}
 	if ui.explorerBtn.Clicked(gtx) {
 		_, mut, err := ui.ws.Bundle.Datasource.LoadFromFile(ui.expl)
 		if err != nil {
@@ -104,6 +144,56 @@ func (ui *UI) Update(gtx C) {
 	}
 }
 
+// openBenchmark shows the session of a benchmark from the trends tab in the monitor chart,
+// scrolled to the end of the benchmark.
+func (ui *UI) openBenchmark(p backend.EnergyPoint) {
+	if p.SessionID != ui.session.ID {
+		_, mut, err := ui.ws.Bundle.Datasource.LoadFromLibrary(p.SessionID)
+		if err != nil {
+			log.Printf("failed loading benchmark session: %v", err)
+			return
+		}
+		ui.sessionStream = stream.New(ui.ws.Controller, mut.Stream)
+	}
+	ui.chart.ShowAt(p.End.UnixNano())
+	ui.tab.Value = tabMonitor
+}
+
+// addDerivation adds the series defined in the derivation editor to every session, after
+// checking that the shown session contains its inputs.
+func (ui *UI) addDerivation() {
+	def, err := backend.ParseDerivation(ui.deriveEditor.Text())
+	if err == nil {
+		_, err = backend.NewDerivedSeries(def, ui.session.Data)
+	}
+	if err != nil {
+		ui.deriveEditor.SetError(err.Error())
+		return
+	}
+	ui.ws.Bundle.Datasource.AddDerivation(def)
+	ui.deriveEditor.Clear()
+}
+
+// pauseSensors pauses or resumes the sensors while a live session is shown, so that the
+// pause button stops recording rather than only freezing the chart.
+func (ui *UI) pauseSensors(paused bool) {
+	if ui.session.Mode != backend.ModeSensing {
+		return
+	}
+	ds := ui.ws.Bundle.Datasource
+	go func() {
+		var err error
+		if paused {
+			err = ds.PauseSensors()
+		} else {
+			err = ds.ResumeSensors()
+		}
+		if err != nil {
+			log.Printf("failed pausing or resuming sensors: %v", err)
+		}
+	}()
+}
+
 type TabStyle struct {
 	state  *widget.Enum
 	label  material.LabelStyle
@@ -156,6 +246,7 @@ func (ui *UI) layoutMainArea(gtx C) D {
 			return layout.Flex{}.Layout(gtx,
 				layout.Flexed(1, Tab(ui.th, &ui.tab, tabMonitor, "Monitor").Layout),
 				layout.Flexed(1, Tab(ui.th, &ui.tab, tabBenchmark, "Benchmark").Layout),
+				layout.Flexed(1, Tab(ui.th, &ui.tab, tabTrends, "Trends").Layout),
 			)
 		}),
 		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
@@ -171,7 +262,14 @@ func (ui *UI) layoutMainArea(gtx C) D {
 				if ui.session.ID == "" {
 					return ui.layoutStartScreen(gtx)
 				}
-				return ui.chart.Layout(gtx, ui.th)
+				return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+					layout.Flexed(1, func(gtx C) D {
+						return ui.chart.Layout(gtx, ui.th)
+					}),
+					layout.Rigid(ui.layoutDeriveForm),
+				)
+			} else if ui.tab.Value == tabTrends {
+				return ui.trends.Layout(gtx, ui.th)
 			} else {
 				return ui.benchmark.Layout(gtx, ui.th, ui.session.Data)
 			}
@@ -179,6 +277,27 @@ func (ui *UI) layoutMainArea(gtx C) D {
 	)
 }
 
+// layoutDeriveForm lays out the editor for defining derived series, like
+// "uncore (W) = package-0 - core".
+func (ui *UI) layoutDeriveForm(gtx C) D {
+	inset := layout.UniformInset(2)
+	return layout.Flex{
+		Alignment: layout.Middle,
+	}.Layout(gtx,
+		layout.Flexed(1, func(gtx C) D {
+			return inset.Layout(gtx, func(gtx C) D {
+				return ui.deriveEditor.Layout(gtx, ui.th, "Derived series, like: uncore (W) = package-0 - core")
+			})
+		}),
+		layout.Rigid(func(gtx C) D {
+			if ui.deriveEditor.Len() == 0 {
+				gtx = gtx.Disabled()
+			}
+			return inset.Layout(gtx, material.Button(ui.th, &ui.deriveBtn, "Derive").Layout)
+		}),
+	)
+}
+
 func (ui *UI) layoutStartScreen(gtx C) D {
 	l := material.Body1(ui.th, "No data yet.")
 	return layout.Flex{
@@ -205,6 +324,35 @@ func (ui *UI) layoutStartScreen(gtx C) D {
 			gtx.Constraints.Min = image.Point{}
 			return material.Body2(ui.th, ui.sensorsErr).Layout(gtx)
 		}),
+		layout.Rigid(ui.layoutRecentSessions),
+	)
+}
+
+// maxRecentSessions is the number of recorded sessions listed on the start screen.
+const maxRecentSessions = 10
+
+// layoutRecentSessions lists the most recently recorded sessions, any of which can be
+// opened by clicking it.
+func (ui *UI) layoutRecentSessions(gtx C) D {
+	if len(ui.recent) == 0 {
+		return D{}
+	}
+	gtx.Constraints.Min = image.Point{}
+	return layout.Flex{
+		Axis:      layout.Vertical,
+		Alignment: layout.Middle,
+	}.Layout(gtx,
+		layout.Rigid(material.H6(ui.th, "Recent Sessions").Layout),
+		layout.Rigid(func(gtx C) D {
+			return material.List(ui.th, &ui.recentList).Layout(gtx, len(ui.recent), func(gtx C, i int) D {
+				session := ui.recent[i]
+				description := fmt.Sprintf("%s on %s, %s, %.1f MB", session.Start.Format(time.DateTime), session.Host, session.Duration.Round(time.Second), float64(session.Size)/1e6)
+				if session.Notes != "" {
+					description += ": " + session.Notes
+				}
+				return layout.UniformInset(2).Layout(gtx, material.Button(ui.th, &ui.recentBtns[i], description).Layout)
+			})
+		}),
 	)
 }
 